@@ -0,0 +1,94 @@
+package netipds
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrPoolExhausted is returned by [Allocator.AllocateLen] when no free block
+// of the requested length remains in the pool.
+var ErrPoolExhausted = errors.New("no free block of the requested length")
+
+// Allocator allocates blocks of addresses from a pool Prefix, such as the
+// address space of a network being subdivided into subnets.
+//
+// An Allocator is not safe for concurrent use.
+type Allocator struct {
+	pool netip.Prefix
+	used PrefixSetBuilder
+}
+
+// NewAllocator returns an Allocator for the pool p. The Prefixes in
+// allocated, if any, are considered already allocated; those which don't
+// overlap p are ignored.
+//
+// p must not be ::/0, which can't be tracked as an allocated Prefix.
+func NewAllocator(p netip.Prefix, allocated *PrefixSet) (*Allocator, error) {
+	if !p.IsValid() {
+		return nil, invalidPrefixError(p)
+	}
+	if p.Bits() == 0 && p.Addr().Is6() {
+		return nil, &PrefixError{p, ErrUnsupportedPrefix}
+	}
+	a := &Allocator{pool: p.Masked()}
+	if allocated != nil {
+		for _, q := range allocated.OverlappingWith(a.pool) {
+			a.used.Add(q)
+		}
+	}
+	return a, nil
+}
+
+// Pool returns a's pool Prefix.
+func (a *Allocator) Pool() netip.Prefix {
+	return a.pool
+}
+
+// AllocateLen allocates and returns the lowest free block of length bits in
+// the pool: the first Prefix of that length which doesn't overlap any
+// allocated Prefix. It returns [ErrPoolExhausted] if there is none.
+func (a *Allocator) AllocateLen(bits int) (netip.Prefix, error) {
+	if bits > a.pool.Addr().BitLen() {
+		return netip.Prefix{}, fmt.Errorf("length %d for a block in %v: %w", bits, a.pool, ErrMaxLenExceeded)
+	}
+	if bits < a.pool.Bits() {
+		return netip.Prefix{}, fmt.Errorf("length %d for a block in %v: %w", bits, a.pool, ErrNotEncompassed)
+	}
+	k := keyFromPrefix(a.pool)
+	free, ok := a.used.tree.firstFree(k, k.len+uint8(bits-a.pool.Bits()))
+	if !ok {
+		return netip.Prefix{}, ErrPoolExhausted
+	}
+	p := free.toPrefix()
+	a.used.Add(p)
+	return p, nil
+}
+
+// Allocate marks p as allocated, so that it won't be returned by
+// [Allocator.AllocateLen]. p must be within the pool, and must not overlap
+// any allocated Prefix.
+func (a *Allocator) Allocate(p netip.Prefix) error {
+	if !p.IsValid() || !a.pool.Contains(p.Addr()) || p.Bits() < a.pool.Bits() {
+		return fmt.Errorf("%w: %v is not within the pool %v", ErrNotEncompassed, p, a.pool)
+	}
+	p = p.Masked()
+	if a.used.tree.overlapsKey(keyFromPrefix(p)) {
+		return fmt.Errorf("%v overlaps an allocated Prefix", p)
+	}
+	return a.used.Add(p)
+}
+
+// Release frees p, which must have been allocated, so that its addresses can
+// be allocated again.
+func (a *Allocator) Release(p netip.Prefix) error {
+	if !p.IsValid() || !a.used.tree.contains(keyFromPrefix(p)) {
+		return fmt.Errorf("%v is not allocated", p)
+	}
+	return a.used.Remove(p)
+}
+
+// Allocated returns the Prefixes currently allocated from the pool.
+func (a *Allocator) Allocated() *PrefixSet {
+	return a.used.PrefixSet()
+}
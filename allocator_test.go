@@ -0,0 +1,110 @@
+package netipds
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAllocatorAllocateLen(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("10.0.0.0/26", "10.0.0.128/27", "10.1.0.0/16", "192.168.0.0/16") {
+		psb.Add(p)
+	}
+	a, err := NewAllocator(pfx("10.0.0.0/24"), psb.PrefixSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		bits int
+		want string
+	}{
+		{26, "10.0.0.64/26"},
+		{28, "10.0.0.160/28"},
+		{27, "10.0.0.192/27"},
+		{27, "10.0.0.224/27"},
+		{30, "10.0.0.176/30"},
+		{32, "10.0.0.180/32"},
+		{27, ""},
+	}
+	for _, s := range steps {
+		got, err := a.AllocateLen(s.bits)
+		if s.want == "" {
+			if !errors.Is(err, ErrPoolExhausted) {
+				t.Errorf("AllocateLen(%d) = %v, %v, want ErrPoolExhausted", s.bits, got, err)
+			}
+			continue
+		}
+		if err != nil || got != pfx(s.want) {
+			t.Errorf("AllocateLen(%d) = %v, %v, want %s", s.bits, got, err, s.want)
+		}
+	}
+
+	// Released blocks are reused
+	if err := a.Release(pfx("10.0.0.64/26")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.AllocateLen(27); err != nil || got != pfx("10.0.0.64/27") {
+		t.Errorf("AllocateLen(27) after Release = %v, %v, want 10.0.0.64/27", got, err)
+	}
+	if err := a.Release(pfx("10.0.0.64/26")); err == nil {
+		t.Errorf("Release of an unallocated Prefix succeeded")
+	}
+	checkPrefixSlice(t, a.Allocated().Prefixes(), pfxs(
+		"10.0.0.0/26", "10.0.0.64/27", "10.0.0.128/27", "10.0.0.160/28",
+		"10.0.0.176/30", "10.0.0.180/32", "10.0.0.192/27", "10.0.0.224/27",
+	))
+
+	for bits, want := range map[int]error{23: ErrNotEncompassed, 33: ErrMaxLenExceeded} {
+		if _, err := a.AllocateLen(bits); !errors.Is(err, want) {
+			t.Errorf("AllocateLen(%d) = %v, want %v", bits, err, want)
+		}
+	}
+
+	// A whole-pool allocation leaves nothing else free
+	a, err = NewAllocator(pfx("0.0.0.0/0"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.AllocateLen(0); err != nil || got != pfx("0.0.0.0/0") {
+		t.Errorf("AllocateLen(0) = %v, %v, want 0.0.0.0/0", got, err)
+	}
+	if got, err := a.AllocateLen(1); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocateLen(1) after AllocateLen(0) = %v, %v, want ErrPoolExhausted", got, err)
+	}
+	// ::/0 can't be tracked as allocated
+	if _, err := NewAllocator(pfx("::/0"), nil); !errors.Is(err, ErrUnsupportedPrefix) {
+		t.Errorf("NewAllocator(::/0) = %v, want %v", err, ErrUnsupportedPrefix)
+	}
+}
+
+func TestAllocatorAllocate(t *testing.T) {
+	a, err := NewAllocator(pfx("2001:db8::/32"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Allocate(pfx("2001:db8::/48")); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pfxs("2001:db8::/56", "2001:db8::/31", "2001:db9::/48") {
+		if err := a.Allocate(p); err == nil {
+			t.Errorf("Allocate(%v) succeeded", p)
+		}
+	}
+	if got, err := a.AllocateLen(48); err != nil || got != pfx("2001:db8:1::/48") {
+		t.Errorf("AllocateLen(48) = %v, %v, want 2001:db8:1::/48", got, err)
+	}
+	if got, err := a.AllocateLen(32); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocateLen(32) = %v, %v, want ErrPoolExhausted", got, err)
+	}
+	if a.Pool() != pfx("2001:db8::/32") {
+		t.Errorf("Pool() = %v", a.Pool())
+	}
+
+	// An allocation encompassing the pool exhausts it
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("10.0.0.0/8"))
+	a, _ = NewAllocator(pfx("10.1.0.0/16"), psb.PrefixSet())
+	if _, err := a.AllocateLen(24); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocateLen(24) = %v, want ErrPoolExhausted", err)
+	}
+}
@@ -0,0 +1,150 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// AddPrefixes adds all of ps to s. It is equivalent to calling Add with each
+// Prefix, but much faster for large inputs: the Prefixes are sorted first, so
+// that the tree can be built in a single pass without searching it.
+//
+// If any Prefix is invalid, an error is returned and s is left unchanged.
+func (s *PrefixSetBuilder) AddPrefixes(ps []netip.Prefix) error {
+	keys, err := keysFromPrefixes(ps)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(keys, key.compare)
+	s.addSorted(slices.Compact(keys))
+	return nil
+}
+
+// keysFromPrefixes returns the keys of ps, or an error if any of them is
+// invalid.
+func keysFromPrefixes(ps []netip.Prefix) ([]key, error) {
+	keys := make([]key, len(ps))
+	for i, p := range ps {
+		if !p.IsValid() {
+			return nil, invalidPrefixError(p)
+		}
+		keys[i] = keyFromPrefix(p)
+	}
+	return keys, nil
+}
+
+// addSorted adds keys, which must be sorted by key.compare and contain no
+// duplicates, to s.
+func (s *PrefixSetBuilder) addSorted(keys []key) {
+	s.own()
+	switch {
+	case s.Lazy:
+		for _, k := range keys {
+			s.tree = *(s.tree.insertLazy(k, true))
+		}
+	case s.tree.isEmpty() && !s.tree.hasEntry:
+		s.tree = *treeFromSorted(keys, func(int) bool { return true })
+	default:
+		s.tree = *s.tree.mergeTree(treeFromSorted(keys, func(int) bool { return true }))
+	}
+	s.lens = countLens(&s.tree)
+}
+
+// SetEntries associates each entry's Value with its Prefix in m. It is
+// equivalent to calling Set with each entry, in order, but much faster for
+// large inputs when m is empty and not lazy: the entries are sorted first, so
+// that the tree can be built in a single pass without searching it. If a
+// Prefix appears more than once, its last entry wins.
+//
+// If any Prefix is invalid, an error is returned and m is left unchanged.
+func (m *PrefixMapBuilder[T]) SetEntries(entries []PrefixEntry[T]) error {
+	type keyEntry struct {
+		k key
+		v T
+	}
+	kes := make([]keyEntry, len(entries))
+	for i, e := range entries {
+		if !e.Prefix.IsValid() {
+			return invalidPrefixError(e.Prefix)
+		}
+		kes[i] = keyEntry{keyFromPrefix(e.Prefix), e.Value}
+	}
+	slices.SortStableFunc(kes, func(a, b keyEntry) int {
+		return a.k.compare(b.k)
+	})
+	// Keep the last of each run of equal keys
+	deduped := kes[:0]
+	for _, ke := range kes {
+		if last := len(deduped) - 1; last >= 0 && deduped[last].k == ke.k {
+			deduped[last] = ke
+		} else {
+			deduped = append(deduped, ke)
+		}
+	}
+	kes = deduped
+
+	if m.Lazy || !m.tree.isEmpty() || m.tree.hasEntry {
+		for _, ke := range kes {
+			m.set(ke.k, ke.v)
+			m.touch(ke.k, time.Now())
+		}
+		return nil
+	}
+
+	m.snapshot()
+	keys := make([]key, len(kes))
+	for i, ke := range kes {
+		keys[i] = ke.k
+	}
+	m.tree = *treeFromSorted(keys, func(i int) T { return kes[i].v })
+	m.lens = countLens(&m.tree)
+	m.times = tree[time.Time]{}
+	if m.TrackTimes {
+		now := time.Now()
+		for _, k := range keys {
+			m.setTime(k, now)
+		}
+	}
+	return nil
+}
+
+// treeFromSorted returns a new compressed tree with an entry for each of keys,
+// whose value is value(i) for keys[i]. keys must be sorted by key.compare and
+// contain no duplicates.
+//
+// Sorted keys arrive in preorder, so each key belongs somewhere along the
+// path to the previous key, and the tree can be built without searching it.
+func treeFromSorted[T any](keys []key, value func(i int) T) *tree[T] {
+	root := &tree[T]{}
+	var path stack[*tree[T]]
+	path.Push(root)
+	for i, k := range keys {
+		// Nodes which aren't ancestors of k are complete
+		for !path.Peek().key.isPrefixOf(k, false) {
+			path.Pop()
+		}
+		parent := path.Peek()
+		if parent.key.equalFromRoot(k) {
+			parent.setValue(value(i))
+			continue
+		}
+		n := newTree[T](k.rest(parent.key.len)).setValue(value(i))
+		child := parent.child(k.bit(parent.key.len))
+		if c := *child; c != nil {
+			// c precedes k but isn't its ancestor, so they diverge below
+			// parent; join them with a new node at their common prefix.
+			common := c.key.commonPrefixLen(k)
+			split := newTree[T](k.truncated(common).rest(parent.key.len))
+			c.key.offset, n.key.offset = common, common
+			*split.child(c.key.bit(common)) = c
+			*split.child(k.bit(common)) = n
+			*child = split
+			path.Push(split)
+		} else {
+			*child = n
+		}
+		path.Push(n)
+	}
+	return root
+}
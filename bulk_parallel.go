@@ -0,0 +1,105 @@
+package netipds
+
+import (
+	"math/bits"
+	"net/netip"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// AddPrefixesParallel is like [PrefixSetBuilder.AddPrefixes], but spreads the
+// work across up to workers goroutines. If workers <= 0, GOMAXPROCS is used.
+//
+// The Prefixes are partitioned by address family and by their leading bits
+// into ranges that are contiguous in tree order, and each partition is sorted
+// concurrently. The partitions are then joined into the tree in a single
+// linear pass. This is worthwhile for large inputs, such as full BGP tables;
+// for small ones, AddPrefixes is faster.
+//
+// If any Prefix is invalid, an error is returned and s is left unchanged.
+func (s *PrefixSetBuilder) AddPrefixesParallel(ps []netip.Prefix, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	keys, err := keysFromPrefixes(ps)
+	if err != nil {
+		return err
+	}
+	if workers == 1 {
+		slices.SortFunc(keys, key.compare)
+		s.addSorted(slices.Compact(keys))
+		return nil
+	}
+
+	// Use several partitions per worker, since they may be unbalanced
+	parts := partitionKeys(keys, uint(min(bits.Len(uint(workers))+2, 16)))
+	next := make(chan int, len(parts))
+	for i := range parts {
+		next <- i
+	}
+	close(next)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				slices.SortFunc(parts[i], key.compare)
+				parts[i] = slices.Compact(parts[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	sorted := keys[:0]
+	for _, part := range parts {
+		sorted = append(sorted, part...)
+	}
+	s.addSorted(sorted)
+	return nil
+}
+
+// partitionKeys splits keys into partitions such that every key in a
+// partition sorts before every key in the following partitions. IPv4 keys and
+// the IPv6 keys above them are each split into 2^b partitions by their
+// leading b bits; the IPv6 keys below the IPv4 range form one partition.
+//
+// The partitions are returned as slices of a single new array.
+func partitionKeys(keys []key, b uint) [][]key {
+	n := numPartitions(b)
+	counts := make([]int, n)
+	for _, k := range keys {
+		counts[partitionOf(k, b)]++
+	}
+	parts := make([][]key, n)
+	buf := make([]key, len(keys))
+	for i, c := range counts {
+		parts[i], buf = buf[:0:c], buf[c:]
+	}
+	for _, k := range keys {
+		i := partitionOf(k, b)
+		parts[i] = append(parts[i], k)
+	}
+	return parts
+}
+
+// numPartitions returns the number of partitions used by partitionOf with b
+// bits.
+func numPartitions(b uint) int {
+	return 1 + 2<<b
+}
+
+// partitionOf returns the index of k's partition, as described in
+// partitionKeys.
+func partitionOf(k key, b uint) int {
+	hi, lo := k.content.hi, k.content.lo
+	switch {
+	case hi == 0 && lo>>32 < 0xffff:
+		return 0
+	case hi == 0 && lo>>32 == 0xffff:
+		return 1 + int(uint32(lo)>>(32-b))
+	default:
+		return 1 + 1<<b + int(hi>>(64-b))
+	}
+}
@@ -0,0 +1,75 @@
+package netipds
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetBuilderAddPrefixesParallel(t *testing.T) {
+	// A mix of IPv4 and IPv6 Prefixes of various lengths, with duplicates and
+	// nesting, spread over the address space
+	var ps []netip.Prefix
+	for i := 0; i < 3000; i++ {
+		x := uint32(i) * 2654435761
+		a4 := netip.AddrFrom4([4]byte{byte(x >> 24), byte(x >> 16), byte(x >> 8), byte(x)})
+		ps = append(ps, netip.PrefixFrom(a4, 8+i%25).Masked())
+		var a16 [16]byte
+		a16[0], a16[1], a16[2], a16[5] = byte(x>>24), byte(x>>16), byte(x>>8), byte(x)
+		if i%7 == 0 {
+			// Below the IPv4 range
+			a16[0], a16[1], a16[2] = 0, 0, 0
+		}
+		ps = append(ps, netip.PrefixFrom(netip.AddrFrom16(a16), 16+i%113).Masked())
+	}
+	want := &PrefixSetBuilder{}
+	want.Add(pfx("1.2.3.0/24"))
+	for _, p := range ps {
+		want.Add(p)
+	}
+	wantPrefixes := want.PrefixSet().Prefixes()
+	for _, workers := range []int{0, 1, 3, 8} {
+		got := &PrefixSetBuilder{}
+		got.Add(pfx("1.2.3.0/24"))
+		if err := got.AddPrefixesParallel(ps, workers); err != nil {
+			t.Fatal(err)
+		}
+		gs := got.PrefixSet()
+		checkPrefixSlice(t, gs.Prefixes(), wantPrefixes)
+		if gs.Size() != len(wantPrefixes) {
+			t.Errorf("workers=%d: Size() = %d, want %d", workers, gs.Size(), len(wantPrefixes))
+		}
+	}
+
+	// Non-empty builders, including ones whose Prefixes diverge from the
+	// added ones below their common ancestor
+	r := rand.New(rand.NewSource(1))
+	nonEmpty := [][2][]netip.Prefix{
+		{pfxs("10.0.0.128/27"), pfxs("10.0.0.160/29", "10.0.0.168/29")},
+		{pfxs("10.0.0.128/27", "2001:db8::/48"), pfxs("10.0.0.160/29", "2001:db8:1::/48", "1.2.3.0/24")},
+	}
+	for i := 0; i < 50; i++ {
+		nonEmpty = append(nonEmpty, [2][]netip.Prefix{randomPrefixes(r, 1+r.Intn(8)), randomPrefixes(r, 1+r.Intn(8))})
+	}
+	for _, tt := range nonEmpty {
+		initial, add := tt[0], tt[1]
+		want := &PrefixSetBuilder{}
+		for _, p := range append(append([]netip.Prefix{}, initial...), add...) {
+			want.Add(p)
+		}
+		for _, workers := range []int{1, 3} {
+			got := &PrefixSetBuilder{}
+			for _, p := range initial {
+				got.Add(p)
+			}
+			if err := got.AddPrefixesParallel(add, workers); err != nil {
+				t.Fatal(err)
+			}
+			checkPrefixSlice(t, got.PrefixSet().Prefixes(), want.PrefixSet().Prefixes())
+		}
+	}
+
+	if err := (&PrefixSetBuilder{}).AddPrefixesParallel([]netip.Prefix{{}}, 2); err == nil {
+		t.Errorf("AddPrefixesParallel(invalid) returned nil error")
+	}
+}
@@ -0,0 +1,167 @@
+package netipds
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetBuilderAddPrefixes(t *testing.T) {
+	tests := []struct {
+		initial []netip.Prefix
+		add     []netip.Prefix
+	}{
+		{pfxs(), pfxs()},
+		{pfxs(), pfxs("1.2.3.0/24")},
+		{pfxs(), pfxs("1.2.3.4/32", "1.2.3.0/24", "1.2.0.0/16", "1.2.3.4/32")},
+		{pfxs(), pfxs("::1/128", "::/127", "::2/127", "::3/128", "1.2.3.0/24")},
+		{pfxs(), pfxs("2001:db8::/32", "1.2.3.128/25", "1.2.3.0/25", "10.0.0.0/8", "::1/128")},
+		{pfxs(), pfxs("1.2.3.5/32", "1.2.3.6/32", "1.2.3.4/32", "1.2.3.7/32", "1.2.3.4/30")},
+		{pfxs("1.2.0.0/16", "::1/128"), pfxs("1.2.3.0/24", "1.3.0.0/16", "::1/128")},
+		// The added Prefixes diverge from an existing one below their parent
+		{pfxs("10.0.0.128/27"), pfxs("10.0.0.160/29", "10.0.0.168/29")},
+	}
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			want := &PrefixSetBuilder{}
+			got := &PrefixSetBuilder{Lazy: lazy}
+			for _, p := range tt.initial {
+				want.Add(p)
+				got.Add(p)
+			}
+			for _, p := range tt.add {
+				want.Add(p)
+			}
+			if err := got.AddPrefixes(tt.add); err != nil {
+				t.Fatal(err)
+			}
+			// Adding individually afterwards must still work
+			want.Add(pfx("1.2.3.4/31"))
+			got.Add(pfx("1.2.3.4/31"))
+
+			ws, gs := want.PrefixSet(), got.PrefixSet()
+			checkPrefixSlice(t, gs.Prefixes(), ws.Prefixes())
+			if gs.Size() != ws.Size() {
+				t.Errorf("AddPrefixes(%v): Size() = %d, want %d", tt.add, gs.Size(), ws.Size())
+			}
+			for _, p := range pfxs("1.2.3.0/24", "1.2.3.4/32", "1.2.3.5/32", "::1/128", "::3/128") {
+				if gs.Encompasses(p) != ws.Encompasses(p) {
+					t.Errorf("AddPrefixes(%v): Encompasses(%v) = %v, want %v",
+						tt.add, p, gs.Encompasses(p), ws.Encompasses(p))
+				}
+			}
+		}
+	}
+
+	// Random Prefixes added to non-empty builders
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		initial, add := randomPrefixes(r, 1+r.Intn(8)), randomPrefixes(r, 1+r.Intn(8))
+		want, got := &PrefixSetBuilder{}, &PrefixSetBuilder{}
+		for _, p := range initial {
+			want.Add(p)
+			got.Add(p)
+		}
+		for _, p := range add {
+			want.Add(p)
+		}
+		got.AddPrefixes(add)
+		checkPrefixSlice(t, got.PrefixSet().Prefixes(), want.PrefixSet().Prefixes())
+	}
+
+	// Invalid Prefixes leave the builder unchanged
+	psb := &PrefixSetBuilder{}
+	if err := psb.AddPrefixes([]netip.Prefix{pfx("1.2.3.0/24"), {}}); err == nil {
+		t.Errorf("AddPrefixes(invalid) returned nil error")
+	}
+	if !psb.IsEmpty() {
+		t.Errorf("AddPrefixes(invalid) modified the builder")
+	}
+}
+
+func TestPrefixMapBuilderSetEntries(t *testing.T) {
+	entries := []PrefixEntry[int]{
+		{pfx("1.2.3.0/24"), 1},
+		{pfx("1.2.0.0/16"), 2},
+		{pfx("2001:db8::/32"), 3},
+		{pfx("1.2.3.0/24"), 4},
+		{pfx("1.2.3.128/25"), 5},
+		{pfx("::1/128"), 6},
+	}
+	want := map[netip.Prefix]int{
+		pfx("1.2.0.0/16"):    2,
+		pfx("1.2.3.0/24"):    4,
+		pfx("1.2.3.128/25"):  5,
+		pfx("2001:db8::/32"): 3,
+		pfx("::1/128"):       6,
+	}
+	for _, lazy := range []bool{false, true} {
+		pmb := &PrefixMapBuilder[int]{Lazy: lazy, TrackTimes: true}
+		if err := pmb.SetEntries(entries); err != nil {
+			t.Fatal(err)
+		}
+		pm := pmb.PrefixMap()
+		checkMap(t, want, pm.ToMap())
+		if pm.Size() != len(want) {
+			t.Errorf("Size() = %d, want %d", pm.Size(), len(want))
+		}
+		if _, tm, _ := pm.GetWithTime(pfx("1.2.3.0/24")); tm.IsZero() {
+			t.Errorf("SetEntries didn't record entry times")
+		}
+		if p, v, ok := pm.Lookup(netip.MustParseAddr("1.2.3.200")); p != pfx("1.2.3.128/25") || v != 5 || !ok {
+			t.Errorf("Lookup(1.2.3.200) = (%v, %v, %v)", p, v, ok)
+		}
+
+		// Setting entries in a non-empty builder overrides existing values
+		pmb.SetEntries([]PrefixEntry[int]{{pfx("1.2.0.0/16"), 7}, {pfx("10.0.0.0/8"), 8}})
+		pm = pmb.PrefixMap()
+		if v, _ := pm.Get(pfx("1.2.0.0/16")); v != 7 {
+			t.Errorf("Get(1.2.0.0/16) = %v, want 7", v)
+		}
+		if pm.Size() != len(want)+1 {
+			t.Errorf("Size() = %d, want %d", pm.Size(), len(want)+1)
+		}
+	}
+	if err := (&PrefixMapBuilder[int]{}).SetEntries([]PrefixEntry[int]{{}}); err == nil {
+		t.Errorf("SetEntries(invalid) returned nil error")
+	}
+}
+
+func TestTreeFromSorted(t *testing.T) {
+	// The tree must have the same structure as one built by insertion, with
+	// consistent offsets.
+	ps := pfxs("::1/128", "::2/127", "1.2.0.0/16", "1.2.3.0/24", "1.2.3.128/25", "1.3.0.0/16", "2001:db8::/32")
+	keys := make([]key, len(ps))
+	want := &tree[bool]{}
+	for i, p := range ps {
+		keys[i] = keyFromPrefix(p)
+		want = want.insert(keys[i], true)
+	}
+	got := treeFromSorted(keys, func(int) bool { return true })
+	var check func(a, b *tree[bool], parentLen uint8)
+	check = func(a, b *tree[bool], parentLen uint8) {
+		if (a == nil) != (b == nil) {
+			t.Fatalf("got node %v, want %v", a, b)
+		}
+		if a == nil {
+			return
+		}
+		if !a.key.equalFromRoot(b.key) || a.hasEntry != b.hasEntry || a.key.offset != parentLen {
+			t.Fatalf("got node %v (offset %d), want %v (offset %d)", a.key, a.key.offset, b.key, parentLen)
+		}
+		check(a.left, b.left, a.key.len)
+		check(a.right, b.right, a.key.len)
+	}
+	check(got, want, 0)
+}
+
+// randomPrefixes returns n random Prefixes within 10.0.0.0/24, which are
+// likely to nest and to diverge from each other at various depths.
+func randomPrefixes(r *rand.Rand, n int) []netip.Prefix {
+	ps := make([]netip.Prefix, n)
+	for i := range ps {
+		a := netip.AddrFrom4([4]byte{10, 0, 0, byte(r.Intn(256))})
+		ps[i] = netip.PrefixFrom(a, 24+r.Intn(9)).Masked()
+	}
+	return ps
+}
@@ -0,0 +1,424 @@
+package netipds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// The CBOR (RFC 8949) format written by MarshalCBOR is, in CDDL:
+//
+//	root  = [version: 1, node]
+//	node  = [len: uint .le 128, content: bstr, left: node / null,
+//	         right: node / null, ? value: any]
+//
+// Each node is one node of the underlying radix tree, so the structure of the
+// tree is reproduced exactly. len is the length of the node's key in bits,
+// and content holds the ceil(len/8) most-significant bytes of the key, as in
+// key.appendBinary. Keys are 128 bits long; IPv4 Prefixes are represented
+// within the IPv4-mapped range ::ffff:0:0/96. The value is present only if
+// the node has an entry; for a PrefixSet, it is always true.
+//
+// The encoding is deterministic, following the core requirements of RFC 8949
+// section 4.2.1: integers and lengths use their shortest forms, and
+// indefinite lengths are never used.
+const cborVersion = 1
+
+const (
+	cborUint  byte = 0
+	cborNeg   byte = 1
+	cborBytes byte = 2
+	cborText  byte = 3
+	cborArray byte = 4
+	cborMap   byte = 5
+	cborTag   byte = 6
+	cborOther byte = 7
+)
+
+const (
+	cborFalse byte = 0xf4
+	cborTrue  byte = 0xf5
+	cborNull  byte = 0xf6
+)
+
+// CBORMarshaler is implemented by PrefixMap value types that encode
+// themselves as CBOR. MarshalCBOR must return a single, well-formed CBOR data
+// item, and should use deterministic encoding if the encoding of the
+// PrefixMap is expected to be deterministic.
+//
+// The method set matches that of common CBOR libraries, so types which
+// already support them need no changes.
+type CBORMarshaler interface {
+	MarshalCBOR() ([]byte, error)
+}
+
+// CBORUnmarshaler is implemented by PrefixMap value types that decode
+// themselves from CBOR. UnmarshalCBOR is called with a single data item.
+type CBORUnmarshaler interface {
+	UnmarshalCBOR([]byte) error
+}
+
+// MarshalCBOR returns a deterministic CBOR encoding of s, which reproduces
+// the structure of s's tree.
+func (s *PrefixSet) MarshalCBOR() ([]byte, error) {
+	return appendCBORRoot(nil, &s.tree, func(b []byte, _ bool) ([]byte, error) {
+		return append(b, cborTrue), nil
+	})
+}
+
+// UnmarshalCBOR replaces the contents of s with a PrefixSet decoded from
+// data, which must have been produced by [PrefixSet.MarshalCBOR].
+func (s *PrefixSet) UnmarshalCBOR(data []byte) error {
+	t, err := treeFromCBOR(data, func(b []byte, v *bool) error {
+		if len(b) != 1 || b[0] != cborTrue {
+			return fmt.Errorf("PrefixSet entry is not true")
+		}
+		*v = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*s = PrefixSet{*t, t.stats()}
+	return nil
+}
+
+// MarshalCBOR returns a deterministic CBOR encoding of m, which reproduces
+// the structure of m's tree.
+//
+// If T implements [CBORMarshaler], values are encoded with its MarshalCBOR
+// method, and *T must implement [CBORUnmarshaler] to decode them. Otherwise,
+// T's underlying type must be a bool, an integer type, a string, or a
+// []byte, which are encoded as the corresponding CBOR types.
+//
+// Entry times (see [PrefixMapBuilder.TrackTimes]) are not encoded.
+func (m *PrefixMap[T]) MarshalCBOR() ([]byte, error) {
+	return appendCBORRoot(nil, &m.tree, appendCBORValue[T])
+}
+
+// UnmarshalCBOR replaces the contents of m with a PrefixMap decoded from
+// data, which must have been produced by [PrefixMap.MarshalCBOR] for the same
+// type T.
+func (m *PrefixMap[T]) UnmarshalCBOR(data []byte) error {
+	t, err := treeFromCBOR(data, cborValue[T])
+	if err != nil {
+		return err
+	}
+	*m = PrefixMap[T]{*t, t.stats(), nil}
+	return nil
+}
+
+// appendCBORHead appends the head of a data item with the provided major type
+// and argument to b, in its shortest form.
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= 0xff:
+		return append(b, major|24, byte(arg))
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), arg)
+	}
+}
+
+// cborHead decodes the head of the data item at the start of b, returning its
+// major type, its argument, and the length of the head.
+func cborHead(b []byte) (major byte, arg uint64, n int, err error) {
+	if len(b) < 1 {
+		return 0, 0, 0, errTruncated
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), 1, nil
+	case info <= 27:
+		n = 1 + 1<<(info-24)
+		if len(b) < n {
+			return 0, 0, 0, errTruncated
+		}
+		var a8 [8]byte
+		copy(a8[8-(n-1):], b[1:n])
+		return major, binary.BigEndian.Uint64(a8[:]), n, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("unsupported additional information %d", info)
+	}
+}
+
+// cborItemLen returns the length of the data item at the start of b.
+func cborItemLen(b []byte) (int, error) {
+	major, arg, n, err := cborHead(b)
+	if err != nil {
+		return 0, err
+	}
+	var items uint64
+	switch major {
+	case cborBytes, cborText:
+		if uint64(len(b)-n) < arg {
+			return 0, errTruncated
+		}
+		return n + int(arg), nil
+	case cborArray:
+		items = arg
+	case cborMap:
+		items = 2 * arg
+	case cborTag:
+		items = 1
+	default:
+		return n, nil
+	}
+	// Each item is at least one byte
+	if items > uint64(len(b)-n) {
+		return 0, errTruncated
+	}
+	for ; items > 0; items-- {
+		l, err := cborItemLen(b[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += l
+	}
+	return n, nil
+}
+
+// appendCBORRoot appends the encoding of t to b, using value to append the
+// value of each entry.
+func appendCBORRoot[T any](
+	b []byte,
+	t *tree[T],
+	value func([]byte, T) ([]byte, error),
+) ([]byte, error) {
+	b = appendCBORHead(b, cborArray, 2)
+	b = appendCBORHead(b, cborUint, cborVersion)
+	return appendCBORNode(b, t, value)
+}
+
+// appendCBORNode appends the encoding of the subtree rooted at t to b.
+func appendCBORNode[T any](
+	b []byte,
+	t *tree[T],
+	value func([]byte, T) ([]byte, error),
+) ([]byte, error) {
+	fields := uint64(4)
+	if t.hasEntry {
+		fields++
+	}
+	b = appendCBORHead(b, cborArray, fields)
+	b = appendCBORHead(b, cborUint, uint64(t.key.len))
+	content := t.key.rooted().appendBinary(nil)[1:]
+	b = append(appendCBORHead(b, cborBytes, uint64(len(content))), content...)
+	for _, c := range []*tree[T]{t.left, t.right} {
+		if c == nil {
+			b = append(b, cborNull)
+			continue
+		}
+		var err error
+		if b, err = appendCBORNode(b, c, value); err != nil {
+			return nil, err
+		}
+	}
+	if t.hasEntry {
+		return value(b, t.value)
+	}
+	return b, nil
+}
+
+// treeFromCBOR decodes a tree encoded by appendCBORRoot from b, using value
+// to decode the value of each entry from its data item.
+func treeFromCBOR[T any](b []byte, value func([]byte, *T) error) (*tree[T], error) {
+	major, arg, n, err := cborHead(b)
+	if err != nil || major != cborArray || arg != 2 {
+		return nil, fmt.Errorf("invalid CBOR encoding: bad header")
+	}
+	b = b[n:]
+	major, arg, n, err = cborHead(b)
+	if err != nil || major != cborUint {
+		return nil, fmt.Errorf("invalid CBOR encoding: bad version")
+	}
+	if arg != cborVersion {
+		return nil, fmt.Errorf("unsupported CBOR encoding version %d", arg)
+	}
+	b = b[n:]
+	t, n, err := cborNode(b, nil, bitL, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode tree: %w", err)
+	}
+	if len(b) > n {
+		return nil, fmt.Errorf("%d trailing bytes after tree", len(b)-n)
+	}
+	return t, nil
+}
+
+// cborNode decodes the node encoded at the start of b, and its subtree,
+// checking that it is a valid child of parent on the provided side. It
+// returns the node and the length of its encoding.
+func cborNode[T any](
+	b []byte,
+	parent *key,
+	side bit,
+	value func([]byte, *T) error,
+) (*tree[T], int, error) {
+	major, fields, n, err := cborHead(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if major != cborArray || (fields != 4 && fields != 5) {
+		return nil, 0, fmt.Errorf("invalid CBOR encoding: node is not an array of 4 or 5 items")
+	}
+	major, l, m, err := cborHead(b[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if major != cborUint || l > 128 {
+		return nil, 0, fmt.Errorf("invalid CBOR encoding: bad key length")
+	}
+	n += m
+	major, cl, m, err := cborHead(b[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if major != cborBytes || cl != (l+7)/8 {
+		return nil, 0, fmt.Errorf("invalid CBOR encoding: bad key content")
+	}
+	n += m
+	if len(b)-n < int(cl) {
+		return nil, 0, errTruncated
+	}
+	var a16 [16]byte
+	copy(a16[:], b[n:n+int(cl)])
+	n += int(cl)
+	k := newKey(u128From16(a16), 0, uint8(l))
+	if parent != nil {
+		if !parent.isPrefixOf(k, true) || k.bit(parent.len) != side {
+			return nil, 0, fmt.Errorf("invalid CBOR encoding: %v is not a valid child of %v",
+				k, *parent)
+		}
+		k.offset = parent.len
+	}
+
+	t := newTree[T](k)
+	for _, side := range eachBit {
+		if n >= len(b) {
+			return nil, 0, errTruncated
+		}
+		if b[n] == cborNull {
+			n++
+			continue
+		}
+		c, m, err := cborNode(b[n:], &k, side, value)
+		if err != nil {
+			return nil, 0, err
+		}
+		*t.child(side) = c
+		n += m
+	}
+	if fields == 5 {
+		m, err := cborItemLen(b[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		var v T
+		if err := value(b[n:n+m], &v); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode value of %v: %w", k.toPrefix(), err)
+		}
+		t.setValue(v)
+		n += m
+	}
+	return t, n, nil
+}
+
+// appendCBORValue appends the encoding of v to b. See [PrefixMap.MarshalCBOR].
+func appendCBORValue[T any](b []byte, v T) ([]byte, error) {
+	if cm, ok := any(v).(CBORMarshaler); ok {
+		vb, err := cm.MarshalCBOR()
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value: %w", err)
+		}
+		if n, err := cborItemLen(vb); err != nil || n != len(vb) {
+			return nil, fmt.Errorf("MarshalCBOR returned an invalid data item")
+		}
+		return append(b, vb...), nil
+	}
+	rv := reflect.ValueOf(&v).Elem()
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return append(b, cborTrue), nil
+		}
+		return append(b, cborFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := rv.Int(); i < 0 {
+			return appendCBORHead(b, cborNeg, uint64(-1-i)), nil
+		}
+		return appendCBORHead(b, cborUint, uint64(rv.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendCBORHead(b, cborUint, rv.Uint()), nil
+	case reflect.String:
+		return append(appendCBORHead(b, cborText, uint64(rv.Len())), rv.String()...), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return append(appendCBORHead(b, cborBytes, uint64(rv.Len())), rv.Bytes()...), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported value type %T: implement CBORMarshaler", v)
+}
+
+// cborValue decodes the data item b into v. See [PrefixMap.UnmarshalCBOR].
+func cborValue[T any](b []byte, v *T) error {
+	if cu, ok := any(v).(CBORUnmarshaler); ok {
+		return cu.UnmarshalCBOR(b)
+	}
+	rv := reflect.ValueOf(v).Elem()
+	major, arg, n, err := cborHead(b)
+	if err != nil {
+		return err
+	}
+	mismatch := fmt.Errorf("cannot decode CBOR major type %d into %T", major, *v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		if b[0] != cborTrue && b[0] != cborFalse {
+			return mismatch
+		}
+		rv.SetBool(b[0] == cborTrue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if (major != cborUint && major != cborNeg) || arg > 1<<63-1 {
+			return mismatch
+		}
+		i := int64(arg)
+		if major == cborNeg {
+			i = -1 - i
+		}
+		if rv.OverflowInt(i) {
+			return fmt.Errorf("value %d overflows %T", i, *v)
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if major != cborUint {
+			return mismatch
+		}
+		if rv.OverflowUint(arg) {
+			return fmt.Errorf("value %d overflows %T", arg, *v)
+		}
+		rv.SetUint(arg)
+	case reflect.String:
+		if major != cborText {
+			return mismatch
+		}
+		rv.SetString(string(b[n:]))
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported value type %T: implement CBORUnmarshaler", *v)
+		}
+		if major != cborBytes {
+			return mismatch
+		}
+		rv.SetBytes(append([]byte{}, b[n:]...))
+	default:
+		return fmt.Errorf("unsupported value type %T: implement CBORUnmarshaler", *v)
+	}
+	return nil
+}
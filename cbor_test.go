@@ -0,0 +1,169 @@
+package netipds
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPrefixSetMarshalCBOR(t *testing.T) {
+	tests := [][]netip.Prefix{
+		pfxs(),
+		pfxs("::/128"),
+		pfxs("1.2.3.0/24"),
+		pfxs("1.2.3.0/24", "1.2.3.4/32", "1.2.0.0/16", "::1/128", "2001:db8::/32"),
+		pfxs("::0/128", "::1/128", "::2/127", "8000::/1"),
+	}
+	for _, set := range tests {
+		for _, lazy := range []bool{false, true} {
+			psb := &PrefixSetBuilder{Lazy: lazy}
+			for _, p := range set {
+				psb.Add(p)
+			}
+			ps := psb.PrefixSet()
+			b, err := ps.MarshalCBOR()
+			if err != nil {
+				t.Fatalf("MarshalCBOR(%v): %v", set, err)
+			}
+			var got PrefixSet
+			if err := got.UnmarshalCBOR(b); err != nil {
+				t.Fatalf("UnmarshalCBOR(%v): %v", set, err)
+			}
+			checkPrefixSlice(t, got.Prefixes(), ps.Prefixes())
+			if got.Size() != ps.Size() {
+				t.Errorf("got Size() %d, want %d", got.Size(), ps.Size())
+			}
+			// The structure is preserved
+			if gotB, _ := got.MarshalCBOR(); !bytes.Equal(gotB, b) {
+				t.Errorf("got tree\n%s\nwant\n%s", got.String(), ps.String())
+			}
+		}
+	}
+
+	// [1, [0, h'', null, null]]
+	b, _ := (&PrefixSet{}).MarshalCBOR()
+	if got, want := hex.EncodeToString(b), "8201840040f6f6"; got != want {
+		t.Errorf("MarshalCBOR(empty) = %s, want %s", got, want)
+	}
+}
+
+// cborString implements CBORMarshaler and CBORUnmarshaler, encoding itself as
+// an upper-case text string.
+type cborString struct{ s string }
+
+func (v cborString) MarshalCBOR() ([]byte, error) {
+	return append(appendCBORHead(nil, cborText, uint64(len(v.s))), strings.ToUpper(v.s)...), nil
+}
+
+func (v *cborString) UnmarshalCBOR(b []byte) error {
+	_, _, n, err := cborHead(b)
+	v.s = strings.ToLower(string(b[n:]))
+	return err
+}
+
+func testMarshalCBORMap[T comparable](t *testing.T, entries map[netip.Prefix]T) {
+	pmb := &PrefixMapBuilder[T]{}
+	for p, v := range entries {
+		pmb.Set(p, v)
+	}
+	pm := pmb.PrefixMap()
+	b, err := pm.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PrefixMap[T]
+	if err := got.UnmarshalCBOR(b); err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, entries, got.ToMap())
+	if gotB, _ := got.MarshalCBOR(); !bytes.Equal(gotB, b) {
+		t.Errorf("MarshalCBOR is not deterministic")
+	}
+}
+
+func TestPrefixMapMarshalCBOR(t *testing.T) {
+	testMarshalCBORMap(t, map[netip.Prefix]string{
+		pfx("1.2.0.0/16"):    "hello",
+		pfx("1.2.3.0/24"):    "",
+		pfx("2001:db8::/32"): strings.Repeat("x", 300),
+	})
+	testMarshalCBORMap(t, map[netip.Prefix]int64{
+		pfx("1.2.0.0/16"): -1 << 63,
+		pfx("1.2.3.0/24"): -24,
+		pfx("1.2.3.4/32"): 1<<63 - 1,
+		pfx("::1/128"):    0,
+	})
+	testMarshalCBORMap(t, map[netip.Prefix]uint8{pfx("1.2.0.0/16"): 255, pfx("::1/128"): 23})
+	testMarshalCBORMap(t, map[netip.Prefix]bool{pfx("1.2.0.0/16"): false, pfx("::1/128"): true})
+	testMarshalCBORMap(t, map[netip.Prefix]cborString{pfx("1.2.0.0/16"): {"a"}, pfx("::1/128"): {"b"}})
+
+	pmb := &PrefixMapBuilder[[]byte]{}
+	pmb.Set(pfx("1.2.0.0/16"), []byte{1, 2, 3})
+	b, err := pmb.PrefixMap().MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotBytes PrefixMap[[]byte]
+	if err := gotBytes.UnmarshalCBOR(b); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := gotBytes.Get(pfx("1.2.0.0/16")); !bytes.Equal(v, []byte{1, 2, 3}) {
+		t.Errorf("got %v, want [1 2 3]", v)
+	}
+
+	// Values must fit the decoded type
+	var gotSmall PrefixMap[int8]
+	big := &PrefixMapBuilder[int]{}
+	big.Set(pfx("1.2.0.0/16"), 128)
+	b, _ = big.PrefixMap().MarshalCBOR()
+	if err := gotSmall.UnmarshalCBOR(b); err == nil {
+		t.Errorf("UnmarshalCBOR of an overflowing value succeeded")
+	}
+	var ps PrefixSet
+	if err := ps.UnmarshalCBOR(b); err == nil {
+		t.Errorf("PrefixSet.UnmarshalCBOR of a PrefixMap succeeded")
+	}
+
+	// Unsupported value types are rejected
+	fm := &PrefixMapBuilder[float64]{}
+	fm.Set(pfx("1.2.0.0/16"), 1.5)
+	if _, err := fm.PrefixMap().MarshalCBOR(); err == nil {
+		t.Errorf("MarshalCBOR of float64 values succeeded")
+	}
+}
+
+func TestUnmarshalCBORInvalid(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("1.2.3.0/24"))
+	psb.Add(pfx("1.2.4.0/24"))
+	b, _ := psb.PrefixSet().MarshalCBOR()
+
+	corrupt := func(i int, c byte) []byte {
+		ret := append([]byte(nil), b...)
+		ret[i] = c
+		return ret
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad header", corrupt(0, 0x83)},
+		{"bad version", corrupt(1, 0x02)},
+		{"not an array", corrupt(2, 0xf6)},
+		{"truncated", b[:len(b)-1]},
+		{"trailing bytes", append(append([]byte(nil), b...), 0)},
+		{"entry not true", corrupt(len(b)-1, cborFalse)},
+		// Flip a bit in the last node's key so it no longer descends from
+		// its parent
+		{"bad child", corrupt(len(b)-5, b[len(b)-5]^0x80)},
+	}
+	for _, tt := range tests {
+		var ps PrefixSet
+		if err := ps.UnmarshalCBOR(tt.data); err == nil {
+			t.Errorf("%s: UnmarshalCBOR succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package cloudranges loads the IP address ranges published by cloud
+// providers into [netipds.PrefixMap]s of [ServiceTag]s, for classifying
+// traffic to and from cloud services.
+//
+// The supported documents are:
+//   - AWS: ip-ranges.json, from https://ip-ranges.amazonaws.com/ip-ranges.json
+//   - Azure: the Service Tags JSON file (ServiceTags_Public_*.json), from the
+//     Microsoft Download Center or the Service Tag Discovery API
+//   - GCP: cloud.json, from https://www.gstatic.com/ipranges/cloud.json
+package cloudranges
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/aromatt/netipds"
+)
+
+// Cloud providers, as used in [ServiceTag.Provider].
+const (
+	AWS   = "aws"
+	Azure = "azure"
+	GCP   = "gcp"
+)
+
+// ServiceTag describes the cloud services using a Prefix.
+type ServiceTag struct {
+	// Provider is one of AWS, Azure or GCP.
+	Provider string
+	// Region is the provider's name for the region the Prefix is used in,
+	// such as "us-east-1" or "eastus", or empty if the Prefix is not
+	// associated with a region. AWS uses "GLOBAL" for some Prefixes.
+	Region string
+	// Services lists the provider's names for the services using the Prefix,
+	// such as "EC2" or "Storage", sorted and without duplicates. Providers
+	// often list a Prefix under a general service (e.g. "AMAZON" or
+	// "AzureCloud") as well as a more specific one.
+	Services []string
+}
+
+// tagger accumulates ServiceTags for the Prefixes listed in a document. A
+// Prefix listed more than once is given the union of its services, and the
+// first non-empty region listed for it.
+type tagger struct {
+	provider string
+	pmb      netipds.PrefixMapBuilder[ServiceTag]
+}
+
+func (t *tagger) add(prefix, region, service string) error {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.provider, err)
+	}
+	p = p.Masked()
+	tag, ok := t.pmb.Get(p)
+	if !ok {
+		tag = ServiceTag{Provider: t.provider}
+	}
+	if tag.Region == "" {
+		tag.Region = region
+	}
+	if service != "" {
+		if i, found := slices.BinarySearch(tag.Services, service); !found {
+			tag.Services = slices.Insert(slices.Clip(tag.Services), i, service)
+		}
+	}
+	return t.pmb.Set(p, tag)
+}
+
+// ReadAWS reads an AWS ip-ranges.json document from r, and returns a map of
+// each Prefix in it to its ServiceTag.
+func ReadAWS(r io.Reader) (*netipds.PrefixMap[ServiceTag], error) {
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", AWS, err)
+	}
+	t := &tagger{provider: AWS}
+	for _, e := range doc.Prefixes {
+		if err := t.add(e.IPPrefix, e.Region, e.Service); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range doc.IPv6Prefixes {
+		if err := t.add(e.IPv6Prefix, e.Region, e.Service); err != nil {
+			return nil, err
+		}
+	}
+	return t.pmb.PrefixMap(), nil
+}
+
+// ReadAzure reads an Azure Service Tags JSON document from r, and returns a
+// map of each Prefix in it to its ServiceTag. The service of each tag is its
+// name up to the first '.', so that regional tags such as "Storage.EastUS"
+// are reported as "Storage".
+func ReadAzure(r io.Reader) (*netipds.PrefixMap[ServiceTag], error) {
+	var doc struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", Azure, err)
+	}
+	t := &tagger{provider: Azure}
+	for _, v := range doc.Values {
+		service, _, _ := strings.Cut(v.Name, ".")
+		for _, p := range v.Properties.AddressPrefixes {
+			if err := t.add(p, v.Properties.Region, service); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t.pmb.PrefixMap(), nil
+}
+
+// ReadGCP reads a GCP cloud.json document from r, and returns a map of each
+// Prefix in it to its ServiceTag. The region of each tag is the "scope" of
+// its Prefix.
+func ReadGCP(r io.Reader) (*netipds.PrefixMap[ServiceTag], error) {
+	var doc struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", GCP, err)
+	}
+	t := &tagger{provider: GCP}
+	for _, e := range doc.Prefixes {
+		p := e.IPv4Prefix
+		if p == "" {
+			p = e.IPv6Prefix
+		}
+		if err := t.add(p, e.Scope, e.Service); err != nil {
+			return nil, err
+		}
+	}
+	return t.pmb.PrefixMap(), nil
+}
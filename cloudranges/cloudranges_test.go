@@ -0,0 +1,123 @@
+package cloudranges
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aromatt/netipds"
+)
+
+func checkTags(t *testing.T, m *netipds.PrefixMap[ServiceTag], want map[string]ServiceTag) {
+	t.Helper()
+	got := m.ToMap()
+	if len(got) != len(want) {
+		t.Errorf("got %d Prefixes, want %d: %v", len(got), len(want), got)
+	}
+	for s, tag := range want {
+		if g := got[netip.MustParsePrefix(s)]; !reflect.DeepEqual(g, tag) {
+			t.Errorf("%s: got %+v, want %+v", s, g, tag)
+		}
+	}
+}
+
+func TestReadAWS(t *testing.T) {
+	m, err := ReadAWS(strings.NewReader(`{
+  "syncToken": "1700000000",
+  "createDate": "2023-11-14-22-13-20",
+  "prefixes": [
+    {"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON", "network_border_group": "ap-northeast-2"},
+    {"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "S3", "network_border_group": "ap-northeast-2"},
+    {"ip_prefix": "13.34.37.64/27", "region": "ap-southeast-4", "service": "AMAZON", "network_border_group": "ap-southeast-4"},
+    {"ip_prefix": "52.94.76.0/22", "region": "us-west-2", "service": "EC2", "network_border_group": "us-west-2"},
+    {"ip_prefix": "52.94.76.0/22", "region": "us-west-2", "service": "AMAZON", "network_border_group": "us-west-2"}
+  ],
+  "ipv6_prefixes": [
+    {"ipv6_prefix": "2600:1f14::/35", "region": "us-west-2", "service": "EC2", "network_border_group": "us-west-2"}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, m, map[string]ServiceTag{
+		"3.5.140.0/22":   {AWS, "ap-northeast-2", []string{"AMAZON", "S3"}},
+		"13.34.37.64/27": {AWS, "ap-southeast-4", []string{"AMAZON"}},
+		"52.94.76.0/22":  {AWS, "us-west-2", []string{"AMAZON", "EC2"}},
+		"2600:1f14::/35": {AWS, "us-west-2", []string{"EC2"}},
+	})
+}
+
+func TestReadAzure(t *testing.T) {
+	m, err := ReadAzure(strings.NewReader(`{
+  "changeNumber": 1,
+  "cloud": "Public",
+  "values": [
+    {
+      "name": "AzureCloud",
+      "id": "AzureCloud",
+      "properties": {"region": "", "systemService": "", "addressPrefixes": ["13.64.0.0/16", "20.38.0.0/20"]}
+    },
+    {
+      "name": "AzureCloud.eastus",
+      "id": "AzureCloud.eastus",
+      "properties": {"region": "eastus", "systemService": "", "addressPrefixes": ["20.38.0.0/20"]}
+    },
+    {
+      "name": "Storage.EastUS",
+      "id": "Storage.EastUS",
+      "properties": {"region": "eastus", "systemService": "AzureStorage", "addressPrefixes": ["20.38.0.0/20", "2603:1030::/45"]}
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, m, map[string]ServiceTag{
+		"13.64.0.0/16":   {Azure, "", []string{"AzureCloud"}},
+		"20.38.0.0/20":   {Azure, "eastus", []string{"AzureCloud", "Storage"}},
+		"2603:1030::/45": {Azure, "eastus", []string{"Storage"}},
+	})
+}
+
+func TestReadGCP(t *testing.T) {
+	m, err := ReadGCP(strings.NewReader(`{
+  "syncToken": "1700000000",
+  "creationTime": "2023-11-14T22:13:20.000000",
+  "prefixes": [
+    {"ipv4Prefix": "34.1.208.0/20", "service": "Google Cloud", "scope": "africa-south1"},
+    {"ipv6Prefix": "2600:1900:8000::/44", "service": "Google Cloud", "scope": "us-central1"}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, m, map[string]ServiceTag{
+		"34.1.208.0/20":       {GCP, "africa-south1", []string{"Google Cloud"}},
+		"2600:1900:8000::/44": {GCP, "us-central1", []string{"Google Cloud"}},
+	})
+	if _, tag, ok := m.Lookup(netip.MustParseAddr("34.1.210.1")); !ok || tag.Region != "africa-south1" {
+		t.Errorf("Lookup(34.1.210.1) = %v, %v", tag, ok)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	for name, read := range map[string]func(string) error{
+		"aws": func(s string) error {
+			_, err := ReadAWS(strings.NewReader(`{"prefixes":[{"ip_prefix":"` + s + `"}]}`))
+			return err
+		},
+		"azure": func(s string) error {
+			_, err := ReadAzure(strings.NewReader(`{"values":[{"name":"x","properties":{"addressPrefixes":["` + s + `"]}}]}`))
+			return err
+		},
+		"gcp": func(s string) error {
+			_, err := ReadGCP(strings.NewReader(`{"prefixes":[{"ipv4Prefix":"` + s + `"}]}`))
+			return err
+		},
+	} {
+		if err := read("1.2.3.4"); err == nil {
+			t.Errorf("%s: invalid document succeeded", name)
+		}
+	}
+}
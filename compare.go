@@ -0,0 +1,153 @@
+package netipds
+
+import "net/netip"
+
+// ComparePrefix returns an integer comparing two Prefixes in the order used
+// by this package's collections, e.g. by [PrefixSet.Prefixes]. The result is
+// 0 if a == b, -1 if a < b, and +1 if a > b.
+//
+// Prefixes are ordered by their masked address, and then by length, with
+// shorter Prefixes first. IPv4 Prefixes are ordered as if they were
+// IPv4-mapped IPv6 Prefixes (within ::ffff:0:0/96), so unlike
+// [netip.Addr.Compare], IPv4 does not sort before all of IPv6. Invalid
+// Prefixes sort before valid ones.
+func ComparePrefix(a, b netip.Prefix) int {
+	switch {
+	case !a.IsValid() && !b.IsValid():
+		return 0
+	case !a.IsValid():
+		return -1
+	case !b.IsValid():
+		return 1
+	}
+	return keyFromPrefix(a).compare(keyFromPrefix(b))
+}
+
+// Equal returns true if s and o contain exactly the same Prefixes.
+func (s *PrefixSet) Equal(o *PrefixSet) bool {
+	return s.stats == o.stats && treesEqual(&s.tree, &o.tree, nil)
+}
+
+// Equal returns true if m and o contain exactly the same Prefixes, with values
+// that are equal according to eq.
+func (m *PrefixMap[T]) Equal(o *PrefixMap[T], eq func(a, b T) bool) bool {
+	return m.stats == o.stats && treesEqual(&m.tree, &o.tree, eq)
+}
+
+// Diff compares s with o, returning the Prefixes that are in o but not s
+// (added) and those that are in s but not o (removed). Prefixes are compared
+// exactly; see [PrefixSet.CoverageDiff] to compare the addresses they cover.
+// To send the differences elsewhere, see [NewSetPatch].
+func (s *PrefixSet) Diff(o *PrefixSet) (added, removed *PrefixSet) {
+	a, r := &tree[bool]{}, &tree[bool]{}
+	mergeEntries(&s.tree, &o.tree, func(x, y *tree[bool]) {
+		switch {
+		case x == nil:
+			a = a.insert(y.key.rooted(), true)
+		case y == nil:
+			r = r.insert(x.key.rooted(), true)
+		}
+	})
+	return &PrefixSet{*a, a.stats()}, &PrefixSet{*r, r.stats()}
+}
+
+// Diff compares m with o, returning the entries of o whose Prefixes are not
+// in m (added), the entries of m whose Prefixes are not in o (removed), and
+// the entries of o whose Prefixes are in m with a different value according
+// to eq (changed). To send the differences elsewhere, see [NewPatch].
+func (m *PrefixMap[T]) Diff(
+	o *PrefixMap[T],
+	eq func(a, b T) bool,
+) (added, removed, changed *PrefixMap[T]) {
+	a, r, c := &tree[T]{}, &tree[T]{}, &tree[T]{}
+	mergeEntries(&m.tree, &o.tree, func(x, y *tree[T]) {
+		switch {
+		case x == nil:
+			a = a.insert(y.key.rooted(), y.value)
+		case y == nil:
+			r = r.insert(x.key.rooted(), x.value)
+		case !eq(x.value, y.value):
+			c = c.insert(y.key.rooted(), y.value)
+		}
+	})
+	return &PrefixMap[T]{*a, a.stats(), nil},
+		&PrefixMap[T]{*r, r.stats(), nil},
+		&PrefixMap[T]{*c, c.stats(), nil}
+}
+
+// mergeEntries walks the entries of a and b simultaneously, in key order,
+// calling fn with the nodes holding each key's entry in a and in b. One of
+// them is nil if the key has no entry in that tree.
+func mergeEntries[T any](a, b *tree[T], fn func(x, y *tree[T])) {
+	ai, bi := newEntryIter(a), newEntryIter(b)
+	x, y := ai.next(), bi.next()
+	for x != nil || y != nil {
+		var c int
+		switch {
+		case y == nil:
+			c = -1
+		case x == nil:
+			c = 1
+		default:
+			c = x.key.compare(y.key)
+		}
+		switch {
+		case c < 0:
+			fn(x, nil)
+			x = ai.next()
+		case c > 0:
+			fn(nil, y)
+			y = bi.next()
+		default:
+			fn(x, y)
+			x, y = ai.next(), bi.next()
+		}
+	}
+}
+
+// treesEqual reports whether a and b have the same entry keys, with values
+// that are equal according to eq. If eq is nil, values are not compared.
+//
+// The trees are walked simultaneously, one entry at a time, so they may differ
+// in shape (e.g. one may be uncompressed).
+func treesEqual[T any](a, b *tree[T], eq func(a, b T) bool) bool {
+	ai, bi := newEntryIter(a), newEntryIter(b)
+	for {
+		x, y := ai.next(), bi.next()
+		if x == nil || y == nil {
+			return x == y
+		}
+		if !x.key.equalFromRoot(y.key) || (eq != nil && !eq(x.value, y.value)) {
+			return false
+		}
+	}
+}
+
+// entryIter visits the nodes of a tree that have entries, in the same order
+// as tree.walk.
+type entryIter[T any] struct {
+	st stack[*tree[T]]
+}
+
+func newEntryIter[T any](t *tree[T]) *entryIter[T] {
+	it := &entryIter[T]{}
+	it.st.Push(t)
+	return it
+}
+
+// next returns the next node with an entry, or nil if there are no more.
+func (it *entryIter[T]) next() *tree[T] {
+	for !it.st.IsEmpty() {
+		n := it.st.Pop()
+		if n.right != nil {
+			it.st.Push(n.right)
+		}
+		if n.left != nil {
+			it.st.Push(n.left)
+		}
+		if n.hasEntry && !n.key.isZero() {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestComparePrefix(t *testing.T) {
+	tests := []struct {
+		a, b netip.Prefix
+		want int
+	}{
+		{pfx("::0/128"), pfx("::0/128"), 0},
+		{pfx("::0/127"), pfx("::0/128"), -1},
+		{pfx("::0/128"), pfx("::1/128"), -1},
+		{pfx("::1/128"), pfx("::2/127"), -1},
+		{pfx("::2/127"), pfx("::0/126"), 1},
+		{pfx("1.2.3.0/24"), pfx("1.2.3.0/24"), 0},
+		{pfx("1.2.3.0/24"), pfx("1.2.3.4/32"), -1},
+		{pfx("1.2.4.0/24"), pfx("1.2.3.4/32"), 1},
+		// IPv4 sorts within the IPv4-mapped range of IPv6
+		{pfx("::1/128"), pfx("0.0.0.0/0"), -1},
+		{pfx("1.2.3.0/24"), pfx("2001:db8::/32"), -1},
+		{pfx("::ffff:0:0/96"), pfx("0.0.0.0/0"), 0},
+		{netip.Prefix{}, pfx("::0/128"), -1},
+		{netip.Prefix{}, netip.Prefix{}, 0},
+	}
+	for _, tt := range tests {
+		if got := ComparePrefix(tt.a, tt.b); got != tt.want {
+			t.Errorf("ComparePrefix(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := ComparePrefix(tt.b, tt.a); got != -tt.want {
+			t.Errorf("ComparePrefix(%v, %v) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestComparePrefixMatchesTreeOrder(t *testing.T) {
+	ps := pfxs(
+		"2001:db8::/32", "1.2.3.4/32", "::0/128", "10.0.0.0/8",
+		"1.2.3.0/24", "::2/127", "::0/126", "10.1.0.0/16", "::1/128",
+	)
+	psb := &PrefixSetBuilder{}
+	for _, p := range ps {
+		psb.Add(p)
+	}
+	slices.SortFunc(ps, ComparePrefix)
+	checkPrefixSlice(t, ps, psb.PrefixSet().Prefixes())
+}
+
+func TestPrefixSetEqual(t *testing.T) {
+	build := func(lazy bool, ps []netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{Lazy: lazy}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	tests := []struct {
+		a, b []netip.Prefix
+		want bool
+	}{
+		{pfxs(), pfxs(), true},
+		{pfxs("::0/128"), pfxs(), false},
+		{pfxs("::0/128"), pfxs("::0/128"), true},
+		{pfxs("::0/128"), pfxs("::1/128"), false},
+		{pfxs("::0/128", "::1/128"), pfxs("::1/128", "::0/128"), true},
+		{pfxs("::0/128", "::1/128"), pfxs("::0/127"), false},
+		{pfxs("1.2.3.0/24", "1.2.0.0/16", "2001:db8::/32"), pfxs("2001:db8::/32", "1.2.0.0/16", "1.2.3.0/24"), true},
+		// Same size and stats, different Prefixes
+		{pfxs("1.2.3.0/24", "1.2.4.0/24"), pfxs("1.2.3.0/24", "1.2.5.0/24"), false},
+	}
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			a, b := build(false, tt.a), build(lazy, tt.b)
+			if got := a.Equal(b); got != tt.want {
+				t.Errorf("%v.Equal(%v) (lazy=%v) = %v, want %v", tt.a, tt.b, lazy, got, tt.want)
+			}
+			if got := b.Equal(a); got != tt.want {
+				t.Errorf("%v.Equal(%v) (lazy=%v) = %v, want %v", tt.b, tt.a, lazy, got, tt.want)
+			}
+		}
+	}
+
+	// Sets whose trees differ in shape after removals
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("::0/128", "::1/128", "::2/128") {
+		psb.Add(p)
+	}
+	psb.Remove(pfx("::1/128"))
+	psb.Remove(pfx("::2/128"))
+	if !psb.PrefixSet().Equal(build(false, pfxs("::0/128"))) {
+		t.Errorf("Equal() = false after removals, want true")
+	}
+}
+
+func TestPrefixMapEqual(t *testing.T) {
+	build := func(m map[netip.Prefix]string) *PrefixMap[string] {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range m {
+			pmb.Set(p, v)
+		}
+		return pmb.PrefixMap()
+	}
+	eq := func(a, b string) bool { return a == b }
+	a := build(map[netip.Prefix]string{pfx("1.2.3.0/24"): "a", pfx("::1/128"): "b"})
+	tests := []struct {
+		b    map[netip.Prefix]string
+		want bool
+	}{
+		{map[netip.Prefix]string{pfx("1.2.3.0/24"): "a", pfx("::1/128"): "b"}, true},
+		{map[netip.Prefix]string{pfx("1.2.3.0/24"): "a", pfx("::1/128"): "c"}, false},
+		{map[netip.Prefix]string{pfx("1.2.3.0/24"): "a"}, false},
+		{map[netip.Prefix]string{pfx("1.2.3.0/24"): "a", pfx("::2/128"): "b"}, false},
+	}
+	for _, tt := range tests {
+		if got := a.Equal(build(tt.b), eq); got != tt.want {
+			t.Errorf("Equal(%v) = %v, want %v", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixSetDiff(t *testing.T) {
+	tests := []struct {
+		a, b           []netip.Prefix
+		added, removed []netip.Prefix
+	}{
+		{pfxs(), pfxs(), pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs("::0/128"), pfxs(), pfxs()},
+		{pfxs(), pfxs("::0/128"), pfxs("::0/128"), pfxs()},
+		{pfxs("::0/128"), pfxs(), pfxs(), pfxs("::0/128")},
+		// Prefixes are compared exactly, not by coverage
+		{pfxs("::0/127"), pfxs("::0/128", "::1/128"), pfxs("::0/128", "::1/128"), pfxs("::0/127")},
+		{
+			pfxs("1.2.0.0/16", "1.2.3.0/24", "2001:db8::/32"),
+			pfxs("1.2.0.0/16", "1.2.4.0/24", "2001:db8::/32", "::1/128"),
+			pfxs("::1/128", "1.2.4.0/24"),
+			pfxs("1.2.3.0/24"),
+		},
+	}
+	build := func(ps []netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	for _, tt := range tests {
+		added, removed := build(tt.a).Diff(build(tt.b))
+		checkPrefixSlice(t, added.Prefixes(), tt.added)
+		checkPrefixSlice(t, removed.Prefixes(), tt.removed)
+	}
+}
+
+func TestPrefixMapDiff(t *testing.T) {
+	build := func(m map[netip.Prefix]string) *PrefixMap[string] {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range m {
+			pmb.Set(p, v)
+		}
+		return pmb.PrefixMap()
+	}
+	a := build(map[netip.Prefix]string{
+		pfx("1.2.0.0/16"): "a",
+		pfx("1.2.3.0/24"): "b",
+		pfx("::1/128"):    "c",
+	})
+	b := build(map[netip.Prefix]string{
+		pfx("1.2.0.0/16"):    "a",
+		pfx("1.2.3.0/24"):    "x",
+		pfx("2001:db8::/32"): "d",
+	})
+	added, removed, changed := a.Diff(b, func(a, b string) bool { return a == b })
+	checkMap(t, map[netip.Prefix]string{pfx("2001:db8::/32"): "d"}, added.ToMap())
+	checkMap(t, map[netip.Prefix]string{pfx("::1/128"): "c"}, removed.ToMap())
+	checkMap(t, map[netip.Prefix]string{pfx("1.2.3.0/24"): "x"}, changed.ToMap())
+}
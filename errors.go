@@ -0,0 +1,58 @@
+package netipds
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// Errors returned for invalid input. They are wrapped with details of the
+// input, so callers should check for them with [errors.Is].
+var (
+	// ErrInvalidPrefix means a Prefix is not valid, such as the zero
+	// Prefix. The error is a *[PrefixError].
+	ErrInvalidPrefix = errors.New("Prefix is not valid")
+	// ErrZoneNotSupported means an address or Prefix being parsed has an
+	// IPv6 zone. Zones are not part of a Prefix, so they can't be stored.
+	ErrZoneNotSupported = errors.New("zones are not supported")
+	// ErrMaxLenExceeded means a Prefix length is longer than the address it
+	// applies to.
+	ErrMaxLenExceeded = errors.New("length exceeds the address length")
+	// ErrInvalidRange means an IPRange is not valid, such as one whose
+	// bounds are in different address families or out of order.
+	ErrInvalidRange = errors.New("IPRange is not valid")
+	// ErrNoEntry means an operation requires a Prefix to have an entry, and
+	// it does not. The error is a *[PrefixError].
+	ErrNoEntry = errors.New("Prefix has no entry")
+	// ErrNotEncompassed means an operation requires one Prefix to encompass
+	// another, and it does not.
+	ErrNotEncompassed = errors.New("Prefix is not encompassed")
+	// ErrUnsupportedPrefix means a valid Prefix can't be used for a purpose,
+	// such as ::/0 as the pool of an [Allocator]. The error is a
+	// *[PrefixError].
+	ErrUnsupportedPrefix = errors.New("Prefix is not supported")
+)
+
+// PrefixError is an error concerning a particular Prefix.
+type PrefixError struct {
+	Prefix netip.Prefix
+	Err    error
+}
+
+func (e *PrefixError) Error() string {
+	return e.Err.Error() + ": " + e.Prefix.String()
+}
+
+func (e *PrefixError) Unwrap() error {
+	return e.Err
+}
+
+// invalidPrefixError returns an error reporting that p is not valid.
+func invalidPrefixError(p netip.Prefix) error {
+	return &PrefixError{p, ErrInvalidPrefix}
+}
+
+// invalidRangeError returns an error reporting that r is not valid.
+func invalidRangeError(r IPRange) error {
+	return fmt.Errorf("%w: %v", ErrInvalidRange, r)
+}
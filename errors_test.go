@@ -0,0 +1,72 @@
+package netipds
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	err := psb.Add(netip.Prefix{})
+	if !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("Add(invalid) = %v, want %v", err, ErrInvalidPrefix)
+	}
+	var pe *PrefixError
+	if !errors.As(err, &pe) || pe.Prefix != (netip.Prefix{}) {
+		t.Errorf("Add(invalid) = %v, want a *PrefixError for the invalid Prefix", err)
+	}
+	if err := (&PrefixMapBuilder[int]{}).Set(netip.Prefix{}, 1); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("Set(invalid) = %v, want %v", err, ErrInvalidPrefix)
+	}
+
+	allocator, err := NewAllocator(pfx("10.0.0.0/8"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, allocErr := allocator.AllocateLen(33)
+	_, bitmapErr := psb.PrefixSet().Bitmap(pfx("10.0.0.0/8"), 40)
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("10.0.0.0/8"), 1)
+	backwards := IPRange{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"ReadPrefixSet zone", readErr("fe80::1%eth0"), ErrZoneNotSupported},
+		{"ReadPrefixSet zone with length", readErr("fe80::1%eth0/64"), ErrZoneNotSupported},
+		{"ReadPrefixSet IPv4 length", readErr("1.2.3.0/33"), ErrMaxLenExceeded},
+		{"ReadPrefixSet IPv6 length", readErr("2001:db8::/129"), ErrMaxLenExceeded},
+		{"ROASetBuilder.Add", (&ROASetBuilder{}).Add(ROA{pfx("10.0.0.0/8"), 33, 64496}), ErrMaxLenExceeded},
+		{"AllocateLen", allocErr, ErrMaxLenExceeded},
+		{"Bitmap", bitmapErr, ErrMaxLenExceeded},
+		{"IPRangeSetBuilder.Add", (&IPRangeSetBuilder{}).Add(backwards), ErrInvalidRange},
+		{"IPRangeSetBuilder.Remove", (&IPRangeSetBuilder{}).Remove(IPRange{}), ErrInvalidRange},
+		{"IPRangeMapBuilder.Set", (&IPRangeMapBuilder[int]{}).Set(backwards, 1), ErrInvalidRange},
+		{"IPRangeMapBuilder.Remove", (&IPRangeMapBuilder[int]{}).Remove(IPRange{}), ErrInvalidRange},
+		{"Carve without entry", pmb.Carve(pfx("11.0.0.0/8"), pfx("11.0.0.0/16")), ErrNoEntry},
+		{"Carve outside entry", pmb.Carve(pfx("10.0.0.0/8"), pfx("11.0.0.0/16")), ErrNotEncompassed},
+		{"CarveSet outside entry", pmb.CarveSet(pfx("10.0.0.0/8"), pfx("11.0.0.0/16"), 2), ErrNotEncompassed},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+
+	// Other malformed input is still rejected, without a sentinel
+	for _, s := range []string{"1.2.3.0/x", "1.2.3/24", "1.2.3.0/-1"} {
+		err := readErr(s)
+		if err == nil || errors.Is(err, ErrMaxLenExceeded) || errors.Is(err, ErrZoneNotSupported) {
+			t.Errorf("ReadPrefixSet(%q) = %v, want a parse error", s, err)
+		}
+	}
+}
+
+func readErr(s string) error {
+	_, err := ReadPrefixSet(strings.NewReader(s + "\n"))
+	return err
+}
@@ -0,0 +1,103 @@
+package netipds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// firewallPrefixes returns the Prefixes of s split by address family, for
+// loading into firewall sets, which don't allow overlapping elements. If
+// aggregate, adjacent Prefixes are merged too.
+func (s *PrefixSet) firewallPrefixes(aggregate bool) (v4, v6 []netip.Prefix) {
+	ps := s.PrefixesCompact()
+	if aggregate {
+		ps = s.PrefixesAggregated()
+	}
+	for _, p := range ps {
+		if p.Addr().Is4() {
+			v4 = append(v4, p)
+		} else {
+			v6 = append(v6, p)
+		}
+	}
+	return v4, v6
+}
+
+// WriteNftSets writes nftables set definitions holding the Prefixes in s to
+// w, suitable for including in a table in an nft script. IPv4 and IPv6
+// Prefixes are written to sets named name+"_v4" and name+"_v6" respectively,
+// both of which are always written, so that rules can refer to them.
+//
+// Interval sets can't hold overlapping elements, so only the Prefixes in s
+// which are not descendants of other Prefixes in s are written. If aggregate,
+// adjacent Prefixes are merged as in [PrefixSet.PrefixesAggregated].
+func (s *PrefixSet) WriteNftSets(w io.Writer, name string, aggregate bool) error {
+	v4, v6 := s.firewallPrefixes(aggregate)
+	bw := bufio.NewWriter(w)
+	for _, set := range []struct {
+		suffix, typ string
+		ps          []netip.Prefix
+	}{
+		{"_v4", "ipv4_addr", v4},
+		{"_v6", "ipv6_addr", v6},
+	} {
+		fmt.Fprintf(bw, "set %s%s {\n\ttype %s\n\tflags interval\n", name, set.suffix, set.typ)
+		// nft rejects empty element lists
+		if len(set.ps) > 0 {
+			bw.WriteString("\telements = {")
+			for i, p := range set.ps {
+				if i > 0 {
+					bw.WriteByte(',')
+				}
+				if i%8 == 0 {
+					bw.WriteString("\n\t\t")
+				} else {
+					bw.WriteByte(' ')
+				}
+				bw.WriteString(p.String())
+			}
+			bw.WriteString("\n\t}\n")
+		}
+		bw.WriteString("}\n")
+	}
+	return bw.Flush()
+}
+
+// WriteIpsetRestore writes input for "ipset restore" to w, which creates
+// hash:net sets holding the Prefixes in s. IPv4 and IPv6 Prefixes are written
+// to sets named name+"_v4" and name+"_v6" respectively, both of which are
+// always created. Existing sets are reused, and Prefixes already in them are
+// ignored.
+//
+// hash:net sets can hold overlapping elements, but only the Prefixes in s
+// which are not descendants of other Prefixes in s are written, since the
+// others don't affect matching. If aggregate, adjacent Prefixes are merged as
+// in [PrefixSet.PrefixesAggregated]. hash:net sets can't hold a /0, so
+// 0.0.0.0/0 is written as its two /1 halves.
+func (s *PrefixSet) WriteIpsetRestore(w io.Writer, name string, aggregate bool) error {
+	v4, v6 := s.firewallPrefixes(aggregate)
+	bw := bufio.NewWriter(w)
+	for _, set := range []struct {
+		suffix, family string
+		ps             []netip.Prefix
+	}{
+		{"_v4", "inet", v4},
+		{"_v6", "inet6", v6},
+	} {
+		ps := set.ps
+		// A /0 encompasses everything else, so it is the only Prefix
+		if len(ps) == 1 && ps[0].Bits() == 0 {
+			k := keyFromPrefix(ps[0])
+			ps = []netip.Prefix{k.next(0).toPrefix(), k.next(1).toPrefix()}
+		}
+		// 65536 is ipset's default maxelem
+		fmt.Fprintf(bw, "create %s%s hash:net family %s maxelem %d -exist\n",
+			name, set.suffix, set.family, max(len(ps), 65536))
+		for _, p := range ps {
+			fmt.Fprintf(bw, "add %s%s %s -exist\n", name, set.suffix, p)
+		}
+	}
+	return bw.Flush()
+}
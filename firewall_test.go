@@ -0,0 +1,122 @@
+package netipds
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixSetWriteNftSets(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.2.0/24", "1.2.3.0/24", "1.2.3.4/32", "10.0.0.0/8", "2001:db8::/32") {
+		psb.Add(p)
+	}
+	s := psb.PrefixSet()
+
+	tests := []struct {
+		aggregate bool
+		want      string
+	}{
+		{false, `set blocked_v4 {
+	type ipv4_addr
+	flags interval
+	elements = {
+		1.2.2.0/24, 1.2.3.0/24, 10.0.0.0/8
+	}
+}
+set blocked_v6 {
+	type ipv6_addr
+	flags interval
+	elements = {
+		2001:db8::/32
+	}
+}
+`},
+		{true, `set blocked_v4 {
+	type ipv4_addr
+	flags interval
+	elements = {
+		1.2.2.0/23, 10.0.0.0/8
+	}
+}
+set blocked_v6 {
+	type ipv6_addr
+	flags interval
+	elements = {
+		2001:db8::/32
+	}
+}
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := s.WriteNftSets(&buf, "blocked", tt.aggregate); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("WriteNftSets(aggregate=%v) =\n%s\nwant\n%s", tt.aggregate, got, tt.want)
+		}
+	}
+
+	// Empty sets have no elements, and long lists are wrapped
+	psb = &PrefixSetBuilder{}
+	for _, p := range pfxs("10.0.0.1/32", "10.0.0.2/32", "10.0.0.4/32", "10.0.0.6/32", "10.0.0.8/32",
+		"10.0.0.10/32", "10.0.0.12/32", "10.0.0.14/32", "10.0.0.16/32") {
+		psb.Add(p)
+	}
+	var buf bytes.Buffer
+	psb.PrefixSet().WriteNftSets(&buf, "s", false)
+	want := `set s_v4 {
+	type ipv4_addr
+	flags interval
+	elements = {
+		10.0.0.1/32, 10.0.0.2/32, 10.0.0.4/32, 10.0.0.6/32, 10.0.0.8/32, 10.0.0.10/32, 10.0.0.12/32, 10.0.0.14/32,
+		10.0.0.16/32
+	}
+}
+set s_v6 {
+	type ipv6_addr
+	flags interval
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteNftSets() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrefixSetWriteIpsetRestore(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.2.0/24", "1.2.3.0/24", "1.2.3.4/32", "2001:db8::/32") {
+		psb.Add(p)
+	}
+	var buf bytes.Buffer
+	if err := psb.PrefixSet().WriteIpsetRestore(&buf, "blocked", true); err != nil {
+		t.Fatal(err)
+	}
+	want := `create blocked_v4 hash:net family inet maxelem 65536 -exist
+add blocked_v4 1.2.2.0/23 -exist
+create blocked_v6 hash:net family inet6 maxelem 65536 -exist
+add blocked_v6 2001:db8::/32 -exist
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteIpsetRestore() =\n%s\nwant\n%s", got, want)
+	}
+
+	// hash:net can't hold /0, so it is split in half
+	psb = &PrefixSetBuilder{}
+	for _, p := range pfxs("0.0.0.0/0", "1.2.3.0/24", "2001:db8::/32") {
+		psb.Add(p)
+	}
+	buf.Reset()
+	if err := psb.PrefixSet().WriteIpsetRestore(&buf, "all", false); err != nil {
+		t.Fatal(err)
+	}
+	want = `create all_v4 hash:net family inet maxelem 65536 -exist
+add all_v4 0.0.0.0/1 -exist
+add all_v4 128.0.0.0/1 -exist
+create all_v6 hash:net family inet6 maxelem 65536 -exist
+add all_v6 2001:db8::/32 -exist
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteIpsetRestore() with /0 =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package netipds
+
+import "unsafe"
+
+// prefixStats summarizes the Prefixes in a collection. It is computed once
+// when an immutable collection is created.
+type prefixStats struct {
+	n4, n6           int
+	minBits, maxBits int
+}
+
+func (s prefixStats) size() int {
+	return s.n4 + s.n6
+}
+
+// lenCounts counts the Prefixes in a builder by address family and length,
+// so that prefixStats can be maintained as entries are added and removed.
+type lenCounts struct {
+	v4 [33]int
+	v6 [129]int
+}
+
+// add adds d to the count of k's family and length.
+func (c *lenCounts) add(k key, d int) {
+	if k.is4() {
+		c.v4[k.len-96] += d
+	} else {
+		c.v6[k.len] += d
+	}
+}
+
+func (c *lenCounts) stats() prefixStats {
+	s := prefixStats{minBits: -1, maxBits: -1}
+	note := func(bits, n int) {
+		if n == 0 {
+			return
+		}
+		if s.minBits < 0 || bits < s.minBits {
+			s.minBits = bits
+		}
+		s.maxBits = max(s.maxBits, bits)
+	}
+	for bits, n := range c.v4 {
+		s.n4 += n
+		note(bits, n)
+	}
+	for bits, n := range c.v6 {
+		s.n6 += n
+		note(bits, n)
+	}
+	return s
+}
+
+// countLens returns the lenCounts of the entries in t.
+func countLens[T any](t *tree[T]) (c lenCounts) {
+	var count func(*tree[T])
+	count = func(n *tree[T]) {
+		if n == nil {
+			return
+		}
+		if n.hasEntry {
+			c.add(n.key, 1)
+		}
+		count(n.left)
+		count(n.right)
+	}
+	count(t)
+	return c
+}
+
+// stats returns the prefixStats of the entries in t.
+func (t *tree[T]) stats() prefixStats {
+	c := countLens(t)
+	return c.stats()
+}
+
+// TreeStats describes the shape of the tree behind a collection, and its
+// approximate memory use.
+type TreeStats struct {
+	// Entries is the number of Prefixes, of which IPv4 and IPv6 are IPv4 and
+	// IPv6 Prefixes respectively.
+	Entries, IPv4, IPv6 int
+	// Nodes is the number of nodes in the tree, including the root. Nodes
+	// without entries are counted in InternalNodes as well.
+	Nodes, InternalNodes int
+	// MaxDepth is the number of nodes between the root and the deepest node,
+	// inclusive of the latter. AvgDepth is the average depth of the entries.
+	MaxDepth int
+	AvgDepth float64
+	// Bytes estimates the memory used by the nodes. It does not include
+	// memory referenced by values, such as the contents of slices or maps.
+	Bytes int
+}
+
+// treeStats returns the TreeStats of t.
+func treeStats[T any](t *tree[T]) TreeStats {
+	var ret TreeStats
+	var depths int
+	var visit func(n *tree[T], depth int)
+	visit = func(n *tree[T], depth int) {
+		ret.Nodes++
+		ret.MaxDepth = max(ret.MaxDepth, depth)
+		switch {
+		case !n.hasEntry:
+			ret.InternalNodes++
+		case n.key.is4():
+			ret.IPv4++
+			depths += depth
+		default:
+			ret.IPv6++
+			depths += depth
+		}
+		for _, c := range []*tree[T]{n.left, n.right} {
+			if c != nil {
+				visit(c, depth+1)
+			}
+		}
+	}
+	visit(t, 0)
+	ret.Entries = ret.IPv4 + ret.IPv6
+	if ret.Entries > 0 {
+		ret.AvgDepth = float64(depths) / float64(ret.Entries)
+	}
+	ret.Bytes = ret.Nodes * int(unsafe.Sizeof(*t))
+	return ret
+}
+
+// Stats returns statistics about the tree behind s.
+func (s *PrefixSet) Stats() TreeStats {
+	return treeStats(&s.tree)
+}
+
+// Stats returns statistics about the tree behind m.
+func (m *PrefixMap[T]) Stats() TreeStats {
+	return treeStats(&m.tree)
+}
+
+// Stats returns statistics about s's tree. Comparing them with the Stats of
+// the PrefixSets it builds shows the cost of the builder's own tree, which is
+// uncompressed if s is lazy.
+func (s *PrefixSetBuilder) Stats() TreeStats {
+	return treeStats(&s.tree)
+}
+
+// Stats returns statistics about m's tree. Entry times, if tracked, are
+// stored separately and not included.
+func (m *PrefixMapBuilder[T]) Stats() TreeStats {
+	return treeStats(&m.tree)
+}
+
+// IsEmpty returns true if s contains no Prefixes.
+func (s *PrefixSet) IsEmpty() bool {
+	return s.stats.size() == 0
+}
+
+// HasIPv4 returns true if s contains any IPv4 Prefixes.
+func (s *PrefixSet) HasIPv4() bool {
+	return s.stats.n4 > 0
+}
+
+// HasIPv6 returns true if s contains any IPv6 Prefixes.
+func (s *PrefixSet) HasIPv6() bool {
+	return s.stats.n6 > 0
+}
+
+// MinPrefixLen returns the smallest Bits() of any Prefix in s, or -1 if s is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (s *PrefixSet) MinPrefixLen() int {
+	return s.stats.minBits
+}
+
+// MaxPrefixLen returns the largest Bits() of any Prefix in s, or -1 if s is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (s *PrefixSet) MaxPrefixLen() int {
+	return s.stats.maxBits
+}
+
+// IsEmpty returns true if m contains no entries.
+func (m *PrefixMap[T]) IsEmpty() bool {
+	return m.stats.size() == 0
+}
+
+// HasIPv4 returns true if m contains any IPv4 Prefixes.
+func (m *PrefixMap[T]) HasIPv4() bool {
+	return m.stats.n4 > 0
+}
+
+// HasIPv6 returns true if m contains any IPv6 Prefixes.
+func (m *PrefixMap[T]) HasIPv6() bool {
+	return m.stats.n6 > 0
+}
+
+// MinPrefixLen returns the smallest Bits() of any Prefix in m, or -1 if m is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (m *PrefixMap[T]) MinPrefixLen() int {
+	return m.stats.minBits
+}
+
+// MaxPrefixLen returns the largest Bits() of any Prefix in m, or -1 if m is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (m *PrefixMap[T]) MaxPrefixLen() int {
+	return m.stats.maxBits
+}
+
+// IsEmpty returns true if s contains no Prefixes.
+func (s *PrefixSetBuilder) IsEmpty() bool {
+	return s.lens.stats().size() == 0
+}
+
+// HasIPv4 returns true if s contains any IPv4 Prefixes.
+func (s *PrefixSetBuilder) HasIPv4() bool {
+	return s.lens.stats().n4 > 0
+}
+
+// HasIPv6 returns true if s contains any IPv6 Prefixes.
+func (s *PrefixSetBuilder) HasIPv6() bool {
+	return s.lens.stats().n6 > 0
+}
+
+// MinPrefixLen returns the smallest Bits() of any Prefix in s, or -1 if s is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (s *PrefixSetBuilder) MinPrefixLen() int {
+	return s.lens.stats().minBits
+}
+
+// MaxPrefixLen returns the largest Bits() of any Prefix in s, or -1 if s is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (s *PrefixSetBuilder) MaxPrefixLen() int {
+	return s.lens.stats().maxBits
+}
+
+// IsEmpty returns true if m contains no entries.
+func (m *PrefixMapBuilder[T]) IsEmpty() bool {
+	return m.lens.stats().size() == 0
+}
+
+// HasIPv4 returns true if m contains any IPv4 Prefixes.
+func (m *PrefixMapBuilder[T]) HasIPv4() bool {
+	return m.lens.stats().n4 > 0
+}
+
+// HasIPv6 returns true if m contains any IPv6 Prefixes.
+func (m *PrefixMapBuilder[T]) HasIPv6() bool {
+	return m.lens.stats().n6 > 0
+}
+
+// MinPrefixLen returns the smallest Bits() of any Prefix in m, or -1 if m is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (m *PrefixMapBuilder[T]) MinPrefixLen() int {
+	return m.lens.stats().minBits
+}
+
+// MaxPrefixLen returns the largest Bits() of any Prefix in m, or -1 if m is
+// empty. IPv4 and IPv6 Prefixes are considered together.
+func (m *PrefixMapBuilder[T]) MaxPrefixLen() int {
+	return m.lens.stats().maxBits
+}
@@ -0,0 +1,140 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+type introspection struct {
+	empty            bool
+	has4, has6       bool
+	minBits, maxBits int
+}
+
+func TestIntrospection(t *testing.T) {
+	tests := []struct {
+		add    []netip.Prefix
+		remove []netip.Prefix
+		want   introspection
+	}{
+		{pfxs(), pfxs(), introspection{true, false, false, -1, -1}},
+		{pfxs("1.2.3.0/24"), pfxs(), introspection{false, true, false, 24, 24}},
+		{pfxs("::1/128"), pfxs(), introspection{false, false, true, 128, 128}},
+		{pfxs("::/0"), pfxs(), introspection{false, false, true, 0, 0}},
+		{pfxs("1.0.0.0/8", "1.2.3.4/32", "2001::/16"), pfxs(), introspection{false, true, true, 8, 32}},
+		{pfxs("1.0.0.0/8", "1.2.3.4/32", "2001::/16", "1.0.0.0/8"), pfxs(), introspection{false, true, true, 8, 32}},
+		{pfxs("1.0.0.0/8", "1.2.3.4/32"), pfxs("1.0.0.0/8"), introspection{false, true, false, 32, 32}},
+		{pfxs("1.0.0.0/8", "1.2.3.4/32"), pfxs("1.2.3.4/32", "1.2.3.4/32"), introspection{false, true, false, 8, 8}},
+		{pfxs("1.0.0.0/8", "::1/128"), pfxs("::1/128"), introspection{false, true, false, 8, 8}},
+		{pfxs("1.0.0.0/8"), pfxs("1.0.0.0/8", "2.0.0.0/8"), introspection{true, false, false, -1, -1}},
+	}
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			psb := &PrefixSetBuilder{Lazy: lazy}
+			pmb := &PrefixMapBuilder[bool]{Lazy: lazy}
+			for _, p := range tt.add {
+				psb.Add(p)
+				pmb.Set(p, true)
+			}
+			for _, p := range tt.remove {
+				psb.Remove(p)
+				pmb.Remove(p)
+			}
+			ps, pm := psb.PrefixSet(), pmb.PrefixMap()
+			for name, got := range map[string]introspection{
+				"PrefixSetBuilder": {psb.IsEmpty(), psb.HasIPv4(), psb.HasIPv6(), psb.MinPrefixLen(), psb.MaxPrefixLen()},
+				"PrefixMapBuilder": {pmb.IsEmpty(), pmb.HasIPv4(), pmb.HasIPv6(), pmb.MinPrefixLen(), pmb.MaxPrefixLen()},
+				"PrefixSet":        {ps.IsEmpty(), ps.HasIPv4(), ps.HasIPv6(), ps.MinPrefixLen(), ps.MaxPrefixLen()},
+				"PrefixMap":        {pm.IsEmpty(), pm.HasIPv4(), pm.HasIPv6(), pm.MinPrefixLen(), pm.MaxPrefixLen()},
+			} {
+				if got != tt.want {
+					t.Errorf("%s (lazy=%v) add %v remove %v: got %+v, want %+v",
+						name, lazy, tt.add, tt.remove, got, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestIntrospectionBulkOps(t *testing.T) {
+	build := func(ps []netip.Prefix) *PrefixSet {
+		b := &PrefixSetBuilder{}
+		for _, p := range ps {
+			b.Add(p)
+		}
+		return b.PrefixSet()
+	}
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("1.0.0.0/8"))
+	psb.Add(pfx("2001::/16"))
+	psb.Subtract(build(pfxs("2001::/16")))
+	if psb.HasIPv6() || psb.MaxPrefixLen() != 8 {
+		t.Errorf("Subtract: got HasIPv6 %v, MaxPrefixLen %d", psb.HasIPv6(), psb.MaxPrefixLen())
+	}
+	psb.SubtractPrefix(pfx("1.0.0.0/10"))
+	if psb.MinPrefixLen() != 9 || psb.MaxPrefixLen() != 10 {
+		t.Errorf("SubtractPrefix: got lengths [%d, %d], want [9, 10]",
+			psb.MinPrefixLen(), psb.MaxPrefixLen())
+	}
+	psb.Merge(build(pfxs("::1/128")))
+	if !psb.HasIPv6() || psb.MaxPrefixLen() != 128 {
+		t.Errorf("Merge: got HasIPv6 %v, MaxPrefixLen %d", psb.HasIPv6(), psb.MaxPrefixLen())
+	}
+	psb.Intersect(build(pfxs("::/120")))
+	if psb.HasIPv4() || psb.IsEmpty() {
+		t.Errorf("Intersect: got HasIPv4 %v, IsEmpty %v", psb.HasIPv4(), psb.IsEmpty())
+	}
+
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("::/126"), 1)
+	pmb.Carve(pfx("::/126"), pfx("::/128"))
+	if pmb.MinPrefixLen() != 127 || pmb.MaxPrefixLen() != 128 {
+		t.Errorf("Carve: got lengths [%d, %d], want [127, 128]",
+			pmb.MinPrefixLen(), pmb.MaxPrefixLen())
+	}
+	pmb.Filter(build(pfxs("::2/127")))
+	if pmb.MinPrefixLen() != 127 || pmb.MaxPrefixLen() != 127 {
+		t.Errorf("Filter: got lengths [%d, %d], want [127, 127]",
+			pmb.MinPrefixLen(), pmb.MaxPrefixLen())
+	}
+}
+
+func TestTreeStats(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.0.0/16", "1.2.3.0/24", "1.2.4.0/24", "::1/128") {
+		psb.Add(p)
+	}
+	got := psb.PrefixSet().Stats()
+	// root -> {::1/128, 1.2.0.0/16} via a shared ancestor;
+	// 1.2.0.0/16 -> shared ancestor -> {1.2.3.0/24, 1.2.4.0/24}
+	want := TreeStats{
+		Entries:       4,
+		IPv4:          3,
+		IPv6:          1,
+		Nodes:         7,
+		InternalNodes: 3,
+		MaxDepth:      4,
+		AvgDepth:      float64(2+2+4+4) / 4,
+	}
+	if got.Bytes <= 0 {
+		t.Errorf("Stats().Bytes = %d, want > 0", got.Bytes)
+	}
+	got.Bytes = 0
+	if got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if got := (&PrefixSet{}).Stats(); got.Nodes != 1 || got.Entries != 0 || got.AvgDepth != 0 {
+		t.Errorf("empty Stats() = %+v", got)
+	}
+
+	// Lazy builders have a node for every bit of every path
+	pmb := &PrefixMapBuilder[int]{Lazy: true}
+	pmb.Set(pfx("1.2.3.0/24"), 1)
+	if got := pmb.Stats(); got.Nodes != 121 || got.InternalNodes != 120 || got.Entries != 1 {
+		t.Errorf("lazy builder Stats() = %+v", got)
+	}
+	// but the maps they build are compressed
+	if got := pmb.PrefixMap().Stats(); got.Nodes != 2 || got.InternalNodes != 1 || got.Entries != 1 {
+		t.Errorf("lazy-built map Stats() = %+v", got)
+	}
+}
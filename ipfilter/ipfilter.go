@@ -0,0 +1,150 @@
+// Package ipfilter provides access control for network services based on
+// [netipds.PrefixSet] allow and deny lists, or a [netipds.Matcher].
+package ipfilter
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aromatt/netipds"
+)
+
+// Filter decides whether client addresses are allowed, based on an allow list
+// and a deny list. Either list may be replaced at any time, including while
+// the Filter is in use.
+//
+// An address is denied if it is contained by the deny list. Otherwise, it is
+// allowed if there is no allow list or if it is contained by the allow list.
+// If a Matcher is set (see [Filter.SetMatcher]), it decides instead.
+//
+// The zero value is a valid Filter which allows every address.
+type Filter struct {
+	// TrustedProxies lists the addresses of proxies whose forwarding headers
+	// are trusted when determining the client address of an HTTP request. If
+	// nil, forwarding headers are ignored.
+	TrustedProxies *netipds.PrefixSet
+
+	// ForwardedHeader is the forwarding header set by TrustedProxies. It may
+	// be "X-Forwarded-For" (the default if empty), another header with the
+	// same syntax such as "X-Real-IP", or "Forwarded", in which case the
+	// "for" parameters of the RFC 7239 syntax are used.
+	ForwardedHeader string
+
+	// Denied, if non-nil, responds to requests which are denied by
+	// [Filter.Handler]. If nil, they receive 403 Forbidden.
+	Denied http.Handler
+
+	allow   atomic.Pointer[netipds.PrefixSet]
+	deny    atomic.Pointer[netipds.PrefixSet]
+	matcher atomic.Pointer[netipds.Matcher]
+}
+
+// SetAllow replaces f's allow list. If s is nil, every address not in the deny
+// list is allowed.
+func (f *Filter) SetAllow(s *netipds.PrefixSet) {
+	f.allow.Store(s)
+}
+
+// SetDeny replaces f's deny list. If s is nil, no address is denied by it.
+func (f *Filter) SetDeny(s *netipds.PrefixSet) {
+	f.deny.Store(s)
+}
+
+// SetMatcher replaces f's Matcher, which, if non-nil, decides which
+// addresses are allowed instead of f's allow and deny lists. Matchers can
+// resolve overlapping lists by most-specific match.
+func (f *Filter) SetMatcher(m *netipds.Matcher) {
+	f.matcher.Store(m)
+}
+
+// Allowed reports whether a is allowed by f.
+func (f *Filter) Allowed(a netip.Addr) bool {
+	if !a.IsValid() {
+		return false
+	}
+	if m := f.matcher.Load(); m != nil {
+		return m.Decide(a) == netipds.Allow
+	}
+	if deny := f.deny.Load(); deny != nil && deny.ContainsAddr(a) {
+		return false
+	}
+	if allow := f.allow.Load(); allow != nil {
+		return allow.ContainsAddr(a)
+	}
+	return true
+}
+
+// ClientAddr returns the address of the client that sent r.
+//
+// If the peer address is in f.TrustedProxies, then the forwarding header (see
+// f.ForwardedHeader) is consulted: its addresses are examined from right to
+// left, and the first one that is not a trusted proxy is returned. ClientAddr
+// returns false if an address cannot be parsed, including obfuscated and
+// "unknown" identifiers in Forwarded headers.
+func (f *Filter) ClientAddr(r *http.Request) (netip.Addr, bool) {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr := ap.Addr().Unmap()
+	if f.TrustedProxies == nil {
+		return addr, true
+	}
+	header := http.CanonicalHeaderKey(f.ForwardedHeader)
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	hops := strings.Split(strings.Join(r.Header.Values(header), ","), ",")
+	for i := len(hops) - 1; i >= 0 && f.TrustedProxies.ContainsAddr(addr); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if header == "Forwarded" {
+			hop = forwardedFor(hop)
+		}
+		if hop == "" {
+			continue
+		}
+		if addr, err = netip.ParseAddr(hop); err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addr.Unmap()
+	}
+	return addr, true
+}
+
+// forwardedFor returns the address in the "for" parameter of an element of an
+// RFC 7239 Forwarded header, without quotes, brackets or port, or the
+// parameter's raw value if it isn't an address. It returns "" if the element
+// has no "for" parameter.
+func forwardedFor(elem string) string {
+	for _, pair := range strings.Split(elem, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if !strings.EqualFold(name, "for") {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		if ap, err := netip.ParseAddrPort(value); err == nil {
+			return ap.Addr().String()
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	}
+	return ""
+}
+
+// Handler returns an http.Handler which passes requests to next if their
+// client address (see [Filter.ClientAddr]) is allowed by f, and to f.Denied
+// (by default, a 403 Forbidden response) otherwise.
+func (f *Filter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a, ok := f.ClientAddr(r); !ok || !f.Allowed(a) {
+			if f.Denied != nil {
+				f.Denied.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
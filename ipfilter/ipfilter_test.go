@@ -0,0 +1,191 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/aromatt/netipds"
+)
+
+func set(prefixes ...string) *netipds.PrefixSet {
+	psb := &netipds.PrefixSetBuilder{}
+	for _, p := range prefixes {
+		psb.Add(netip.MustParsePrefix(p))
+	}
+	return psb.PrefixSet()
+}
+
+func TestFilterAllowed(t *testing.T) {
+	tests := []struct {
+		allow, deny *netipds.PrefixSet
+		addr        string
+		want        bool
+	}{
+		{nil, nil, "1.2.3.4", true},
+		{set("1.2.3.0/24"), nil, "1.2.3.4", true},
+		{set("1.2.3.0/24"), nil, "1.2.4.4", false},
+		{nil, set("1.2.3.0/24"), "1.2.3.4", false},
+		{nil, set("1.2.3.0/24"), "1.2.4.4", true},
+		// Deny takes precedence over allow
+		{set("1.2.0.0/16"), set("1.2.3.0/24"), "1.2.3.4", false},
+		{set("1.2.0.0/16"), set("1.2.3.0/24"), "1.2.4.4", true},
+	}
+	for _, tt := range tests {
+		var f Filter
+		f.SetAllow(tt.allow)
+		f.SetDeny(tt.deny)
+		if got := f.Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("f.Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	var f Filter
+	if f.Allowed(netip.Addr{}) {
+		t.Errorf("f.Allowed(invalid) = true, want false")
+	}
+}
+
+func TestFilterClientAddr(t *testing.T) {
+	tests := []struct {
+		trusted    *netipds.PrefixSet
+		remoteAddr string
+		xff        []string
+		want       string
+		wantOK     bool
+	}{
+		{nil, "1.2.3.4:1234", nil, "1.2.3.4", true},
+		{nil, "[::ffff:1.2.3.4]:1234", nil, "1.2.3.4", true},
+		{nil, "[2001:db8::1]:1234", nil, "2001:db8::1", true},
+		{nil, "bogus", nil, "", false},
+		// X-Forwarded-For is ignored without trusted proxies
+		{nil, "1.2.3.4:1234", []string{"5.6.7.8"}, "1.2.3.4", true},
+		// X-Forwarded-For is ignored from untrusted peers
+		{set("10.0.0.0/8"), "1.2.3.4:1234", []string{"5.6.7.8"}, "1.2.3.4", true},
+		{set("10.0.0.0/8"), "10.0.0.1:1234", []string{"5.6.7.8"}, "5.6.7.8", true},
+		{set("10.0.0.0/8"), "10.0.0.1:1234", []string{"5.6.7.8, 10.0.0.2"}, "5.6.7.8", true},
+		{set("10.0.0.0/8"), "10.0.0.1:1234", []string{"5.6.7.8", "10.0.0.2"}, "5.6.7.8", true},
+		// Spoofed entries to the left of the client are ignored
+		{set("10.0.0.0/8"), "10.0.0.1:1234", []string{"9.9.9.9, 5.6.7.8"}, "5.6.7.8", true},
+		// Only trusted proxies
+		{set("10.0.0.0/8"), "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2", true},
+		{set("10.0.0.0/8"), "10.0.0.1:1234", nil, "10.0.0.1", true},
+		{set("10.0.0.0/8"), "10.0.0.1:1234", []string{"bogus"}, "", false},
+	}
+	for _, tt := range tests {
+		f := Filter{TrustedProxies: tt.trusted}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		got, ok := f.ClientAddr(r)
+		if ok != tt.wantOK || (ok && got.String() != tt.want) {
+			t.Errorf("f.ClientAddr(%s, %v) = (%v, %v), want (%v, %v)",
+				tt.remoteAddr, tt.xff, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFilterClientAddrForwarded(t *testing.T) {
+	tests := []struct {
+		header string
+		values []string
+		want   string
+		wantOK bool
+	}{
+		{"X-Real-IP", []string{"5.6.7.8"}, "5.6.7.8", true},
+		{"x-real-ip", []string{"5.6.7.8"}, "5.6.7.8", true},
+		{"Forwarded", []string{"for=5.6.7.8"}, "5.6.7.8", true},
+		{"Forwarded", []string{`for=5.6.7.8;proto=https, For="[2001:db8::1]:4711";by=10.0.0.1`}, "2001:db8::1", true},
+		{"Forwarded", []string{`for=5.6.7.8`, `for="[2001:db8::1]"`, `for=10.0.0.2`}, "2001:db8::1", true},
+		{"Forwarded", []string{"for=5.6.7.8:1234"}, "5.6.7.8", true},
+		{"Forwarded", []string{"proto=https"}, "10.0.0.1", true},
+		{"Forwarded", []string{"for=unknown"}, "", false},
+		{"Forwarded", []string{"for=_hidden"}, "", false},
+		// Other headers are ignored
+		{"Forwarded", nil, "10.0.0.1", true},
+	}
+	for _, tt := range tests {
+		f := Filter{TrustedProxies: set("10.0.0.0/8"), ForwardedHeader: tt.header}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "9.9.9.9")
+		for _, v := range tt.values {
+			r.Header.Add(tt.header, v)
+		}
+		got, ok := f.ClientAddr(r)
+		if ok != tt.wantOK || (ok && got.String() != tt.want) {
+			t.Errorf("f.ClientAddr(%s: %v) = (%v, %v), want (%v, %v)",
+				tt.header, tt.values, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFilterMatcher(t *testing.T) {
+	var f Filter
+	f.SetDeny(set("1.2.0.0/16"))
+	f.SetMatcher(&netipds.Matcher{
+		Allow:      set("1.2.3.0/24"),
+		Deny:       set("1.2.0.0/16"),
+		Resolution: netipds.MostSpecific,
+		Default:    netipds.Allow,
+	})
+	for addr, want := range map[string]bool{"1.2.3.4": true, "1.2.4.4": false, "5.6.7.8": true} {
+		if got := f.Allowed(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("f.Allowed(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	// The lists apply again without a Matcher
+	f.SetMatcher(nil)
+	if f.Allowed(netip.MustParseAddr("1.2.3.4")) {
+		t.Errorf("f.Allowed(1.2.3.4) = true after SetMatcher(nil)")
+	}
+}
+
+func TestFilterHandler(t *testing.T) {
+	var f Filter
+	f.SetDeny(set("1.2.3.0/24"))
+	h := f.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"1.2.3.4:1234", http.StatusForbidden},
+		{"1.2.4.4:1234", http.StatusNoContent},
+		{"bogus", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("status for %s = %d, want %d", tt.remoteAddr, w.Code, tt.want)
+		}
+	}
+
+	// Lists can be swapped while in use
+	f.SetDeny(nil)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status after SetDeny(nil) = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	// Denied requests can be handled
+	f.SetDeny(set("1.2.3.0/24"))
+	f.Denied = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("status with Denied = %d, want %d", w.Code, http.StatusTeapot)
+	}
+}
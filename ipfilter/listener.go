@@ -0,0 +1,62 @@
+package ipfilter
+
+import (
+	"net"
+	"net/netip"
+)
+
+// FilteredListener wraps a net.Listener so that connections from addresses
+// which are not allowed by Filter are closed before they are returned from
+// Accept. Since Filter's lists can be replaced at any time, the set of allowed
+// sources can be changed without restarting the listener.
+type FilteredListener struct {
+	net.Listener
+
+	// Filter decides which remote addresses are allowed. If nil, every
+	// connection is allowed.
+	Filter *Filter
+
+	// OnReject, if non-nil, is called with the remote address of each
+	// rejected connection, before the connection is closed.
+	OnReject func(net.Addr)
+}
+
+// Accept waits for and returns the next connection from an allowed address.
+// Connections from other addresses, including those which are not IP
+// addresses, are closed.
+func (l *FilteredListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.Filter == nil {
+			return c, nil
+		}
+		if a, ok := addrFromNetAddr(c.RemoteAddr()); ok && l.Filter.Allowed(a) {
+			return c, nil
+		}
+		if l.OnReject != nil {
+			l.OnReject(c.RemoteAddr())
+		}
+		c.Close()
+	}
+}
+
+// addrFromNetAddr returns the IP address of a, if it has one.
+func addrFromNetAddr(a net.Addr) (netip.Addr, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort().Addr().Unmap(), true
+	case *net.UDPAddr:
+		return a.AddrPort().Addr().Unmap(), true
+	case *net.IPAddr:
+		addr, ok := netip.AddrFromSlice(a.IP)
+		return addr.Unmap(), ok
+	}
+	if a == nil {
+		return netip.Addr{}, false
+	}
+	ap, err := netip.ParseAddrPort(a.String())
+	return ap.Addr().Unmap(), err == nil
+}
@@ -0,0 +1,96 @@
+package ipfilter
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeConn is a net.Conn with a fixed remote address.
+type fakeConn struct {
+	net.Conn
+	remote net.Addr
+	closed bool
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr { return c.remote }
+func (c *fakeConn) Close() error         { c.closed = true; return nil }
+
+// fakeListener returns its conns from Accept in order, then an error.
+type fakeListener struct {
+	net.Listener
+	conns []*fakeConn
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	if len(l.conns) == 0 {
+		return nil, errors.New("closed")
+	}
+	c := l.conns[0]
+	l.conns = l.conns[1:]
+	return c, nil
+}
+
+func TestFilteredListenerAccept(t *testing.T) {
+	denied := &fakeConn{remote: &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1}}
+	unix := &fakeConn{remote: &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}}
+	allowed := &fakeConn{remote: &net.TCPAddr{IP: net.IPv4(5, 6, 7, 8), Port: 1}}
+
+	var f Filter
+	f.SetDeny(set("1.2.3.0/24"))
+	var rejected []net.Addr
+	l := &FilteredListener{
+		Listener: &fakeListener{conns: []*fakeConn{denied, unix, allowed}},
+		Filter:   &f,
+		OnReject: func(a net.Addr) { rejected = append(rejected, a) },
+	}
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("l.Accept() error = %v", err)
+	}
+	if c != allowed {
+		t.Errorf("l.Accept() = %v, want %v", c.RemoteAddr(), allowed.RemoteAddr())
+	}
+	if !denied.closed || !unix.closed || allowed.closed {
+		t.Errorf("closed = (%v, %v, %v), want (true, true, false)",
+			denied.closed, unix.closed, allowed.closed)
+	}
+	if len(rejected) != 2 {
+		t.Errorf("rejected %v, want 2 addresses", rejected)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Errorf("l.Accept() on exhausted listener succeeded, want error")
+	}
+}
+
+func TestFilteredListenerNilFilter(t *testing.T) {
+	unix := &fakeConn{remote: &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}}
+	l := &FilteredListener{Listener: &fakeListener{conns: []*fakeConn{unix}}}
+	if c, err := l.Accept(); err != nil || c != unix {
+		t.Errorf("l.Accept() = (%v, %v), want (%v, nil)", c, err, unix)
+	}
+}
+
+func TestFilteredListenerTCP(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen:", err)
+	}
+	defer inner.Close()
+
+	var f Filter
+	f.SetAllow(set("127.0.0.0/8"))
+	l := &FilteredListener{Listener: inner, Filter: &f}
+
+	go func() {
+		if c, err := net.Dial("tcp", inner.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("l.Accept() error = %v", err)
+	}
+	c.Close()
+}
@@ -0,0 +1,77 @@
+package netipds
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// prefixFromIPNet returns the Prefix equivalent to n.
+//
+// IPv4 networks are returned as IPv4 Prefixes regardless of whether n.IP is
+// stored in its 4- or 16-byte form. n's mask must be canonical (i.e. a run of
+// ones followed by zeros).
+func prefixFromIPNet(n *net.IPNet) (netip.Prefix, error) {
+	if n == nil {
+		return netip.Prefix{}, fmt.Errorf("IPNet is nil")
+	}
+	ones, bits := n.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, fmt.Errorf("IPNet mask is not canonical: %v", n)
+	}
+	addr, ok := netip.AddrFromSlice(n.IP)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("IPNet is not valid: %v", n)
+	}
+	switch {
+	case bits == 32 && (addr.Is4() || addr.Is4In6()):
+		addr = addr.Unmap()
+	case bits != addr.BitLen():
+		return netip.Prefix{}, fmt.Errorf("IPNet mask does not match address: %v", n)
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), nil
+}
+
+// ipNetFromPrefix returns the IPNet equivalent to p.
+func ipNetFromPrefix(p netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   net.IP(p.Addr().AsSlice()),
+		Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+	}
+}
+
+// AddIPNet adds the Prefix equivalent to n to s.
+//
+// IPv4 networks are added as IPv4 Prefixes regardless of whether n.IP is
+// stored in its 4- or 16-byte form. AddIPNet returns an error if n's mask is
+// not canonical (i.e. a run of ones followed by zeros) or does not match n.IP.
+func (s *PrefixSetBuilder) AddIPNet(n *net.IPNet) error {
+	p, err := prefixFromIPNet(n)
+	if err != nil {
+		return err
+	}
+	return s.Add(p)
+}
+
+// SetIPNet associates v with the Prefix equivalent to n. See
+// [PrefixSetBuilder.AddIPNet] for details on the conversion.
+func (m *PrefixMapBuilder[T]) SetIPNet(n *net.IPNet, v T) error {
+	p, err := prefixFromIPNet(n)
+	if err != nil {
+		return err
+	}
+	return m.Set(p, v)
+}
+
+// ToIPNets returns a slice of IPNets equivalent to the Prefixes in s. IPv4
+// networks use 4-byte IPs and masks.
+func (s *PrefixSet) ToIPNets() []*net.IPNet {
+	res := make([]*net.IPNet, 0, s.stats.size())
+	s.tree.walk(key{}, func(n *tree[bool]) bool {
+		if n.hasEntry {
+			res = append(res, ipNetFromPrefix(n.key.toPrefix()))
+		}
+		return false
+	})
+	return res
+}
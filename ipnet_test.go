@@ -0,0 +1,99 @@
+package netipds
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetBuilderAddIPNet(t *testing.T) {
+	tests := []struct {
+		n       *net.IPNet
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{
+			&net.IPNet{IP: net.IPv4(1, 2, 3, 0).To4(), Mask: net.CIDRMask(24, 32)},
+			pfxs("1.2.3.0/24"),
+			false,
+		},
+		// 16-byte IPv4 address with a 4-byte mask
+		{
+			&net.IPNet{IP: net.IPv4(1, 2, 3, 0), Mask: net.CIDRMask(24, 32)},
+			pfxs("1.2.3.0/24"),
+			false,
+		},
+		// Host bits are masked off
+		{
+			&net.IPNet{IP: net.IPv4(1, 2, 3, 4).To4(), Mask: net.CIDRMask(24, 32)},
+			pfxs("1.2.3.0/24"),
+			false,
+		},
+		{
+			&net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)},
+			pfxs("2001:db8::/32"),
+			false,
+		},
+		// Errors
+		{nil, pfxs(), true},
+		{
+			&net.IPNet{IP: net.IPv4(1, 2, 3, 0).To4(), Mask: net.IPv4Mask(255, 0, 255, 0)},
+			pfxs(),
+			true,
+		},
+		{
+			&net.IPNet{IP: net.IPv4(1, 2, 3, 0).To4(), Mask: net.CIDRMask(24, 128)},
+			pfxs(),
+			true,
+		},
+		{
+			&net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(24, 32)},
+			pfxs(),
+			true,
+		},
+		{&net.IPNet{IP: net.IP{1, 2, 3}, Mask: net.CIDRMask(24, 32)}, pfxs(), true},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		if err := psb.AddIPNet(tt.n); (err != nil) != tt.wantErr {
+			t.Errorf("psb.AddIPNet(%v) error = %v, want error %v", tt.n, err, tt.wantErr)
+		}
+		checkPrefixSlice(t, psb.PrefixSet().Prefixes(), tt.want)
+	}
+}
+
+func TestPrefixMapBuilderSetIPNet(t *testing.T) {
+	_, n, _ := net.ParseCIDR("1.2.3.0/24")
+	pmb := &PrefixMapBuilder[int]{}
+	if err := pmb.SetIPNet(n, 1); err != nil {
+		t.Fatalf("pmb.SetIPNet(%v) error = %v", n, err)
+	}
+	checkMap(t, wantMap(1, "1.2.3.0/24"), pmb.PrefixMap().ToMap())
+}
+
+func TestPrefixSetToIPNets(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.3.0/24", "2001:db8::/32") {
+		psb.Add(p)
+	}
+	got := psb.PrefixSet().ToIPNets()
+	want := []string{"1.2.3.0/24", "2001:db8::/32"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, n := range got {
+		if n.String() != want[i] {
+			t.Errorf("got %v, want %v", n, want[i])
+		}
+	}
+	if len(got[0].IP) != net.IPv4len || len(got[0].Mask) != net.IPv4len {
+		t.Errorf("got %#v, want 4-byte IP and mask", got[0])
+	}
+
+	// Round trip
+	rt := &PrefixSetBuilder{}
+	for _, n := range got {
+		rt.AddIPNet(n)
+	}
+	checkPrefixSlice(t, rt.PrefixSet().Prefixes(), psb.PrefixSet().Prefixes())
+}
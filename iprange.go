@@ -0,0 +1,351 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"sort"
+)
+
+// IPRange is an inclusive range of addresses, [From, To]. From and To must be
+// of the same address family.
+type IPRange struct {
+	From, To netip.Addr
+}
+
+// IPRangeFrom returns the IPRange [from, to].
+func IPRangeFrom(from, to netip.Addr) IPRange {
+	return IPRange{from, to}
+}
+
+// IsValid reports whether r's endpoints are valid addresses of the same
+// family, with From <= To.
+func (r IPRange) IsValid() bool {
+	return r.From.IsValid() && r.To.IsValid() &&
+		r.From.Is4() == r.To.Is4() && !r.To.Less(r.From)
+}
+
+// String returns r in the form "From-To".
+func (r IPRange) String() string {
+	return r.From.String() + "-" + r.To.String()
+}
+
+// Prefixes returns the smallest set of Prefixes which exactly covers r, in
+// ascending order. It returns nil if r is not valid.
+func (r IPRange) Prefixes() []netip.Prefix {
+	if !r.IsValid() {
+		return nil
+	}
+	var ret []netip.Prefix
+	lo, hi := r.bounds()
+	rangeKeys(lo, hi, func(k key) bool {
+		ret = append(ret, k.toPrefix())
+		return true
+	})
+	return ret
+}
+
+// bounds returns the key content of r's endpoints. r must be valid.
+func (r IPRange) bounds() (lo, hi uint128) {
+	return keyFromAddr(r.From).content, keyFromAddr(r.To).content
+}
+
+// ipRangeFromBounds returns the IPRange with endpoints lo and hi, which are
+// key contents.
+func ipRangeFromBounds(lo, hi uint128) IPRange {
+	return IPRange{addrFromContent(lo), addrFromContent(hi)}
+}
+
+// addrFromContent returns the address represented by the key content u.
+func addrFromContent(u uint128) netip.Addr {
+	var a16 [16]byte
+	bePutUint64(a16[:8], u.hi)
+	bePutUint64(a16[8:], u.lo)
+	return netip.AddrFrom16(a16).Unmap()
+}
+
+// rangeEntry is an entry in a rangeList.
+type rangeEntry[T any] struct {
+	lo, hi uint128
+	value  T
+}
+
+// rangeList is a list of non-overlapping ranges of key contents with values,
+// in ascending order.
+type rangeList[T any] []rangeEntry[T]
+
+// span returns the indexes [i, j) of the entries in l which overlap [lo, hi].
+func (l rangeList[T]) span(lo, hi uint128) (i, j int) {
+	i = sort.Search(len(l), func(i int) bool { return !l[i].hi.less(lo) })
+	j = sort.Search(len(l), func(j int) bool { return hi.less(l[j].lo) })
+	return i, max(i, j)
+}
+
+// set associates v with [lo, hi], replacing any overlapping portions of
+// existing entries. If remove is true, [lo, hi] is cleared instead.
+func (l rangeList[T]) set(lo, hi uint128, v T, remove bool) rangeList[T] {
+	i, j := l.span(lo, hi)
+	repl := make([]rangeEntry[T], 0, 3)
+	if i < j && l[i].lo.less(lo) {
+		repl = append(repl, rangeEntry[T]{l[i].lo, lo.subOne(), l[i].value})
+	}
+	if !remove {
+		repl = append(repl, rangeEntry[T]{lo, hi, v})
+	}
+	if i < j && hi.less(l[j-1].hi) {
+		repl = append(repl, rangeEntry[T]{hi.addOne(), l[j-1].hi, l[j-1].value})
+	}
+	return slices.Replace(l, i, j, repl...)
+}
+
+// coalesce joins adjacent entries of l, keeping the first value of each
+// joined run.
+func (l rangeList[T]) coalesce() rangeList[T] {
+	ret := make(rangeList[T], 0, len(l))
+	for _, e := range l {
+		if last := len(ret) - 1; last >= 0 && ret[last].hi.addOne() == e.lo &&
+			// Don't join IPv4 and IPv6
+			keyFromContent(e.lo).is4() == keyFromContent(ret[last].hi).is4() {
+			ret[last].hi = e.hi
+		} else {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// keyFromContent returns the single-address key with content u.
+func keyFromContent(u uint128) key {
+	return key{u, 0, 128}
+}
+
+// IPRangeSetBuilder builds an immutable [IPRangeSet].
+//
+// The zero value is a valid IPRangeSetBuilder representing a builder with no
+// addresses.
+type IPRangeSetBuilder struct {
+	ranges rangeList[struct{}]
+}
+
+// Add adds the addresses in r to s.
+func (s *IPRangeSetBuilder) Add(r IPRange) error {
+	if !r.IsValid() {
+		return invalidRangeError(r)
+	}
+	lo, hi := r.bounds()
+	s.ranges = s.ranges.set(lo, hi, struct{}{}, false)
+	return nil
+}
+
+// AddPrefix adds the addresses in p to s.
+func (s *IPRangeSetBuilder) AddPrefix(p netip.Prefix) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	k := keyFromPrefix(p)
+	s.ranges = s.ranges.set(k.content, k.content.bitsSetFrom(k.len), struct{}{}, false)
+	return nil
+}
+
+// AddPrefixSet adds the addresses covered by o to s.
+func (s *IPRangeSetBuilder) AddPrefixSet(o *PrefixSet) {
+	for _, r := range o.tree.coverage() {
+		s.ranges = s.ranges.set(r.lo, r.hi, struct{}{}, false)
+	}
+}
+
+// Remove removes the addresses in r from s.
+func (s *IPRangeSetBuilder) Remove(r IPRange) error {
+	if !r.IsValid() {
+		return invalidRangeError(r)
+	}
+	lo, hi := r.bounds()
+	s.ranges = s.ranges.set(lo, hi, struct{}{}, true)
+	return nil
+}
+
+// IPRangeSet returns an immutable IPRangeSet representing the current state
+// of s.
+//
+// The builder remains usable after calling IPRangeSet.
+func (s *IPRangeSetBuilder) IPRangeSet() *IPRangeSet {
+	return &IPRangeSet{s.ranges.coalesce()}
+}
+
+// IPRangeSet is a set of IP addresses stored as non-overlapping ranges. Unlike
+// a [PrefixSet], it can store ranges that do not fall on CIDR boundaries
+// without splitting them into many Prefixes.
+//
+// Use [IPRangeSetBuilder] to construct IPRangeSets.
+type IPRangeSet struct {
+	// Adjacent ranges are coalesced, except across the IPv4/IPv6 boundary.
+	ranges rangeList[struct{}]
+}
+
+// Contains returns true if a is in s. a's zone, if any, is ignored.
+func (s *IPRangeSet) Contains(a netip.Addr) bool {
+	if !a.IsValid() {
+		return false
+	}
+	u := keyFromAddr(a).content
+	i, j := s.ranges.span(u, u)
+	return i < j
+}
+
+// ContainsRange returns true if every address in r is in s.
+func (s *IPRangeSet) ContainsRange(r IPRange) bool {
+	if !r.IsValid() {
+		return false
+	}
+	lo, hi := r.bounds()
+	i, j := s.ranges.span(lo, hi)
+	return j-i == 1 && !lo.less(s.ranges[i].lo) && !s.ranges[i].hi.less(hi)
+}
+
+// Overlaps returns true if any address in r is in s.
+func (s *IPRangeSet) Overlaps(r IPRange) bool {
+	if !r.IsValid() {
+		return false
+	}
+	i, j := s.ranges.span(r.bounds())
+	return i < j
+}
+
+// OverlappingRanges returns the ranges of s which overlap r, in ascending
+// order. The ranges are not clipped to r.
+func (s *IPRangeSet) OverlappingRanges(r IPRange) []IPRange {
+	if !r.IsValid() {
+		return nil
+	}
+	i, j := s.ranges.span(r.bounds())
+	ret := make([]IPRange, 0, j-i)
+	for _, e := range s.ranges[i:j] {
+		ret = append(ret, ipRangeFromBounds(e.lo, e.hi))
+	}
+	return ret
+}
+
+// Ranges returns the ranges in s, in ascending order. Adjacent ranges are
+// joined, except where IPv4 and IPv6 meet.
+func (s *IPRangeSet) Ranges() []IPRange {
+	ret := make([]IPRange, len(s.ranges))
+	for i, e := range s.ranges {
+		ret[i] = ipRangeFromBounds(e.lo, e.hi)
+	}
+	return ret
+}
+
+// Size returns the number of ranges in s.
+func (s *IPRangeSet) Size() int {
+	return len(s.ranges)
+}
+
+// PrefixSet returns a PrefixSet containing the smallest set of Prefixes which
+// exactly covers s.
+func (s *IPRangeSet) PrefixSet() *PrefixSet {
+	ret := &tree[bool]{}
+	for _, e := range s.ranges {
+		rangeKeys(e.lo, e.hi, func(k key) bool {
+			ret = ret.insert(k, true)
+			return true
+		})
+	}
+	return &PrefixSet{*ret, ret.stats()}
+}
+
+// IPRangeMapBuilder builds an immutable [IPRangeMap].
+//
+// The zero value is a valid IPRangeMapBuilder representing a builder with no
+// addresses.
+type IPRangeMapBuilder[T any] struct {
+	ranges rangeList[T]
+}
+
+// Set associates v with the addresses in r, replacing the values of any
+// addresses that were already in m.
+func (m *IPRangeMapBuilder[T]) Set(r IPRange, v T) error {
+	if !r.IsValid() {
+		return invalidRangeError(r)
+	}
+	lo, hi := r.bounds()
+	m.ranges = m.ranges.set(lo, hi, v, false)
+	return nil
+}
+
+// Remove removes the addresses in r from m.
+func (m *IPRangeMapBuilder[T]) Remove(r IPRange) error {
+	if !r.IsValid() {
+		return invalidRangeError(r)
+	}
+	lo, hi := r.bounds()
+	var zero T
+	m.ranges = m.ranges.set(lo, hi, zero, true)
+	return nil
+}
+
+// IPRangeMap returns an immutable IPRangeMap representing the current state
+// of m.
+//
+// The builder remains usable after calling IPRangeMap.
+func (m *IPRangeMapBuilder[T]) IPRangeMap() *IPRangeMap[T] {
+	return &IPRangeMap[T]{slices.Clone(m.ranges)}
+}
+
+// IPRangeMap is a map of non-overlapping IP address ranges to values of type
+// T. Where ranges were set on top of each other, the most recent value wins.
+//
+// Use [IPRangeMapBuilder] to construct IPRangeMaps.
+type IPRangeMap[T any] struct {
+	ranges rangeList[T]
+}
+
+// Get returns the value associated with a, if any. a's zone, if any, is
+// ignored.
+func (m *IPRangeMap[T]) Get(a netip.Addr) (val T, ok bool) {
+	if !a.IsValid() {
+		return val, false
+	}
+	u := keyFromAddr(a).content
+	if i, j := m.ranges.span(u, u); i < j {
+		return m.ranges[i].value, true
+	}
+	return val, false
+}
+
+// Overlapping returns the ranges of m which overlap r, along with their
+// values, in ascending order. The ranges are not clipped to r.
+func (m *IPRangeMap[T]) Overlapping(r IPRange) ([]IPRange, []T) {
+	if !r.IsValid() {
+		return nil, nil
+	}
+	i, j := m.ranges.span(r.bounds())
+	ranges, values := make([]IPRange, 0, j-i), make([]T, 0, j-i)
+	for _, e := range m.ranges[i:j] {
+		ranges = append(ranges, ipRangeFromBounds(e.lo, e.hi))
+		values = append(values, e.value)
+	}
+	return ranges, values
+}
+
+// Ranges returns the ranges in m, in ascending order, along with their
+// values.
+func (m *IPRangeMap[T]) Ranges() ([]IPRange, []T) {
+	ranges, values := make([]IPRange, len(m.ranges)), make([]T, len(m.ranges))
+	for i, e := range m.ranges {
+		ranges[i], values[i] = ipRangeFromBounds(e.lo, e.hi), e.value
+	}
+	return ranges, values
+}
+
+// Size returns the number of ranges in m.
+func (m *IPRangeMap[T]) Size() int {
+	return len(m.ranges)
+}
+
+// IPRangeSet returns an IPRangeSet containing the addresses in m.
+func (m *IPRangeMap[T]) IPRangeSet() *IPRangeSet {
+	ranges := make(rangeList[struct{}], len(m.ranges))
+	for i, e := range m.ranges {
+		ranges[i] = rangeEntry[struct{}]{lo: e.lo, hi: e.hi}
+	}
+	return &IPRangeSet{ranges.coalesce()}
+}
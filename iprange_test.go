@@ -0,0 +1,190 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func ipr(s string) IPRange {
+	for i := range s {
+		if s[i] == '-' {
+			return IPRange{netip.MustParseAddr(s[:i]), netip.MustParseAddr(s[i+1:])}
+		}
+	}
+	a := netip.MustParseAddr(s)
+	return IPRange{a, a}
+}
+
+func iprs(strings ...string) []IPRange {
+	rs := make([]IPRange, len(strings))
+	for i, s := range strings {
+		rs[i] = ipr(s)
+	}
+	return rs
+}
+
+func TestIPRangePrefixes(t *testing.T) {
+	tests := []struct {
+		r    IPRange
+		want []netip.Prefix
+	}{
+		{ipr("1.2.3.0-1.2.3.255"), pfxs("1.2.3.0/24")},
+		{ipr("1.2.3.4"), pfxs("1.2.3.4/32")},
+		{ipr("1.2.3.1-1.2.3.6"), pfxs("1.2.3.1/32", "1.2.3.2/31", "1.2.3.4/31", "1.2.3.6/32")},
+		{ipr("::-::3"), pfxs("::/126")},
+		{ipr("1.2.3.4-1.2.3.3"), nil},
+		{ipr("1.2.3.4-::1"), nil},
+		{IPRange{}, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Prefixes(); !slices.Equal(got, tt.want) {
+			t.Errorf("%v.Prefixes() = %v, want %v", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestIPRangeSet(t *testing.T) {
+	tests := []struct {
+		add    []IPRange
+		remove []IPRange
+		want   []IPRange
+	}{
+		{iprs(), iprs(), iprs()},
+		{iprs("1.2.3.4-1.2.3.9"), iprs(), iprs("1.2.3.4-1.2.3.9")},
+		// Overlapping and adjacent ranges are joined
+		{iprs("1.2.3.4-1.2.3.9", "1.2.3.8-1.2.3.20"), iprs(), iprs("1.2.3.4-1.2.3.20")},
+		{iprs("1.2.3.4-1.2.3.9", "1.2.3.10-1.2.3.20"), iprs(), iprs("1.2.3.4-1.2.3.20")},
+		{iprs("1.2.3.10-1.2.3.20", "1.2.3.4-1.2.3.9"), iprs(), iprs("1.2.3.4-1.2.3.20")},
+		{iprs("1.2.3.4", "1.2.3.6", "1.2.3.0-1.2.3.255"), iprs(), iprs("1.2.3.0-1.2.3.255")},
+		{iprs("1.2.3.4", "1.2.3.6"), iprs(), iprs("1.2.3.4", "1.2.3.6")},
+		// Removal splits ranges
+		{iprs("1.2.3.0-1.2.3.255"), iprs("1.2.3.10-1.2.3.19"), iprs("1.2.3.0-1.2.3.9", "1.2.3.20-1.2.3.255")},
+		{iprs("1.2.3.0-1.2.3.9", "1.2.3.20-1.2.3.29"), iprs("1.2.3.5-1.2.3.24"), iprs("1.2.3.0-1.2.3.4", "1.2.3.25-1.2.3.29")},
+		{iprs("1.2.3.0-1.2.3.9"), iprs("1.2.3.0-1.2.3.9"), iprs()},
+		// IPv4 and IPv6 are not joined
+		{
+			iprs("::fffe:ffff:ffff-::fffe:ffff:ffff", "0.0.0.0-0.0.0.1"), iprs(),
+			iprs("::fffe:ffff:ffff", "0.0.0.0-0.0.0.1"),
+		},
+		{iprs("2001:db8::1-2001:db8::ff"), iprs(), iprs("2001:db8::1-2001:db8::ff")},
+	}
+	for _, tt := range tests {
+		b := &IPRangeSetBuilder{}
+		for _, r := range tt.add {
+			if err := b.Add(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, r := range tt.remove {
+			if err := b.Remove(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := b.IPRangeSet().Ranges(); !slices.Equal(got, tt.want) {
+			t.Errorf("add %v remove %v: got %v, want %v", tt.add, tt.remove, got, tt.want)
+		}
+	}
+}
+
+func TestIPRangeSetQueries(t *testing.T) {
+	b := &IPRangeSetBuilder{}
+	b.Add(ipr("1.2.3.4-1.2.3.9"))
+	b.Add(ipr("1.2.3.20-1.2.3.29"))
+	b.AddPrefix(pfx("2001:db8::/126"))
+	s := b.IPRangeSet()
+
+	for _, tt := range []struct {
+		addr string
+		want bool
+	}{
+		{"1.2.3.3", false},
+		{"1.2.3.4", true},
+		{"1.2.3.9", true},
+		{"1.2.3.10", false},
+		{"::ffff:1.2.3.25", true},
+		{"2001:db8::3", true},
+		{"2001:db8::4", false},
+	} {
+		if got := s.Contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		r                  IPRange
+		contains, overlaps bool
+		overlapping        []IPRange
+	}{
+		{ipr("1.2.3.5-1.2.3.8"), true, true, iprs("1.2.3.4-1.2.3.9")},
+		{ipr("1.2.3.5-1.2.3.10"), false, true, iprs("1.2.3.4-1.2.3.9")},
+		{ipr("1.2.3.10-1.2.3.19"), false, false, iprs()},
+		{ipr("1.2.3.0-1.2.3.255"), false, true, iprs("1.2.3.4-1.2.3.9", "1.2.3.20-1.2.3.29")},
+	} {
+		if got := s.ContainsRange(tt.r); got != tt.contains {
+			t.Errorf("ContainsRange(%v) = %v, want %v", tt.r, got, tt.contains)
+		}
+		if got := s.Overlaps(tt.r); got != tt.overlaps {
+			t.Errorf("Overlaps(%v) = %v, want %v", tt.r, got, tt.overlaps)
+		}
+		if got := s.OverlappingRanges(tt.r); !slices.Equal(got, tt.overlapping) {
+			t.Errorf("OverlappingRanges(%v) = %v, want %v", tt.r, got, tt.overlapping)
+		}
+	}
+
+	// Conversion to and from PrefixSet
+	checkPrefixSlice(t, s.PrefixSet().Prefixes(), pfxs(
+		"1.2.3.4/30", "1.2.3.8/31", "1.2.3.20/30", "1.2.3.24/30", "1.2.3.28/31",
+		"2001:db8::/126",
+	))
+	b2 := &IPRangeSetBuilder{}
+	b2.AddPrefixSet(s.PrefixSet())
+	if got := b2.IPRangeSet().Ranges(); !slices.Equal(got, s.Ranges()) {
+		t.Errorf("AddPrefixSet: got %v, want %v", got, s.Ranges())
+	}
+}
+
+func TestIPRangeMap(t *testing.T) {
+	b := &IPRangeMapBuilder[string]{}
+	b.Set(ipr("1.2.3.0-1.2.3.255"), "a")
+	b.Set(ipr("1.2.3.10-1.2.3.19"), "b")
+	b.Set(ipr("1.2.3.200-1.2.4.10"), "c")
+	b.Remove(ipr("1.2.3.0-1.2.3.4"))
+	m := b.IPRangeMap()
+
+	ranges, values := m.Ranges()
+	wantRanges := iprs("1.2.3.5-1.2.3.9", "1.2.3.10-1.2.3.19", "1.2.3.20-1.2.3.199", "1.2.3.200-1.2.4.10")
+	wantValues := []string{"a", "b", "a", "c"}
+	if !slices.Equal(ranges, wantRanges) || !slices.Equal(values, wantValues) {
+		t.Errorf("Ranges() = %v, %v, want %v, %v", ranges, values, wantRanges, wantValues)
+	}
+	for _, tt := range []struct {
+		addr   string
+		want   string
+		wantOK bool
+	}{
+		{"1.2.3.4", "", false},
+		{"1.2.3.5", "a", true},
+		{"1.2.3.15", "b", true},
+		{"1.2.3.100", "a", true},
+		{"1.2.4.0", "c", true},
+		{"1.2.4.11", "", false},
+	} {
+		if got, ok := m.Get(netip.MustParseAddr(tt.addr)); got != tt.want || ok != tt.wantOK {
+			t.Errorf("Get(%s) = (%v, %v), want (%v, %v)", tt.addr, got, ok, tt.want, tt.wantOK)
+		}
+	}
+	ranges, values = m.Overlapping(ipr("1.2.3.15-1.2.3.25"))
+	if !slices.Equal(ranges, iprs("1.2.3.10-1.2.3.19", "1.2.3.20-1.2.3.199")) ||
+		!slices.Equal(values, []string{"b", "a"}) {
+		t.Errorf("Overlapping() = %v, %v", ranges, values)
+	}
+	if got := m.IPRangeSet().Ranges(); !slices.Equal(got, iprs("1.2.3.5-1.2.4.10")) {
+		t.Errorf("IPRangeSet() = %v", got)
+	}
+
+	// Later changes to the builder don't affect m
+	b.Set(ipr("1.2.3.5"), "d")
+	if got, _ := m.Get(netip.MustParseAddr("1.2.3.5")); got != "a" {
+		t.Errorf("IPRangeMap was modified by its builder")
+	}
+}
@@ -2,6 +2,7 @@ package netipds
 
 import (
 	"fmt"
+	"io"
 	"net/netip"
 	"strings"
 )
@@ -42,6 +43,73 @@ func keyFromPrefix(p netip.Prefix) key {
 	return newKey(u128From16(addr.As16()), 0, bits)
 }
 
+// lenBounds converts the Prefix length bounds [minBits, maxBits], expressed
+// in terms of p's address family, to key length bounds. The bounds are
+// clamped to the valid lengths for p's address family.
+func lenBounds(p netip.Prefix, minBits, maxBits int) (lo, hi uint8) {
+	minBits = max(minBits, 0)
+	maxBits = min(maxBits, p.Addr().BitLen())
+	if maxBits < minBits {
+		// Nothing can match
+		return 1, 0
+	}
+	lo, hi = uint8(minBits), uint8(maxBits)
+	if p.Addr().Is4() {
+		lo, hi = lo+96, hi+96
+	}
+	return lo, hi
+}
+
+// keyFromAddr returns the key that represents the single-address Prefix
+// containing a. a must be valid.
+func keyFromAddr(a netip.Addr) key {
+	return key{u128From16(a.As16()), 0, 128}
+}
+
+// rangeKeys calls fn with each key in the smallest set of keys which exactly
+// covers the range of 128-bit values [from, to], in ascending order. If fn
+// returns false, rangeKeys stops.
+func rangeKeys(from, to uint128, fn func(key) bool) {
+	for !to.less(from) {
+		// Find the shortest key starting at from that doesn't extend past to
+		var n uint8
+		for ; n < 128; n++ {
+			if from.bitsClearedFrom(n) == from && !to.less(from.bitsSetFrom(n)) {
+				break
+			}
+		}
+		last := from.bitsSetFrom(n)
+		if !fn(newKey(from, 0, n)) || last == to {
+			return
+		}
+		from = last.addOne()
+	}
+}
+
+// compare returns -1, 0, or +1 depending on whether k sorts before, the same
+// as, or after o in a pre-order traversal of a tree. k.offset and o.offset are
+// ignored.
+func (k key) compare(o key) int {
+	switch {
+	case k.content.less(o.content):
+		return -1
+	case o.content.less(k.content):
+		return 1
+	case k.len < o.len:
+		return -1
+	case k.len > o.len:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// is4 reports whether k represents an IPv4 Prefix, i.e. lies within the
+// IPv4-mapped IPv6 range ::ffff:0:0/96.
+func (k key) is4() bool {
+	return k.len >= 96 && k.content.hi == 0 && k.content.lo>>32 == 0xffff
+}
+
 // toPrefix returns the Prefix represented by k.
 func (k key) toPrefix() netip.Prefix {
 	var a16 [16]byte
@@ -55,6 +123,36 @@ func (k key) toPrefix() netip.Prefix {
 	return netip.PrefixFrom(addr.Unmap(), bits)
 }
 
+// appendBinary appends the compact binary encoding of k to b and returns the
+// result: one byte containing k.len, followed by the ceil(k.len/8)
+// most-significant bytes of k.content. k.offset is not encoded.
+func (k key) appendBinary(b []byte) []byte {
+	var a16 [16]byte
+	bePutUint64(a16[:8], k.content.hi)
+	bePutUint64(a16[8:], k.content.lo)
+	b = append(b, k.len)
+	return append(b, a16[:(int(k.len)+7)/8]...)
+}
+
+// keyFromBinary decodes a key encoded by appendBinary from the start of b,
+// returning the key and the number of bytes consumed.
+func keyFromBinary(b []byte) (key, int, error) {
+	if len(b) < 1 {
+		return key{}, 0, fmt.Errorf("failed to decode key: %w", io.ErrUnexpectedEOF)
+	}
+	l := b[0]
+	if l > 128 {
+		return key{}, 0, fmt.Errorf("failed to decode key: invalid length %d", l)
+	}
+	n := 1 + (int(l)+7)/8
+	if len(b) < n {
+		return key{}, 0, fmt.Errorf("failed to decode key: %w", io.ErrUnexpectedEOF)
+	}
+	var a16 [16]byte
+	copy(a16[:], b[1:n])
+	return newKey(u128From16(a16), 0, l), n, nil
+}
+
 // bit is used as a selector for a node's children.
 //
 // bitL refers to the left child, and bitR to the right.
@@ -1,6 +1,7 @@
 package netipds
 
 import (
+	"net/netip"
 	"testing"
 )
 
@@ -135,3 +136,60 @@ func TestKeyNext(t *testing.T) {
 		}
 	}
 }
+
+func TestRangeKeys(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     []netip.Prefix
+	}{
+		{"::0", "::0", pfxs("::0/128")},
+		{"::0", "::1", pfxs("::0/127")},
+		{"::1", "::2", pfxs("::1/128", "::2/128")},
+		{"::1", "::6", pfxs("::1/128", "::2/127", "::4/127", "::6/128")},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", pfxs("::/0")},
+		{"1.2.3.0", "1.2.3.255", pfxs("1.2.3.0/24")},
+		{"1.2.3.4", "1.2.3.9", pfxs("1.2.3.4/30", "1.2.3.8/31")},
+		{"0.0.0.0", "255.255.255.255", pfxs("0.0.0.0/0")},
+		{"::1", "::0", pfxs()},
+	}
+	for _, tt := range tests {
+		from := u128From16(netip.MustParseAddr(tt.from).As16())
+		to := u128From16(netip.MustParseAddr(tt.to).As16())
+		var got []netip.Prefix
+		rangeKeys(from, to, func(k key) bool {
+			got = append(got, k.toPrefix())
+			return true
+		})
+		checkPrefixSlice(t, got, tt.want)
+	}
+}
+
+func TestKeyBinary(t *testing.T) {
+	tests := []struct {
+		k    key
+		want []byte
+	}{
+		{k(uint128{0, 0}, 0, 0), []byte{0}},
+		{k(uint128{1 << 63, 0}, 0, 1), []byte{1, 0x80}},
+		{k(uint128{0xabcd << 48, 0}, 0, 12), []byte{12, 0xab, 0xc0}},
+		{k(uint128{0xabcd << 48, 0}, 5, 16), []byte{16, 0xab, 0xcd}},
+		{k(uint128{0, 1}, 0, 128), []byte{128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		got := tt.k.appendBinary(nil)
+		if string(got) != string(tt.want) {
+			t.Errorf("%v.appendBinary() = %v, want %v", tt.k, got, tt.want)
+		}
+		dec, n, err := keyFromBinary(append(got, 0xff))
+		if err != nil || n != len(tt.want) || dec != tt.k.rooted() {
+			t.Errorf("keyFromBinary(%v) = (%v, %d, %v), want (%v, %d, nil)",
+				got, dec, n, err, tt.k.rooted(), len(tt.want))
+		}
+	}
+
+	for _, b := range [][]byte{{}, {129}, {16, 0xab}} {
+		if _, _, err := keyFromBinary(b); err == nil {
+			t.Errorf("keyFromBinary(%v) succeeded, want error", b)
+		}
+	}
+}
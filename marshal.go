@@ -0,0 +1,302 @@
+package netipds
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// The binary format written by MarshalBinary is:
+//
+//	magic   [4]byte "NIPD"
+//	version byte    binaryVersion
+//	values  byte    one of the values* constants
+//	count   uvarint the number of nodes
+//	nodes   [count]node
+//	entries the values of the entries, in node order (PrefixMap only)
+//
+// The nodes are listed in preorder (a node, then its left subtree, then its
+// right subtree). Each node is a flags byte (see the node* constants)
+// followed by its key in the encoding of key.appendBinary, so the tree can be
+// rebuilt exactly, without any insertions.
+//
+// If values is valuesBinary, each entry value is a uvarint length followed by the
+// output of its MarshalBinary method. If values is valuesGob, the values are
+// a single gob-encoded []T.
+const (
+	binaryMagic   = "NIPD"
+	binaryVersion = 1
+)
+
+const (
+	valuesNone byte = iota
+	valuesBinary
+	valuesGob
+)
+
+const (
+	nodeEntry byte = 1 << iota
+	nodeLeft
+	nodeRight
+)
+
+// MarshalBinary implements [encoding.BinaryMarshaler]. The encoding is
+// versioned and reproduces the structure of s's tree, so it can be decoded
+// without rebuilding the tree from scratch.
+func (s *PrefixSet) MarshalBinary() ([]byte, error) {
+	return appendTreeBinary(nil, &s.tree, valuesNone, func(*tree[bool]) {}), nil
+}
+
+// UnmarshalBinary implements [encoding.BinaryUnmarshaler]. It replaces the
+// contents of s with a PrefixSet decoded from data, which must have been
+// produced by [PrefixSet.MarshalBinary].
+func (s *PrefixSet) UnmarshalBinary(data []byte) error {
+	var entries []*tree[bool]
+	t, _, err := treeFromBinary(data, valuesNone, func(n *tree[bool]) {
+		entries = append(entries, n)
+	})
+	if err != nil {
+		return err
+	}
+	for _, n := range entries {
+		n.value = true
+	}
+	*s = PrefixSet{*t, t.stats()}
+	return nil
+}
+
+// MarshalBinary implements [encoding.BinaryMarshaler].
+//
+// If T implements [encoding.BinaryMarshaler], values are encoded with its
+// MarshalBinary method, and *T must implement [encoding.BinaryUnmarshaler] to
+// decode them. Otherwise, values are encoded with [encoding/gob], and T must
+// be a type that gob supports.
+//
+// Entry times (see [PrefixMapBuilder.TrackTimes]) are not encoded.
+func (m *PrefixMap[T]) MarshalBinary() ([]byte, error) {
+	enc := mapValueEncoding[T]()
+	var values []T
+	b := appendTreeBinary(nil, &m.tree, enc, func(n *tree[T]) {
+		values = append(values, n.value)
+	})
+	return appendValuesBinary(b, enc, values)
+}
+
+// UnmarshalBinary implements [encoding.BinaryUnmarshaler]. It replaces the
+// contents of m with a PrefixMap decoded from data, which must have been
+// produced by [PrefixMap.MarshalBinary] for the same type T.
+func (m *PrefixMap[T]) UnmarshalBinary(data []byte) error {
+	enc := mapValueEncoding[T]()
+	var entries []*tree[T]
+	t, rest, err := treeFromBinary(data, enc, func(n *tree[T]) {
+		entries = append(entries, n)
+	})
+	if err != nil {
+		return err
+	}
+	values, err := valuesFromBinary[T](rest, enc, len(entries))
+	if err != nil {
+		return err
+	}
+	for i, n := range entries {
+		n.value = values[i]
+	}
+	*m = PrefixMap[T]{*t, t.stats(), nil}
+	return nil
+}
+
+// appendValuesBinary appends values to b in the encoding enc, which must be
+// valuesBinary or valuesGob.
+func appendValuesBinary[T any](b []byte, enc byte, values []T) ([]byte, error) {
+	switch enc {
+	case valuesBinary:
+		for _, v := range values {
+			vb, err := any(v).(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal value: %w", err)
+			}
+			b = binary.AppendUvarint(b, uint64(len(vb)))
+			b = append(b, vb...)
+		}
+	default:
+		buf := bytes.NewBuffer(b)
+		if err := gob.NewEncoder(buf).Encode(values); err != nil {
+			return nil, fmt.Errorf("failed to marshal values: %w", err)
+		}
+		b = buf.Bytes()
+	}
+	return b, nil
+}
+
+// valuesFromBinary decodes n values written by appendValuesBinary in the
+// encoding enc from b, which must contain nothing else.
+func valuesFromBinary[T any](b []byte, enc byte, n int) ([]T, error) {
+	switch enc {
+	case valuesBinary:
+		values := make([]T, n)
+		for i := range values {
+			l, vn := binary.Uvarint(b)
+			if vn <= 0 || uint64(len(b)-vn) < l {
+				return nil, fmt.Errorf("failed to decode value %d: %w", i, errTruncated)
+			}
+			vb := b[vn : vn+int(l)]
+			b = b[vn+int(l):]
+			if err := any(&values[i]).(encoding.BinaryUnmarshaler).UnmarshalBinary(vb); err != nil {
+				return nil, fmt.Errorf("failed to decode value %d: %w", i, err)
+			}
+		}
+		if len(b) > 0 {
+			return nil, fmt.Errorf("%d trailing bytes after values", len(b))
+		}
+		return values, nil
+	default:
+		var values []T
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&values); err != nil {
+			return nil, fmt.Errorf("failed to decode values: %w", err)
+		}
+		if len(values) != n {
+			return nil, fmt.Errorf("got %d values for %d entries", len(values), n)
+		}
+		return values, nil
+	}
+}
+
+// mapValueEncoding returns the encoding used for values of type T.
+func mapValueEncoding[T any]() byte {
+	var v T
+	_, m := any(v).(encoding.BinaryMarshaler)
+	_, u := any(&v).(encoding.BinaryUnmarshaler)
+	if m && u {
+		return valuesBinary
+	}
+	return valuesGob
+}
+
+var errTruncated = errors.New("unexpected end of data")
+
+// appendTreeBinary appends the header and nodes of t to b. It calls entry with
+// each node that has an entry, in node order.
+func appendTreeBinary[T any](
+	b []byte,
+	t *tree[T],
+	values byte,
+	entry func(*tree[T]),
+) []byte {
+	b = append(b, binaryMagic...)
+	b = append(b, binaryVersion, values)
+	count := 0
+	var countNodes func(*tree[T])
+	countNodes = func(n *tree[T]) {
+		count++
+		if n.left != nil {
+			countNodes(n.left)
+		}
+		if n.right != nil {
+			countNodes(n.right)
+		}
+	}
+	countNodes(t)
+	b = binary.AppendUvarint(b, uint64(count))
+
+	var appendNode func(*tree[T])
+	appendNode = func(n *tree[T]) {
+		var flags byte
+		if n.hasEntry {
+			flags |= nodeEntry
+			entry(n)
+		}
+		if n.left != nil {
+			flags |= nodeLeft
+		}
+		if n.right != nil {
+			flags |= nodeRight
+		}
+		b = n.key.rooted().appendBinary(append(b, flags))
+		if n.left != nil {
+			appendNode(n.left)
+		}
+		if n.right != nil {
+			appendNode(n.right)
+		}
+	}
+	appendNode(t)
+	return b
+}
+
+// treeFromBinary decodes the header and nodes written by appendTreeBinary
+// from b, checking that the values were encoded as expected. It calls entry
+// with each node that has an entry, in node order, and returns the tree and
+// the bytes following the nodes.
+func treeFromBinary[T any](
+	b []byte,
+	values byte,
+	entry func(*tree[T]),
+) (*tree[T], []byte, error) {
+	if len(b) < len(binaryMagic)+2 || string(b[:len(binaryMagic)]) != binaryMagic {
+		return nil, nil, fmt.Errorf("invalid binary encoding: bad header")
+	}
+	b = b[len(binaryMagic):]
+	if b[0] != binaryVersion {
+		return nil, nil, fmt.Errorf("unsupported binary encoding version %d", b[0])
+	}
+	if b[1] != values {
+		return nil, nil, fmt.Errorf("invalid binary encoding: unexpected value encoding %d", b[1])
+	}
+	count, n := binary.Uvarint(b[2:])
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("invalid binary encoding: bad node count")
+	}
+	b = b[2+n:]
+
+	var decode func(parent *key, side bit) (*tree[T], error)
+	decode = func(parent *key, side bit) (*tree[T], error) {
+		if count == 0 {
+			return nil, fmt.Errorf("invalid binary encoding: too many nodes")
+		}
+		count--
+		if len(b) < 1 {
+			return nil, errTruncated
+		}
+		flags := b[0]
+		k, n, err := keyFromBinary(b[1:])
+		if err != nil {
+			return nil, err
+		}
+		b = b[1+n:]
+		if parent != nil {
+			if !parent.isPrefixOf(k, true) || k.bit(parent.len) != side {
+				return nil, fmt.Errorf("invalid binary encoding: %v is not a valid child of %v",
+					k, *parent)
+			}
+			k.offset = parent.len
+		}
+		t := newTree[T](k)
+		if flags&nodeEntry != 0 {
+			var zero T
+			t.setValue(zero)
+			entry(t)
+		}
+		if flags&nodeLeft != 0 {
+			if t.left, err = decode(&k, bitL); err != nil {
+				return nil, err
+			}
+		}
+		if flags&nodeRight != 0 {
+			if t.right, err = decode(&k, bitR); err != nil {
+				return nil, err
+			}
+		}
+		return t, nil
+	}
+	t, err := decode(nil, bitL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode tree: %w", err)
+	}
+	if count != 0 {
+		return nil, nil, fmt.Errorf("invalid binary encoding: %d missing nodes", count)
+	}
+	return t, b, nil
+}
@@ -0,0 +1,128 @@
+package netipds
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPrefixSetMarshalBinary(t *testing.T) {
+	tests := [][]netip.Prefix{
+		pfxs(),
+		pfxs("::/128"),
+		pfxs("1.2.3.0/24"),
+		pfxs("1.2.3.0/24", "1.2.3.4/32", "1.2.0.0/16", "::1/128", "2001:db8::/32"),
+		pfxs("::0/128", "::1/128", "::2/127", "8000::/1"),
+	}
+	for _, set := range tests {
+		for _, lazy := range []bool{false, true} {
+			psb := &PrefixSetBuilder{Lazy: lazy}
+			for _, p := range set {
+				psb.Add(p)
+			}
+			ps := psb.PrefixSet()
+			b, err := ps.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary(%v): %v", set, err)
+			}
+			var got PrefixSet
+			if err := got.UnmarshalBinary(b); err != nil {
+				t.Fatalf("UnmarshalBinary(%v): %v", set, err)
+			}
+			checkPrefixSlice(t, got.Prefixes(), ps.Prefixes())
+			if got.Size() != ps.Size() {
+				t.Errorf("got Size() %d, want %d", got.Size(), ps.Size())
+			}
+			// The structure is preserved
+			if gotB, _ := got.MarshalBinary(); !bytes.Equal(gotB, b) {
+				t.Errorf("got tree\n%s\nwant\n%s", got.String(), ps.String())
+			}
+		}
+	}
+}
+
+// binaryValue implements encoding.BinaryMarshaler and BinaryUnmarshaler.
+type binaryValue struct{ s string }
+
+func (v binaryValue) MarshalBinary() ([]byte, error) {
+	return []byte(strings.ToUpper(v.s)), nil
+}
+
+func (v *binaryValue) UnmarshalBinary(b []byte) error {
+	v.s = strings.ToLower(string(b))
+	return nil
+}
+
+func TestPrefixMapMarshalBinary(t *testing.T) {
+	set := pfxs("1.2.0.0/16", "1.2.3.0/24", "::1/128", "2001:db8::/32")
+	pmb := &PrefixMapBuilder[string]{}
+	bmb := &PrefixMapBuilder[binaryValue]{}
+	for _, p := range set {
+		pmb.Set(p, p.String())
+		bmb.Set(p, binaryValue{p.String()})
+	}
+
+	pm := pmb.PrefixMap()
+	b, err := pm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PrefixMap[string]
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, pm.ToMap(), got.ToMap())
+
+	bm := bmb.PrefixMap()
+	if b, err = bm.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "2001:DB8::/32") {
+		t.Errorf("values were not encoded with MarshalBinary")
+	}
+	var gotB PrefixMap[binaryValue]
+	if err := gotB.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, bm.ToMap(), gotB.ToMap())
+
+	// Decoding as a different kind of collection fails
+	var ps PrefixSet
+	if err := ps.UnmarshalBinary(b); err == nil {
+		t.Errorf("PrefixSet.UnmarshalBinary of a PrefixMap succeeded")
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("1.2.3.0/24"))
+	psb.Add(pfx("1.2.4.0/24"))
+	b, _ := psb.PrefixSet().MarshalBinary()
+
+	corrupt := func(i int, c byte) []byte {
+		ret := append([]byte(nil), b...)
+		ret[i] = c
+		return ret
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", corrupt(0, 'X')},
+		{"bad version", corrupt(4, 99)},
+		{"truncated", b[:len(b)-1]},
+		{"extra node", corrupt(6, b[6]+1)},
+		{"missing node", corrupt(6, b[6]-1)},
+		// Flip a bit in the last node's key so it no longer descends from
+		// its parent
+		{"bad child", corrupt(len(b)-2, b[len(b)-2]^0x80)},
+	}
+	for _, tt := range tests {
+		var ps PrefixSet
+		if err := ps.UnmarshalBinary(tt.data); err == nil {
+			t.Errorf("%s: UnmarshalBinary succeeded", tt.name)
+		}
+	}
+}
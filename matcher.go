@@ -0,0 +1,120 @@
+package netipds
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Decision is the outcome of matching an address against a [Matcher].
+type Decision int
+
+const (
+	// Deny means the address is not permitted.
+	Deny Decision = iota
+	// Allow means the address is permitted.
+	Allow
+)
+
+// String returns "Deny" or "Allow".
+func (d Decision) String() string {
+	switch d {
+	case Deny:
+		return "Deny"
+	case Allow:
+		return "Allow"
+	}
+	return fmt.Sprintf("Decision(%d)", int(d))
+}
+
+// Resolution determines how a [Matcher] decides on addresses encompassed by
+// both its allow list and its deny list.
+type Resolution int
+
+const (
+	// MostSpecific decides according to the longest Prefix encompassing the
+	// address in either list, as in a routing table. If the same Prefix is
+	// in both lists, the address is denied.
+	MostSpecific Resolution = iota
+	// DenyOverrides denies any address encompassed by the deny list,
+	// regardless of the allow list.
+	DenyOverrides
+)
+
+// Verdict is a [Decision] together with the entry that determined it. See
+// [Matcher.Explain].
+type Verdict struct {
+	Decision Decision
+	// Prefix is the entry in the allow or deny list (according to Decision)
+	// that determined Decision, or the zero Prefix if the Matcher's Default
+	// was applied.
+	Prefix netip.Prefix
+}
+
+// Matcher decides whether addresses are allowed, according to an allow list
+// and a deny list. Addresses encompassed by neither list are given the
+// Default decision, and those encompassed by both are decided according to
+// the Resolution.
+//
+// The zero value is a valid Matcher which denies every address. A Matcher
+// must not be modified while in use, but may be used concurrently.
+type Matcher struct {
+	// Allow and Deny are the allow and deny lists. A nil list is empty.
+	Allow, Deny *PrefixSet
+	Resolution  Resolution
+	Default     Decision
+}
+
+// Decide returns the decision for a. Invalid addresses are always denied.
+//
+// Decide does not allocate.
+func (m *Matcher) Decide(a netip.Addr) Decision {
+	return m.Explain(a).Decision
+}
+
+// Explain returns the decision for a, and the entry that determined it.
+// Invalid addresses are always denied, with the zero Prefix.
+//
+// Explain does not allocate.
+func (m *Matcher) Explain(a netip.Addr) Verdict {
+	if !a.IsValid() {
+		return Verdict{Deny, netip.Prefix{}}
+	}
+	k := keyFromAddr(a)
+	var allow, deny *tree[bool]
+	if m.Allow != nil {
+		allow = m.Allow.tree.longestMatch(k)
+	}
+	if m.Deny != nil {
+		deny = m.Deny.tree.longestMatch(k)
+	}
+	switch {
+	case deny != nil && (allow == nil || m.Resolution == DenyOverrides || deny.key.len >= allow.key.len):
+		return Verdict{Deny, deny.key.toPrefix()}
+	case allow != nil:
+		return Verdict{Allow, allow.key.toPrefix()}
+	}
+	return Verdict{m.Default, netip.Prefix{}}
+}
+
+// ShadowedRules returns the indexes of the rules in a first-match rule list
+// which can never match, because the earlier rules together cover every
+// address they do, in order. Invalid Prefixes are ignored.
+//
+// For example, in the list [10.0.0.0/8, 192.168.0.0/24, 192.168.1.0/24,
+// 10.1.0.0/16, 192.168.0.0/23], the rules at indexes 3 and 4 are shadowed.
+func ShadowedRules(rules []netip.Prefix) []int {
+	var res []int
+	t := &tree[bool]{}
+	for i, p := range rules {
+		if !p.IsValid() {
+			continue
+		}
+		k := keyFromPrefix(p)
+		if t.covers(k) {
+			res = append(res, i)
+			continue
+		}
+		t = t.insert(k, true)
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestMatcherExplain(t *testing.T) {
+	set := func(prefixes ...string) *PrefixSet {
+		psb := &PrefixSetBuilder{}
+		for _, p := range pfxs(prefixes...) {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	allow := set("10.0.0.0/8", "10.1.2.0/24", "192.168.0.0/16", "2001:db8::/32")
+	deny := set("10.1.0.0/16", "192.168.0.0/16", "2001:db8:1::/48")
+
+	tests := []struct {
+		res     Resolution
+		def     Decision
+		addr    string
+		want    Decision
+		wantPfx string
+	}{
+		// Neither list
+		{MostSpecific, Deny, "1.2.3.4", Deny, ""},
+		{MostSpecific, Allow, "1.2.3.4", Allow, ""},
+		{DenyOverrides, Allow, "1.2.3.4", Allow, ""},
+		// One list
+		{MostSpecific, Deny, "10.2.0.1", Allow, "10.0.0.0/8"},
+		{DenyOverrides, Deny, "10.2.0.1", Allow, "10.0.0.0/8"},
+		{MostSpecific, Allow, "2001:db8:1::1", Deny, "2001:db8:1::/48"},
+		// Both lists
+		{MostSpecific, Deny, "10.1.0.1", Deny, "10.1.0.0/16"},
+		{MostSpecific, Deny, "10.1.2.3", Allow, "10.1.2.0/24"},
+		{DenyOverrides, Deny, "10.1.2.3", Deny, "10.1.0.0/16"},
+		{MostSpecific, Deny, "2001:db8::1", Allow, "2001:db8::/32"},
+		// Ties are denied
+		{MostSpecific, Allow, "192.168.1.1", Deny, "192.168.0.0/16"},
+	}
+	for _, tt := range tests {
+		m := &Matcher{Allow: allow, Deny: deny, Resolution: tt.res, Default: tt.def}
+		a := netip.MustParseAddr(tt.addr)
+		var wantPfx netip.Prefix
+		if tt.wantPfx != "" {
+			wantPfx = pfx(tt.wantPfx)
+		}
+		if got := m.Explain(a); got != (Verdict{tt.want, wantPfx}) {
+			t.Errorf("Explain(%s) with %v = %+v, want {%v %v}", a, tt.res, got, tt.want, wantPfx)
+		}
+		if got := m.Decide(a); got != tt.want {
+			t.Errorf("Decide(%s) with %v = %v, want %v", a, tt.res, got, tt.want)
+		}
+	}
+
+	// The zero Matcher denies everything, as do all Matchers for invalid
+	// addresses
+	if got := (&Matcher{}).Decide(netip.MustParseAddr("1.2.3.4")); got != Deny {
+		t.Errorf("zero Matcher Decide() = %v, want Deny", got)
+	}
+	if got := (&Matcher{Default: Allow}).Decide(netip.Addr{}); got != Deny {
+		t.Errorf("Decide(invalid) = %v, want Deny", got)
+	}
+
+	m := &Matcher{Allow: allow, Deny: deny}
+	a := netip.MustParseAddr("10.1.2.3")
+	if n := testing.AllocsPerRun(100, func() { m.Explain(a) }); n != 0 {
+		t.Errorf("Explain() allocated %v times, want 0", n)
+	}
+}
+
+func TestDecisionString(t *testing.T) {
+	for d, want := range map[Decision]string{Deny: "Deny", Allow: "Allow", 5: "Decision(5)"} {
+		if got := d.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestShadowedRules(t *testing.T) {
+	tests := []struct {
+		rules []netip.Prefix
+		want  []int
+	}{
+		{pfxs(), nil},
+		{pfxs("10.0.0.0/8", "10.1.0.0/16"), []int{1}},
+		{pfxs("10.1.0.0/16", "10.0.0.0/8"), nil},
+		{pfxs("10.0.0.0/8", "10.0.0.0/8"), []int{1}},
+		{
+			pfxs("10.0.0.0/8", "192.168.0.0/24", "192.168.1.0/24", "10.1.0.0/16", "192.168.0.0/23"),
+			[]int{3, 4},
+		},
+		{pfxs("192.168.0.0/24", "192.168.2.0/24", "192.168.0.0/23", "192.168.0.0/22"), nil},
+		{append(pfxs("1.2.3.0/24"), netip.Prefix{}), nil},
+	}
+	for _, tt := range tests {
+		if got := ShadowedRules(tt.rules); !slices.Equal(got, tt.want) {
+			t.Errorf("ShadowedRules(%v) = %v, want %v", tt.rules, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+// Package mmdb loads the networks in MaxMind DB files, such as the GeoIP2 and
+// GeoLite2 databases, into [netipds.PrefixMap]s.
+//
+// Looking up an address in the resulting PrefixMap is typically much faster
+// than searching the database file, at the cost of holding every network and
+// its decoded record in memory.
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+
+	"github.com/aromatt/netipds"
+)
+
+// metadataMarker precedes the metadata section at the end of a database.
+const metadataMarker = "\xab\xcd\xefMaxMind.com"
+
+// metadataMaxSize bounds the search for the metadata section.
+const metadataMaxSize = 128 * 1024
+
+// dataSectionSeparatorSize is the number of zero bytes between the search
+// tree and the data section.
+const dataSectionSeparatorSize = 16
+
+// maxDepth bounds the nesting of maps and arrays in data records.
+const maxDepth = 512
+
+// Data field types (MaxMind DB spec, "Output Data Section").
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// Metadata describes a database.
+type Metadata struct {
+	NodeCount    uint32
+	RecordSize   uint16
+	IPVersion    uint16
+	DatabaseType string
+	BuildEpoch   uint64
+}
+
+// Record is the data record of a network in a database.
+type Record struct {
+	// Offset is the offset of the record within the data section. It can be
+	// passed to the Decode method of readers from other MaxMind DB libraries
+	// which accept data section offsets.
+	Offset int
+
+	data []byte
+}
+
+// Decode decodes the record into Go values: maps become map[string]any,
+// arrays []any, strings string, bytes []byte, doubles float64, floats
+// float32, int32s int, booleans bool, unsigned integers up to 64 bits
+// uint64, and uint128s *big.Int.
+func (r Record) Decode() (any, error) {
+	v, _, err := decoder{r.data}.decode(r.Offset, 0)
+	return v, err
+}
+
+// Load reads the database in db and returns a map of each network in it to
+// its record, as converted by decode. Networks without records are omitted.
+// decode is called once for each distinct record, and its result is shared by
+// all networks with that record.
+//
+// In IPv6 databases, networks within ::/96 are returned as IPv4 Prefixes.
+// The IPv4 networks aliased elsewhere in the IPv6 address space (such as
+// within ::ffff:0:0/96 and 2002::/16) are omitted.
+func Load[T any](db []byte, decode func(Record) (T, error)) (*netipds.PrefixMap[T], Metadata, error) {
+	md, err := readMetadata(db)
+	if err != nil {
+		return nil, md, err
+	}
+	treeSize := int(md.NodeCount) * int(md.RecordSize) / 4
+	metaStart := bytes.LastIndex(db, []byte(metadataMarker))
+	if treeSize+dataSectionSeparatorSize > metaStart {
+		return nil, md, fmt.Errorf("search tree of %d nodes exceeds database size", md.NodeCount)
+	}
+	t := searchTree{db[:treeSize], md.NodeCount, md.RecordSize}
+	data := db[treeSize+dataSectionSeparatorSize : metaStart]
+
+	bits := 32
+	if md.IPVersion == 6 {
+		bits = 128
+	}
+	// Find the IPv4 subtree of an IPv6 database, so that aliases of it can be
+	// recognized.
+	ipv4Start := uint32(math.MaxUint32)
+	if bits == 128 {
+		ipv4Start = 0
+		for i := 0; i < 96 && ipv4Start < md.NodeCount; i++ {
+			ipv4Start, _ = t.node(ipv4Start)
+		}
+	}
+
+	pmb := &netipds.PrefixMapBuilder[T]{Lazy: true}
+	if md.NodeCount == 0 {
+		return pmb.PrefixMap(), md, nil
+	}
+	values := make(map[uint32]T)
+	type frame struct {
+		node  uint32
+		depth int
+		addr  [16]byte
+	}
+	stack := []frame{{0, 0, [16]byte{}}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		left, right := t.node(f.node)
+		for _, c := range []struct {
+			b      byte
+			record uint32
+		}{{0, left}, {1, right}} {
+			addr := f.addr
+			depth := f.depth + 1
+			if c.b == 1 {
+				addr[f.depth/8] |= 0x80 >> (f.depth % 8)
+			}
+			switch {
+			case c.record < md.NodeCount:
+				if depth >= bits {
+					return nil, md, fmt.Errorf("search tree is deeper than %d bits", bits)
+				}
+				if c.record == ipv4Start && (depth != 96 || !isZero(addr[:12])) {
+					// An alias of the IPv4 subtree
+					continue
+				}
+				stack = append(stack, frame{c.record, depth, addr})
+			case c.record == md.NodeCount:
+				// No data
+			default:
+				v, ok := values[c.record]
+				if !ok {
+					off := int(c.record - md.NodeCount - dataSectionSeparatorSize)
+					if off < 0 || off >= len(data) {
+						return nil, md, fmt.Errorf("record pointer %d is out of range", c.record)
+					}
+					if v, err = decode(Record{off, data}); err != nil {
+						return nil, md, fmt.Errorf("decoding record at offset %d: %w", off, err)
+					}
+					values[c.record] = v
+				}
+				if err := pmb.Set(prefix(addr, depth, bits), v); err != nil {
+					return nil, md, err
+				}
+			}
+		}
+	}
+	return pmb.PrefixMap(), md, nil
+}
+
+// prefix returns the Prefix of the network at the provided depth in a search
+// tree of the provided bit width.
+func prefix(addr [16]byte, depth, bits int) netip.Prefix {
+	if bits == 32 {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte(addr[:4])), depth)
+	}
+	if depth >= 96 && isZero(addr[:12]) {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte(addr[12:])), depth-96)
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(addr), depth)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// searchTree is the binary search tree at the start of a database.
+type searchTree struct {
+	b          []byte
+	nodeCount  uint32
+	recordSize uint16
+}
+
+// node returns the left and right records of node i, which must be less than
+// the node count.
+func (t searchTree) node(i uint32) (left, right uint32) {
+	switch t.recordSize {
+	case 24:
+		b := t.b[i*6:]
+		return uint24(b), uint24(b[3:])
+	case 28:
+		b := t.b[i*7:]
+		return uint32(b[3]>>4)<<24 | uint24(b), uint32(b[3]&0x0f)<<24 | uint24(b[4:])
+	default:
+		b := t.b[i*8:]
+		return uint24(b)<<8 | uint32(b[3]), uint24(b[4:])<<8 | uint32(b[7])
+	}
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// readMetadata decodes and validates the metadata section of db.
+func readMetadata(db []byte) (Metadata, error) {
+	var md Metadata
+	start := max(len(db)-metadataMaxSize, 0)
+	i := bytes.LastIndex(db[start:], []byte(metadataMarker))
+	if i < 0 {
+		return md, errors.New("metadata section not found")
+	}
+	v, _, err := decoder{db[start+i+len(metadataMarker):]}.decode(0, 0)
+	if err != nil {
+		return md, fmt.Errorf("decoding metadata: %w", err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return md, errors.New("metadata is not a map")
+	}
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+	md.DatabaseType, _ = m["database_type"].(string)
+	md.BuildEpoch, _ = m["build_epoch"].(uint64)
+	if nodeCount > math.MaxUint32 {
+		return md, fmt.Errorf("invalid node count %d", nodeCount)
+	}
+	md.NodeCount = uint32(nodeCount)
+	switch recordSize {
+	case 24, 28, 32:
+		md.RecordSize = uint16(recordSize)
+	default:
+		return md, fmt.Errorf("unsupported record size %d", recordSize)
+	}
+	switch ipVersion {
+	case 4, 6:
+		md.IPVersion = uint16(ipVersion)
+	default:
+		return md, fmt.Errorf("unsupported IP version %d", ipVersion)
+	}
+	return md, nil
+}
+
+var errTruncated = errors.New("truncated data field")
+
+// decoder decodes fields in a data section.
+type decoder struct {
+	b []byte
+}
+
+// decode decodes the field at off, returning its value and the offset
+// following it.
+func (d decoder) decode(off, depth int) (any, int, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data fields are nested too deeply")
+	}
+	typ, size, off, err := d.control(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		target, next, err := d.pointer(size, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		if t, _, _, err := d.control(target); err == nil && t == typePointer {
+			return nil, 0, errors.New("pointer to a pointer")
+		}
+		v, _, err := d.decode(target, depth+1)
+		return v, next, err
+	}
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, len(d.b)))
+		for i := 0; i < size; i++ {
+			k, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key has type %T", k)
+			}
+			if m[ks], off, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, off, nil
+	case typeArray:
+		a := make([]any, 0, min(size, len(d.b)))
+		for i := 0; i < size; i++ {
+			v, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("invalid boolean size %d", size)
+		}
+		return size == 1, off, nil
+	}
+
+	if off+size > len(d.b) {
+		return nil, 0, errTruncated
+	}
+	b, next := d.b[off:off+size], off+size
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(uintN(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return math.Float32frombits(uint32(uintN(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if (typ == typeUint16 && size > 2) || (typ == typeUint32 && size > 4) || size > 8 {
+			return nil, 0, fmt.Errorf("invalid unsigned integer size %d", size)
+		}
+		return uintN(b), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid int32 size %d", size)
+		}
+		return int(int32(uintN(b))), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid uint128 size %d", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data field type %d", typ)
+	}
+}
+
+// control decodes the control byte(s) of the field at off, returning its
+// type, its size, and the offset of its payload. The size of a pointer is
+// the low 5 bits of its control byte.
+func (d decoder) control(off int) (typ, size, next int, err error) {
+	if off >= len(d.b) {
+		return 0, 0, 0, errTruncated
+	}
+	c := d.b[off]
+	off++
+	typ, size = int(c>>5), int(c&0x1f)
+	if typ == typePointer {
+		return typ, size, off, nil
+	}
+	if typ == typeExtended {
+		if off >= len(d.b) {
+			return 0, 0, 0, errTruncated
+		}
+		typ = 7 + int(d.b[off])
+		off++
+	}
+	if size >= 29 {
+		n := size - 28
+		if off+n > len(d.b) {
+			return 0, 0, 0, errTruncated
+		}
+		size = [...]int{29, 285, 65821}[n-1] + int(uintN(d.b[off:off+n]))
+		off += n
+	}
+	return typ, size, off, nil
+}
+
+// pointer decodes the target of a pointer with the provided control size
+// bits, whose payload is at off, returning the target and the offset
+// following the pointer.
+func (d decoder) pointer(size, off int) (target, next int, err error) {
+	n := size>>3 + 1
+	if off+n > len(d.b) {
+		return 0, 0, errTruncated
+	}
+	p := int(uintN(d.b[off : off+n]))
+	switch n {
+	case 1, 2, 3:
+		p |= (size & 0x7) << (8 * n)
+		p += [...]int{0, 2048, 526336}[n-1]
+	}
+	return p, off + n, nil
+}
+
+// uintN decodes a big-endian unsigned integer of up to 8 bytes.
+func uintN(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
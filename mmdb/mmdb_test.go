@@ -0,0 +1,275 @@
+package mmdb
+
+import (
+	"bytes"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/aromatt/netipds"
+)
+
+// pointerTo encodes a pointer to a data section offset below 2048.
+type pointerTo int
+
+// appendField appends the encoding of v as a data field to b.
+func appendField(b []byte, v any) []byte {
+	ctrl := func(b []byte, typ, size int) []byte {
+		var sizeBits int
+		var ext []byte
+		switch {
+		case size < 29:
+			sizeBits = size
+		case size < 285:
+			sizeBits, ext = 29, []byte{byte(size - 29)}
+		default:
+			sizeBits, ext = 30, []byte{byte((size - 285) >> 8), byte(size - 285)}
+		}
+		if typ < 8 {
+			b = append(b, byte(typ<<5|sizeBits))
+		} else {
+			b = append(b, byte(sizeBits), byte(typ-7))
+		}
+		return append(b, ext...)
+	}
+	switch v := v.(type) {
+	case pointerTo:
+		return append(b, byte(typePointer<<5|int(v)>>8), byte(v))
+	case string:
+		return append(ctrl(b, typeString, len(v)), v...)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return ctrl(b, typeBool, size)
+	case uint64:
+		var be []byte
+		for ; v > 0; v >>= 8 {
+			be = append([]byte{byte(v)}, be...)
+		}
+		typ := typeUint32
+		if len(be) > 4 {
+			typ = typeUint64
+		}
+		return append(ctrl(b, typ, len(be)), be...)
+	case []any:
+		b = ctrl(b, typeArray, len(v))
+		for _, e := range v {
+			b = appendField(b, e)
+		}
+		return b
+	case map[string]any:
+		b = ctrl(b, typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = appendField(appendField(b, k), v[k])
+		}
+		return b
+	}
+	panic("unsupported field")
+}
+
+// testNode is a node in a search tree under construction. If ref is set, the
+// node is replaced by a record pointing to ref.
+type testNode struct {
+	children [2]*testNode
+	data     int
+	ref      *testNode
+}
+
+// at returns the node at the provided path of bits below n, creating it if
+// necessary.
+func (n *testNode) at(addr [16]byte, depth int) *testNode {
+	for i := 0; i < depth; i++ {
+		b := addr[i/8] >> (7 - i%8) & 1
+		if n.children[b] == nil {
+			n.children[b] = &testNode{data: -1}
+		}
+		n = n.children[b]
+	}
+	return n
+}
+
+// buildDB returns a database with the provided search tree, data section and
+// metadata, assigning "node_count" and "record_size" itself.
+func buildDB(root *testNode, data []byte, recordSize int, meta map[string]any) []byte {
+	var nodes []*testNode
+	index := map[*testNode]int{}
+	var number func(n *testNode)
+	number = func(n *testNode) {
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil && c.ref == nil && c.data < 0 {
+				number(c)
+			}
+		}
+	}
+	number(root)
+	count := len(nodes)
+	record := func(c *testNode) uint32 {
+		switch {
+		case c == nil:
+			return uint32(count)
+		case c.ref != nil:
+			return uint32(index[c.ref])
+		case c.data >= 0:
+			return uint32(count + 16 + c.data)
+		}
+		return uint32(index[c])
+	}
+	var db []byte
+	for _, n := range nodes {
+		l, r := record(n.children[0]), record(n.children[1])
+		switch recordSize {
+		case 24:
+			db = append(db, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			db = append(db, byte(l>>16), byte(l>>8), byte(l), byte(l>>24<<4|r>>24),
+				byte(r>>16), byte(r>>8), byte(r))
+		case 32:
+			db = append(db, byte(l>>24), byte(l>>16), byte(l>>8), byte(l),
+				byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	db = append(db, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, metadataMarker...)
+	meta["node_count"] = uint64(count)
+	meta["record_size"] = uint64(recordSize)
+	return appendField(db, meta)
+}
+
+func addr16(s string) [16]byte {
+	return netip.MustParseAddr(s).As16()
+}
+
+// addr4 returns the bits of an IPv4 address, followed by zeros.
+func addr4(s string) [16]byte {
+	var a [16]byte
+	a4 := netip.MustParseAddr(s).As4()
+	copy(a[:], a4[:])
+	return a
+}
+
+func TestLoad(t *testing.T) {
+	var data []byte
+	countryA := len(data)
+	data = appendField(data, map[string]any{"country": "A", "eu": true})
+	countryB := len(data)
+	data = appendField(data, map[string]any{
+		"country": "B",
+		"names":   []any{pointerTo(countryA + 1 + 1 + len("country")), uint64(1 << 40)},
+	})
+
+	for _, recordSize := range []int{24, 28, 32} {
+		root := &testNode{data: -1}
+		root.at(addr16("::1.2.0.0"), 96+16).data = countryA
+		root.at(addr16("::5.0.0.0"), 96+8).data = countryA
+		root.at(addr16("2001:db8::"), 32).data = countryB
+		ipv4 := root.at(addr16("::"), 96)
+		root.at(addr16("::ffff:0:0"), 96).ref = ipv4
+		root.at(addr16("2002::"), 16).ref = ipv4
+
+		db := buildDB(root, data, recordSize, map[string]any{
+			"ip_version":    uint64(6),
+			"database_type": "Test",
+			"build_epoch":   uint64(1700000000),
+		})
+		decodes := 0
+		m, md, err := Load(db, func(r Record) (string, error) {
+			decodes++
+			v, err := r.Decode()
+			if err != nil {
+				return "", err
+			}
+			rec := v.(map[string]any)
+			if names, ok := rec["names"].([]any); ok {
+				return rec["country"].(string) + names[0].(string), nil
+			}
+			return rec["country"].(string), nil
+		})
+		if err != nil {
+			t.Fatalf("record size %d: %v", recordSize, err)
+		}
+		want := map[netip.Prefix]string{
+			netip.MustParsePrefix("1.2.0.0/16"):    "A",
+			netip.MustParsePrefix("5.0.0.0/8"):     "A",
+			netip.MustParsePrefix("2001:db8::/32"): "BA",
+		}
+		if got := m.ToMap(); len(got) != len(want) {
+			t.Errorf("record size %d: got %v, want %v", recordSize, got, want)
+		} else {
+			for p, v := range want {
+				if got[p] != v {
+					t.Errorf("record size %d: got %v, want %v", recordSize, got, want)
+				}
+			}
+		}
+		if decodes != 2 {
+			t.Errorf("record size %d: decode called %d times, want 2", recordSize, decodes)
+		}
+		if md.RecordSize != uint16(recordSize) || md.IPVersion != 6 ||
+			md.DatabaseType != "Test" || md.BuildEpoch != 1700000000 {
+			t.Errorf("record size %d: got metadata %+v", recordSize, md)
+		}
+		if v, ok := m.Get(netip.MustParsePrefix("1.2.0.0/16")); !ok || v != "A" {
+			t.Errorf("record size %d: Get(1.2.0.0/16) = %v, %v", recordSize, v, ok)
+		}
+	}
+}
+
+func TestLoadIPv4(t *testing.T) {
+	data := appendField(nil, uint64(64500))
+	root := &testNode{data: -1}
+	root.at(addr4("10.0.0.0"), 8).data = 0
+	root.at(addr4("192.168.1.0"), 24).data = 0
+	db := buildDB(root, data, 24, map[string]any{"ip_version": uint64(4)})
+	m, _, err := Load(db, func(r Record) (uint64, error) {
+		v, err := r.Decode()
+		n, _ := v.(uint64)
+		return n, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pmb := &netipds.PrefixMapBuilder[uint64]{}
+	pmb.Set(netip.MustParsePrefix("10.0.0.0/8"), 64500)
+	pmb.Set(netip.MustParsePrefix("192.168.1.0/24"), 64500)
+	if !m.Equal(pmb.PrefixMap(), func(a, b uint64) bool { return a == b }) {
+		t.Errorf("got %v, want %v", m.ToMap(), pmb.PrefixMap().ToMap())
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	root := &testNode{data: -1}
+	root.at(addr16("2001:db8::"), 32).data = 0
+	data := appendField(nil, "x")
+	valid := buildDB(root, data, 24, map[string]any{"ip_version": uint64(6)})
+	decode := func(r Record) (any, error) { return r.Decode() }
+	if _, _, err := Load(valid, decode); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		db   []byte
+	}{
+		{"empty", nil},
+		{"no metadata", valid[:bytes.LastIndex(valid, []byte(metadataMarker))+4]},
+		{"bad record size", buildDB(root, data, 16, map[string]any{"ip_version": uint64(6)})},
+		{"bad ip version", buildDB(root, data, 24, map[string]any{"ip_version": uint64(5)})},
+		{"bad data", buildDB(root, []byte{0xff}, 24, map[string]any{"ip_version": uint64(6)})},
+		{"truncated tree", append(valid[:6], valid[bytes.LastIndex(valid, []byte(metadataMarker)):]...)},
+	}
+	for _, tt := range tests {
+		if _, _, err := Load(tt.db, decode); err == nil {
+			t.Errorf("%s: Load succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package mrt loads BGP routing tables from MRT TABLE_DUMP_V2 files (RFC
+// 6396), such as the RIB dumps published by RouteViews and RIPE RIS, into
+// [netipds.PrefixMap]s.
+//
+// Dumps are usually distributed compressed; wrap the reader with the
+// appropriate decompressor (e.g. compress/bzip2 or compress/gzip) first.
+package mrt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+
+	"github.com/aromatt/netipds"
+)
+
+// MRT record types and TABLE_DUMP_V2 subtypes (RFC 6396, section 4).
+const (
+	typeTableDumpV2 = 13
+
+	subtypeRIBIPv4Unicast = 2
+	subtypeRIBIPv6Unicast = 4
+)
+
+// BGP path attribute flags and types (RFC 4271, section 4.3).
+const (
+	attrFlagExtendedLength = 0x10
+	attrTypeASPath         = 2
+)
+
+// headerLen is the length of the common MRT header.
+const headerLen = 12
+
+// ReadRIB reads TABLE_DUMP_V2 records from r, and calls fn with the Prefix
+// and AS path of each RIB entry, of which there is usually one per peer for
+// each Prefix. The AS path runs from the peer to the origin AS; the members
+// of AS_SET segments are included in the order they appear.
+//
+// Only IPv4 and IPv6 unicast RIB records are read. Other records, including
+// other MRT types, are skipped. ReadRIB stops and returns the first error
+// returned by fn.
+func ReadRIB(r io.Reader, fn func(p netip.Prefix, path []uint32) error) error {
+	var hdr [headerLen]byte
+	var body []byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("reading MRT header: %w", noEOF(err))
+		}
+		typ := binary.BigEndian.Uint16(hdr[4:])
+		subtype := binary.BigEndian.Uint16(hdr[6:])
+		n := binary.BigEndian.Uint32(hdr[8:])
+		// n is untrusted, so the body is read into a buffer which grows as
+		// data arrives, rather than being allocated up front
+		buf := bytes.NewBuffer(body[:0])
+		if m, err := buf.ReadFrom(io.LimitReader(r, int64(n))); err != nil {
+			return fmt.Errorf("reading MRT record: %w", err)
+		} else if m < int64(n) {
+			return fmt.Errorf("reading MRT record: %w", errTruncated)
+		}
+		body = buf.Bytes()
+		if typ != typeTableDumpV2 {
+			continue
+		}
+		var err error
+		switch subtype {
+		case subtypeRIBIPv4Unicast:
+			err = readRIBRecord(body, 4, fn)
+		case subtypeRIBIPv6Unicast:
+			err = readRIBRecord(body, 16, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readRIBRecord parses the body of a RIB_IPV4_UNICAST or RIB_IPV6_UNICAST
+// record, whose addresses are addrLen bytes long.
+func readRIBRecord(b []byte, addrLen int, fn func(netip.Prefix, []uint32) error) error {
+	// Sequence number (4) and prefix length (1)
+	if len(b) < 5 {
+		return errTruncated
+	}
+	bits := int(b[4])
+	if bits > addrLen*8 {
+		return fmt.Errorf("invalid prefix length: %d", bits)
+	}
+	b = b[5:]
+	n := (bits + 7) / 8
+	if len(b) < n+2 {
+		return errTruncated
+	}
+	var a [16]byte
+	copy(a[:], b[:n])
+	var addr netip.Addr
+	if addrLen == 4 {
+		addr = netip.AddrFrom4([4]byte(a[:4]))
+	} else {
+		addr = netip.AddrFrom16(a)
+	}
+	p := netip.PrefixFrom(addr, bits).Masked()
+	count := int(binary.BigEndian.Uint16(b[n:]))
+	b = b[n+2:]
+
+	for i := 0; i < count; i++ {
+		// Peer index (2), originated time (4) and attribute length (2)
+		if len(b) < 8 {
+			return errTruncated
+		}
+		attrLen := int(binary.BigEndian.Uint16(b[6:]))
+		b = b[8:]
+		if len(b) < attrLen {
+			return errTruncated
+		}
+		path, err := asPath(b[:attrLen])
+		if err != nil {
+			return err
+		}
+		if err := fn(p, path); err != nil {
+			return err
+		}
+		b = b[attrLen:]
+	}
+	return nil
+}
+
+// asPath returns the AS path found in the BGP path attributes b, if any. In
+// TABLE_DUMP_V2 records, AS_PATH always uses 4-byte AS numbers.
+func asPath(b []byte) ([]uint32, error) {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, errTruncated
+		}
+		flags, typ := b[0], b[1]
+		var n int
+		if flags&attrFlagExtendedLength != 0 {
+			if len(b) < 4 {
+				return nil, errTruncated
+			}
+			n, b = int(binary.BigEndian.Uint16(b[2:])), b[4:]
+		} else {
+			n, b = int(b[2]), b[3:]
+		}
+		if len(b) < n {
+			return nil, errTruncated
+		}
+		if typ == attrTypeASPath {
+			return asPathSegments(b[:n])
+		}
+		b = b[n:]
+	}
+	return nil, nil
+}
+
+// asPathSegments parses the value of an AS_PATH attribute.
+func asPathSegments(b []byte) ([]uint32, error) {
+	var path []uint32
+	for len(b) > 0 {
+		// Segment type (1) and number of ASes (1)
+		if len(b) < 2 {
+			return nil, errTruncated
+		}
+		n := int(b[1])
+		b = b[2:]
+		if len(b) < 4*n {
+			return nil, errTruncated
+		}
+		for i := 0; i < n; i++ {
+			path = append(path, binary.BigEndian.Uint32(b[4*i:]))
+		}
+		b = b[4*n:]
+	}
+	return path, nil
+}
+
+// ReadASPaths reads a TABLE_DUMP_V2 RIB dump from r and returns a map of each
+// Prefix to the first non-empty AS path seen for it (see [ReadRIB]).
+func ReadASPaths(r io.Reader) (*netipds.PrefixMap[[]uint32], error) {
+	pmb := &netipds.PrefixMapBuilder[[]uint32]{Lazy: true}
+	err := ReadRIB(r, func(p netip.Prefix, path []uint32) error {
+		if len(path) == 0 {
+			return nil
+		}
+		if _, ok := pmb.Get(p); ok {
+			return nil
+		}
+		return pmb.Set(p, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pmb.PrefixMap(), nil
+}
+
+// ReadOrigins reads a TABLE_DUMP_V2 RIB dump from r and returns a map of each
+// Prefix to its origin AS: the last AS in the first non-empty AS path seen
+// for it (see [ReadRIB]). Prefixes originated by an AS_SET are given the last
+// member of the set.
+func ReadOrigins(r io.Reader) (*netipds.PrefixMap[uint32], error) {
+	pmb := &netipds.PrefixMapBuilder[uint32]{Lazy: true}
+	err := ReadRIB(r, func(p netip.Prefix, path []uint32) error {
+		if len(path) == 0 {
+			return nil
+		}
+		if _, ok := pmb.Get(p); ok {
+			return nil
+		}
+		return pmb.Set(p, path[len(path)-1])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pmb.PrefixMap(), nil
+}
+
+var errTruncated = errors.New("truncated MRT record")
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF, for reads that must not end
+// cleanly.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
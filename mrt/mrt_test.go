@@ -0,0 +1,181 @@
+package mrt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// record returns an MRT record with the provided type, subtype and body.
+func record(typ, subtype uint16, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1700000000)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, subtype)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// ribEntry returns a RIB entry whose attributes are ORIGIN and an AS_PATH
+// with the provided segments (each a segment type followed by ASNs).
+func ribEntry(peer uint16, segments ...[]uint32) []byte {
+	var path []byte
+	for _, seg := range segments {
+		path = append(path, byte(seg[0]), byte(len(seg)-1))
+		for _, as := range seg[1:] {
+			path = binary.BigEndian.AppendUint32(path, as)
+		}
+	}
+	// ORIGIN: IGP
+	attrs := []byte{0x40, 1, 1, 0}
+	if segments != nil {
+		// AS_PATH, with an extended length
+		attrs = append(attrs, 0x50, 2)
+		attrs = binary.BigEndian.AppendUint16(attrs, uint16(len(path)))
+		attrs = append(attrs, path...)
+	}
+	b := binary.BigEndian.AppendUint16(nil, peer)
+	b = binary.BigEndian.AppendUint32(b, 1700000000)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attrs)))
+	return append(b, attrs...)
+}
+
+// rib returns the body of a RIB record for p with the provided entries.
+func rib(p netip.Prefix, entries ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 0)
+	b = append(b, byte(p.Bits()))
+	b = append(b, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(entries)))
+	for _, e := range entries {
+		b = append(b, e...)
+	}
+	return b
+}
+
+func testDump() []byte {
+	var b []byte
+	// PEER_INDEX_TABLE, which is not needed
+	b = append(b, record(13, 1, []byte{1, 2, 3, 4, 0, 0, 0, 0})...)
+	b = append(b, record(13, 2, rib(netip.MustParsePrefix("1.2.3.0/24"),
+		ribEntry(0, []uint32{2, 3356, 13335}),
+		ribEntry(1, []uint32{2, 174, 13335}),
+	))...)
+	// A route with no AS path, then one originated by an AS_SET
+	b = append(b, record(13, 2, rib(netip.MustParsePrefix("10.0.0.0/9"),
+		ribEntry(0),
+		ribEntry(1, []uint32{2, 64500}, []uint32{1, 64510, 64511}),
+	))...)
+	// BGP4MP records are skipped
+	b = append(b, record(16, 4, []byte{0xff, 0xff})...)
+	b = append(b, record(13, 4, rib(netip.MustParsePrefix("2001:db8::/32"),
+		ribEntry(0, []uint32{2, 6939, 64496}),
+	))...)
+	return b
+}
+
+func TestReadRIB(t *testing.T) {
+	type entry struct {
+		p    netip.Prefix
+		path []uint32
+	}
+	var got []entry
+	err := ReadRIB(bytes.NewReader(testDump()), func(p netip.Prefix, path []uint32) error {
+		got = append(got, entry{p, path})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []entry{
+		{netip.MustParsePrefix("1.2.3.0/24"), []uint32{3356, 13335}},
+		{netip.MustParsePrefix("1.2.3.0/24"), []uint32{174, 13335}},
+		{netip.MustParsePrefix("10.0.0.0/9"), nil},
+		{netip.MustParsePrefix("10.0.0.0/9"), []uint32{64500, 64510, 64511}},
+		{netip.MustParsePrefix("2001:db8::/32"), []uint32{6939, 64496}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i].p != want[i].p || !slices.Equal(got[i].path, want[i].path) {
+			t.Errorf("entry %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	errStop := errors.New("stop")
+	err = ReadRIB(bytes.NewReader(testDump()), func(netip.Prefix, []uint32) error { return errStop })
+	if err != errStop {
+		t.Errorf("ReadRIB() = %v, want %v", err, errStop)
+	}
+}
+
+func TestReadRIBErrors(t *testing.T) {
+	dump := testDump()
+	tests := [][]byte{
+		// Truncated header and body
+		dump[:5],
+		dump[:len(dump)-3],
+		// Truncated RIB entry
+		record(13, 2, rib(netip.MustParsePrefix("1.2.3.0/24"), ribEntry(0, []uint32{2, 1}))[:20]),
+		// Prefix longer than the address
+		record(13, 2, []byte{0, 0, 0, 0, 33}),
+	}
+	for i, b := range tests {
+		err := ReadRIB(bytes.NewReader(b), func(netip.Prefix, []uint32) error { return nil })
+		if err == nil {
+			t.Errorf("case %d: ReadRIB() returned nil error", i)
+		}
+	}
+	// A corrupt length is not allocated up front
+	huge := binary.BigEndian.AppendUint32(record(13, 2, nil)[:8], 0xffffffff)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := ReadRIB(bytes.NewReader(huge), nil)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, errTruncated) {
+		t.Errorf("ReadRIB(huge length) = %v, want %v", err, errTruncated)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("ReadRIB(huge length) allocated %d bytes", n)
+	}
+	if err := ReadRIB(bytes.NewReader(nil), nil); err != nil {
+		t.Errorf("ReadRIB(empty) = %v, want nil", err)
+	}
+	if _, err := ReadASPaths(bytes.NewReader(dump[:5])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadASPaths(truncated) = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestReadASPathsAndOrigins(t *testing.T) {
+	paths, err := ReadASPaths(bytes.NewReader(testDump()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths.Size(); got != 3 {
+		t.Errorf("Size() = %d, want 3", got)
+	}
+	for p, want := range map[string][]uint32{
+		"1.2.3.0/24":    {3356, 13335},
+		"10.0.0.0/9":    {64500, 64510, 64511},
+		"2001:db8::/32": {6939, 64496},
+	} {
+		if got, _ := paths.Get(netip.MustParsePrefix(p)); !slices.Equal(got, want) {
+			t.Errorf("paths.Get(%s) = %v, want %v", p, got, want)
+		}
+	}
+
+	origins, err := ReadOrigins(bytes.NewReader(testDump()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, as, ok := origins.Lookup(netip.MustParseAddr("1.2.3.4")); as != 13335 || !ok {
+		t.Errorf("origins.Lookup(1.2.3.4) = (%d, %v), want (13335, true)", as, ok)
+	}
+	if as, _ := origins.Get(netip.MustParsePrefix("10.0.0.0/9")); as != 64511 {
+		t.Errorf("origins.Get(10.0.0.0/9) = %d, want 64511", as)
+	}
+}
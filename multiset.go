@@ -0,0 +1,125 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// MultiSetBuilder builds an immutable [MultiSet].
+//
+// The zero value is a valid MultiSetBuilder representing a builder with zero
+// sets.
+type MultiSetBuilder struct {
+	names   []string
+	indexes map[string]int
+	tree    tree[[]int]
+}
+
+// Add adds the Prefixes in s to the set called name. If name has already been
+// added, then s is merged into it.
+func (b *MultiSetBuilder) Add(name string, s *PrefixSet) {
+	if b.indexes == nil {
+		b.indexes = make(map[string]int)
+	}
+	i, ok := b.indexes[name]
+	if !ok {
+		i = len(b.names)
+		b.indexes[name] = i
+		b.names = append(b.names, name)
+	}
+	s.tree.walk(key{}, func(n *tree[bool]) bool {
+		if !n.hasEntry {
+			return false
+		}
+		k := n.key.rooted()
+		sets, _ := b.tree.get(k)
+		if !slices.Contains(sets, i) {
+			b.tree = *b.tree.insert(k, append(sets, i))
+		}
+		return false
+	})
+}
+
+// MultiSet returns an immutable MultiSet representing the current state of b.
+//
+// The builder remains usable after calling MultiSet.
+func (b *MultiSetBuilder) MultiSet() *MultiSet {
+	t := b.tree.copy()
+	t.walk(key{}, func(n *tree[[]int]) bool {
+		n.value = slices.Clone(n.value)
+		return false
+	})
+	return &MultiSet{slices.Clone(b.names), *t}
+}
+
+// MultiSet indexes several named sets of Prefixes together, so that all of the
+// sets covering an address can be found with a single traversal rather than
+// one lookup per set.
+//
+// Use [MultiSetBuilder] to construct MultiSets.
+type MultiSet struct {
+	names []string
+	tree  tree[[]int]
+}
+
+// Names returns the names of the sets in m, in the order they were added.
+func (m *MultiSet) Names() []string {
+	return slices.Clone(m.names)
+}
+
+// Classify returns the names of the sets in m which contain a, in the order
+// the sets were added.
+func (m *MultiSet) Classify(a netip.Addr) []string {
+	if !a.IsValid() {
+		return nil
+	}
+	return m.classify(keyFromAddr(a))
+}
+
+// ClassifyPrefix returns the names of the sets in m which encompass p, in the
+// order the sets were added.
+func (m *MultiSet) ClassifyPrefix(p netip.Prefix) []string {
+	if !p.IsValid() {
+		return nil
+	}
+	return m.classify(keyFromPrefix(p))
+}
+
+// Sources returns the names of the sets in m which contain the exact Prefix
+// p, in the order the sets were added. This identifies which sets contributed
+// an entry of [MultiSet.PrefixSet].
+func (m *MultiSet) Sources(p netip.Prefix) []string {
+	if !p.IsValid() {
+		return nil
+	}
+	sets, _ := m.tree.get(keyFromPrefix(p))
+	sets = slices.Clone(sets)
+	slices.Sort(sets)
+	var res []string
+	for _, i := range sets {
+		res = append(res, m.names[i])
+	}
+	return res
+}
+
+// PrefixSet returns a PrefixSet containing the union of the sets in m.
+func (m *MultiSet) PrefixSet() *PrefixSet {
+	t := mapTree(&m.tree, func(key, []int) bool { return true })
+	return &PrefixSet{*t, t.stats()}
+}
+
+func (m *MultiSet) classify(k key) []string {
+	var res []string
+	seen := make([]bool, len(m.names))
+	for n := m.tree.pathNext(k); n != nil && n.key.isPrefixOf(k, false); n = n.pathNext(k) {
+		for _, i := range n.value {
+			seen[i] = true
+		}
+	}
+	for i, ok := range seen {
+		if ok {
+			res = append(res, m.names[i])
+		}
+	}
+	return res
+}
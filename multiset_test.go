@@ -0,0 +1,117 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestMultiSetClassify(t *testing.T) {
+	sets := []struct {
+		name string
+		set  []netip.Prefix
+	}{
+		{"private", pfxs("10.0.0.0/8", "192.168.0.0/16")},
+		{"office", pfxs("10.1.0.0/16")},
+		{"vpn", pfxs("10.1.2.0/24", "2001:db8::/32")},
+		{"empty", pfxs()},
+	}
+	msb := &MultiSetBuilder{}
+	for _, s := range sets {
+		psb := &PrefixSetBuilder{}
+		for _, p := range s.set {
+			psb.Add(p)
+		}
+		msb.Add(s.name, psb.PrefixSet())
+	}
+	ms := msb.MultiSet()
+
+	tests := []struct {
+		addr netip.Addr
+		want []string
+	}{
+		{netip.MustParseAddr("10.1.2.3"), []string{"private", "office", "vpn"}},
+		{netip.MustParseAddr("10.1.3.3"), []string{"private", "office"}},
+		{netip.MustParseAddr("10.2.3.3"), []string{"private"}},
+		{netip.MustParseAddr("192.168.1.1"), []string{"private"}},
+		{netip.MustParseAddr("2001:db8::1"), []string{"vpn"}},
+		{netip.MustParseAddr("8.8.8.8"), nil},
+		{netip.Addr{}, nil},
+	}
+	for _, tt := range tests {
+		if got := ms.Classify(tt.addr); !slices.Equal(got, tt.want) {
+			t.Errorf("ms.Classify(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	if got := ms.ClassifyPrefix(pfx("10.1.0.0/16")); !slices.Equal(got, []string{"private", "office"}) {
+		t.Errorf("ms.ClassifyPrefix(10.1.0.0/16) = %v, want [private office]", got)
+	}
+	if got, want := ms.Names(), []string{"private", "office", "vpn", "empty"}; !slices.Equal(got, want) {
+		t.Errorf("ms.Names() = %v, want %v", got, want)
+	}
+}
+
+func TestMultiSetBuilderAddSameName(t *testing.T) {
+	a := &PrefixSetBuilder{}
+	a.Add(pfx("10.0.0.0/8"))
+	b := &PrefixSetBuilder{}
+	b.Add(pfx("10.0.0.0/8"))
+	b.Add(pfx("172.16.0.0/12"))
+
+	msb := &MultiSetBuilder{}
+	msb.Add("x", a.PrefixSet())
+	ms1 := msb.MultiSet()
+	msb.Add("x", b.PrefixSet())
+	msb.Add("y", a.PrefixSet())
+	ms2 := msb.MultiSet()
+
+	// ms1 is unaffected by later changes to the builder
+	if got := ms1.Classify(netip.MustParseAddr("10.0.0.1")); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("ms1.Classify(10.0.0.1) = %v, want [x]", got)
+	}
+	if got := ms2.Classify(netip.MustParseAddr("10.0.0.1")); !slices.Equal(got, []string{"x", "y"}) {
+		t.Errorf("ms2.Classify(10.0.0.1) = %v, want [x y]", got)
+	}
+	if got := ms2.Classify(netip.MustParseAddr("172.16.0.1")); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("ms2.Classify(172.16.0.1) = %v, want [x]", got)
+	}
+}
+
+func TestMultiSetSources(t *testing.T) {
+	msb := &MultiSetBuilder{}
+	for _, s := range []struct {
+		name string
+		set  []netip.Prefix
+	}{
+		{"feed-a", pfxs("1.2.3.0/24", "5.6.0.0/16")},
+		{"feed-b", pfxs("1.2.3.0/24", "1.2.3.4/32")},
+		{"feed-c", pfxs("5.6.0.0/16")},
+	} {
+		psb := &PrefixSetBuilder{}
+		for _, p := range s.set {
+			psb.Add(p)
+		}
+		msb.Add(s.name, psb.PrefixSet())
+	}
+	ms := msb.MultiSet()
+
+	checkPrefixSlice(t, ms.PrefixSet().Prefixes(), pfxs("1.2.3.0/24", "1.2.3.4/32", "5.6.0.0/16"))
+
+	tests := []struct {
+		p    netip.Prefix
+		want []string
+	}{
+		{pfx("1.2.3.0/24"), []string{"feed-a", "feed-b"}},
+		{pfx("1.2.3.4/32"), []string{"feed-b"}},
+		{pfx("5.6.0.0/16"), []string{"feed-a", "feed-c"}},
+		// Sources only reports exact entries
+		{pfx("1.2.3.5/32"), nil},
+		{netip.Prefix{}, nil},
+	}
+	for _, tt := range tests {
+		if got := ms.Sources(tt.p); !slices.Equal(got, tt.want) {
+			t.Errorf("ms.Sources(%s) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package netipds
+
+import "net/netip"
+
+// PrefixLister is implemented by collections that can list their Prefixes,
+// such as *netipx.IPSet from go4.org/netipx. It is used by [FromIPSet] so that
+// this package does not depend on netipx.
+type PrefixLister interface {
+	Prefixes() []netip.Prefix
+}
+
+// PrefixAdder is implemented by builders that accept Prefixes, such as
+// *netipx.IPSetBuilder from go4.org/netipx. It is used by
+// [PrefixSet.ToIPSet] so that this package does not depend on netipx.
+type PrefixAdder interface {
+	AddPrefix(p netip.Prefix)
+}
+
+// FromIPSet returns a PrefixSet containing the Prefixes listed by l, which is
+// typically a *netipx.IPSet. Invalid Prefixes are skipped.
+func FromIPSet(l PrefixLister) *PrefixSet {
+	psb := &PrefixSetBuilder{}
+	for _, p := range l.Prefixes() {
+		psb.Add(p)
+	}
+	return psb.PrefixSet()
+}
+
+// ToIPSet adds the Prefixes in s to b, which is typically a
+// *netipx.IPSetBuilder. Nested Prefixes are skipped, since they add no
+// addresses. For example:
+//
+//	var b netipx.IPSetBuilder
+//	s.ToIPSet(&b)
+//	ipset, err := b.IPSet()
+func (s *PrefixSet) ToIPSet(b PrefixAdder) {
+	s.tree.walk(key{}, func(n *tree[bool]) bool {
+		if n.hasEntry {
+			b.AddPrefix(n.key.toPrefix())
+			return true
+		}
+		return false
+	})
+}
@@ -0,0 +1,33 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// fakeIPSet stands in for *netipx.IPSet and *netipx.IPSetBuilder.
+type fakeIPSet struct {
+	prefixes []netip.Prefix
+}
+
+func (s *fakeIPSet) Prefixes() []netip.Prefix {
+	return s.prefixes
+}
+
+func (s *fakeIPSet) AddPrefix(p netip.Prefix) {
+	s.prefixes = append(s.prefixes, p)
+}
+
+func TestIPSetInterop(t *testing.T) {
+	ipset := &fakeIPSet{pfxs("1.2.3.0/24", "10.0.0.0/8", "2001:db8::/32")}
+	ps := FromIPSet(ipset)
+	checkPrefixSlice(t, ps.Prefixes(), pfxs("1.2.3.0/24", "10.0.0.0/8", "2001:db8::/32"))
+
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.0.0/16", "1.2.3.0/24", "::1/128") {
+		psb.Add(p)
+	}
+	b := &fakeIPSet{}
+	psb.PrefixSet().ToIPSet(b)
+	checkPrefixSlice(t, b.prefixes, pfxs("::1/128", "1.2.0.0/16"))
+}
@@ -0,0 +1,271 @@
+package netipds
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+)
+
+// Patch describes the changes which turn one PrefixMap or PrefixSet into
+// another. Producers can send consumers holding the older map or set a Patch
+// instead of the whole newer one. See [NewPatch] and [NewSetPatch].
+//
+// A Patch can be encoded with MarshalBinary or MarshalJSON. The JSON encoding
+// is an object with "added", "removed" and "changed" members; entries are
+// encoded as in [PrefixMap.WriteNDJSON]:
+//
+//	{"added":[{"prefix":"1.2.3.0/24","value":"a"}],"removed":["10.0.0.0/8"],"changed":[]}
+type Patch[T any] struct {
+	// Added holds the entries to add, in Prefix order.
+	Added []PrefixEntry[T]
+	// Removed holds the Prefixes to remove, in order.
+	Removed []netip.Prefix
+	// Changed holds the entries whose values change, with their new values,
+	// in Prefix order.
+	Changed []PrefixEntry[T]
+}
+
+// NewPatch returns a Patch which turns from into to. Values are compared using
+// eq. The Patch is equivalent to the result of from.Diff(to, eq).
+func NewPatch[T any](from, to *PrefixMap[T], eq func(a, b T) bool) *Patch[T] {
+	p := &Patch[T]{}
+	mergeEntries(&from.tree, &to.tree, func(x, y *tree[T]) {
+		switch {
+		case x == nil:
+			p.Added = append(p.Added, PrefixEntry[T]{y.key.toPrefix(), y.value})
+		case y == nil:
+			p.Removed = append(p.Removed, x.key.toPrefix())
+		case !eq(x.value, y.value):
+			p.Changed = append(p.Changed, PrefixEntry[T]{y.key.toPrefix(), y.value})
+		}
+	})
+	return p
+}
+
+// NewSetPatch returns a Patch which turns from into to. The values of its
+// Added entries are true, and it has no Changed entries.
+func NewSetPatch(from, to *PrefixSet) *Patch[bool] {
+	return NewPatch(
+		&PrefixMap[bool]{from.tree, from.stats, nil},
+		&PrefixMap[bool]{to.tree, to.stats, nil},
+		func(a, b bool) bool { return true },
+	)
+}
+
+// IsEmpty returns true if p makes no changes.
+func (p *Patch[T]) IsEmpty() bool {
+	return len(p.Added) == 0 && len(p.Removed) == 0 && len(p.Changed) == 0
+}
+
+// validate returns an error if any of p's Prefixes is invalid.
+func (p *Patch[T]) validate() error {
+	for _, e := range p.Added {
+		if !e.Prefix.IsValid() {
+			return invalidPrefixError(e.Prefix)
+		}
+	}
+	for _, pfx := range p.Removed {
+		if !pfx.IsValid() {
+			return invalidPrefixError(pfx)
+		}
+	}
+	for _, e := range p.Changed {
+		if !e.Prefix.IsValid() {
+			return invalidPrefixError(e.Prefix)
+		}
+	}
+	return nil
+}
+
+// Apply makes the changes described by p to m: the Removed Prefixes are
+// removed, and the Added and Changed entries are set. Applying a Patch
+// created by NewPatch(from, to) to a builder holding from makes it hold to.
+//
+// If any of p's Prefixes is invalid, an error is returned and m is left
+// unchanged.
+func (p *Patch[T]) Apply(m *PrefixMapBuilder[T]) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	for _, pfx := range p.Removed {
+		m.Remove(pfx)
+	}
+	for _, e := range p.Added {
+		m.Set(e.Prefix, e.Value)
+	}
+	for _, e := range p.Changed {
+		m.Set(e.Prefix, e.Value)
+	}
+	return nil
+}
+
+// ApplySet is like [Patch.Apply], but makes the changes to a PrefixSetBuilder.
+// The Removed Prefixes are removed, and the Prefixes of the Added entries are
+// added. Values, and therefore Changed entries, are ignored.
+func (p *Patch[T]) ApplySet(s *PrefixSetBuilder) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	for _, pfx := range p.Removed {
+		s.Remove(pfx)
+	}
+	for _, e := range p.Added {
+		s.Add(e.Prefix)
+	}
+	return nil
+}
+
+// The binary format written by Patch.MarshalBinary is:
+//
+//	magic    [4]byte "NIPP"
+//	version  byte    patchBinaryVersion
+//	values   byte    valuesBinary or valuesGob
+//	removed  uvarint the number of Removed Prefixes
+//	added    uvarint the number of Added entries
+//	changed  uvarint the number of Changed entries
+//	keys     the keys of the Removed, Added and Changed Prefixes, in that
+//	         order, in the encoding of key.appendBinary
+//	values   the values of the Added and Changed entries, as in the binary
+//	         format of PrefixMap
+const (
+	patchBinaryMagic   = "NIPP"
+	patchBinaryVersion = 1
+)
+
+// MarshalBinary implements [encoding.BinaryMarshaler]. Values are encoded as
+// in [PrefixMap.MarshalBinary].
+func (p *Patch[T]) MarshalBinary() ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	enc := mapValueEncoding[T]()
+	b := append([]byte(patchBinaryMagic), patchBinaryVersion, enc)
+	b = binary.AppendUvarint(b, uint64(len(p.Removed)))
+	b = binary.AppendUvarint(b, uint64(len(p.Added)))
+	b = binary.AppendUvarint(b, uint64(len(p.Changed)))
+	for _, pfx := range p.Removed {
+		b = keyFromPrefix(pfx).appendBinary(b)
+	}
+	values := make([]T, 0, len(p.Added)+len(p.Changed))
+	for _, es := range [][]PrefixEntry[T]{p.Added, p.Changed} {
+		for _, e := range es {
+			b = keyFromPrefix(e.Prefix).appendBinary(b)
+			values = append(values, e.Value)
+		}
+	}
+	return appendValuesBinary(b, enc, values)
+}
+
+// UnmarshalBinary implements [encoding.BinaryUnmarshaler]. It replaces the
+// contents of p with a Patch decoded from data, which must have been produced
+// by [Patch.MarshalBinary] for the same type T.
+func (p *Patch[T]) UnmarshalBinary(data []byte) error {
+	b := data
+	if len(b) < len(patchBinaryMagic)+2 || string(b[:len(patchBinaryMagic)]) != patchBinaryMagic {
+		return fmt.Errorf("invalid binary encoding: bad header")
+	}
+	b = b[len(patchBinaryMagic):]
+	if b[0] != patchBinaryVersion {
+		return fmt.Errorf("unsupported binary encoding version %d", b[0])
+	}
+	enc := mapValueEncoding[T]()
+	if b[1] != enc {
+		return fmt.Errorf("invalid binary encoding: unexpected value encoding %d", b[1])
+	}
+	b = b[2:]
+	var counts [3]int
+	for i := range counts {
+		c, n := binary.Uvarint(b)
+		// Each Prefix takes at least one byte
+		if n <= 0 || c > uint64(len(b)) {
+			return fmt.Errorf("invalid binary encoding: bad count")
+		}
+		counts[i] = int(c)
+		b = b[n:]
+	}
+	prefixes := make([]netip.Prefix, counts[0]+counts[1]+counts[2])
+	for i := range prefixes {
+		k, n, err := keyFromBinary(b)
+		if err != nil {
+			return err
+		}
+		if prefixes[i] = k.toPrefix(); !prefixes[i].IsValid() {
+			return fmt.Errorf("invalid binary encoding: invalid key %v", k)
+		}
+		b = b[n:]
+	}
+	values, err := valuesFromBinary[T](b, enc, counts[1]+counts[2])
+	if err != nil {
+		return err
+	}
+	entries := func(ps []netip.Prefix, vs []T) []PrefixEntry[T] {
+		if len(ps) == 0 {
+			return nil
+		}
+		es := make([]PrefixEntry[T], len(ps))
+		for i := range es {
+			es[i] = PrefixEntry[T]{ps[i], vs[i]}
+		}
+		return es
+	}
+	removed := prefixes[:counts[0]]
+	if len(removed) == 0 {
+		removed = nil
+	}
+	*p = Patch[T]{
+		Added:   entries(prefixes[counts[0]:counts[0]+counts[1]], values[:counts[1]]),
+		Removed: removed,
+		Changed: entries(prefixes[counts[0]+counts[1]:], values[counts[1]:]),
+	}
+	return nil
+}
+
+// patchJSON is the JSON encoding of a Patch.
+type patchJSON[T any] struct {
+	Added   []ndjsonEntry[T] `json:"added"`
+	Removed []netip.Prefix   `json:"removed"`
+	Changed []ndjsonEntry[T] `json:"changed"`
+}
+
+// MarshalJSON implements [json.Marshaler]. Values are encoded with
+// [encoding/json].
+func (p *Patch[T]) MarshalJSON() ([]byte, error) {
+	entries := func(es []PrefixEntry[T]) []ndjsonEntry[T] {
+		ret := make([]ndjsonEntry[T], len(es))
+		for i, e := range es {
+			ret[i] = ndjsonEntry[T]{e.Prefix, e.Value}
+		}
+		return ret
+	}
+	removed := p.Removed
+	if removed == nil {
+		removed = []netip.Prefix{}
+	}
+	return json.Marshal(patchJSON[T]{entries(p.Added), removed, entries(p.Changed)})
+}
+
+// UnmarshalJSON implements [json.Unmarshaler]. It replaces the contents of p
+// with a Patch decoded from data.
+func (p *Patch[T]) UnmarshalJSON(data []byte) error {
+	var pj patchJSON[T]
+	if err := json.Unmarshal(data, &pj); err != nil {
+		return err
+	}
+	entries := func(es []ndjsonEntry[T]) []PrefixEntry[T] {
+		if len(es) == 0 {
+			return nil
+		}
+		ret := make([]PrefixEntry[T], len(es))
+		for i, e := range es {
+			ret[i] = PrefixEntry[T]{e.Prefix, e.Value}
+		}
+		return ret
+	}
+	removed := pj.Removed
+	if len(removed) == 0 {
+		removed = nil
+	}
+	*p = Patch[T]{entries(pj.Added), removed, entries(pj.Changed)}
+	return nil
+}
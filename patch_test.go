@@ -0,0 +1,140 @@
+package netipds
+
+import (
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	build := func(entries map[string]string) *PrefixMapBuilder[string] {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range entries {
+			pmb.Set(pfx(p), v)
+		}
+		return pmb
+	}
+	from := build(map[string]string{
+		"1.2.0.0/16":    "a",
+		"1.2.3.0/24":    "b",
+		"10.0.0.0/8":    "c",
+		"2001:db8::/32": "d",
+	}).PrefixMap()
+	to := build(map[string]string{
+		"1.2.0.0/16":      "a",
+		"1.2.3.0/24":      "B",
+		"192.168.0.0/16":  "e",
+		"2001:db8::/32":   "d",
+		"2001:db8:1::/48": "f",
+	}).PrefixMap()
+	eq := func(a, b string) bool { return a == b }
+
+	p := NewPatch(from, to, eq)
+	want := &Patch[string]{
+		Added: []PrefixEntry[string]{
+			{pfx("192.168.0.0/16"), "e"},
+			{pfx("2001:db8:1::/48"), "f"},
+		},
+		Removed: pfxs("10.0.0.0/8"),
+		Changed: []PrefixEntry[string]{{pfx("1.2.3.0/24"), "B"}},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("NewPatch() = %+v, want %+v", p, want)
+	}
+	if NewPatch(from, from, eq).IsEmpty() != true || p.IsEmpty() {
+		t.Errorf("IsEmpty() returned the wrong result")
+	}
+
+	// Encoded Patches decode to the same Patch
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromBinary := &Patch[string]{}
+	if err := fromBinary.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	j, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON := &Patch[string]{}
+	if err := json.Unmarshal(j, fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string]*Patch[string]{"binary": fromBinary, "JSON": fromJSON} {
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s round trip = %+v, want %+v", name, got, want)
+		}
+		pmb := build(nil)
+		pmb.MergeWith(from, nil)
+		if err := got.Apply(pmb); err != nil {
+			t.Fatal(err)
+		}
+		if !pmb.PrefixMap().Equal(to, eq) {
+			t.Errorf("%s: Apply() = %v, want %v", name, pmb.PrefixMap().ToMap(), to.ToMap())
+		}
+	}
+
+	// Invalid Prefixes are rejected before any change is made
+	pmb := build(map[string]string{"10.0.0.0/8": "c"})
+	bad := &Patch[string]{
+		Removed: pfxs("10.0.0.0/8"),
+		Added:   []PrefixEntry[string]{{netip.Prefix{}, "x"}},
+	}
+	if err := bad.Apply(pmb); err == nil {
+		t.Errorf("Apply() with invalid Prefix returned nil error")
+	}
+	if _, ok := pmb.Get(pfx("10.0.0.0/8")); !ok {
+		t.Errorf("Apply() with invalid Prefix modified the builder")
+	}
+	if _, err := bad.MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary() with invalid Prefix returned nil error")
+	}
+	for _, data := range [][]byte{nil, []byte("NIPD"), b[:len(b)-1], b[:12]} {
+		if err := (&Patch[string]{}).UnmarshalBinary(data); err == nil {
+			t.Errorf("UnmarshalBinary(%v) returned nil error", data)
+		}
+	}
+	if err := (&Patch[binaryValue]{}).UnmarshalBinary(b); err == nil {
+		t.Errorf("UnmarshalBinary() with the wrong value encoding returned nil error")
+	}
+}
+
+func TestSetPatch(t *testing.T) {
+	build := func(ps ...string) *PrefixSetBuilder {
+		psb := &PrefixSetBuilder{}
+		for _, p := range pfxs(ps...) {
+			psb.Add(p)
+		}
+		return psb
+	}
+	from := build("1.2.0.0/16", "10.0.0.0/8", "2001:db8::/32")
+	to := build("1.2.0.0/16", "1.2.3.0/24", "2001:db8::/32").PrefixSet()
+
+	p := NewSetPatch(from.PrefixSet(), to)
+	if len(p.Changed) != 0 {
+		t.Errorf("NewSetPatch() has Changed entries: %v", p.Changed)
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Patch[bool]{}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.ApplySet(from); err != nil {
+		t.Fatal(err)
+	}
+	checkPrefixSlice(t, from.PrefixSet().Prefixes(), to.Prefixes())
+
+	j, err := json.Marshal(NewSetPatch(to, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"added":[],"removed":[],"changed":[]}`; string(j) != want {
+		t.Errorf("json.Marshal(empty Patch) = %s, want %s", j, want)
+	}
+}
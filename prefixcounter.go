@@ -0,0 +1,102 @@
+package netipds
+
+import (
+	"net/netip"
+	"sync/atomic"
+)
+
+// PrefixCounter counts hits on each Prefix of a fixed PrefixSet, such as
+// traffic per allocated block. Each hit is counted against the longest
+// Prefix containing the address.
+//
+// Counting is safe for concurrent use and does not allocate.
+type PrefixCounter struct {
+	// tree maps each Prefix to the index of its counter
+	tree   tree[int]
+	counts []atomic.Uint64
+	stats  prefixStats
+}
+
+// NewPrefixCounter returns a PrefixCounter with a zero counter for each
+// Prefix in s.
+func NewPrefixCounter(s *PrefixSet) *PrefixCounter {
+	i := 0
+	t := mapTree(&s.tree, func(key, bool) int {
+		i++
+		return i - 1
+	})
+	return &PrefixCounter{tree: *t, counts: make([]atomic.Uint64, i), stats: s.stats}
+}
+
+// Inc increments the counter of the longest Prefix containing a. It returns
+// false if no Prefix contains a. a's zone, if any, is ignored.
+func (c *PrefixCounter) Inc(a netip.Addr) bool {
+	return c.Add(a, 1)
+}
+
+// Add adds d to the counter of the longest Prefix containing a. It returns
+// false if no Prefix contains a. a's zone, if any, is ignored.
+func (c *PrefixCounter) Add(a netip.Addr, d uint64) bool {
+	if !a.IsValid() {
+		return false
+	}
+	n := c.tree.longestMatch(keyFromAddr(a))
+	if n == nil {
+		return false
+	}
+	c.counts[n.value].Add(d)
+	return true
+}
+
+// Count returns the counter of the exact Prefix provided, and whether c has
+// a counter for it.
+func (c *PrefixCounter) Count(p netip.Prefix) (uint64, bool) {
+	i, ok := c.tree.get(keyFromPrefix(p))
+	if !ok {
+		return 0, false
+	}
+	return c.counts[i].Load(), true
+}
+
+// Totals returns a PrefixMap of each Prefix in c to its counter. The counters
+// are read one at a time, so hits counted concurrently with Totals may or may
+// not be included.
+func (c *PrefixCounter) Totals() *PrefixMap[uint64] {
+	t := mapTree(&c.tree, func(_ key, i int) uint64 {
+		return c.counts[i].Load()
+	})
+	return &PrefixMap[uint64]{*t, c.stats, nil}
+}
+
+// RollupTotals is like [PrefixCounter.Totals], but each Prefix's total also
+// includes the counters of its descendants, so that the total of a block
+// includes the hits on its sub-allocations.
+func (c *PrefixCounter) RollupTotals() *PrefixMap[uint64] {
+	m := c.Totals()
+	rollup(&m.tree)
+	return m
+}
+
+// rollup adds the sum of the values of each entry's descendants to its value,
+// and returns the sum of the values of the topmost entries in t.
+func rollup(t *tree[uint64]) uint64 {
+	var sum uint64
+	if t.left != nil {
+		sum += rollup(t.left)
+	}
+	if t.right != nil {
+		sum += rollup(t.right)
+	}
+	if t.hasEntry {
+		t.value += sum
+		return t.value
+	}
+	return sum
+}
+
+// Reset sets all of c's counters to zero.
+func (c *PrefixCounter) Reset() {
+	for i := range c.counts {
+		c.counts[i].Store(0)
+	}
+}
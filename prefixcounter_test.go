@@ -0,0 +1,72 @@
+package netipds
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+func TestPrefixCounter(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "2001:db8::/32") {
+		psb.Add(p)
+	}
+	c := NewPrefixCounter(psb.PrefixSet())
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Inc(netip.MustParseAddr("10.1.2.3"))
+				c.Inc(netip.MustParseAddr("10.1.3.3"))
+				c.Inc(netip.MustParseAddr("10.9.9.9"))
+			}
+		}()
+	}
+	wg.Wait()
+	c.Add(netip.MustParseAddr("2001:db8::1"), 7)
+	if c.Inc(netip.MustParseAddr("192.168.0.1")) || c.Inc(netip.Addr{}) {
+		t.Errorf("Inc counted an address outside the set")
+	}
+
+	if got, ok := c.Count(pfx("10.1.2.0/24")); got != 400 || !ok {
+		t.Errorf("Count(10.1.2.0/24) = (%d, %v), want (400, true)", got, ok)
+	}
+	if _, ok := c.Count(pfx("10.3.0.0/16")); ok {
+		t.Errorf("Count(10.3.0.0/16) found a counter")
+	}
+	checkMap(t, map[netip.Prefix]uint64{
+		pfx("10.0.0.0/8"):    400,
+		pfx("10.1.0.0/16"):   400,
+		pfx("10.1.2.0/24"):   400,
+		pfx("10.2.0.0/16"):   0,
+		pfx("2001:db8::/32"): 7,
+	}, c.Totals().ToMap())
+	checkMap(t, map[netip.Prefix]uint64{
+		pfx("10.0.0.0/8"):    1200,
+		pfx("10.1.0.0/16"):   800,
+		pfx("10.1.2.0/24"):   400,
+		pfx("10.2.0.0/16"):   0,
+		pfx("2001:db8::/32"): 7,
+	}, c.RollupTotals().ToMap())
+	if got := c.Totals().Size(); got != 5 {
+		t.Errorf("Totals().Size() = %d, want 5", got)
+	}
+
+	c.Reset()
+	if got, _ := c.Count(pfx("10.0.0.0/8")); got != 0 {
+		t.Errorf("Count(10.0.0.0/8) after Reset = %d, want 0", got)
+	}
+}
+
+func TestPrefixCounterIncAllocs(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("10.0.0.0/8"))
+	c := NewPrefixCounter(psb.PrefixSet())
+	a := netip.MustParseAddr("10.1.2.3")
+	if n := testing.AllocsPerRun(100, func() { c.Inc(a) }); n != 0 {
+		t.Errorf("Inc allocates %v times, want 0", n)
+	}
+}
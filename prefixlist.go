@@ -0,0 +1,92 @@
+package netipds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ReadPrefixSet builds a PrefixSet from a list of Prefixes or addresses, one
+// per line, as written by [PrefixSet.WriteTo]. Addresses are read as
+// single-address Prefixes, and Prefixes with host bits set are masked. Blank
+// lines and comments starting with '#' are ignored.
+//
+// The list is read incrementally into a lazy builder, so it is never held in
+// memory in full. If a line cannot be parsed, the error includes its line
+// number.
+func ReadPrefixSet(r io.Reader) (*PrefixSet, error) {
+	psb := &PrefixSetBuilder{Lazy: true}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		p, err := parsePrefixOrAddr(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err = psb.Add(p); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return psb.PrefixSet(), nil
+}
+
+// parsePrefixOrAddr parses s as a Prefix, or as an address, which is
+// converted to a single-address Prefix.
+func parsePrefixOrAddr(s string) (netip.Prefix, error) {
+	addr, bits, isPrefix := strings.Cut(s, "/")
+	// netip drops the zones of addresses converted to Prefixes
+	if strings.Contains(addr, "%") {
+		return netip.Prefix{}, fmt.Errorf("%q: %w", s, ErrZoneNotSupported)
+	}
+	if !isPrefix {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(a, a.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		a, aerr := netip.ParseAddr(addr)
+		if n, nerr := strconv.Atoi(bits); aerr == nil && nerr == nil && n > a.BitLen() {
+			return netip.Prefix{}, fmt.Errorf("%q: %w", s, ErrMaxLenExceeded)
+		}
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+// WriteTo writes the Prefixes in s to w in sorted order, one per line, and
+// returns the number of bytes written. The output can be read back with
+// [ReadPrefixSet].
+func (s *PrefixSet) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	var err error
+	var buf []byte
+	s.tree.walk(key{}, func(t *tree[bool]) bool {
+		if err != nil {
+			return true
+		}
+		if t.hasEntry {
+			buf = append(t.key.toPrefix().AppendTo(buf[:0]), '\n')
+			var m int
+			m, err = bw.Write(buf)
+			n += int64(m)
+		}
+		return false
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
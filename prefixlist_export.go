@@ -0,0 +1,106 @@
+package netipds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// PrefixListFormat is a router configuration syntax for prefix lists. See
+// [PrefixSet.ExportPrefixList].
+type PrefixListFormat int
+
+const (
+	// IOSPrefixList is Cisco IOS "ip prefix-list" syntax.
+	IOSPrefixList PrefixListFormat = iota
+	// FRRPrefixList is FRRouting "ip prefix-list" syntax, which is the same
+	// as IOS syntax.
+	FRRPrefixList
+	// JunosPrefixList is Junos "set policy-options" syntax. Exact Prefixes
+	// are written to a prefix-list, and ranges to a route-filter-list.
+	JunosPrefixList
+)
+
+// prefixListEntry is a Prefix, and the range of lengths of the routes within
+// it that a prefix list entry should match. ge == le == Bits() means an exact
+// match.
+type prefixListEntry struct {
+	p      netip.Prefix
+	ge, le int
+}
+
+// ExportPrefixList writes a prefix list named name, permitting the Prefixes
+// in s, to w in the provided format. IPv4 and IPv6 Prefixes are written to
+// separate lists in IOS and FRR syntax, the IPv6 one named name+"_v6", with
+// sequence numbers starting at 5 in steps of 5.
+//
+// If aggregate is false, each Prefix in s is written as an exact match.
+// Otherwise, s is aggregated as in [PrefixSet.Aggregated], and each aggregate
+// is written with the range of lengths (ge and le) of the Prefixes in s
+// within it. The list then permits every Prefix in s with fewer entries, but
+// may also permit routes that s doesn't contain.
+func (s *PrefixSet) ExportPrefixList(w io.Writer, format PrefixListFormat, name string, aggregate bool) error {
+	var v4, v6 []prefixListEntry
+	if aggregate {
+		for _, p := range s.PrefixesAggregated() {
+			d := s.DescendantsOf(p)
+			e := prefixListEntry{p, d.MinPrefixLen(), d.MaxPrefixLen()}
+			if p.Addr().Is4() {
+				v4 = append(v4, e)
+			} else {
+				v6 = append(v6, e)
+			}
+		}
+	} else {
+		for _, p := range s.Prefixes() {
+			e := prefixListEntry{p, p.Bits(), p.Bits()}
+			if p.Addr().Is4() {
+				v4 = append(v4, e)
+			} else {
+				v6 = append(v6, e)
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	switch format {
+	case IOSPrefixList, FRRPrefixList:
+		for _, list := range []struct {
+			cmd, name string
+			entries   []prefixListEntry
+		}{
+			{"ip", name, v4},
+			{"ipv6", name + "_v6", v6},
+		} {
+			for i, e := range list.entries {
+				fmt.Fprintf(bw, "%s prefix-list %s seq %d permit %s", list.cmd, list.name, 5*(i+1), e.p)
+				if e.ge > e.p.Bits() {
+					fmt.Fprintf(bw, " ge %d", e.ge)
+				}
+				// Without le, ge matches every longer length
+				if e.le > e.p.Bits() {
+					fmt.Fprintf(bw, " le %d", e.le)
+				}
+				bw.WriteByte('\n')
+			}
+		}
+	case JunosPrefixList:
+		for _, e := range append(v4, v6...) {
+			switch {
+			case !aggregate:
+				fmt.Fprintf(bw, "set policy-options prefix-list %s %s\n", name, e.p)
+			case e.ge == e.p.Bits() && e.le == e.ge:
+				fmt.Fprintf(bw, "set policy-options route-filter-list %s %s exact\n", name, e.p)
+			case e.ge == e.p.Bits():
+				fmt.Fprintf(bw, "set policy-options route-filter-list %s %s upto /%d\n", name, e.p, e.le)
+			default:
+				fmt.Fprintf(bw, "set policy-options route-filter-list %s %s prefix-length-range /%d-/%d\n",
+					name, e.p, e.ge, e.le)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown prefix list format: %d", format)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,68 @@
+package netipds
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixSetExportPrefixList(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs(
+		"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24",
+		"192.168.0.0/24", "192.168.1.0/24",
+		"172.16.0.0/25", "172.16.0.128/26", "172.16.0.192/26",
+		"2001:db8::/32",
+	) {
+		psb.Add(p)
+	}
+	s := psb.PrefixSet()
+
+	tests := []struct {
+		format    PrefixListFormat
+		aggregate bool
+		want      string
+	}{
+		{IOSPrefixList, false, `ip prefix-list PL seq 5 permit 10.0.0.0/8
+ip prefix-list PL seq 10 permit 10.1.0.0/16
+ip prefix-list PL seq 15 permit 10.1.2.0/24
+ip prefix-list PL seq 20 permit 172.16.0.0/25
+ip prefix-list PL seq 25 permit 172.16.0.128/26
+ip prefix-list PL seq 30 permit 172.16.0.192/26
+ip prefix-list PL seq 35 permit 192.168.0.0/24
+ip prefix-list PL seq 40 permit 192.168.1.0/24
+ipv6 prefix-list PL_v6 seq 5 permit 2001:db8::/32
+`},
+		{FRRPrefixList, true, `ip prefix-list PL seq 5 permit 10.0.0.0/8 le 24
+ip prefix-list PL seq 10 permit 172.16.0.0/24 ge 25 le 26
+ip prefix-list PL seq 15 permit 192.168.0.0/23 ge 24 le 24
+ipv6 prefix-list PL_v6 seq 5 permit 2001:db8::/32
+`},
+		{JunosPrefixList, false, `set policy-options prefix-list PL 10.0.0.0/8
+set policy-options prefix-list PL 10.1.0.0/16
+set policy-options prefix-list PL 10.1.2.0/24
+set policy-options prefix-list PL 172.16.0.0/25
+set policy-options prefix-list PL 172.16.0.128/26
+set policy-options prefix-list PL 172.16.0.192/26
+set policy-options prefix-list PL 192.168.0.0/24
+set policy-options prefix-list PL 192.168.1.0/24
+set policy-options prefix-list PL 2001:db8::/32
+`},
+		{JunosPrefixList, true, `set policy-options route-filter-list PL 10.0.0.0/8 upto /24
+set policy-options route-filter-list PL 172.16.0.0/24 prefix-length-range /25-/26
+set policy-options route-filter-list PL 192.168.0.0/23 prefix-length-range /24-/24
+set policy-options route-filter-list PL 2001:db8::/32 exact
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := s.ExportPrefixList(&buf, tt.format, "PL", tt.aggregate); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("ExportPrefixList(%d, aggregate=%v) =\n%s\nwant\n%s", tt.format, tt.aggregate, got, tt.want)
+		}
+	}
+	if err := s.ExportPrefixList(&bytes.Buffer{}, 99, "PL", false); err == nil {
+		t.Errorf("ExportPrefixList(unknown format) returned nil error")
+	}
+}
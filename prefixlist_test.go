@@ -0,0 +1,80 @@
+package netipds
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestReadPrefixSet(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{"", pfxs(), false},
+		{"1.2.3.0/24\n", pfxs("1.2.3.0/24"), false},
+		{"# comment\n\n 1.2.3.0/24 # trailing\n::1\n", pfxs("::1/128", "1.2.3.0/24"), false},
+		{"1.2.3.4/24\n", pfxs("1.2.3.0/24"), false},
+		{"1.2.3.0/24\n1.2.3.0/25\n1.2.0.0/16", pfxs("1.2.0.0/16", "1.2.3.0/24", "1.2.3.0/25"), false},
+		{"1.2.3.0/24\nbogus\n", nil, true},
+		{"1.2.3.0/33\n", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ReadPrefixSet(strings.NewReader(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ReadPrefixSet(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			checkPrefixSlice(t, got.Prefixes(), tt.want)
+		}
+	}
+	if _, err := ReadPrefixSet(strings.NewReader("1.2.3.0/24\n\nbogus\n")); err == nil ||
+		!strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("ReadPrefixSet error = %v, want line 3", err)
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.n < len(b) {
+		n := w.n
+		w.n = 0
+		return n, errors.New("write failed")
+	}
+	w.n -= len(b)
+	return len(b), nil
+}
+
+func TestPrefixSetWriteTo(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.3.0/24", "::1/128", "10.0.0.0/8", "1.2.3.4/32") {
+		psb.Add(p)
+	}
+	s := psb.PrefixSet()
+
+	var buf bytes.Buffer
+	n, err := s.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "::1/128\n1.2.3.0/24\n1.2.3.4/32\n10.0.0.0/8\n"
+	if got := buf.String(); got != want || n != int64(len(want)) {
+		t.Errorf("WriteTo() wrote (%q, %d), want (%q, %d)", got, n, want, len(want))
+	}
+
+	// Round trip
+	got, err := ReadPrefixSet(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPrefixSlice(t, got.Prefixes(), s.Prefixes())
+
+	if _, err := s.WriteTo(&failingWriter{n: 5}); err == nil {
+		t.Errorf("WriteTo(failing writer) returned nil error")
+	}
+}
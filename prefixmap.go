@@ -3,6 +3,7 @@ package netipds
 import (
 	"fmt"
 	"net/netip"
+	"time"
 )
 
 // PrefixMapBuilder builds an immutable [PrefixMap].
@@ -16,9 +17,25 @@ import (
 // If Lazy == true, then path compression is delayed until a PrefixMap is
 // created. The builder itself remains uncompressed. Lazy mode can dramatically
 // reduce the time required to build a large PrefixMap.
+//
+// If TrackTimes == true, then the builder records the time at which each entry
+// was last set, and PrefixMaps created from it expose those times (see
+// [PrefixMap.GetWithTime]).
+//
+// PrefixMaps created from a builder have their own copies of its entries, but
+// values are copied as is, so pointers (and slices and maps) are shared with
+// the builder. To give PrefixMaps deep copies instead, so that modifying a
+// value in the builder cannot affect them, implement [Cloner] on T or set
+// CloneFunc. CloneFunc takes precedence.
 type PrefixMapBuilder[T any] struct {
-	Lazy bool
-	tree tree[T]
+	Lazy       bool
+	TrackTimes bool
+	CloneFunc  func(T) T
+	tree       tree[T]
+	lens       lenCounts
+	times      tree[time.Time]
+	// txn is the transaction in progress, if any
+	txn *txn[PrefixMapBuilder[T], T]
 }
 
 // Get returns the value associated with the exact Prefix provided, if any.
@@ -29,15 +46,38 @@ func (m *PrefixMapBuilder[T]) Get(p netip.Prefix) (T, bool) {
 // Set associates v with p.
 func (m *PrefixMapBuilder[T]) Set(p netip.Prefix, v T) error {
 	if !p.IsValid() {
-		return fmt.Errorf("Prefix is not valid: %v", p)
+		return invalidPrefixError(p)
+	}
+	m.set(keyFromPrefix(p), v)
+	m.touch(keyFromPrefix(p), time.Now())
+	return nil
+}
+
+// set associates v with k, leaving k's entry time unchanged. Callers must
+// update the entry time afterwards, so that the original can be restored by
+// Rollback.
+func (m *PrefixMapBuilder[T]) set(k key, v T) {
+	m.logKey(k)
+	if !m.tree.contains(k) {
+		m.lens.add(k, 1)
 	}
 	// TODO so should m.tree just be a *tree[T]?
 	if m.Lazy {
-		m.tree = *(m.tree.insertLazy(keyFromPrefix(p), v))
+		m.tree = *(m.tree.insertLazy(k, v))
 	} else {
-		m.tree = *(m.tree.insert(keyFromPrefix(p), v))
+		m.tree = *(m.tree.insert(k, v))
 	}
-	return nil
+}
+
+// Update sets the value associated with p to the result of fn, which is
+// called with p's current value and whether p has an entry in m. It can be
+// used to accumulate values when the same Prefix is seen repeatedly.
+func (m *PrefixMapBuilder[T]) Update(p netip.Prefix, fn func(old T, exists bool) T) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	old, ok := m.tree.get(keyFromPrefix(p))
+	return m.Set(p, fn(old, ok))
 }
 
 // Remove removes p from m. Only the exact Prefix provided is removed;
@@ -47,26 +87,294 @@ func (m *PrefixMapBuilder[T]) Set(p netip.Prefix, v T) error {
 // [PrefixMapBuilder.Filter].
 func (m *PrefixMapBuilder[T]) Remove(p netip.Prefix) error {
 	if !p.IsValid() {
-		return fmt.Errorf("Prefix is not valid: %v", p)
+		return invalidPrefixError(p)
 	}
-	m.tree.remove(keyFromPrefix(p))
+	m.remove(keyFromPrefix(p))
 	return nil
 }
 
+func (m *PrefixMapBuilder[T]) remove(k key) {
+	m.logKey(k)
+	if m.tree.contains(k) {
+		m.lens.add(k, -1)
+	}
+	m.tree.remove(k)
+	m.times.remove(k)
+}
+
 // Filter removes all Prefixes that are not encompassed by s from m.
 func (m *PrefixMapBuilder[T]) Filter(s *PrefixSet) {
+	m.snapshot()
 	m.tree.filter(&s.tree)
+	m.lens = countLens(&m.tree)
+}
+
+// IntersectFunc modifies m so that it contains the intersection of the
+// entries in m and o, using the same rules as [PrefixSetBuilder.Intersect]: to
+// be included in the result, a Prefix must either (a) exist in both maps or
+// (b) exist in one map and have an ancestor in the other.
+//
+// The value of each resulting entry is determined by keep, which is called
+// with the value from m and the value from o. When a Prefix exists in only one
+// map, the value of its longest-prefix ancestor in the other map is used. If
+// keep returns false, the Prefix is left out of the result.
+func (m *PrefixMapBuilder[T]) IntersectFunc(
+	o *PrefixMap[T],
+	keep func(a, b T) (T, bool),
+) {
+	m.snapshot()
+	now := time.Now()
+	ret := &tree[T]{}
+	add := func(k key, a, b T) {
+		v, ok := keep(a, b)
+		switch {
+		case !ok:
+			return
+		case m.Lazy:
+			ret = ret.insertLazy(k, v)
+		default:
+			ret = ret.insert(k, v)
+		}
+		m.touch(k, now)
+	}
+	m.tree.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry {
+			if _, b, ok := o.tree.parentOf(n.key, false); ok {
+				add(n.key.rooted(), n.value, b)
+			}
+		}
+		return false
+	})
+	o.tree.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry && !m.tree.contains(n.key) {
+			if _, a, ok := m.tree.parentOf(n.key, true); ok {
+				add(n.key.rooted(), a, n.value)
+			}
+		}
+		return false
+	})
+	m.tree = *ret
+	m.lens = countLens(&m.tree)
+	m.dropStaleTimes()
+}
+
+// IntersectWith modifies m so that it contains the intersection of the
+// entries in m and o, as in [PrefixMapBuilder.IntersectFunc]. The value of
+// each resulting entry is combine(a, b), where a is the value from m and b
+// is the value from o.
+func (m *PrefixMapBuilder[T]) IntersectWith(o *PrefixMap[T], combine func(a, b T) T) {
+	m.IntersectFunc(o, func(a, b T) (T, bool) {
+		return combine(a, b), true
+	})
+}
+
+// MergeWith modifies m so that it contains the union of the entries in m and
+// o. When a Prefix exists in both maps, its value becomes combine(a, b),
+// where a is the value from m and b is the value from o. Otherwise, its value
+// from whichever map contains it is kept.
+func (m *PrefixMapBuilder[T]) MergeWith(o *PrefixMap[T], combine func(a, b T) T) {
+	now := time.Now()
+	o.tree.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry {
+			k, v := n.key.rooted(), n.value
+			if a, ok := m.tree.get(k); ok {
+				v = combine(a, v)
+			}
+			m.set(k, v)
+			m.touch(k, now)
+		}
+		return false
+	})
+}
+
+// SubtractPrefix modifies m so that p and all of its descendants are removed.
+// Each ancestor entry of p is replaced by new entries covering the remaining
+// portions of its Prefix.
+//
+// The value of each new entry is determined by fn, which is called with the
+// new Prefix and the value of the entry it was split from. If fn returns
+// false, the new Prefix is left without an entry.
+//
+// For example, if m is {::0/126: "a"}, and we subtract ::0/128 with an fn that
+// returns its input value, then m will become {::1/128: "a", ::2/127: "a"}.
+//
+// An ancestor is only split as far as the next ancestor entry below it, so
+// the longest-prefix match of every address outside of p is preserved (unless
+// fn drops it).
+func (m *PrefixMapBuilder[T]) SubtractPrefix(
+	p netip.Prefix,
+	fn func(netip.Prefix, T) (T, bool),
+) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	m.snapshot()
+	m.tree = *m.tree.subtractKeyFunc(keyFromPrefix(p), m.touchFunc(prefixFunc(fn)))
+	m.lens = countLens(&m.tree)
+	m.dropStaleTimes()
+	return nil
+}
+
+// Subtract modifies m so that the Prefixes in s, and all of their
+// descendants, are removed from m. Affected ancestor entries are split as in
+// [PrefixMapBuilder.SubtractPrefix], with new values determined by fn.
+func (m *PrefixMapBuilder[T]) Subtract(
+	s *PrefixSet,
+	fn func(netip.Prefix, T) (T, bool),
+) {
+	m.snapshot()
+	keyFn := m.touchFunc(prefixFunc(fn))
+	s.tree.walk(key{}, func(n *tree[bool]) bool {
+		if n.hasEntry {
+			m.tree = *m.tree.subtractKeyFunc(n.key.rooted(), keyFn)
+			return true
+		}
+		return false
+	})
+	m.lens = countLens(&m.tree)
+	m.dropStaleTimes()
+}
+
+// Carve replaces the entry at e with entries covering the portions of e that
+// remain after p is removed from it. Each new entry receives e's value.
+// Entries other than e, including any entry at p and its descendants, are left
+// unchanged.
+//
+// For example, if m is {::0/126: "a"}, then carving ::0/128 out of ::0/126
+// makes m {::1/128: "a", ::2/127: "a"}.
+//
+// Carve returns an error wrapping [ErrNoEntry] if e has no entry in m, or
+// [ErrNotEncompassed] if e does not encompass p.
+func (m *PrefixMapBuilder[T]) Carve(e, p netip.Prefix) error {
+	if !e.IsValid() {
+		return invalidPrefixError(e)
+	}
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	eKey, pKey := keyFromPrefix(e), keyFromPrefix(p)
+	if !m.tree.contains(eKey) {
+		return &PrefixError{e, ErrNoEntry}
+	}
+	if !eKey.isPrefixOf(pKey, false) {
+		return fmt.Errorf("%w: %v does not encompass %v", ErrNotEncompassed, e, p)
+	}
+	m.snapshot()
+	m.tree = *m.tree.carve(eKey, pKey, m.touchFunc(func(_ key, v T) (T, bool) {
+		return v, true
+	}))
+	m.lens = countLens(&m.tree)
+	m.times.remove(eKey)
+	return nil
+}
+
+// CarveSet is like [PrefixMapBuilder.Carve], but also associates v with p,
+// creating an exception to e's value within e.
+func (m *PrefixMapBuilder[T]) CarveSet(e, p netip.Prefix, v T) error {
+	if err := m.Carve(e, p); err != nil {
+		return err
+	}
+	m.set(keyFromPrefix(p), v)
+	m.touch(keyFromPrefix(p), time.Now())
+	return nil
+}
+
+// prefixFunc adapts a callback on Prefixes to a callback on keys.
+func prefixFunc[T any](fn func(netip.Prefix, T) (T, bool)) func(key, T) (T, bool) {
+	return func(k key, v T) (T, bool) {
+		return fn(k.toPrefix(), v)
+	}
 }
 
 // PrefixMap returns an immutable PrefixMap representing the current state of m.
 //
 // The builder remains usable after calling PrefixMap.
 func (m *PrefixMapBuilder[T]) PrefixMap() *PrefixMap[T] {
-	t := m.tree.copy()
-	if m.Lazy && t != nil {
-		t = t.compress()
+	var t *tree[T]
+	if m.Lazy {
+		t = m.tree.compressedCopy()
+	} else {
+		t = m.tree.copy()
+	}
+	m.cloneValues(t)
+	return &PrefixMap[T]{*t, t.stats(), m.times.copy()}
+}
+
+// Cloner is implemented by values which can make deep copies of themselves.
+// See [PrefixMapBuilder].
+//
+// Clone is called with every value copied, including nil pointers.
+type Cloner[T any] interface {
+	Clone() T
+}
+
+// cloneValues replaces each value in t, which must be a copy of m's tree, with
+// a deep copy of it, if m has a way to make them.
+func (m *PrefixMapBuilder[T]) cloneValues(t *tree[T]) {
+	clone := m.CloneFunc
+	if clone == nil {
+		var zero T
+		if _, ok := any(zero).(Cloner[T]); !ok {
+			return
+		}
+		clone = func(v T) T { return any(v).(Cloner[T]).Clone() }
+	}
+	t.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry {
+			n.value = clone(n.value)
+		}
+		return false
+	})
+}
+
+// Compact removes nodes from m's tree which no longer lead to any Prefix, such
+// as those left behind by Remove, along with any entry times recorded for
+// Prefixes that are no longer in m. Long-lived builders whose entries change
+// over time can call it periodically to reclaim memory. If m is lazy, its tree
+// is not compressed.
+func (m *PrefixMapBuilder[T]) Compact() {
+	m.tree.prune(!m.Lazy)
+	m.dropStaleTimes()
+	m.times.prune(true)
+}
+
+// Compress performs path compression on m's tree, and removes nodes which no
+// longer lead to any Prefix. It is mainly useful when m is lazy: the time
+// saved by lazy insertion comes at the cost of memory, which Compress
+// reclaims. m remains lazy, and later insertions are not compressed.
+//
+// To compress only the part of the tree that has finished loading, see
+// [PrefixMapBuilder.CompressPrefix].
+func (m *PrefixMapBuilder[T]) Compress() {
+	m.tree.prune(true)
+}
+
+// CompressPrefix is like [PrefixMapBuilder.Compress], but only compresses
+// the part of m's tree beneath p, including p itself. When loading a large,
+// sorted feed into a lazy builder, calling CompressPrefix on each block of
+// address space once it has been loaded bounds the memory held by
+// uncompressed nodes.
+func (m *PrefixMapBuilder[T]) CompressPrefix(p netip.Prefix) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	m.tree.compressBelow(keyFromPrefix(p))
+	return nil
+}
+
+// clone returns a builder with the same settings and contents as m, which can
+// be modified without affecting m. Values are cloned as in PrefixMap.
+func (m *PrefixMapBuilder[T]) clone() *PrefixMapBuilder[T] {
+	ret := &PrefixMapBuilder[T]{
+		Lazy:       m.Lazy,
+		TrackTimes: m.TrackTimes,
+		CloneFunc:  m.CloneFunc,
+		tree:       *m.tree.copy(),
+		lens:       m.lens,
+		times:      *m.times.copy(),
 	}
-	return &PrefixMap[T]{*t, t.size()}
+	m.cloneValues(&ret.tree)
+	return ret
 }
 
 func (s *PrefixMapBuilder[T]) String() string {
@@ -78,8 +386,12 @@ func (s *PrefixMapBuilder[T]) String() string {
 //
 // Use [PrefixMapBuilder] to construct PrefixMaps.
 type PrefixMap[T any] struct {
-	tree tree[T]
-	size int
+	tree  tree[T]
+	stats prefixStats
+	// times holds the entry times recorded by the builder, if any. It may
+	// contain keys that m does not, so it must only be consulted for keys
+	// known to have entries in tree.
+	times *tree[time.Time]
 }
 
 // Get returns the value associated with the exact Prefix provided, if any.
@@ -105,6 +417,126 @@ func (m *PrefixMap[T]) EncompassesStrict(p netip.Prefix) bool {
 	return m.tree.encompasses(keyFromPrefix(p), true)
 }
 
+// EncompassesWithin is like [PrefixMap.Encompasses], but only considers
+// Prefixes in m whose lengths are between minBits and maxBits (inclusive).
+func (m *PrefixMap[T]) EncompassesWithin(p netip.Prefix, minBits, maxBits int) bool {
+	lo, hi := lenBounds(p, minBits, maxBits)
+	return m.tree.encompassesWithin(keyFromPrefix(p), lo, hi)
+}
+
+// LookupAddr returns the value associated with the longest Prefix in m which
+// contains a, if any.
+//
+// LookupAddr does not allocate.
+func (m *PrefixMap[T]) LookupAddr(a netip.Addr) (val T, ok bool) {
+	_, val, ok = m.Lookup(a)
+	return
+}
+
+// Lookup returns the longest Prefix in m which contains a, along with its
+// value, if any. a's zone, if any, is ignored.
+//
+// Lookup does not allocate.
+func (m *PrefixMap[T]) Lookup(a netip.Addr) (p netip.Prefix, val T, ok bool) {
+	if !a.IsValid() {
+		return p, val, false
+	}
+	if n := m.tree.longestMatch(keyFromAddr(a)); n != nil {
+		return n.key.toPrefix(), n.value, true
+	}
+	return p, val, false
+}
+
+// Match describes the result of [PrefixMap.LookupDetail].
+type Match[T any] struct {
+	// Prefix is the longest Prefix in the map containing the address.
+	Prefix netip.Prefix
+	// Value is the value associated with Prefix.
+	Value T
+	// Depth is the number of Prefixes in the map that are ancestors of
+	// Prefix, and therefore also contain the address.
+	Depth int
+	// MoreSpecifics is true if the map has Prefixes that are descendants of
+	// Prefix, none of which contain the address.
+	MoreSpecifics bool
+}
+
+// LookupDetail is like [PrefixMap.Lookup], but describes the match in more
+// detail. a's zone, if any, is ignored.
+func (m *PrefixMap[T]) LookupDetail(a netip.Addr) (Match[T], bool) {
+	var match Match[T]
+	if !a.IsValid() {
+		return match, false
+	}
+	k := keyFromAddr(a)
+	var found *tree[T]
+	depth := 0
+	for n := m.tree.pathNext(k); n != nil && n.key.isPrefixOf(k, false); n = n.pathNext(k) {
+		if n.hasEntry {
+			if found != nil {
+				depth++
+			}
+			found = n
+		}
+	}
+	if found == nil {
+		return match, false
+	}
+	match.Prefix, match.Value, match.Depth = found.key.toPrefix(), found.value, depth
+	for _, c := range []*tree[T]{found.left, found.right} {
+		if c != nil && newEntryIter(c).next() != nil {
+			match.MoreSpecifics = true
+		}
+	}
+	return match, true
+}
+
+// PrefixEntry is a Prefix and its associated value.
+type PrefixEntry[T any] struct {
+	Prefix netip.Prefix
+	Value  T
+}
+
+// LookupAll returns every entry in m whose Prefix contains a, ordered from
+// the shortest Prefix to the longest. a's zone, if any, is ignored.
+func (m *PrefixMap[T]) LookupAll(a netip.Addr) []PrefixEntry[T] {
+	if !a.IsValid() {
+		return nil
+	}
+	var ret []PrefixEntry[T]
+	k := keyFromAddr(a)
+	for n := m.tree.pathNext(k); n != nil && n.key.isPrefixOf(k, false); n = n.pathNext(k) {
+		if n.hasEntry {
+			ret = append(ret, PrefixEntry[T]{n.key.toPrefix(), n.value})
+		}
+	}
+	return ret
+}
+
+// LookupN returns up to n entries in m whose Prefixes contain a, ordered from
+// the longest Prefix to the shortest. a's zone, if any, is ignored.
+func (m *PrefixMap[T]) LookupN(a netip.Addr, n int) []PrefixEntry[T] {
+	if !a.IsValid() || n <= 0 {
+		return nil
+	}
+	// Matches are found from shortest to longest, so keep the last n.
+	var last []*tree[T]
+	k := keyFromAddr(a)
+	for t := m.tree.pathNext(k); t != nil && t.key.isPrefixOf(k, false); t = t.pathNext(k) {
+		if t.hasEntry {
+			if len(last) == n {
+				last = append(last[:0], last[1:]...)
+			}
+			last = append(last, t)
+		}
+	}
+	ret := make([]PrefixEntry[T], len(last))
+	for i, t := range last {
+		ret[len(last)-1-i] = PrefixEntry[T]{t.key.toPrefix(), t.value}
+	}
+	return ret
+}
+
 // OverlapsPrefix returns true if this map includes a Prefix which overlaps p.
 func (m *PrefixMap[T]) OverlapsPrefix(p netip.Prefix) bool {
 	return m.tree.overlapsKey(keyFromPrefix(p))
@@ -157,6 +589,117 @@ func (m *PrefixMap[T]) ParentOfStrict(p netip.Prefix) (netip.Prefix, T, bool) {
 	return m.parentOf(p, true)
 }
 
+// Roots returns the entries in m whose Prefixes have no ancestor in m, in
+// order.
+func (m *PrefixMap[T]) Roots() []PrefixEntry[T] {
+	var res []PrefixEntry[T]
+	m.tree.eachRoot(func(n *tree[T]) bool {
+		res = append(res, PrefixEntry[T]{n.key.toPrefix(), n.value})
+		return true
+	})
+	return res
+}
+
+// Leaves returns the entries in m whose Prefixes have no descendants in m, in
+// order.
+func (m *PrefixMap[T]) Leaves() []PrefixEntry[T] {
+	var res []PrefixEntry[T]
+	m.tree.eachLeaf(func(n *tree[T]) bool {
+		res = append(res, PrefixEntry[T]{n.key.toPrefix(), n.value})
+		return true
+	})
+	return res
+}
+
+// Shadowed returns the Prefixes in m which can never be the longest match for
+// any address, because their descendants in m together cover every address
+// they do, in order. Such entries have no effect on [PrefixMap.Lookup].
+//
+// For example, in a map of 10.0.0.0/23, 10.0.0.0/24 and 10.0.1.0/24, the
+// entry for 10.0.0.0/23 is shadowed.
+func (m *PrefixMap[T]) Shadowed() []netip.Prefix {
+	keys := m.tree.shadowedKeys()
+	res := make([]netip.Prefix, len(keys))
+	for i, k := range keys {
+		res[i] = k.toPrefix()
+	}
+	return res
+}
+
+// Conflict is a pair of entries in a PrefixMap, one an ancestor of the other,
+// whose values disagree. See [PrefixMap.Conflicts].
+type Conflict[T any] struct {
+	Ancestor, Descendant PrefixEntry[T]
+}
+
+// Conflicts returns every pair of entries in m where one entry's Prefix is an
+// ancestor of the other's and eq reports that their values disagree. The
+// pairs are ordered by descendant, in order, and then by ancestor, from the
+// shortest Prefix to the longest.
+//
+// For example, in a firewall policy mapping 10.0.0.0/8 to "deny" and
+// 10.1.0.0/16 to "allow", the two entries conflict.
+func (m *PrefixMap[T]) Conflicts(eq func(a, b T) bool) []Conflict[T] {
+	var res []Conflict[T]
+	var ancestors []*tree[T]
+	m.tree.walk(key{}, func(n *tree[T]) bool {
+		if !n.hasEntry {
+			return false
+		}
+		for len(ancestors) > 0 && !ancestors[len(ancestors)-1].key.isPrefixOf(n.key, true) {
+			ancestors = ancestors[:len(ancestors)-1]
+		}
+		for _, a := range ancestors {
+			if !eq(a.value, n.value) {
+				res = append(res, Conflict[T]{
+					PrefixEntry[T]{a.key.toPrefix(), a.value},
+					PrefixEntry[T]{n.key.toPrefix(), n.value},
+				})
+			}
+		}
+		ancestors = append(ancestors, n)
+		return false
+	})
+	return res
+}
+
+// Rollup returns a map of each Prefix of length level that contains entries
+// in m to the result of calling agg with the values of those entries, in
+// order. For example, with agg summing its arguments, Rollup(16, agg) totals
+// the values in each /16. level applies to IPv4 and IPv6 Prefixes alike.
+//
+// Entries whose Prefixes are shorter than level are omitted, since they don't
+// belong to any one Prefix of that length.
+func (m *PrefixMap[T]) Rollup(level int, agg func([]T) T) *PrefixMap[T] {
+	var groups []key
+	values := make(map[key][]T)
+	if level >= 0 && level <= 128 {
+		m.tree.walk(key{}, func(n *tree[T]) bool {
+			if !n.hasEntry {
+				return false
+			}
+			l := level
+			if n.key.is4() {
+				l += 96
+			}
+			if int(n.key.len) < l {
+				return false
+			}
+			g := n.key.truncated(uint8(l)).rooted()
+			if _, ok := values[g]; !ok {
+				groups = append(groups, g)
+			}
+			values[g] = append(values[g], n.value)
+			return false
+		})
+	}
+	t := &tree[T]{}
+	for _, g := range groups {
+		t = t.insert(g, agg(values[g]))
+	}
+	return &PrefixMap[T]{*t, t.stats(), nil}
+}
+
 // ToMap returns a map of all Prefixes in m to their associated values.
 func (m *PrefixMap[T]) ToMap() map[netip.Prefix]T {
 	res := make(map[netip.Prefix]T)
@@ -173,35 +716,86 @@ func (m *PrefixMap[T]) ToMap() map[netip.Prefix]T {
 // including p itself if it has an entry.
 func (m *PrefixMap[T]) DescendantsOf(p netip.Prefix) *PrefixMap[T] {
 	t := m.tree.descendantsOf(keyFromPrefix(p), false)
-	return &PrefixMap[T]{*t, t.size()}
+	return &PrefixMap[T]{*t, t.stats(), m.times}
 }
 
 // DescendantsOfStrict returns a PrefixMap containing all descendants of p in
 // m, excluding p itself.
 func (m *PrefixMap[T]) DescendantsOfStrict(p netip.Prefix) *PrefixMap[T] {
 	t := m.tree.descendantsOf(keyFromPrefix(p), true)
-	return &PrefixMap[T]{*t, t.size()}
+	return &PrefixMap[T]{*t, t.stats(), m.times}
+}
+
+// DescendantsOfWithin returns a PrefixMap containing the descendants of p in
+// m, including p itself, whose lengths are between minBits and maxBits
+// (inclusive).
+func (m *PrefixMap[T]) DescendantsOfWithin(p netip.Prefix, minBits, maxBits int) *PrefixMap[T] {
+	lo, hi := lenBounds(p, minBits, maxBits)
+	t := m.tree.descendantsWithin(keyFromPrefix(p), lo, hi)
+	return &PrefixMap[T]{*t, t.stats(), m.times}
 }
 
 // AncestorsOf returns a PrefixMap containing all ancestors of p in m,
 // including p itself if it has an entry.
 func (m *PrefixMap[T]) AncestorsOf(p netip.Prefix) *PrefixMap[T] {
 	t := m.tree.ancestorsOf(keyFromPrefix(p), false)
-	return &PrefixMap[T]{*t, t.size()}
+	return &PrefixMap[T]{*t, t.stats(), m.times}
 }
 
 // AncestorsOfStrict returns a PrefixMap containing all ancestors of p in m,
 // excluding p itself.
 func (m *PrefixMap[T]) AncestorsOfStrict(p netip.Prefix) *PrefixMap[T] {
 	t := m.tree.ancestorsOf(keyFromPrefix(p), true)
-	return &PrefixMap[T]{*t, t.size()}
+	return &PrefixMap[T]{*t, t.stats(), m.times}
 }
 
 // Filter returns a new PrefixMap containing the entries of m that are
-// encompassed by s.
+// encompassed by s. It is equivalent to [PrefixMap.FilteredBy].
 func (m *PrefixMap[T]) Filter(s *PrefixSet) *PrefixMap[T] {
+	return m.FilteredBy(s)
+}
+
+// FilteredBy returns a new PrefixMap containing the entries of m that are
+// encompassed by s. m and s are walked together, so FilteredBy takes time
+// linear in their sizes.
+func (m *PrefixMap[T]) FilteredBy(s *PrefixSet) *PrefixMap[T] {
 	t := m.tree.filterCopy(&s.tree)
-	return &PrefixMap[T]{*t, t.size()}
+	return &PrefixMap[T]{*t, t.stats(), m.times}
+}
+
+// KeySet returns a PrefixSet containing the Prefixes in m.
+//
+// The result shares no memory with m, and can be passed to PrefixSetBuilder
+// methods like [PrefixSetBuilder.Subtract] and [PrefixSetBuilder.Intersect]
+// to combine sets with the key space of m.
+func (m *PrefixMap[T]) KeySet() *PrefixSet {
+	t := mapTree(&m.tree, func(key, T) bool { return true })
+	return &PrefixSet{*t, m.stats}
+}
+
+// MapValues returns a new PrefixMap with the same Prefixes as m, in which each
+// value v for Prefix p has been replaced by fn(p, v). The tree of m is copied
+// node for node rather than being rebuilt.
+func MapValues[T, U any](m *PrefixMap[T], fn func(netip.Prefix, T) U) *PrefixMap[U] {
+	t := mapTree(&m.tree, func(k key, v T) U { return fn(k.toPrefix(), v) })
+	return &PrefixMap[U]{*t, m.stats, m.times}
+}
+
+// Fold calls fn for each entry in m in sorted order, passing the result of
+// the previous call as acc, and returns the result of the last call. The
+// first call receives init. Fold returns init if m is empty.
+//
+// Fold is a function rather than a method because methods cannot have type
+// parameters.
+func Fold[T, A any](m *PrefixMap[T], init A, fn func(acc A, p netip.Prefix, v T) A) A {
+	acc := init
+	m.tree.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry {
+			acc = fn(acc, n.key.toPrefix(), n.value)
+		}
+		return false
+	})
+	return acc
 }
 
 // String returns a human-readable representation of m's tree structure.
@@ -211,5 +805,5 @@ func (m *PrefixMap[T]) String() string {
 
 // Size returns the number of entries in m.
 func (m *PrefixMap[T]) Size() int {
-	return m.size
+	return m.stats.size()
 }
@@ -0,0 +1,237 @@
+package netipds
+
+import (
+	"net/netip"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentPrefixMap is a PrefixMap which can be read and written
+// concurrently. Reads use an immutable PrefixMap which is published
+// atomically after each batch of writes, so they never block and never see a
+// partially applied batch. Writes are serialized.
+//
+// Each batch of writes copies the map to publish it, so writes should be
+// grouped into batches with [ConcurrentPrefixMap.Update] where possible.
+//
+// If T implements [Cloner], published maps hold deep copies of the values,
+// so later batches never modify them.
+//
+// Subscribers registered with [ConcurrentPrefixMap.Subscribe] are notified of
+// the changes made by each batch, so that copies of the map held elsewhere
+// can be kept in sync.
+//
+// The zero value is a valid, empty ConcurrentPrefixMap. A ConcurrentPrefixMap
+// must not be copied after first use.
+type ConcurrentPrefixMap[T any] struct {
+	// Equal reports whether two values are equal, to determine which entries
+	// were changed by a batch. If nil, values are compared with
+	// [reflect.DeepEqual].
+	Equal func(a, b T) bool
+
+	current atomic.Pointer[PrefixMap[T]]
+
+	mu sync.Mutex
+	b  PrefixMapBuilder[T]
+
+	subsMu sync.Mutex
+	subs   []*mapSubscriber[T]
+}
+
+// mapSubscriber is a callback registered with ConcurrentPrefixMap.Subscribe.
+// It is referred to by pointer, so that it can be found to be removed.
+type mapSubscriber[T any] struct {
+	fn func(*PrefixMap[T], *Patch[T])
+}
+
+// Load returns the most recently published PrefixMap. Load is safe for
+// concurrent use and does not block.
+func (c *ConcurrentPrefixMap[T]) Load() *PrefixMap[T] {
+	if m := c.current.Load(); m != nil {
+		return m
+	}
+	return &PrefixMap[T]{}
+}
+
+// Get returns the value associated with the exact Prefix provided, if any, in
+// the most recently published PrefixMap.
+func (c *ConcurrentPrefixMap[T]) Get(p netip.Prefix) (T, bool) {
+	return c.Load().Get(p)
+}
+
+// Lookup returns the longest Prefix containing a, and its value, in the most
+// recently published PrefixMap. a's zone, if any, is ignored.
+func (c *ConcurrentPrefixMap[T]) Lookup(a netip.Addr) (netip.Prefix, T, bool) {
+	return c.Load().Lookup(a)
+}
+
+// Update calls fn with a builder holding the current contents of c. When fn
+// returns, the builder's contents are published as a single change. If fn
+// returns an error, its changes are discarded and the error is returned.
+// Values which fn modifies in place, rather than through the builder, are not
+// restored.
+//
+// fn must not retain the builder or call c's write methods.
+func (c *ConcurrentPrefixMap[T]) Update(fn func(*PrefixMapBuilder[T]) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// fn works on c.b directly, and the transaction both records the changes
+	// for subscribers and undoes them if fn fails. Only publishing copies c.b.
+	b := &c.b
+	b.Begin()
+	x := b.txn
+	if err := fn(b); err != nil {
+		if b.txn == x {
+			b.Rollback()
+		} else {
+			// fn ended the transaction, so start over from the published map
+			c.b = c.builderFrom(c.Load())
+		}
+		return err
+	}
+	// If fn ended the transaction, it may not have recorded every change
+	logged := b.txn == x && x.snapshot == nil
+	b.Commit()
+	old := c.Load()
+	m := c.b.PrefixMap()
+	c.current.Store(m)
+	if subs := c.subscribers(); len(subs) > 0 {
+		var changes *Patch[T]
+		if logged {
+			changes = c.loggedChanges(x.log)
+		} else {
+			changes = NewPatch(old, m, c.equal())
+		}
+		notify(subs, m, changes)
+	}
+	return nil
+}
+
+// Store replaces the contents of c with m, and publishes m as is.
+func (c *ConcurrentPrefixMap[T]) Store(m *PrefixMap[T]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.Load()
+	c.b = c.builderFrom(m)
+	c.current.Store(m)
+	if subs := c.subscribers(); len(subs) > 0 {
+		notify(subs, m, NewPatch(old, m, c.equal()))
+	}
+}
+
+// builderFrom returns a builder with c.b's settings holding the contents of m.
+// m is published as is, so the builder gets its own copies of m's values.
+func (c *ConcurrentPrefixMap[T]) builderFrom(m *PrefixMap[T]) PrefixMapBuilder[T] {
+	b := PrefixMapBuilder[T]{
+		Lazy:       c.b.Lazy,
+		TrackTimes: c.b.TrackTimes,
+		CloneFunc:  c.b.CloneFunc,
+		tree:       *m.tree.copy(),
+		lens:       countLens(&m.tree),
+	}
+	b.cloneValues(&b.tree)
+	if m.times != nil {
+		b.times = *m.times.copy()
+	}
+	return b
+}
+
+// Set associates v with p and publishes the change.
+func (c *ConcurrentPrefixMap[T]) Set(p netip.Prefix, v T) error {
+	return c.Update(func(b *PrefixMapBuilder[T]) error {
+		return b.Set(p, v)
+	})
+}
+
+// Remove removes p and publishes the change.
+func (c *ConcurrentPrefixMap[T]) Remove(p netip.Prefix) error {
+	return c.Update(func(b *PrefixMapBuilder[T]) error {
+		return b.Remove(p)
+	})
+}
+
+// Subscribe registers fn to be called with each PrefixMap published after
+// Subscribe returns, together with the changes made to the previously
+// published PrefixMap. Batches which change nothing are not reported.
+//
+// Subscribers are called in the order they were registered, while c's writes
+// are blocked, so each sees every change exactly once, in order. fn must not
+// call c's write methods, and should return quickly, for example by handing
+// the changes off to a channel.
+//
+// Subscribe returns a function which cancels the subscription. It may be
+// called from within fn.
+//
+// To find which entries changed, each batch's changes are recorded as it is
+// made. Batches which use [PrefixMapBuilder] methods other than those which
+// set or remove one Prefix at a time (see [PrefixMapBuilder.Begin]), and
+// calls to Store, are compared with the previous PrefixMap in full instead.
+func (c *ConcurrentPrefixMap[T]) Subscribe(fn func(published *PrefixMap[T], changes *Patch[T])) (cancel func()) {
+	sub := &mapSubscriber[T]{fn}
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	c.subs = append(c.subs, sub)
+	return func() {
+		c.subsMu.Lock()
+		defer c.subsMu.Unlock()
+		c.subs = slices.DeleteFunc(c.subs, func(s *mapSubscriber[T]) bool {
+			return s == sub
+		})
+	}
+}
+
+// subscribers returns the current subscribers of c.
+func (c *ConcurrentPrefixMap[T]) subscribers() []*mapSubscriber[T] {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return slices.Clone(c.subs)
+}
+
+func (c *ConcurrentPrefixMap[T]) equal() func(a, b T) bool {
+	if c.Equal != nil {
+		return c.Equal
+	}
+	return func(a, b T) bool { return reflect.DeepEqual(a, b) }
+}
+
+// loggedChanges returns the changes made to c.b by the transaction whose log
+// is provided, which must have recorded every change.
+func (c *ConcurrentPrefixMap[T]) loggedChanges(log []txnEntry[T]) *Patch[T] {
+	// The first entry for each key holds its state before the transaction
+	before := make(map[key]txnEntry[T], len(log))
+	keys := make([]key, 0, len(log))
+	for _, e := range log {
+		if _, ok := before[e.k]; !ok {
+			before[e.k] = e
+			keys = append(keys, e.k)
+		}
+	}
+	slices.SortFunc(keys, key.compare)
+	eq := c.equal()
+	p := &Patch[T]{}
+	for _, k := range keys {
+		old := before[k]
+		v, ok := c.b.tree.get(k)
+		switch {
+		case ok && !old.ok:
+			p.Added = append(p.Added, PrefixEntry[T]{k.toPrefix(), v})
+		case !ok && old.ok:
+			p.Removed = append(p.Removed, k.toPrefix())
+		case ok && !eq(old.v, v):
+			p.Changed = append(p.Changed, PrefixEntry[T]{k.toPrefix(), v})
+		}
+	}
+	return p
+}
+
+// notify calls each of subs with m and changes, unless changes is empty.
+func notify[T any](subs []*mapSubscriber[T], m *PrefixMap[T], changes *Patch[T]) {
+	if changes.IsEmpty() {
+		return
+	}
+	for _, sub := range subs {
+		sub.fn(m, changes)
+	}
+}
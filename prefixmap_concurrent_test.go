@@ -0,0 +1,201 @@
+package netipds
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentPrefixMap(t *testing.T) {
+	var c ConcurrentPrefixMap[int]
+	if got := c.Load().Size(); got != 0 {
+		t.Errorf("zero value Size() = %d, want 0", got)
+	}
+	if _, ok := c.Get(pfx("1.2.3.0/24")); ok {
+		t.Errorf("zero value Get() found an entry")
+	}
+
+	c.Set(pfx("1.2.0.0/16"), 1)
+	before := c.Load()
+	err := c.Update(func(b *PrefixMapBuilder[int]) error {
+		b.Set(pfx("1.2.3.0/24"), 2)
+		b.Set(pfx("2001:db8::/32"), 3)
+		return b.Remove(pfx("1.2.0.0/16"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.2.3.0/24"):    2,
+		pfx("2001:db8::/32"): 3,
+	}, c.Load().ToMap())
+	// Previously loaded maps are unaffected
+	checkMap(t, map[netip.Prefix]int{pfx("1.2.0.0/16"): 1}, before.ToMap())
+
+	// Failed batches are discarded entirely
+	errFail := errors.New("fail")
+	err = c.Update(func(b *PrefixMapBuilder[int]) error {
+		b.Set(pfx("10.0.0.0/8"), 4)
+		return errFail
+	})
+	if err != errFail {
+		t.Errorf("Update() = %v, want %v", err, errFail)
+	}
+	// Including those that rewrite the tree or end the transaction themselves
+	c.Update(func(b *PrefixMapBuilder[int]) error {
+		b.Filter(&PrefixSet{})
+		return errFail
+	})
+	c.Update(func(b *PrefixMapBuilder[int]) error {
+		b.Remove(pfx("1.2.3.0/24"))
+		b.Commit()
+		b.Set(pfx("10.0.0.0/8"), 4)
+		return errFail
+	})
+	if err = c.Set(netip.Prefix{}, 5); err == nil {
+		t.Errorf("Set(invalid) returned nil error")
+	}
+	c.Set(pfx("1.2.3.4/32"), 6)
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.2.3.0/24"):    2,
+		pfx("1.2.3.4/32"):    6,
+		pfx("2001:db8::/32"): 3,
+	}, c.Load().ToMap())
+	if p, v, ok := c.Lookup(netip.MustParseAddr("1.2.3.5")); p != pfx("1.2.3.0/24") || v != 2 || !ok {
+		t.Errorf("Lookup(1.2.3.5) = (%v, %v, %v), want (1.2.3.0/24, 2, true)", p, v, ok)
+	}
+}
+
+func TestConcurrentPrefixMapStore(t *testing.T) {
+	var c ConcurrentPrefixMap[int]
+	c.Set(pfx("10.0.0.0/8"), 1)
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("192.168.0.0/16"), 2)
+	stored := pmb.PrefixMap()
+	c.Store(stored)
+	if c.Load() != stored {
+		t.Errorf("Load() after Store returned a different map")
+	}
+	c.Set(pfx("172.16.0.0/12"), 3)
+	checkMap(t, map[netip.Prefix]int{
+		pfx("172.16.0.0/12"):  3,
+		pfx("192.168.0.0/16"): 2,
+	}, c.Load().ToMap())
+	checkMap(t, map[netip.Prefix]int{pfx("192.168.0.0/16"): 2}, stored.ToMap())
+	if got := c.Load().Size(); got != 2 {
+		t.Errorf("Size() = %d, want 2", got)
+	}
+}
+
+func TestConcurrentPrefixMapSubscribe(t *testing.T) {
+	var c ConcurrentPrefixMap[int]
+	c.Set(pfx("10.0.0.0/8"), 1)
+	c.Set(pfx("192.168.0.0/16"), 2)
+
+	// Replaying the changes onto a copy of the map keeps it in sync
+	var got []*Patch[int]
+	replica := &PrefixMapBuilder[int]{}
+	replica.MergeWith(c.Load(), nil)
+	cancel := c.Subscribe(func(m *PrefixMap[int], p *Patch[int]) {
+		got = append(got, p)
+		p.Apply(replica)
+		if !replica.PrefixMap().Equal(m, func(a, b int) bool { return a == b }) {
+			t.Errorf("replica = %v, want %v", replica.PrefixMap().ToMap(), m.ToMap())
+		}
+	})
+
+	c.Update(func(b *PrefixMapBuilder[int]) error {
+		b.Set(pfx("1.2.3.0/24"), 3)
+		b.Set(pfx("10.0.0.0/8"), 4)
+		b.Set(pfx("192.168.0.0/16"), 2)
+		b.Remove(pfx("10.0.0.0/8"))
+		b.Set(pfx("10.0.0.0/8"), 5)
+		b.Set(pfx("2001:db8::/32"), 6)
+		return b.Remove(pfx("2001:db8::/32"))
+	})
+	// No changes, and failed batches, aren't reported
+	c.Set(pfx("1.2.3.0/24"), 3)
+	c.Update(func(b *PrefixMapBuilder[int]) error {
+		b.Set(pfx("1.2.3.0/24"), 7)
+		return errors.New("fail")
+	})
+	// Bulk operations and Store are compared in full
+	c.Update(func(b *PrefixMapBuilder[int]) error {
+		b.Set(pfx("172.16.0.0/12"), 8)
+		return b.SubtractPrefix(pfx("192.168.1.0/24"), func(_ netip.Prefix, v int) (int, bool) {
+			return v, true
+		})
+	})
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("10.0.0.0/8"), 9)
+	c.Store(pmb.PrefixMap())
+
+	want := []*Patch[int]{
+		{
+			Added:   []PrefixEntry[int]{{pfx("1.2.3.0/24"), 3}},
+			Changed: []PrefixEntry[int]{{pfx("10.0.0.0/8"), 5}},
+		},
+		{
+			Added: []PrefixEntry[int]{
+				{pfx("172.16.0.0/12"), 8},
+				{pfx("192.168.0.0/24"), 2},
+				{pfx("192.168.2.0/23"), 2},
+				{pfx("192.168.4.0/22"), 2},
+				{pfx("192.168.8.0/21"), 2},
+				{pfx("192.168.16.0/20"), 2},
+				{pfx("192.168.32.0/19"), 2},
+				{pfx("192.168.64.0/18"), 2},
+				{pfx("192.168.128.0/17"), 2},
+			},
+			Removed: pfxs("192.168.0.0/16"),
+		},
+		{
+			Removed: pfxs("1.2.3.0/24", "172.16.0.0/12", "192.168.0.0/24", "192.168.2.0/23",
+				"192.168.4.0/22", "192.168.8.0/21", "192.168.16.0/20", "192.168.32.0/19",
+				"192.168.64.0/18", "192.168.128.0/17"),
+			Changed: []PrefixEntry[int]{{pfx("10.0.0.0/8"), 9}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes:")
+		for _, p := range got {
+			t.Errorf("  %+v", *p)
+		}
+		t.Errorf("want:")
+		for _, p := range want {
+			t.Errorf("  %+v", *p)
+		}
+	}
+
+	cancel()
+	c.Set(pfx("1.0.0.0/8"), 10)
+	if len(got) != len(want) {
+		t.Errorf("subscriber called after cancel")
+	}
+}
+
+func TestConcurrentPrefixMapRace(t *testing.T) {
+	var c ConcurrentPrefixMap[int]
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Set(netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i), byte(j), 0}), 24), j)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Lookup(netip.MustParseAddr("10.1.2.3"))
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.Load().Size(); got != 200 {
+		t.Errorf("Size() = %d, want 200", got)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build go1.23
+
+package netipds
+
+import (
+	"iter"
+	"net/netip"
+)
+
+// DescendantsOfIter returns an iterator over the descendants of p in m and
+// their values, including p itself if it has an entry. Unlike
+// [PrefixMap.DescendantsOf], it visits the nodes of m in place instead of
+// building a new PrefixMap.
+func (m *PrefixMap[T]) DescendantsOfIter(p netip.Prefix) iter.Seq2[netip.Prefix, T] {
+	return func(yield func(netip.Prefix, T) bool) {
+		m.tree.eachDescendant(keyFromPrefix(p), func(n *tree[T]) bool {
+			return yield(n.key.toPrefix(), n.value)
+		})
+	}
+}
+
+// RootsIter returns an iterator over the entries in m whose Prefixes have no
+// ancestor in m. Unlike [PrefixMap.Roots], it does not allocate a slice.
+func (m *PrefixMap[T]) RootsIter() iter.Seq2[netip.Prefix, T] {
+	return func(yield func(netip.Prefix, T) bool) {
+		m.tree.eachRoot(func(n *tree[T]) bool {
+			return yield(n.key.toPrefix(), n.value)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+//go:build go1.23
+
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixMapDescendantsOfIter(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("1.0.0.0/8"), 1)
+	pmb.Set(pfx("1.2.0.0/16"), 2)
+	pmb.Set(pfx("1.2.3.0/24"), 3)
+	pmb.Set(pfx("1.3.0.0/16"), 4)
+	pm := pmb.PrefixMap()
+
+	got := make(map[netip.Prefix]int)
+	for p, v := range pm.DescendantsOfIter(pfx("1.2.0.0/15")) {
+		got[p] = v
+	}
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.2.0.0/16"): 2,
+		pfx("1.2.3.0/24"): 3,
+		pfx("1.3.0.0/16"): 4,
+	}, got)
+
+	var i int
+	for range pm.DescendantsOfIter(pfx("1.0.0.0/8")) {
+		i++
+		break
+	}
+	if i > 1 {
+		t.Fatal("iteration continued after yield returned false")
+	}
+}
+
+func TestPrefixMapRootsIter(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("1.0.0.0/8"), 1)
+	pmb.Set(pfx("1.2.0.0/16"), 2)
+	pmb.Set(pfx("2.0.0.0/16"), 3)
+	pm := pmb.PrefixMap()
+
+	got := make(map[netip.Prefix]int)
+	for p, v := range pm.RootsIter() {
+		got[p] = v
+	}
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.0.0.0/8"):  1,
+		pfx("2.0.0.0/16"): 3,
+	}, got)
+
+	var i int
+	for range pm.RootsIter() {
+		i++
+		break
+	}
+	if i > 1 {
+		t.Fatal("iteration continued after yield returned false")
+	}
+}
@@ -684,3 +684,56 @@ func TestOverlapsPrefixLazy(t *testing.T) {
 		}
 	}
 }
+
+func TestPrefixMapBuilderLazyMatchesEager(t *testing.T) {
+	keep := func(_ netip.Prefix, v int) (int, bool) { return v, true }
+	build := func(lazy bool) *PrefixMapBuilder[int] {
+		pmb := &PrefixMapBuilder[int]{Lazy: lazy}
+		pmb.Set(pfx("10.0.0.0/8"), 1)
+		pmb.Set(pfx("10.1.2.0/24"), 2)
+		pmb.Set(pfx("2001:db8::/32"), 3)
+		pmb.Set(pfx("2001:db8:1::/48"), 4)
+		// Subtraction and carving leave compressed nodes in lazy builders
+		pmb.SubtractPrefix(pfx("10.1.0.0/16"), keep)
+		pmb.Carve(pfx("2001:db8::/32"), pfx("2001:db8:ff00::/40"))
+		// Inserts beneath, above and beside the compressed nodes
+		pmb.Set(pfx("10.200.3.0/24"), 5)
+		pmb.Set(pfx("10.1.3.0/24"), 6)
+		pmb.Set(pfx("10.128.0.0/9"), 7)
+		pmb.Set(pfx("10.128.0.0/10"), 8)
+		pmb.Set(pfx("2001:db8:ff00::/44"), 9)
+		pmb.Set(pfx("2001:db8:8000::/33"), 10)
+		pmb.Remove(pfx("2001:db8:1::/48"))
+		return pmb
+	}
+	eager, lazy := build(false).PrefixMap(), build(true).PrefixMap()
+	checkMap(t, eager.ToMap(), lazy.ToMap())
+	if eager.Size() != lazy.Size() {
+		t.Errorf("lazy Size() = %d, want %d", lazy.Size(), eager.Size())
+	}
+	if _, v, _ := lazy.Lookup(netip.MustParseAddr("10.1.3.1")); v != 6 {
+		t.Errorf("lazy Lookup(10.1.3.1) = %d, want 6", v)
+	}
+	// Compressing the lazy tree yields the same shape as building eagerly
+	if got, want := lazy.Stats().Nodes, eager.Stats().Nodes; got != want {
+		t.Errorf("lazy map has %d nodes, want %d", got, want)
+	}
+}
+
+func TestPrefixMapBuilderCompress(t *testing.T) {
+	eager := &PrefixMapBuilder[int]{}
+	pmb := &PrefixMapBuilder[int]{Lazy: true}
+	for i, p := range pfxs("10.1.0.0/16", "10.2.0.0/16", "10.2.3.0/24", "2001:db8::/32") {
+		eager.Set(p, i)
+		pmb.Set(p, i)
+	}
+	pmb.CompressPrefix(pfx("10.0.0.0/8"))
+	lazyNodes := pmb.Stats().Nodes
+	pmb.Compress()
+	if got, want := pmb.Stats().Nodes, eager.Stats().Nodes; got != want || got >= lazyNodes {
+		t.Errorf("%d nodes after Compress (%d before), want %d", got, lazyNodes, want)
+	}
+	pmb.Set(pfx("10.2.3.128/25"), 9)
+	eager.Set(pfx("10.2.3.128/25"), 9)
+	checkMap(t, eager.PrefixMap().ToMap(), pmb.PrefixMap().ToMap())
+}
@@ -0,0 +1,57 @@
+package netipds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// ndjsonEntry is the form of each line of a PrefixMap's NDJSON encoding.
+type ndjsonEntry[T any] struct {
+	Prefix netip.Prefix `json:"prefix"`
+	Value  T            `json:"value"`
+}
+
+// WriteNDJSON writes the entries of m to w as newline-delimited JSON, one
+// object per line, in Prefix order:
+//
+//	{"prefix":"1.2.0.0/16","value":"hello"}
+//	{"prefix":"1.2.3.0/24","value":"world"}
+//
+// Values are encoded with [encoding/json]. Entries are written as they are
+// visited, so memory usage does not depend on the size of m.
+func (m *PrefixMap[T]) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var err error
+	m.tree.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry {
+			err = enc.Encode(ndjsonEntry[T]{n.key.toPrefix(), n.value})
+		}
+		return err != nil
+	})
+	return err
+}
+
+// ReadNDJSON reads newline-delimited JSON entries in the format written by
+// [PrefixMap.WriteNDJSON] from r, and sets each of them in m.
+//
+// Entries are applied as they are read. If an entry cannot be decoded or
+// set, ReadNDJSON returns an error identifying it; the entries before it
+// remain in m, so the caller may resume from that point.
+func (m *PrefixMapBuilder[T]) ReadNDJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var e ndjsonEntry[T]
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode entry %d: %w", i, err)
+		}
+		if err := m.Set(e.Prefix, e.Value); err != nil {
+			return fmt.Errorf("failed to set entry %d: %w", i, err)
+		}
+	}
+}
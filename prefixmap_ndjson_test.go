@@ -0,0 +1,70 @@
+package netipds
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestPrefixMapWriteNDJSON(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("1.2.3.0/24"), "world")
+	pmb.Set(pfx("1.2.0.0/16"), "hello")
+	pmb.Set(pfx("2001:db8::/32"), "v6")
+
+	var buf bytes.Buffer
+	if err := pmb.PrefixMap().WriteNDJSON(&buf); err != nil {
+		t.Fatalf("pm.WriteNDJSON() error = %v", err)
+	}
+	want := `{"prefix":"1.2.0.0/16","value":"hello"}
+{"prefix":"1.2.3.0/24","value":"world"}
+{"prefix":"2001:db8::/32","value":"v6"}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("pm.WriteNDJSON() wrote\n%s\nwant\n%s", got, want)
+	}
+
+	// Round trip
+	rt := &PrefixMapBuilder[string]{}
+	if err := rt.ReadNDJSON(&buf); err != nil {
+		t.Fatalf("pmb.ReadNDJSON() error = %v", err)
+	}
+	checkMap(t, pmb.PrefixMap().ToMap(), rt.PrefixMap().ToMap())
+}
+
+func TestPrefixMapBuilderReadNDJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[netip.Prefix]int
+		wantErr bool
+	}{
+		{"", map[netip.Prefix]int{}, false},
+		{`{"prefix":"::0/128","value":1}`, map[netip.Prefix]int{pfx("::0/128"): 1}, false},
+		{
+			"{\"prefix\":\"::0/128\",\"value\":1}\n\n{\"prefix\":\"::1/128\",\"value\":2}\n",
+			map[netip.Prefix]int{pfx("::0/128"): 1, pfx("::1/128"): 2},
+			false,
+		},
+		// Entries before an error are kept
+		{
+			"{\"prefix\":\"::0/128\",\"value\":1}\n{\"prefix\":\"bogus\",\"value\":2}\n",
+			map[netip.Prefix]int{pfx("::0/128"): 1},
+			true,
+		},
+		{
+			"{\"prefix\":\"::0/128\",\"value\":1}\n{\"value\":2}\n",
+			map[netip.Prefix]int{pfx("::0/128"): 1},
+			true,
+		},
+		{`{"prefix":"::0/128","value":"x"}`, map[netip.Prefix]int{}, true},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[int]{}
+		err := pmb.ReadNDJSON(strings.NewReader(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pmb.ReadNDJSON(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+		}
+		checkMap(t, tt.want, pmb.PrefixMap().ToMap())
+	}
+}
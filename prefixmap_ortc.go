@@ -0,0 +1,174 @@
+package netipds
+
+// Minimize returns the smallest PrefixMap which yields the same
+// longest-prefix-match result as m for every address, where values are
+// compared using eq. It implements the Optimal Route Table Construction
+// (ORTC) algorithm of Draves et al.
+//
+// Addresses that have no match in m also have no match in the result, so
+// the result may have more entries than an unconstrained ORTC table would.
+func (m *PrefixMap[T]) Minimize(eq func(a, b T) bool) *PrefixMap[T] {
+	o := &ortc[T]{eq: eq}
+	root := o.expand(&m.tree, -1)
+	o.reduce(root)
+	ret := &tree[T]{}
+	o.emit(root, key{}, -1, func(k key, v T) {
+		ret = ret.insert(k.rooted(), v)
+	})
+	return &PrefixMap[T]{*ret, ret.stats(), nil}
+}
+
+// ortcNode is a node in the complete binary tree built by ORTC, in which
+// every node has either zero or two children.
+type ortcNode struct {
+	left, right *ortcNode
+	// val is the index of a leaf's value, or -1 if the leaf has none.
+	val int
+	// set is the set of value indexes which the node may receive without
+	// increasing the size of the table beneath it.
+	set []int
+	// partial is true if any leaf beneath the node has no value. Such nodes
+	// cannot receive values.
+	partial bool
+}
+
+type ortc[T any] struct {
+	eq     func(a, b T) bool
+	values []T
+}
+
+// index returns the index of v in o.values, adding it if necessary.
+func (o *ortc[T]) index(v T) int {
+	for i, w := range o.values {
+		if o.eq(v, w) {
+			return i
+		}
+	}
+	o.values = append(o.values, v)
+	return len(o.values) - 1
+}
+
+// expand returns the complete binary tree equivalent to t, in which each leaf
+// holds the value it receives via longest-prefix match (inherited from inh if
+// t has none).
+func (o *ortc[T]) expand(t *tree[T], inh int) *ortcNode {
+	if t.hasEntry {
+		inh = o.index(t.value)
+	}
+	n := &ortcNode{val: inh}
+	if t.left == nil && t.right == nil {
+		return n
+	}
+	for _, bit := range eachBit {
+		var c *ortcNode
+		if tc := *t.child(bit); tc == nil {
+			c = &ortcNode{val: inh}
+		} else {
+			// Fill in the path compressed between t and tc
+			c = o.expand(tc, inh)
+			for l := tc.key.len - 1; l > t.key.len; l-- {
+				parent := &ortcNode{val: inh}
+				*parent.child(tc.key.bit(l)) = c
+				*parent.child((^tc.key.bit(l)) & 1) = &ortcNode{val: inh}
+				c = parent
+			}
+		}
+		*n.child(bit) = c
+	}
+	return n
+}
+
+// reduce computes the value sets of n and its descendants (ORTC pass two).
+func (o *ortc[T]) reduce(n *ortcNode) {
+	if n.left == nil {
+		n.set = []int{n.val}
+		n.partial = n.val < 0
+		return
+	}
+	o.reduce(n.left)
+	o.reduce(n.right)
+	n.partial = n.left.partial || n.right.partial
+	if n.set = intersectSorted(n.left.set, n.right.set); len(n.set) == 0 {
+		n.set = unionSorted(n.left.set, n.right.set)
+	}
+}
+
+// emit calls fn with each entry of the minimized table beneath n, which has
+// key k and inherits the value with index inh (ORTC pass three).
+func (o *ortc[T]) emit(n *ortcNode, k key, inh int, fn func(key, T)) {
+	if !n.partial && !containsSorted(n.set, inh) {
+		inh = n.set[0]
+		fn(k, o.values[inh])
+	}
+	if n.left != nil {
+		o.emit(n.left, k.next(bitL), inh, fn)
+		o.emit(n.right, k.next(bitR), inh, fn)
+	}
+}
+
+func (n *ortcNode) child(b bit) **ortcNode {
+	if b == bitR {
+		return &n.right
+	}
+	return &n.left
+}
+
+// intersectSorted returns the intersection of sorted slices a and b.
+func intersectSorted(a, b []int) []int {
+	var ret []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			ret = append(ret, a[i])
+			i++
+			j++
+		}
+	}
+	return ret
+}
+
+// unionSorted returns the union of sorted slices a and b.
+func unionSorted(a, b []int) []int {
+	ret := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			ret = append(ret, a[i])
+			i++
+		case a[i] > b[j]:
+			ret = append(ret, b[j])
+			j++
+		default:
+			ret = append(ret, a[i])
+			i++
+			j++
+		}
+	}
+	ret = append(ret, a[i:]...)
+	return append(ret, b[j:]...)
+}
+
+// containsSorted reports whether sorted slice a contains v.
+func containsSorted(a []int, v int) bool {
+	for _, x := range a {
+		if x >= v {
+			return x == v
+		}
+	}
+	return false
+}
+
+// LPMEquivalent reports whether every address has the same longest-prefix
+// match in a and b, where values are compared using eq. Addresses with no
+// match in one map must have no match in the other.
+//
+// For example, {::0/127: "x"} is LPM-equivalent to
+// {::0/127: "x", ::0/128: "x"}, and to {::0/128: "x", ::1/128: "x"}.
+func LPMEquivalent[T any](a, b *PrefixMap[T], eq func(a, b T) bool) bool {
+	return lpmEquivalent(&a.tree, &b.tree, eq)
+}
@@ -0,0 +1,130 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixMapMinimize(t *testing.T) {
+	tests := []struct {
+		set  map[netip.Prefix]string
+		want map[netip.Prefix]string
+	}{
+		{wantMap(""), wantMap("")},
+		{
+			wantMap("a", "::0/128"),
+			wantMap("a", "::0/128"),
+		},
+		// Redundant descendants are removed
+		{
+			wantMap("a", "::0/126", "::0/127", "::0/128"),
+			wantMap("a", "::0/126"),
+		},
+		// Complete siblings with the same value are merged
+		{
+			wantMap("a", "::0/128", "::1/128"),
+			wantMap("a", "::0/127"),
+		},
+		// Siblings which don't cover their parent are not merged
+		{
+			wantMap("a", "::0/128", "::2/128"),
+			wantMap("a", "::0/128", "::2/128"),
+		},
+		// The majority value is hoisted to the parent
+		{
+			map[netip.Prefix]string{
+				pfx("::0/126"): "a",
+				pfx("::0/128"): "b",
+				pfx("::1/128"): "b",
+				pfx("::2/128"): "b",
+			},
+			map[netip.Prefix]string{
+				pfx("::0/126"): "b",
+				pfx("::3/128"): "a",
+			},
+		},
+
+		// IPv4
+		{
+			map[netip.Prefix]string{
+				pfx("10.0.0.0/8"):    "x",
+				pfx("10.0.0.0/9"):    "y",
+				pfx("10.128.0.0/9"):  "y",
+				pfx("10.128.0.0/16"): "x",
+			},
+			map[netip.Prefix]string{
+				pfx("10.0.0.0/8"):    "y",
+				pfx("10.128.0.0/16"): "x",
+			},
+		},
+	}
+	eq := func(a, b string) bool { return a == b }
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range tt.set {
+			pmb.Set(p, v)
+		}
+		pm := pmb.PrefixMap()
+		got := pm.Minimize(eq)
+		checkMap(t, tt.want, got.ToMap())
+		if !LPMEquivalent(pm, got, eq) {
+			t.Errorf("%v.Minimize() is not LPM-equivalent", tt.set)
+		}
+	}
+}
+
+func TestLPMEquivalent(t *testing.T) {
+	tests := []struct {
+		a    map[netip.Prefix]string
+		b    map[netip.Prefix]string
+		want bool
+	}{
+		{wantMap(""), wantMap(""), true},
+		{wantMap("x", "::0/128"), wantMap(""), false},
+		{wantMap("x", "::0/128"), wantMap("x", "::0/128"), true},
+		{wantMap("x", "::0/128"), wantMap("y", "::0/128"), false},
+		{wantMap("x", "::0/127"), wantMap("x", "::0/127", "::0/128"), true},
+		{wantMap("x", "::0/127"), wantMap("x", "::0/128", "::1/128"), true},
+		{wantMap("x", "::0/127"), wantMap("x", "::0/128"), false},
+		{wantMap("x", "::0/127"), wantMap("x", "::0/126"), false},
+		{
+			map[netip.Prefix]string{pfx("::0/126"): "x", pfx("::0/127"): "y"},
+			map[netip.Prefix]string{pfx("::0/127"): "y", pfx("::2/127"): "x"},
+			true,
+		},
+		{
+			map[netip.Prefix]string{pfx("::0/126"): "x", pfx("::0/127"): "y"},
+			map[netip.Prefix]string{pfx("::0/126"): "y", pfx("::2/127"): "x"},
+			true,
+		},
+		{
+			map[netip.Prefix]string{pfx("::0/126"): "x", pfx("::0/128"): "y"},
+			map[netip.Prefix]string{pfx("::0/126"): "y", pfx("::2/127"): "x"},
+			false,
+		},
+		// b's keys diverge from a's below their common ancestor
+		{
+			map[netip.Prefix]string{pfx("10.0.0.0/24"): "x", pfx("10.0.0.128/27"): "x"},
+			map[netip.Prefix]string{
+				pfx("10.0.0.0/24"): "x", pfx("10.0.0.160/29"): "y", pfx("10.0.0.168/29"): "y",
+			},
+			false,
+		},
+	}
+	eq := func(a, b string) bool { return a == b }
+	for _, tt := range tests {
+		a, b := &PrefixMapBuilder[string]{}, &PrefixMapBuilder[string]{}
+		for p, v := range tt.a {
+			a.Set(p, v)
+		}
+		for p, v := range tt.b {
+			b.Set(p, v)
+		}
+		if got := LPMEquivalent(a.PrefixMap(), b.PrefixMap(), eq); got != tt.want {
+			t.Errorf("LPMEquivalent(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := LPMEquivalent(b.PrefixMap(), a.PrefixMap(), eq); got != tt.want {
+			t.Errorf("LPMEquivalent(%v, %v) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package netipds
 
 import (
+	"fmt"
+	"maps"
+	"math/rand"
 	"net/netip"
+	"slices"
 	"testing"
 )
 
@@ -87,6 +91,31 @@ func TestPrefixMapGet(t *testing.T) {
 	}
 }
 
+func TestPrefixMapBuilderUpdate(t *testing.T) {
+	count := func(old int, exists bool) int {
+		if !exists && old != 0 {
+			t.Errorf("Update called with old value %d for a new entry", old)
+		}
+		return old + 1
+	}
+	for _, lazy := range []bool{false, true} {
+		pmb := &PrefixMapBuilder[int]{Lazy: lazy}
+		for _, p := range pfxs("1.2.3.0/24", "1.2.0.0/16", "1.2.3.0/24", "::1/128", "1.2.3.0/24") {
+			if err := pmb.Update(p, count); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkMap(t, map[netip.Prefix]int{
+			pfx("1.2.0.0/16"): 1,
+			pfx("1.2.3.0/24"): 3,
+			pfx("::1/128"):    1,
+		}, pmb.PrefixMap().ToMap())
+		if err := pmb.Update(netip.Prefix{}, count); err == nil {
+			t.Errorf("Update(invalid) succeeded")
+		}
+	}
+}
+
 func TestPrefixMapContains(t *testing.T) {
 	tests := []struct {
 		set  []netip.Prefix
@@ -358,6 +387,439 @@ func TestPrefixMapRemove(t *testing.T) {
 	}
 }
 
+func TestPrefixMapLookupAddr(t *testing.T) {
+	tests := []struct {
+		set    map[netip.Prefix]string
+		get    netip.Addr
+		want   string
+		wantOK bool
+	}{
+		{map[netip.Prefix]string{}, netip.MustParseAddr("::0"), "", false},
+		{map[netip.Prefix]string{pfx("::0/128"): "a"}, netip.MustParseAddr("::0"), "a", true},
+		{map[netip.Prefix]string{pfx("::0/128"): "a"}, netip.MustParseAddr("::1"), "", false},
+		{
+			map[netip.Prefix]string{pfx("::0/126"): "a", pfx("::0/127"): "b"},
+			netip.MustParseAddr("::1"), "b", true,
+		},
+		{
+			map[netip.Prefix]string{pfx("::0/126"): "a", pfx("::0/127"): "b"},
+			netip.MustParseAddr("::2"), "a", true,
+		},
+		// Entry-less nodes are skipped
+		{
+			map[netip.Prefix]string{pfx("::0/128"): "a", pfx("::2/128"): "b"},
+			netip.MustParseAddr("::1"), "", false,
+		},
+		{
+			map[netip.Prefix]string{pfx("1.2.0.0/16"): "a", pfx("1.2.3.0/24"): "b"},
+			netip.MustParseAddr("1.2.3.4"), "b", true,
+		},
+		{
+			map[netip.Prefix]string{pfx("1.2.0.0/16"): "a", pfx("1.2.3.0/24"): "b"},
+			netip.MustParseAddr("1.2.4.4"), "a", true,
+		},
+		{map[netip.Prefix]string{pfx("::0/128"): "a"}, netip.Addr{}, "", false},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range tt.set {
+			pmb.Set(p, v)
+		}
+		pm := pmb.PrefixMap()
+		if got, ok := pm.LookupAddr(tt.get); got != tt.want || ok != tt.wantOK {
+			t.Errorf("pm.LookupAddr(%s) = (%v, %v), want (%v, %v)",
+				tt.get, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPrefixMapLookupAddrAllocs(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("1.2.0.0/16"), "a")
+	pmb.Set(pfx("1.2.3.0/24"), "b")
+	pm := pmb.PrefixMap()
+	a := netip.MustParseAddr("1.2.3.4")
+	if n := testing.AllocsPerRun(100, func() { pm.LookupAddr(a) }); n != 0 {
+		t.Errorf("pm.LookupAddr allocated %v times, want 0", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { pm.Lookup(a) }); n != 0 {
+		t.Errorf("pm.Lookup allocated %v times, want 0", n)
+	}
+}
+
+func TestPrefixMapLookup(t *testing.T) {
+	tests := []struct {
+		set        map[netip.Prefix]string
+		get        netip.Addr
+		wantPrefix netip.Prefix
+		want       string
+		wantOK     bool
+	}{
+		{map[netip.Prefix]string{}, netip.MustParseAddr("::0"), netip.Prefix{}, "", false},
+		{
+			map[netip.Prefix]string{pfx("::0/126"): "a", pfx("::0/127"): "b"},
+			netip.MustParseAddr("::1"), pfx("::0/127"), "b", true,
+		},
+		{
+			map[netip.Prefix]string{pfx("::0/126"): "a", pfx("::0/127"): "b"},
+			netip.MustParseAddr("::2"), pfx("::0/126"), "a", true,
+		},
+		{
+			map[netip.Prefix]string{pfx("::0/128"): "a", pfx("::2/128"): "b"},
+			netip.MustParseAddr("::1"), netip.Prefix{}, "", false,
+		},
+		{
+			map[netip.Prefix]string{pfx("1.2.0.0/16"): "a", pfx("1.2.3.0/24"): "b"},
+			netip.MustParseAddr("1.2.3.4"), pfx("1.2.3.0/24"), "b", true,
+		},
+		{
+			map[netip.Prefix]string{pfx("1.2.0.0/16"): "a", pfx("1.2.3.0/24"): "b"},
+			netip.MustParseAddr("::ffff:1.2.4.4"), pfx("1.2.0.0/16"), "a", true,
+		},
+		{
+			map[netip.Prefix]string{pfx("fe80::/64"): "a"},
+			netip.MustParseAddr("fe80::1%eth0"), pfx("fe80::/64"), "a", true,
+		},
+		{map[netip.Prefix]string{pfx("::0/128"): "a"}, netip.Addr{}, netip.Prefix{}, "", false},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range tt.set {
+			pmb.Set(p, v)
+		}
+		pm := pmb.PrefixMap()
+		gotPrefix, got, ok := pm.Lookup(tt.get)
+		if gotPrefix != tt.wantPrefix || got != tt.want || ok != tt.wantOK {
+			t.Errorf("pm.Lookup(%s) = (%v, %v, %v), want (%v, %v, %v)",
+				tt.get, gotPrefix, got, ok, tt.wantPrefix, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPrefixMapRoots(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("1.0.0.0/8"), "org")
+	pmb.Set(pfx("1.2.0.0/16"), "site")
+	pmb.Set(pfx("2.3.0.0/16"), "other")
+	pmb.Set(pfx("2.3.4.0/24"), "subnet")
+	pmb.Set(pfx("2000::/3"), "v6")
+	want := []PrefixEntry[string]{
+		{pfx("1.0.0.0/8"), "org"},
+		{pfx("2.3.0.0/16"), "other"},
+		{pfx("2000::/3"), "v6"},
+	}
+	if got := pmb.PrefixMap().Roots(); !slices.Equal(got, want) {
+		t.Errorf("Roots() = %v, want %v", got, want)
+	}
+	if got := (&PrefixMap[string]{}).Roots(); len(got) != 0 {
+		t.Errorf("Roots() of empty map = %v, want none", got)
+	}
+}
+
+func TestPrefixMapLeaves(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("1.0.0.0/8"), "org")
+	pmb.Set(pfx("1.2.0.0/16"), "site")
+	pmb.Set(pfx("1.2.3.0/24"), "subnet")
+	pmb.Set(pfx("1.3.0.0/16"), "other")
+	pmb.Set(pfx("2000::/3"), "v6")
+	want := []PrefixEntry[string]{
+		{pfx("1.2.3.0/24"), "subnet"},
+		{pfx("1.3.0.0/16"), "other"},
+		{pfx("2000::/3"), "v6"},
+	}
+	if got := pmb.PrefixMap().Leaves(); !slices.Equal(got, want) {
+		t.Errorf("Leaves() = %v, want %v", got, want)
+	}
+}
+
+func TestPrefixMapShadowed(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		want []netip.Prefix
+	}{
+		{pfxs(), pfxs()},
+		{pfxs("10.0.0.0/23", "10.0.0.0/24"), pfxs()},
+		{pfxs("10.0.0.0/23", "10.0.0.0/24", "10.0.1.0/24"), pfxs("10.0.0.0/23")},
+		// Descendants may cover parts of each other
+		{
+			pfxs("10.0.0.0/8", "10.0.0.0/9", "10.128.0.0/10", "10.192.0.0/10", "10.192.0.0/11"),
+			pfxs("10.0.0.0/8"),
+		},
+		// Nested shadowing
+		{
+			pfxs("10.0.0.0/22", "10.0.0.0/23", "10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/23"),
+			pfxs("10.0.0.0/22", "10.0.0.0/23"),
+		},
+		// A gap anywhere below the entry means it's not shadowed
+		{pfxs("10.0.0.0/22", "10.0.0.0/23", "10.0.2.0/24"), pfxs()},
+		{pfxs("::/127", "::/128", "::1/128", "1.2.3.4/32"), pfxs("::/127")},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[bool]{}
+		for _, p := range tt.set {
+			pmb.Set(p, true)
+		}
+		checkPrefixSlice(t, pmb.PrefixMap().Shadowed(), tt.want)
+	}
+}
+
+func TestPrefixMapConflicts(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("10.0.0.0/8"), "deny")
+	pmb.Set(pfx("10.1.0.0/16"), "allow")
+	pmb.Set(pfx("10.1.2.0/24"), "deny")
+	pmb.Set(pfx("10.1.3.0/24"), "allow")
+	pmb.Set(pfx("10.2.0.0/16"), "deny")
+	pmb.Set(pfx("192.168.0.0/16"), "allow")
+	pmb.Set(pfx("2001:db8::/32"), "deny")
+	pmb.Set(pfx("2001:db8::/48"), "allow")
+	e := func(p, v string) PrefixEntry[string] { return PrefixEntry[string]{pfx(p), v} }
+	want := []Conflict[string]{
+		{e("10.0.0.0/8", "deny"), e("10.1.0.0/16", "allow")},
+		{e("10.1.0.0/16", "allow"), e("10.1.2.0/24", "deny")},
+		{e("10.0.0.0/8", "deny"), e("10.1.3.0/24", "allow")},
+		{e("2001:db8::/32", "deny"), e("2001:db8::/48", "allow")},
+	}
+	got := pmb.PrefixMap().Conflicts(func(a, b string) bool { return a == b })
+	if !slices.Equal(got, want) {
+		t.Errorf("Conflicts() = %v, want %v", got, want)
+	}
+
+	// Values can be compared loosely
+	got = pmb.PrefixMap().Conflicts(func(a, b string) bool { return true })
+	if len(got) != 0 {
+		t.Errorf("Conflicts(always equal) = %v, want none", got)
+	}
+}
+
+type clonedCounter struct{ n *int }
+
+func (c clonedCounter) Clone() clonedCounter {
+	if c.n == nil {
+		return c
+	}
+	n := *c.n
+	return clonedCounter{&n}
+}
+
+func TestPrefixMapBuilderClone(t *testing.T) {
+	// Values implementing Cloner are cloned
+	n := 1
+	pmb := &PrefixMapBuilder[clonedCounter]{}
+	pmb.Set(pfx("1.2.3.0/24"), clonedCounter{&n})
+	pmb.Set(pfx("1.2.4.0/24"), clonedCounter{})
+	pm := pmb.PrefixMap()
+	n = 2
+	if v, _ := pm.Get(pfx("1.2.3.0/24")); *v.n != 1 {
+		t.Errorf("Cloner: PrefixMap value changed with builder value: got %d, want 1", *v.n)
+	}
+
+	// CloneFunc is used for other types, and takes precedence over Cloner
+	for _, lazy := range []bool{false, true} {
+		pmb := &PrefixMapBuilder[[]int]{Lazy: lazy, CloneFunc: slices.Clone[[]int]}
+		pmb.Set(pfx("1.2.3.0/24"), []int{1, 2})
+		pm := pmb.PrefixMap()
+		v, _ := pmb.Get(pfx("1.2.3.0/24"))
+		v[0] = 3
+		if got, _ := pm.Get(pfx("1.2.3.0/24")); got[0] != 1 {
+			t.Errorf("CloneFunc (lazy = %v): PrefixMap value changed with builder value: got %v", lazy, got)
+		}
+	}
+	calls := 0
+	pmb.CloneFunc = func(c clonedCounter) clonedCounter {
+		calls++
+		return c
+	}
+	pmb.PrefixMap()
+	if calls != 2 {
+		t.Errorf("CloneFunc called %d times, want 2", calls)
+	}
+
+	// Without either, values are shared
+	pmbp := &PrefixMapBuilder[*int]{}
+	pmbp.Set(pfx("1.2.3.0/24"), &n)
+	if v, _ := pmbp.PrefixMap().Get(pfx("1.2.3.0/24")); v != &n {
+		t.Errorf("value was copied without Cloner or CloneFunc")
+	}
+
+	// Values published by ConcurrentPrefixMap aren't shared with later
+	// batches
+	var c ConcurrentPrefixMap[clonedCounter]
+	c.Set(pfx("1.2.3.0/24"), clonedCounter{&n})
+	published := c.Load()
+	c.Update(func(b *PrefixMapBuilder[clonedCounter]) error {
+		v, _ := b.Get(pfx("1.2.3.0/24"))
+		*v.n = 5
+		return nil
+	})
+	if v, _ := published.Get(pfx("1.2.3.0/24")); *v.n != 2 {
+		t.Errorf("ConcurrentPrefixMap: published value changed by later batch: got %d, want 2", *v.n)
+	}
+}
+
+func TestPrefixMapRollup(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("10.0.0.0/8"), 1000)
+	pmb.Set(pfx("10.1.0.0/16"), 1)
+	pmb.Set(pfx("10.1.2.0/24"), 2)
+	pmb.Set(pfx("10.1.3.4/32"), 3)
+	pmb.Set(pfx("10.2.0.0/24"), 4)
+	pmb.Set(pfx("192.168.1.0/24"), 5)
+	// Beneath ::/16, but not in the same group as the IPv4 entries
+	pmb.Set(pfx("::1/128"), 6)
+	pmb.Set(pfx("2001:db8::/32"), 7)
+	pmb.Set(pfx("2001:db8:1::/48"), 8)
+	pm := pmb.PrefixMap()
+	sum := func(vs []int) int {
+		total := 0
+		for _, v := range vs {
+			total += v
+		}
+		return total
+	}
+
+	checkMap(t, map[netip.Prefix]int{
+		pfx("10.1.0.0/16"):    6,
+		pfx("10.2.0.0/16"):    4,
+		pfx("192.168.0.0/16"): 5,
+		pfx("::/16"):          6,
+		pfx("2001::/16"):      15,
+	}, pm.Rollup(16, sum).ToMap())
+
+	checkMap(t, map[netip.Prefix]int{
+		pfx("10.0.0.0/8"):  1010,
+		pfx("192.0.0.0/8"): 5,
+		pfx("::/8"):        6,
+		pfx("2000::/8"):    15,
+	}, pm.Rollup(8, sum).ToMap())
+
+	// Values are passed in order
+	first := func(vs []int) int { return vs[0] }
+	checkMap(t, map[netip.Prefix]int{
+		pfx("10.0.0.0/8"): 1000, pfx("192.0.0.0/8"): 5, pfx("::/8"): 6, pfx("2000::/8"): 7,
+	}, pm.Rollup(8, first).ToMap())
+
+	checkMap(t, map[netip.Prefix]int{}, pm.Rollup(129, sum).ToMap())
+	checkMap(t, map[netip.Prefix]int{}, pm.Rollup(-1, sum).ToMap())
+}
+
+func TestPrefixMapLookupDetail(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("1.0.0.0/8"), "org")
+	pmb.Set(pfx("1.2.0.0/16"), "site")
+	pmb.Set(pfx("1.2.3.0/24"), "subnet")
+	pmb.Set(pfx("1.3.0.0/16"), "other")
+	pmb.Set(pfx("1.3.4.0/24"), "removed")
+	pmb.Remove(pfx("1.3.4.0/24"))
+	pmb.Set(pfx("2000::/3"), "v6")
+	pm := pmb.PrefixMap()
+
+	tests := []struct {
+		get  string
+		want Match[string]
+		ok   bool
+	}{
+		{"1.2.3.4", Match[string]{pfx("1.2.3.0/24"), "subnet", 2, false}, true},
+		{"1.2.4.4", Match[string]{pfx("1.2.0.0/16"), "site", 1, true}, true},
+		{"1.4.0.0", Match[string]{pfx("1.0.0.0/8"), "org", 0, true}, true},
+		// Removed entries are not more-specifics
+		{"1.3.0.1", Match[string]{pfx("1.3.0.0/16"), "other", 1, false}, true},
+		{"2001::1", Match[string]{pfx("2000::/3"), "v6", 0, false}, true},
+		{"2.0.0.0", Match[string]{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := pm.LookupDetail(netip.MustParseAddr(tt.get))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("LookupDetail(%s) = (%+v, %v), want (%+v, %v)", tt.get, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := pm.LookupDetail(netip.Addr{}); ok {
+		t.Errorf("LookupDetail(invalid) found a match")
+	}
+}
+
+func TestPrefixMapLookupN(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("1.0.0.0/8"), "org")
+	pmb.Set(pfx("1.2.0.0/16"), "site")
+	pmb.Set(pfx("1.2.3.0/24"), "subnet")
+	pmb.Set(pfx("1.2.3.4/32"), "host")
+	pm := pmb.PrefixMap()
+
+	tests := []struct {
+		get  string
+		n    int
+		want []PrefixEntry[string]
+	}{
+		{"1.2.3.4", 2, []PrefixEntry[string]{
+			{pfx("1.2.3.4/32"), "host"},
+			{pfx("1.2.3.0/24"), "subnet"},
+		}},
+		{"1.2.3.5", 2, []PrefixEntry[string]{
+			{pfx("1.2.3.0/24"), "subnet"},
+			{pfx("1.2.0.0/16"), "site"},
+		}},
+		{"1.2.3.5", 5, []PrefixEntry[string]{
+			{pfx("1.2.3.0/24"), "subnet"},
+			{pfx("1.2.0.0/16"), "site"},
+			{pfx("1.0.0.0/8"), "org"},
+		}},
+		{"1.2.3.4", 1, []PrefixEntry[string]{{pfx("1.2.3.4/32"), "host"}}},
+		{"1.2.3.4", 0, nil},
+		{"2.0.0.0", 3, nil},
+	}
+	for _, tt := range tests {
+		got := pm.LookupN(netip.MustParseAddr(tt.get), tt.n)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("LookupN(%s, %d) = %v, want %v", tt.get, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixMapLookupAll(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("1.0.0.0/8"), "org")
+	pmb.Set(pfx("1.2.0.0/16"), "site")
+	pmb.Set(pfx("1.2.3.0/24"), "subnet")
+	pmb.Set(pfx("1.2.3.128/25"), "other")
+	pmb.Set(pfx("2000::/3"), "v6")
+	pm := pmb.PrefixMap()
+
+	tests := []struct {
+		get  string
+		want []PrefixEntry[string]
+	}{
+		{"1.2.3.4", []PrefixEntry[string]{
+			{pfx("1.0.0.0/8"), "org"},
+			{pfx("1.2.0.0/16"), "site"},
+			{pfx("1.2.3.0/24"), "subnet"},
+		}},
+		{"1.2.4.4", []PrefixEntry[string]{
+			{pfx("1.0.0.0/8"), "org"},
+			{pfx("1.2.0.0/16"), "site"},
+		}},
+		{"2.0.0.0", nil},
+		{"2001::1", []PrefixEntry[string]{{pfx("2000::/3"), "v6"}}},
+	}
+	for _, tt := range tests {
+		got := pm.LookupAll(netip.MustParseAddr(tt.get))
+		if len(got) != len(tt.want) {
+			t.Errorf("LookupAll(%s) = %v, want %v", tt.get, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("LookupAll(%s) = %v, want %v", tt.get, got, tt.want)
+				break
+			}
+		}
+	}
+	if got := pm.LookupAll(netip.Addr{}); got != nil {
+		t.Errorf("LookupAll(invalid) = %v, want nil", got)
+	}
+}
+
 func TestPrefixMapRootOf(t *testing.T) {
 	tests := []struct {
 		set        []netip.Prefix
@@ -938,6 +1400,13 @@ func TestPrefixMapBuilderFilter(t *testing.T) {
 		// Filtering uses encompassment; the filter covers "::0/127" but does
 		// not encompass it.
 		{pfxs("::0/127"), pfxs("::0/128", "::1/128"), wantMap(true)},
+
+		// Nested filter entries
+		{
+			set:    pfxs("1.2.3.0/24", "1.2.4.0/24", "1.3.0.0/16", "2001:db8::/48"),
+			filter: pfxs("1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/17", "2001:db8::/32"),
+			want:   wantMap(true, "1.2.3.0/24", "1.2.4.0/24", "2001:db8::/48"),
+		},
 	}
 	for _, tt := range tests {
 		pmb := &PrefixMapBuilder[bool]{}
@@ -998,6 +1467,13 @@ func TestPrefixMapFilter(t *testing.T) {
 		// Filtering uses encompassment; the filter covers "::0/127" but does
 		// not encompass it.
 		{pfxs("::0/127"), pfxs("::0/128", "::1/128"), wantMap(true)},
+
+		// Nested filter entries
+		{
+			set:    pfxs("1.2.3.0/24", "1.2.4.0/24", "1.3.0.0/16", "2001:db8::/48"),
+			filter: pfxs("1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/17", "2001:db8::/32"),
+			want:   wantMap(true, "1.2.3.0/24", "1.2.4.0/24", "2001:db8::/48"),
+		},
 	}
 	for _, tt := range tests {
 		pmb := &PrefixMapBuilder[bool]{}
@@ -1014,6 +1490,492 @@ func TestPrefixMapFilter(t *testing.T) {
 	}
 }
 
+func TestPrefixMapFilteredBy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randPrefix := func() netip.Prefix {
+		a := netip.AddrFrom4([4]byte{10, byte(r.Intn(4)), byte(r.Intn(4)), byte(r.Intn(256))})
+		return netip.PrefixFrom(a, 16+r.Intn(17)).Masked()
+	}
+	pmb := &PrefixMapBuilder[int]{}
+	for i := 0; i < 500; i++ {
+		pmb.Set(randPrefix(), i)
+	}
+	psb := &PrefixSetBuilder{}
+	for i := 0; i < 50; i++ {
+		psb.Add(randPrefix())
+	}
+	pm, ps := pmb.PrefixMap(), psb.PrefixSet()
+
+	want := make(map[netip.Prefix]int)
+	for p, v := range pm.ToMap() {
+		if ps.Encompasses(p) {
+			want[p] = v
+		}
+	}
+	got := pm.FilteredBy(ps)
+	checkMap(t, want, got.ToMap())
+	if got.Size() != len(want) {
+		t.Errorf("Size() = %d, want %d", got.Size(), len(want))
+	}
+}
+
+func TestMapValues(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("1.2.0.0/16"), 1)
+	pmb.Set(pfx("1.2.3.0/24"), 2)
+	pmb.Set(pfx("2001:db8::/32"), 3)
+	pm := pmb.PrefixMap()
+
+	got := MapValues(pm, func(p netip.Prefix, v int) string {
+		return fmt.Sprintf("%s=%d", p, v*10)
+	})
+	checkMap(t, map[netip.Prefix]string{
+		pfx("1.2.0.0/16"):    "1.2.0.0/16=10",
+		pfx("1.2.3.0/24"):    "1.2.3.0/24=20",
+		pfx("2001:db8::/32"): "2001:db8::/32=30",
+	}, got.ToMap())
+	if got.Size() != pm.Size() {
+		t.Errorf("Size() = %d, want %d", got.Size(), pm.Size())
+	}
+	if p, v, ok := got.Lookup(netip.MustParseAddr("1.2.3.4")); !ok || p != pfx("1.2.3.0/24") || v != "1.2.3.0/24=20" {
+		t.Errorf("Lookup(1.2.3.4) = (%v, %v, %v)", p, v, ok)
+	}
+	// m is unchanged
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.2.0.0/16"):    1,
+		pfx("1.2.3.0/24"):    2,
+		pfx("2001:db8::/32"): 3,
+	}, pm.ToMap())
+}
+
+func TestFold(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("1.2.3.0/24"), 2)
+	pmb.Set(pfx("1.2.0.0/16"), 1)
+	pmb.Set(pfx("2001:db8::/32"), 3)
+	pmb.Set(pfx("1.3.0.0/16"), 4)
+	pm := pmb.PrefixMap()
+
+	if got := Fold(pm, 0, func(acc int, _ netip.Prefix, v int) int { return acc + v }); got != 10 {
+		t.Errorf("sum = %d, want 10", got)
+	}
+	byLen := Fold(pm, map[int]int{}, func(acc map[int]int, p netip.Prefix, _ int) map[int]int {
+		acc[p.Bits()]++
+		return acc
+	})
+	if want := map[int]int{16: 2, 24: 1, 32: 1}; !maps.Equal(byLen, want) {
+		t.Errorf("counts by length = %v, want %v", byLen, want)
+	}
+
+	// Entries are visited in sorted order
+	order := Fold(pm, []netip.Prefix(nil), func(acc []netip.Prefix, p netip.Prefix, _ int) []netip.Prefix {
+		return append(acc, p)
+	})
+	checkPrefixSlice(t, order, pfxs("1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/16", "2001:db8::/32"))
+
+	if got := Fold(&PrefixMap[int]{}, 7, func(acc int, _ netip.Prefix, v int) int { return acc + v }); got != 7 {
+		t.Errorf("Fold of empty map = %d, want 7", got)
+	}
+}
+
+func TestPrefixMapBuilderIntersectFunc(t *testing.T) {
+	type kv = map[netip.Prefix]string
+	join := func(a, b string) (string, bool) { return a + b, true }
+	skipEqual := func(a, b string) (string, bool) { return a, a != b }
+	tests := []struct {
+		a    kv
+		b    kv
+		keep func(a, b string) (string, bool)
+		want kv
+	}{
+		{kv{}, kv{}, join, kv{}},
+		{kv{pfx("::0/128"): "a"}, kv{}, join, kv{}},
+		{kv{}, kv{pfx("::0/128"): "b"}, join, kv{}},
+		{kv{pfx("::0/128"): "a"}, kv{pfx("::1/128"): "b"}, join, kv{}},
+		{kv{pfx("::0/128"): "a"}, kv{pfx("::0/128"): "b"}, join, kv{pfx("::0/128"): "ab"}},
+		// Ancestors in the other map provide values
+		{kv{pfx("::0/128"): "a"}, kv{pfx("::0/127"): "b"}, join, kv{pfx("::0/128"): "ab"}},
+		{kv{pfx("::0/127"): "a"}, kv{pfx("::1/128"): "b"}, join, kv{pfx("::1/128"): "ab"}},
+		{
+			kv{pfx("::0/126"): "a", pfx("::0/127"): "c"},
+			kv{pfx("::0/128"): "b", pfx("::2/128"): "d"},
+			join,
+			kv{pfx("::0/128"): "cb", pfx("::2/128"): "ad"},
+		},
+		// keep can drop entries
+		{
+			kv{pfx("::0/128"): "x", pfx("::1/128"): "x"},
+			kv{pfx("::0/128"): "x", pfx("::1/128"): "y"},
+			skipEqual,
+			kv{pfx("::1/128"): "x"},
+		},
+
+		// IPv4
+		{
+			kv{pfx("10.0.0.0/8"): "corp", pfx("192.168.0.0/16"): "home"},
+			kv{pfx("10.1.0.0/16"): "-nyc", pfx("172.16.0.0/12"): "-lab"},
+			join,
+			kv{pfx("10.1.0.0/16"): "corp-nyc"},
+		},
+	}
+	for _, tt := range tests {
+		a, b := &PrefixMapBuilder[string]{}, &PrefixMapBuilder[string]{}
+		for p, v := range tt.a {
+			a.Set(p, v)
+		}
+		for p, v := range tt.b {
+			b.Set(p, v)
+		}
+		a.IntersectFunc(b.PrefixMap(), tt.keep)
+		checkMap(t, tt.want, a.PrefixMap().ToMap())
+	}
+}
+
+func TestPrefixMapBuilderIntersectWith(t *testing.T) {
+	a, b := &PrefixMapBuilder[int]{}, &PrefixMapBuilder[int]{}
+	a.Set(pfx("10.0.0.0/8"), 1)
+	a.Set(pfx("192.168.0.0/16"), 2)
+	b.Set(pfx("10.1.0.0/16"), 10)
+	b.Set(pfx("192.168.0.0/16"), 20)
+	b.Set(pfx("172.16.0.0/12"), 30)
+	a.IntersectWith(b.PrefixMap(), func(a, b int) int { return a + b })
+	checkMap(t, map[netip.Prefix]int{
+		pfx("10.1.0.0/16"):    11,
+		pfx("192.168.0.0/16"): 22,
+	}, a.PrefixMap().ToMap())
+}
+
+func TestPrefixMapBuilderMergeWith(t *testing.T) {
+	type kv = map[netip.Prefix]int
+	sum := func(a, b int) int { return a + b }
+	tests := []struct {
+		a, b kv
+		want kv
+	}{
+		{kv{}, kv{}, kv{}},
+		{kv{pfx("::0/128"): 1}, kv{}, kv{pfx("::0/128"): 1}},
+		{kv{}, kv{pfx("::0/128"): 2}, kv{pfx("::0/128"): 2}},
+		{kv{pfx("::0/128"): 1}, kv{pfx("::0/128"): 2}, kv{pfx("::0/128"): 3}},
+		// Ancestors are not combined with descendants
+		{
+			kv{pfx("10.0.0.0/8"): 1, pfx("192.168.0.0/16"): 2},
+			kv{pfx("10.1.0.0/16"): 10, pfx("192.168.0.0/16"): 20},
+			kv{pfx("10.0.0.0/8"): 1, pfx("10.1.0.0/16"): 10, pfx("192.168.0.0/16"): 22},
+		},
+	}
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			a, b := &PrefixMapBuilder[int]{Lazy: lazy}, &PrefixMapBuilder[int]{}
+			for p, v := range tt.a {
+				a.Set(p, v)
+			}
+			for p, v := range tt.b {
+				b.Set(p, v)
+			}
+			a.MergeWith(b.PrefixMap(), sum)
+			pm := a.PrefixMap()
+			checkMap(t, tt.want, pm.ToMap())
+			if pm.Size() != len(tt.want) {
+				t.Errorf("Size() = %d, want %d", pm.Size(), len(tt.want))
+			}
+		}
+	}
+}
+
+func TestPrefixMapBuilderSubtractPrefix(t *testing.T) {
+	inherit := func(_ netip.Prefix, v string) (string, bool) { return v, true }
+	drop := func(netip.Prefix, string) (string, bool) { return "", false }
+	mark := func(_ netip.Prefix, v string) (string, bool) { return v + "'", true }
+
+	tests := []struct {
+		set      map[netip.Prefix]string
+		subtract netip.Prefix
+		fn       func(netip.Prefix, string) (string, bool)
+		want     map[netip.Prefix]string
+	}{
+		{
+			set:      map[netip.Prefix]string{},
+			subtract: pfx("::0/128"),
+			fn:       inherit,
+			want:     map[netip.Prefix]string{},
+		},
+		{
+			set:      map[netip.Prefix]string{pfx("::0/128"): "a"},
+			subtract: pfx("::0/128"),
+			fn:       inherit,
+			want:     map[netip.Prefix]string{},
+		},
+		{
+			set:      map[netip.Prefix]string{pfx("::0/128"): "a"},
+			subtract: pfx("::1/128"),
+			fn:       inherit,
+			want:     map[netip.Prefix]string{pfx("::0/128"): "a"},
+		},
+		{
+			set:      map[netip.Prefix]string{pfx("::0/128"): "a"},
+			subtract: pfx("::0/127"),
+			fn:       inherit,
+			want:     map[netip.Prefix]string{},
+		},
+		{
+			set:      map[netip.Prefix]string{pfx("::0/126"): "a"},
+			subtract: pfx("::0/128"),
+			fn:       inherit,
+			want: map[netip.Prefix]string{
+				pfx("::1/128"): "a",
+				pfx("::2/127"): "a",
+			},
+		},
+		{
+			set:      map[netip.Prefix]string{pfx("::0/126"): "a"},
+			subtract: pfx("::0/128"),
+			fn:       mark,
+			want: map[netip.Prefix]string{
+				pfx("::1/128"): "a'",
+				pfx("::2/127"): "a'",
+			},
+		},
+		{
+			set:      map[netip.Prefix]string{pfx("::0/126"): "a"},
+			subtract: pfx("::0/128"),
+			fn:       drop,
+			want:     map[netip.Prefix]string{},
+		},
+		// Ancestors are only split as far as the next ancestor entry
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/126"): "a",
+				pfx("::0/127"): "b",
+			},
+			subtract: pfx("::0/128"),
+			fn:       inherit,
+			want: map[netip.Prefix]string{
+				pfx("::2/127"): "a",
+				pfx("::1/128"): "b",
+			},
+		},
+		// Existing entries are not overwritten by new fragments
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/126"): "a",
+				pfx("::2/127"): "b",
+			},
+			subtract: pfx("::0/128"),
+			fn:       inherit,
+			want: map[netip.Prefix]string{
+				pfx("::1/128"): "a",
+				pfx("::2/127"): "b",
+			},
+		},
+		// Descendants of the subtracted Prefix are removed
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/126"): "a",
+				pfx("::0/128"): "b",
+				pfx("::1/128"): "c",
+			},
+			subtract: pfx("::0/127"),
+			fn:       inherit,
+			want:     map[netip.Prefix]string{pfx("::2/127"): "a"},
+		},
+		// Subtracting beneath an entry-less node leaves no stray entries
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/128"): "a",
+				pfx("::4/128"): "b",
+			},
+			subtract: pfx("::2/128"),
+			fn:       inherit,
+			want: map[netip.Prefix]string{
+				pfx("::0/128"): "a",
+				pfx("::4/128"): "b",
+			},
+		},
+		// IPv4
+		{
+			set:      map[netip.Prefix]string{pfx("1.2.3.0/30"): "a"},
+			subtract: pfx("1.2.3.0/32"),
+			fn:       inherit,
+			want: map[netip.Prefix]string{
+				pfx("1.2.3.1/32"): "a",
+				pfx("1.2.3.2/31"): "a",
+			},
+		},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range tt.set {
+			pmb.Set(p, v)
+		}
+		pmb.SubtractPrefix(tt.subtract, tt.fn)
+		checkMap(t, tt.want, pmb.PrefixMap().ToMap())
+	}
+}
+
+func TestPrefixMapBuilderSubtract(t *testing.T) {
+	inherit := func(_ netip.Prefix, v string) (string, bool) { return v, true }
+
+	tests := []struct {
+		set      map[netip.Prefix]string
+		subtract []netip.Prefix
+		want     map[netip.Prefix]string
+	}{
+		{
+			set:      map[netip.Prefix]string{pfx("::0/126"): "a"},
+			subtract: pfxs(),
+			want:     map[netip.Prefix]string{pfx("::0/126"): "a"},
+		},
+		{
+			set:      map[netip.Prefix]string{pfx("::0/126"): "a"},
+			subtract: pfxs("::0/128", "::3/128"),
+			want: map[netip.Prefix]string{
+				pfx("::1/128"): "a",
+				pfx("::2/128"): "a",
+			},
+		},
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/127"): "a",
+				pfx("::2/127"): "b",
+			},
+			subtract: pfxs("::0/126"),
+			want:     map[netip.Prefix]string{},
+		},
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/127"): "a",
+				pfx("::2/127"): "b",
+			},
+			subtract: pfxs("::1/128", "::2/128"),
+			want: map[netip.Prefix]string{
+				pfx("::0/128"): "a",
+				pfx("::3/128"): "b",
+			},
+		},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range tt.set {
+			pmb.Set(p, v)
+		}
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.subtract {
+			psb.Add(p)
+		}
+		pmb.Subtract(psb.PrefixSet(), inherit)
+		checkMap(t, tt.want, pmb.PrefixMap().ToMap())
+	}
+}
+
+func TestPrefixMapBuilderCarve(t *testing.T) {
+	tests := []struct {
+		set     map[netip.Prefix]string
+		e, p    netip.Prefix
+		want    map[netip.Prefix]string
+		wantErr bool
+	}{
+		{
+			set:  map[netip.Prefix]string{pfx("::0/126"): "a"},
+			e:    pfx("::0/126"),
+			p:    pfx("::0/128"),
+			want: map[netip.Prefix]string{pfx("::1/128"): "a", pfx("::2/127"): "a"},
+		},
+		{
+			set:  map[netip.Prefix]string{pfx("::0/126"): "a"},
+			e:    pfx("::0/126"),
+			p:    pfx("::0/126"),
+			want: map[netip.Prefix]string{},
+		},
+		// Entries at and beneath p are left alone
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/126"): "a",
+				pfx("::0/127"): "b",
+				pfx("::0/128"): "c",
+			},
+			e: pfx("::0/126"),
+			p: pfx("::0/127"),
+			want: map[netip.Prefix]string{
+				pfx("::2/127"): "a",
+				pfx("::0/127"): "b",
+				pfx("::0/128"): "c",
+			},
+		},
+		// e is only split as far as the next entry on the path to p
+		{
+			set: map[netip.Prefix]string{
+				pfx("::0/126"): "a",
+				pfx("::0/127"): "b",
+			},
+			e: pfx("::0/126"),
+			p: pfx("::0/128"),
+			want: map[netip.Prefix]string{
+				pfx("::2/127"): "a",
+				pfx("::0/127"): "b",
+			},
+		},
+		// IPv4
+		{
+			set: map[netip.Prefix]string{pfx("10.0.0.0/8"): "a"},
+			e:   pfx("10.0.0.0/8"),
+			p:   pfx("10.128.0.0/10"),
+			want: map[netip.Prefix]string{
+				pfx("10.0.0.0/9"):    "a",
+				pfx("10.192.0.0/10"): "a",
+			},
+		},
+		// Errors
+		{
+			set:     map[netip.Prefix]string{pfx("::0/126"): "a"},
+			e:       pfx("::0/127"),
+			p:       pfx("::0/128"),
+			want:    map[netip.Prefix]string{pfx("::0/126"): "a"},
+			wantErr: true,
+		},
+		{
+			set:     map[netip.Prefix]string{pfx("::0/126"): "a"},
+			e:       pfx("::0/126"),
+			p:       pfx("::4/128"),
+			want:    map[netip.Prefix]string{pfx("::0/126"): "a"},
+			wantErr: true,
+		},
+		{
+			set:     map[netip.Prefix]string{pfx("::0/126"): "a"},
+			e:       pfx("::0/126"),
+			p:       netip.Prefix{},
+			want:    map[netip.Prefix]string{pfx("::0/126"): "a"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[string]{}
+		for p, v := range tt.set {
+			pmb.Set(p, v)
+		}
+		if err := pmb.Carve(tt.e, tt.p); (err != nil) != tt.wantErr {
+			t.Errorf("pmb.Carve(%s, %s) error = %v, want error %v", tt.e, tt.p, err, tt.wantErr)
+		}
+		checkMap(t, tt.want, pmb.PrefixMap().ToMap())
+	}
+}
+
+func TestPrefixMapBuilderCarveSet(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("10.0.0.0/8"), "allow")
+	if err := pmb.CarveSet(pfx("10.0.0.0/8"), pfx("10.0.0.0/10"), "deny"); err != nil {
+		t.Fatalf("pmb.CarveSet() error = %v", err)
+	}
+	checkMap(t, map[netip.Prefix]string{
+		pfx("10.0.0.0/10"):  "deny",
+		pfx("10.64.0.0/10"): "allow",
+		pfx("10.128.0.0/9"): "allow",
+	}, pmb.PrefixMap().ToMap())
+
+	if err := pmb.CarveSet(pfx("10.0.0.0/8"), pfx("10.0.0.0/10"), "deny"); err == nil {
+		t.Errorf("pmb.CarveSet() on a missing entry succeeded, want error")
+	}
+}
+
 func TestOverlapsPrefix(t *testing.T) {
 	tests := []struct {
 		set  []netip.Prefix
@@ -1046,6 +2008,59 @@ func TestOverlapsPrefix(t *testing.T) {
 	}
 }
 
+func TestPrefixMapKeySet(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		from []netip.Prefix
+		want []netip.Prefix
+	}{
+		{pfxs(), pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs("::0/127"), pfxs("::1/128")},
+		{pfxs("::0/128", "::1/128"), pfxs("::0/127"), pfxs()},
+		{pfxs("::0/128", "::2/128"), pfxs("::0/126"), pfxs("::1/128", "::3/128")},
+		{pfxs("1.2.0.0/16", "1.2.3.0/24"), pfxs("1.2.0.0/16"), pfxs()},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[string]{}
+		for _, p := range tt.set {
+			pmb.Set(p, p.String())
+		}
+		ks := pmb.PrefixMap().KeySet()
+		checkPrefixSlice(t, ks.Prefixes(), tt.set)
+		if ks.Size() != len(tt.set) {
+			t.Errorf("ks.Size() = %d, want %d", ks.Size(), len(tt.set))
+		}
+
+		// The key set can be subtracted from a set builder
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.from {
+			psb.Add(p)
+		}
+		psb.Subtract(ks)
+		checkPrefixSlice(t, psb.PrefixSet().Prefixes(), tt.want)
+	}
+}
+
+func TestPrefixMapWithin(t *testing.T) {
+	pmb := &PrefixMapBuilder[string]{}
+	pmb.Set(pfx("10.0.0.0/8"), "a")
+	pmb.Set(pfx("10.1.0.0/16"), "b")
+	pmb.Set(pfx("10.1.2.0/24"), "c")
+	pm := pmb.PrefixMap()
+
+	if !pm.EncompassesWithin(pfx("10.1.2.3/32"), 16, 16) {
+		t.Errorf("pm.EncompassesWithin(10.1.2.3/32, 16, 16) = false, want true")
+	}
+	if pm.EncompassesWithin(pfx("10.2.0.0/16"), 9, 32) {
+		t.Errorf("pm.EncompassesWithin(10.2.0.0/16, 9, 32) = true, want false")
+	}
+	checkMap(t,
+		map[netip.Prefix]string{pfx("10.1.0.0/16"): "b", pfx("10.1.2.0/24"): "c"},
+		pm.DescendantsOfWithin(pfx("10.0.0.0/8"), 9, 32).ToMap(),
+	)
+}
+
 func TestPrefixMapSize(t *testing.T) {
 	tests := []struct {
 		add  []netip.Prefix
@@ -1070,3 +2085,20 @@ func TestPrefixMapSize(t *testing.T) {
 		}
 	}
 }
+
+func TestPrefixMapBuilderCompact(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{TrackTimes: true}
+	for i, p := range pfxs("::0/128", "::1/128", "::2/128", "1.2.3.0/24") {
+		pmb.Set(p, i)
+	}
+	pmb.Filter(&PrefixSet{})
+	pmb.Set(pfx("::0/128"), 4)
+	pmb.Compact()
+	if got := countNodes(&pmb.tree); got != 2 {
+		t.Errorf("%d nodes after Compact, want 2", got)
+	}
+	if got := countNodes(&pmb.times); got != 2 {
+		t.Errorf("%d time nodes after Compact, want 2", got)
+	}
+	checkMap(t, map[netip.Prefix]int{pfx("::0/128"): 4}, pmb.PrefixMap().ToMap())
+}
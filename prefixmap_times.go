@@ -0,0 +1,113 @@
+package netipds
+
+import (
+	"net/netip"
+	"time"
+)
+
+// SetWithTime associates v with p and records t as the time at which the
+// entry was set, regardless of m.TrackTimes. This is useful when replaying
+// entries whose times are already known.
+func (m *PrefixMapBuilder[T]) SetWithTime(p netip.Prefix, v T, t time.Time) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	m.set(keyFromPrefix(p), v)
+	m.setTime(keyFromPrefix(p), t)
+	return nil
+}
+
+// RemoveOlderThan removes every entry from m that was set before cutoff.
+// Entries without a recorded time are considered to have been set at the
+// zero time.
+func (m *PrefixMapBuilder[T]) RemoveOlderThan(cutoff time.Time) {
+	m.snapshot()
+	m.tree.filterFunc(func(k key, _ T) bool {
+		t, _ := m.times.get(k)
+		if t.Before(cutoff) {
+			m.times.remove(k)
+			return false
+		}
+		return true
+	})
+	m.lens = countLens(&m.tree)
+}
+
+func (m *PrefixMapBuilder[T]) setTime(k key, t time.Time) {
+	m.times = *(m.times.insert(k, t))
+}
+
+// GetWithTime is like [PrefixMap.Get], but also returns the time at which the
+// entry was set. The time is zero if the entry's time was not recorded (see
+// [PrefixMapBuilder.TrackTimes]).
+func (m *PrefixMap[T]) GetWithTime(p netip.Prefix) (T, time.Time, bool) {
+	v, ok := m.tree.get(keyFromPrefix(p))
+	if !ok {
+		return v, time.Time{}, false
+	}
+	return v, m.entryTime(keyFromPrefix(p)), true
+}
+
+// OlderThan returns a PrefixMap containing the entries of m that were set
+// before cutoff. Entries without a recorded time are considered to have been
+// set at the zero time.
+func (m *PrefixMap[T]) OlderThan(cutoff time.Time) *PrefixMap[T] {
+	return m.filterTimes(func(t time.Time) bool { return t.Before(cutoff) })
+}
+
+// NewerThan returns a PrefixMap containing the entries of m that were set
+// after cutoff.
+func (m *PrefixMap[T]) NewerThan(cutoff time.Time) *PrefixMap[T] {
+	return m.filterTimes(func(t time.Time) bool { return t.After(cutoff) })
+}
+
+func (m *PrefixMap[T]) entryTime(k key) time.Time {
+	if m.times == nil {
+		return time.Time{}
+	}
+	t, _ := m.times.get(k)
+	return t
+}
+
+// filterTimes returns a PrefixMap containing the entries of m whose times
+// satisfy fn.
+func (m *PrefixMap[T]) filterTimes(fn func(time.Time) bool) *PrefixMap[T] {
+	ret := &tree[T]{}
+	m.tree.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry && fn(m.entryTime(n.key)) {
+			ret = ret.insert(n.key, n.value)
+		}
+		return false
+	})
+	return &PrefixMap[T]{*ret, ret.stats(), m.times}
+}
+
+// touch updates the time of the entry at k, which was just set, according to
+// m.TrackTimes.
+func (m *PrefixMapBuilder[T]) touch(k key, now time.Time) {
+	if m.TrackTimes {
+		m.setTime(k, now)
+	} else {
+		m.times.remove(k)
+	}
+}
+
+// touchFunc wraps fn, which decides the values of entries created by a tree
+// operation, so that the times of those entries are updated as in Set.
+func (m *PrefixMapBuilder[T]) touchFunc(fn func(key, T) (T, bool)) func(key, T) (T, bool) {
+	now := time.Now()
+	return func(k key, v T) (T, bool) {
+		v, ok := fn(k, v)
+		if ok {
+			m.touch(k, now)
+		}
+		return v, ok
+	}
+}
+
+// dropStaleTimes removes the times of Prefixes that are no longer in m.
+func (m *PrefixMapBuilder[T]) dropStaleTimes() {
+	m.times.filterFunc(func(k key, _ time.Time) bool {
+		return m.tree.contains(k)
+	})
+}
@@ -0,0 +1,159 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPrefixMapTimes(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	t3 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.SetWithTime(pfx("1.0.0.0/8"), 1, t1)
+	pmb.SetWithTime(pfx("1.2.0.0/16"), 2, t2)
+	pmb.SetWithTime(pfx("2.0.0.0/8"), 3, t3)
+	// Untracked Set clears any recorded time
+	pmb.SetWithTime(pfx("3.0.0.0/8"), 0, t3)
+	pmb.Set(pfx("3.0.0.0/8"), 4)
+	// Removed entries lose their times
+	pmb.SetWithTime(pfx("4.0.0.0/8"), 0, t3)
+	pmb.Remove(pfx("4.0.0.0/8"))
+	pmb.Set(pfx("4.0.0.0/8"), 5)
+	pm := pmb.PrefixMap()
+
+	getTests := []struct {
+		get      string
+		wantVal  int
+		wantTime time.Time
+		wantOK   bool
+	}{
+		{"1.0.0.0/8", 1, t1, true},
+		{"1.2.0.0/16", 2, t2, true},
+		{"2.0.0.0/8", 3, t3, true},
+		{"3.0.0.0/8", 4, time.Time{}, true},
+		{"4.0.0.0/8", 5, time.Time{}, true},
+		{"5.0.0.0/8", 0, time.Time{}, false},
+	}
+	for _, tt := range getTests {
+		v, tm, ok := pm.GetWithTime(pfx(tt.get))
+		if v != tt.wantVal || !tm.Equal(tt.wantTime) || ok != tt.wantOK {
+			t.Errorf("GetWithTime(%s) = (%v, %v, %v), want (%v, %v, %v)",
+				tt.get, v, tm, ok, tt.wantVal, tt.wantTime, tt.wantOK)
+		}
+	}
+
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.0.0.0/8"): 1,
+		pfx("3.0.0.0/8"): 4,
+		pfx("4.0.0.0/8"): 5,
+	}, pm.OlderThan(t2).ToMap())
+	checkMap(t, map[netip.Prefix]int{
+		pfx("2.0.0.0/8"): 3,
+	}, pm.NewerThan(t2).ToMap())
+
+	// Derived maps keep their times
+	if _, tm, _ := pm.DescendantsOf(pfx("1.0.0.0/8")).GetWithTime(pfx("1.2.0.0/16")); !tm.Equal(t2) {
+		t.Errorf("DescendantsOf: got time %v, want %v", tm, t2)
+	}
+
+	pmb.RemoveOlderThan(t2)
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.2.0.0/16"): 2,
+		pfx("2.0.0.0/8"):  3,
+	}, pmb.PrefixMap().ToMap())
+}
+
+func TestPrefixMapTrackTimes(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{TrackTimes: true, Lazy: true}
+	before := time.Now()
+	pmb.Set(pfx("1.2.3.0/24"), 1)
+	after := time.Now()
+	pm := pmb.PrefixMap()
+
+	_, tm, ok := pm.GetWithTime(pfx("1.2.3.0/24"))
+	if !ok || tm.Before(before) || tm.After(after) {
+		t.Errorf("GetWithTime = (%v, %v), want time in [%v, %v]", tm, ok, before, after)
+	}
+}
+
+func TestPrefixMapTrackTimesSplit(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	keepValue := func(_ netip.Prefix, v int) (int, bool) { return v, true }
+	o := &PrefixMapBuilder[int]{}
+	o.Set(pfx("10.0.0.0/12"), 7)
+	o.Set(pfx("20.0.0.0/8"), 8)
+	tests := []struct {
+		name string
+		op   func(*PrefixMapBuilder[int])
+		// wantOld holds the entries which keep their original times; all
+		// others are new or rewritten
+		wantOld []string
+	}{
+		{"Carve", func(m *PrefixMapBuilder[int]) {
+			m.Carve(pfx("10.0.0.0/8"), pfx("10.0.0.0/16"))
+		}, []string{"10.1.0.0/16", "20.0.0.0/8"}},
+		{"CarveSet", func(m *PrefixMapBuilder[int]) {
+			m.CarveSet(pfx("10.0.0.0/8"), pfx("10.0.0.0/16"), 9)
+		}, []string{"10.1.0.0/16", "20.0.0.0/8"}},
+		{"SubtractPrefix", func(m *PrefixMapBuilder[int]) {
+			m.SubtractPrefix(pfx("10.0.0.0/16"), keepValue)
+		}, []string{"10.1.0.0/16", "20.0.0.0/8"}},
+		{"Subtract", func(m *PrefixMapBuilder[int]) {
+			psb := &PrefixSetBuilder{}
+			psb.Add(pfx("10.0.0.0/16"))
+			m.Subtract(psb.PrefixSet(), keepValue)
+		}, []string{"10.1.0.0/16", "20.0.0.0/8"}},
+		{"IntersectFunc", func(m *PrefixMapBuilder[int]) {
+			m.IntersectFunc(o.PrefixMap(), func(a, b int) (int, bool) { return a, true })
+		}, nil},
+	}
+	for _, tt := range tests {
+		for _, track := range []bool{true, false} {
+			pmb := &PrefixMapBuilder[int]{TrackTimes: track}
+			pmb.SetWithTime(pfx("10.0.0.0/8"), 1, t1)
+			pmb.SetWithTime(pfx("10.1.0.0/16"), 2, t1)
+			pmb.SetWithTime(pfx("20.0.0.0/8"), 3, t1)
+			before := time.Now()
+			tt.op(pmb)
+
+			old := make(map[netip.Prefix]bool)
+			for _, p := range tt.wantOld {
+				old[pfx(p)] = true
+			}
+			pm := pmb.PrefixMap()
+			if pm.Size() <= len(old) {
+				t.Fatalf("%s: got %v, want new entries", tt.name, pm.ToMap())
+			}
+			for p := range pm.ToMap() {
+				_, tm, _ := pm.GetWithTime(p)
+				switch {
+				case old[p]:
+					if !tm.Equal(t1) {
+						t.Errorf("%s (TrackTimes %v): time of %v = %v, want %v", tt.name, track, p, tm, t1)
+					}
+				case track && tm.Before(before):
+					t.Errorf("%s (TrackTimes %v): time of %v = %v, want after %v", tt.name, track, p, tm, before)
+				case !track && !tm.IsZero():
+					t.Errorf("%s (TrackTimes %v): time of %v = %v, want zero", tt.name, track, p, tm)
+				}
+			}
+			pmb.times.walk(key{}, func(n *tree[time.Time]) bool {
+				if n.hasEntry && !pmb.tree.contains(n.key) {
+					t.Errorf("%s (TrackTimes %v): stale time for %v", tt.name, track, n.key.toPrefix())
+				}
+				return false
+			})
+
+			// New entries survive the removal of older ones
+			if track {
+				pmb.RemoveOlderThan(before)
+				if got, want := pmb.PrefixMap().Size(), pm.Size()-len(old); got != want {
+					t.Errorf("%s: RemoveOlderThan() left %d entries, want %d", tt.name, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package netipds
+
+import (
+	"net/netip"
+)
+
+// PrefixMultiMapBuilder builds an immutable [PrefixMultiMap].
+//
+// The zero value is a valid PrefixMultiMapBuilder representing a builder with
+// zero Prefixes.
+//
+// If Lazy == true, then path compression is delayed until a PrefixMultiMap is
+// created, as with [PrefixMapBuilder].
+type PrefixMultiMapBuilder[T any] struct {
+	Lazy bool
+	tree tree[[]T]
+}
+
+// Add appends v to the values associated with p.
+func (m *PrefixMultiMapBuilder[T]) Add(p netip.Prefix, v T) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	k := keyFromPrefix(p)
+	// Values are only ever appended, so PrefixMultiMaps built earlier, which
+	// share the underlying arrays, never see the new values.
+	vals, _ := m.tree.get(k)
+	if m.Lazy {
+		m.tree = *m.tree.insertLazy(k, append(vals, v))
+	} else {
+		m.tree = *m.tree.insert(k, append(vals, v))
+	}
+	return nil
+}
+
+// Get returns the values associated with the exact Prefix provided, if any.
+// The returned slice must not be modified.
+func (m *PrefixMultiMapBuilder[T]) Get(p netip.Prefix) []T {
+	vals, _ := m.tree.get(keyFromPrefix(p))
+	return vals
+}
+
+// Remove removes p and all of its values from m. Only the exact Prefix
+// provided is removed; descendants are not.
+func (m *PrefixMultiMapBuilder[T]) Remove(p netip.Prefix) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	m.tree.remove(keyFromPrefix(p))
+	return nil
+}
+
+// PrefixMultiMap returns an immutable PrefixMultiMap representing the current
+// state of m. The values are not copied.
+//
+// The builder remains usable after calling PrefixMultiMap.
+func (m *PrefixMultiMapBuilder[T]) PrefixMultiMap() *PrefixMultiMap[T] {
+	var t *tree[[]T]
+	if m.Lazy {
+		t = m.tree.compressedCopy()
+	} else {
+		t = m.tree.copy()
+	}
+	return &PrefixMultiMap[T]{*t, t.stats()}
+}
+
+// PrefixMultiMap is a map of [netip.Prefix] to one or more values of type T,
+// kept in the order they were added.
+//
+// Use [PrefixMultiMapBuilder] to construct PrefixMultiMaps.
+type PrefixMultiMap[T any] struct {
+	tree  tree[[]T]
+	stats prefixStats
+}
+
+// Get returns the values associated with the exact Prefix provided, if any.
+// The returned slice must not be modified.
+func (m *PrefixMultiMap[T]) Get(p netip.Prefix) []T {
+	vals, _ := m.tree.get(keyFromPrefix(p))
+	return vals
+}
+
+// LookupAll returns every value associated with a Prefix in m which
+// encompasses p, including p itself. The entries are ordered from the
+// shortest Prefix to the longest, and then by the order in which the values
+// were added.
+func (m *PrefixMultiMap[T]) LookupAll(p netip.Prefix) []PrefixEntry[T] {
+	if !p.IsValid() {
+		return nil
+	}
+	return m.lookupAll(keyFromPrefix(p))
+}
+
+// LookupAllAddr is like [PrefixMultiMap.LookupAll], but returns the values of
+// the Prefixes which contain a. a's zone, if any, is ignored.
+func (m *PrefixMultiMap[T]) LookupAllAddr(a netip.Addr) []PrefixEntry[T] {
+	if !a.IsValid() {
+		return nil
+	}
+	return m.lookupAll(keyFromAddr(a))
+}
+
+func (m *PrefixMultiMap[T]) lookupAll(k key) []PrefixEntry[T] {
+	var ret []PrefixEntry[T]
+	for n := m.tree.pathNext(k); n != nil && n.key.isPrefixOf(k, false); n = n.pathNext(k) {
+		if n.hasEntry {
+			p := n.key.toPrefix()
+			for _, v := range n.value {
+				ret = append(ret, PrefixEntry[T]{p, v})
+			}
+		}
+	}
+	return ret
+}
+
+// ToMap returns a map of all Prefixes in m to their associated values. The
+// values are not copied and must not be modified.
+func (m *PrefixMultiMap[T]) ToMap() map[netip.Prefix][]T {
+	res := make(map[netip.Prefix][]T, m.stats.size())
+	m.tree.walk(key{}, func(n *tree[[]T]) bool {
+		if n.hasEntry {
+			res[n.key.toPrefix()] = n.value
+		}
+		return false
+	})
+	return res
+}
+
+// Size returns the number of Prefixes in m.
+func (m *PrefixMultiMap[T]) Size() int {
+	return m.stats.size()
+}
@@ -0,0 +1,79 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestPrefixMultiMap(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		b := &PrefixMultiMapBuilder[string]{Lazy: lazy}
+		b.Add(pfx("1.0.0.0/8"), "a")
+		b.Add(pfx("1.2.0.0/16"), "b")
+		b.Add(pfx("1.0.0.0/8"), "c")
+		b.Add(pfx("1.2.3.0/24"), "d")
+		b.Add(pfx("2000::/3"), "e")
+		b.Add(pfx("3.0.0.0/8"), "f")
+		b.Remove(pfx("3.0.0.0/8"))
+		if err := b.Add(netip.Prefix{}, "x"); err == nil {
+			t.Error("Add(invalid) returned nil error")
+		}
+		m := b.PrefixMultiMap()
+
+		if got := m.Size(); got != 4 {
+			t.Errorf("lazy=%v: Size() = %d, want 4", lazy, got)
+		}
+		if got := m.Get(pfx("1.0.0.0/8")); !slices.Equal(got, []string{"a", "c"}) {
+			t.Errorf("lazy=%v: Get(1.0.0.0/8) = %v, want [a c]", lazy, got)
+		}
+		if got := m.Get(pfx("3.0.0.0/8")); got != nil {
+			t.Errorf("lazy=%v: Get(3.0.0.0/8) = %v, want nil", lazy, got)
+		}
+
+		tests := []struct {
+			get  string
+			want []PrefixEntry[string]
+		}{
+			{"1.2.3.4", []PrefixEntry[string]{
+				{pfx("1.0.0.0/8"), "a"},
+				{pfx("1.0.0.0/8"), "c"},
+				{pfx("1.2.0.0/16"), "b"},
+				{pfx("1.2.3.0/24"), "d"},
+			}},
+			{"1.3.0.0", []PrefixEntry[string]{
+				{pfx("1.0.0.0/8"), "a"},
+				{pfx("1.0.0.0/8"), "c"},
+			}},
+			{"3.0.0.1", nil},
+			{"2001::1", []PrefixEntry[string]{{pfx("2000::/3"), "e"}}},
+		}
+		for _, tt := range tests {
+			if got := m.LookupAllAddr(netip.MustParseAddr(tt.get)); !slices.Equal(got, tt.want) {
+				t.Errorf("lazy=%v: LookupAllAddr(%s) = %v, want %v", lazy, tt.get, got, tt.want)
+			}
+		}
+		if got := m.LookupAll(pfx("1.2.0.0/16")); !slices.Equal(got, []PrefixEntry[string]{
+			{pfx("1.0.0.0/8"), "a"},
+			{pfx("1.0.0.0/8"), "c"},
+			{pfx("1.2.0.0/16"), "b"},
+		}) {
+			t.Errorf("lazy=%v: LookupAll(1.2.0.0/16) = %v", lazy, got)
+		}
+		if got := m.LookupAllAddr(netip.Addr{}); got != nil {
+			t.Errorf("lazy=%v: LookupAllAddr(invalid) = %v, want nil", lazy, got)
+		}
+
+		// Later changes to the builder don't affect m
+		b.Add(pfx("1.0.0.0/8"), "g")
+		b.Remove(pfx("1.2.0.0/16"))
+		if got := m.ToMap(); len(got) != 4 ||
+			!slices.Equal(got[pfx("1.0.0.0/8")], []string{"a", "c"}) ||
+			!slices.Equal(got[pfx("1.2.0.0/16")], []string{"b"}) {
+			t.Errorf("lazy=%v: PrefixMultiMap was modified by its builder: %v", lazy, got)
+		}
+		if got := b.Get(pfx("1.0.0.0/8")); !slices.Equal(got, []string{"a", "c", "g"}) {
+			t.Errorf("lazy=%v: builder Get(1.0.0.0/8) = %v, want [a c g]", lazy, got)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package netipds
 
 import (
-	"fmt"
+	"math"
+	"math/big"
 	"net/netip"
 )
 
@@ -15,22 +16,51 @@ import (
 // If Lazy == true, then path compression is delayed until a PrefixSet is
 // created. The builder itself remains uncompressed. Lazy mode can dramatically
 // improve performance when building large PrefixSets.
+//
+// Builders can be combined with one another directly using methods like
+// [PrefixSetBuilder.MergeBuilder]. When the argument is a lazy builder, a
+// compressed copy of its tree is made first.
+//
+// Calling PrefixSet on a non-lazy builder takes constant time: the PrefixSet
+// shares the builder's tree, and the builder copies only the nodes it
+// modifies afterwards.
 type PrefixSetBuilder struct {
 	Lazy bool
 	tree tree[bool]
+	lens lenCounts
+	// If shared, then the nodes of s.tree that don't belong to generation gen
+	// may be shared with PrefixSets, so they must not be modified in place.
+	gen    uint16
+	shared bool
+	// txn is the transaction in progress, if any
+	txn *txn[PrefixSetBuilder, bool]
 }
 
 // Add adds p to s.
 func (s *PrefixSetBuilder) Add(p netip.Prefix) error {
 	if !p.IsValid() {
-		return fmt.Errorf("Prefix is not valid: %v", p)
+		return invalidPrefixError(p)
+	}
+	s.add(keyFromPrefix(p))
+	return nil
+}
+
+func (s *PrefixSetBuilder) add(k key) {
+	s.logKey(k)
+	if !s.tree.contains(k) {
+		s.lens.add(k, 1)
+	}
+	if s.shared {
+		s.tree.ownPath(k, s.gen)
 	}
 	if s.Lazy {
-		s.tree = *(s.tree.insertLazy(keyFromPrefix(p), true))
+		s.tree = *(s.tree.insertLazy(k, true))
 	} else {
-		s.tree = *(s.tree.insert(keyFromPrefix(p), true))
+		s.tree = *(s.tree.insert(k, true))
+	}
+	if s.shared {
+		s.tree.stampPath(k, s.gen)
 	}
-	return nil
 }
 
 // Remove removes p from s. Only the exact Prefix provided is removed;
@@ -41,15 +71,37 @@ func (s *PrefixSetBuilder) Add(p netip.Prefix) error {
 // [PrefixSetBuilder.SubtractPrefix].
 func (s *PrefixSetBuilder) Remove(p netip.Prefix) error {
 	if !p.IsValid() {
-		return fmt.Errorf("Prefix is not valid: %v", p)
+		return invalidPrefixError(p)
 	}
-	s.tree.remove(keyFromPrefix(p))
+	s.remove(keyFromPrefix(p))
 	return nil
 }
 
+func (s *PrefixSetBuilder) remove(k key) {
+	s.logKey(k)
+	if s.tree.contains(k) {
+		s.lens.add(k, -1)
+	}
+	if s.shared {
+		s.tree.ownPath(k, s.gen)
+	}
+	s.tree.remove(k)
+}
+
 // Filter removes all Prefixes that are not encompassed by o from s.
 func (s *PrefixSetBuilder) Filter(o *PrefixSet) {
+	s.own()
 	s.tree.filter(&o.tree)
+	s.lens = countLens(&s.tree)
+}
+
+// FilterFunc removes all Prefixes for which fn returns false from s.
+func (s *PrefixSetBuilder) FilterFunc(fn func(netip.Prefix) bool) {
+	s.own()
+	s.tree.filterFunc(func(k key, _ bool) bool {
+		return fn(k.toPrefix())
+	})
+	s.lens = countLens(&s.tree)
 }
 
 // SubtractPrefix modifies s so that p and all of its descendants are removed,
@@ -60,9 +112,11 @@ func (s *PrefixSetBuilder) Filter(o *PrefixSet) {
 // {::1/128, ::2/127}.
 func (s *PrefixSetBuilder) SubtractPrefix(p netip.Prefix) error {
 	if !p.IsValid() {
-		return fmt.Errorf("Prefix is not valid: %v", p)
+		return invalidPrefixError(p)
 	}
+	s.own()
 	s.tree.subtractKey(keyFromPrefix(p))
+	s.lens = countLens(&s.tree)
 	return nil
 }
 
@@ -74,30 +128,178 @@ func (s *PrefixSetBuilder) SubtractPrefix(p netip.Prefix) error {
 // For example, if s is {::0/126}, and we subtract ::0/128, then s will become
 // {::1/128, ::2/127}.
 func (s *PrefixSetBuilder) Subtract(o *PrefixSet) {
+	s.own()
 	s.tree = *s.tree.subtractTree(&o.tree)
+	s.lens = countLens(&s.tree)
 }
 
 // Intersect modifies s so that it contains the intersection of the entries
 // in s and o: to be included in the result, a Prefix must either (a) exist in
 // both sets or (b) exist in one set and have an ancestor in the other.
 func (s *PrefixSetBuilder) Intersect(o *PrefixSet) {
+	s.own()
 	s.tree = *s.tree.intersectTree(&o.tree)
+	s.lens = countLens(&s.tree)
 }
 
 // Merge modifies s so that it contains the union of the entries in s and o.
 func (s *PrefixSetBuilder) Merge(o *PrefixSet) {
+	s.own()
 	s.tree = *s.tree.mergeTree(&o.tree)
+	s.lens = countLens(&s.tree)
+}
+
+// SymmetricDifference modifies s so that it covers exactly the addresses
+// covered by either s or o, but not both, using the fewest Prefixes possible.
+// Unlike the other set operations, this considers only the addresses covered,
+// so nested Prefixes in s and o are not preserved.
+//
+// For example, if s is {::0/126} and o is {::1/128, ::4/127}, then s will
+// become {::0/128, ::2/127, ::4/127}.
+func (s *PrefixSetBuilder) SymmetricDifference(o *PrefixSet) {
+	s.own()
+	s.tree = *symmetricDifference(&s.tree, &o.tree)
+	s.lens = countLens(&s.tree)
+}
+
+// FilterBuilder is like [PrefixSetBuilder.Filter], but accepts another
+// builder, which is left unchanged.
+func (s *PrefixSetBuilder) FilterBuilder(o *PrefixSetBuilder) {
+	s.own()
+	s.tree.filter(o.compressedTree())
+	s.lens = countLens(&s.tree)
+}
+
+// SubtractBuilder is like [PrefixSetBuilder.Subtract], but accepts another
+// builder, which is left unchanged.
+func (s *PrefixSetBuilder) SubtractBuilder(o *PrefixSetBuilder) {
+	s.own()
+	s.tree = *s.tree.subtractTree(o.compressedTree())
+	s.lens = countLens(&s.tree)
+}
+
+// IntersectBuilder is like [PrefixSetBuilder.Intersect], but accepts another
+// builder, which is left unchanged.
+func (s *PrefixSetBuilder) IntersectBuilder(o *PrefixSetBuilder) {
+	s.own()
+	s.tree = *s.tree.intersectTree(o.compressedTree())
+	s.lens = countLens(&s.tree)
+}
+
+// MergeBuilder is like [PrefixSetBuilder.Merge], but accepts another builder,
+// which is left unchanged.
+func (s *PrefixSetBuilder) MergeBuilder(o *PrefixSetBuilder) {
+	s.own()
+	s.tree = *s.tree.mergeTree(o.compressedTree())
+	s.lens = countLens(&s.tree)
+}
+
+// SymmetricDifferenceBuilder is like [PrefixSetBuilder.SymmetricDifference],
+// but accepts another builder, which is left unchanged.
+func (s *PrefixSetBuilder) SymmetricDifferenceBuilder(o *PrefixSetBuilder) {
+	s.own()
+	s.tree = *symmetricDifference(&s.tree, &o.tree)
+	s.lens = countLens(&s.tree)
+}
+
+// Compact removes nodes from s's tree which no longer lead to any Prefix, such
+// as those left behind by Remove. Long-lived builders whose Prefixes change
+// over time can call it periodically to reclaim memory. If s is lazy, its
+// tree is not compressed.
+func (s *PrefixSetBuilder) Compact() {
+	s.own()
+	s.tree.prune(!s.Lazy)
+}
+
+// Compress performs path compression on s's tree, and removes nodes which no
+// longer lead to any Prefix. It is mainly useful when s is lazy: the time
+// saved by lazy insertion comes at the cost of memory, which Compress
+// reclaims. s remains lazy, and later insertions are not compressed.
+//
+// To compress only the part of the tree that has finished loading, see
+// [PrefixSetBuilder.CompressPrefix].
+func (s *PrefixSetBuilder) Compress() {
+	s.own()
+	s.tree.prune(true)
+}
+
+// CompressPrefix is like [PrefixSetBuilder.Compress], but only compresses
+// the part of s's tree beneath p, including p itself. When loading a large,
+// sorted feed into a lazy builder, calling CompressPrefix on each block of
+// address space once it has been loaded bounds the memory held by
+// uncompressed nodes.
+func (s *PrefixSetBuilder) CompressPrefix(p netip.Prefix) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	s.own()
+	s.tree.compressBelow(keyFromPrefix(p))
+	return nil
+}
+
+// own gives s exclusive ownership of all of its tree's nodes, copying the tree
+// if it may be shared with a PrefixSet. It must be called before s.tree is
+// modified other than by Add or Remove.
+func (s *PrefixSetBuilder) own() {
+	s.snapshot()
+	if s.shared {
+		s.tree = *s.tree.copy()
+		s.shared = false
+	}
+}
+
+// compressedTree returns s's tree if s is not lazy, or a compressed copy of it
+// otherwise.
+func (s *PrefixSetBuilder) compressedTree() *tree[bool] {
+	if s.Lazy {
+		return s.tree.compressedCopy()
+	}
+	return &s.tree
 }
 
 // PrefixSet returns an immutable PrefixSet representing the current state of s.
 //
 // The builder remains usable after calling PrefixSet.
 func (s *PrefixSetBuilder) PrefixSet() *PrefixSet {
-	t := s.tree.copy()
-	if s.Lazy && t != nil {
-		t = t.compress()
+	if s.Lazy {
+		t := s.tree.compressedCopy()
+		return &PrefixSet{*t, t.stats()}
 	}
-	return &PrefixSet{*t, t.size()}
+	s.share()
+	ret := &PrefixSet{s.tree, s.lens.stats()}
+	s.tree.gen = s.gen
+	return ret
+}
+
+// share starts a new generation of s, so that the nodes of s's tree which are
+// about to be shared are copied before being modified. Callers sharing s.tree
+// must take their own copy of its root node before marking s's root as
+// belonging to the new generation.
+//
+// If the generations run out, s gets a fresh copy of its tree, which shares
+// nothing, and starts over.
+func (s *PrefixSetBuilder) share() {
+	if s.gen == math.MaxUint16 {
+		s.tree = *s.tree.copy()
+		s.gen = 0
+	}
+	s.gen++
+	s.shared = true
+}
+
+// clone returns a builder with the same settings and contents as s, which can
+// be modified without affecting s. Unless s is lazy, the two builders share
+// their nodes until they modify them, as with PrefixSet.
+func (s *PrefixSetBuilder) clone() *PrefixSetBuilder {
+	if s.Lazy {
+		return &PrefixSetBuilder{Lazy: true, tree: *s.tree.copy(), lens: s.lens}
+	}
+	s.share()
+	ret := *s
+	ret.txn = nil
+	s.tree.gen = s.gen
+	ret.tree.gen = s.gen
+	return &ret
 }
 
 // String returns a human-readable representation of s's tree structure.
@@ -115,8 +317,8 @@ func (s *PrefixSetBuilder) String() string {
 //
 // Use [PrefixSetBuilder] to construct PrefixSets.
 type PrefixSet struct {
-	tree tree[bool]
-	size int
+	tree  tree[bool]
+	stats prefixStats
 }
 
 // Contains returns true if this set includes the exact Prefix provided.
@@ -137,11 +339,52 @@ func (s *PrefixSet) EncompassesStrict(p netip.Prefix) bool {
 	return s.tree.encompasses(keyFromPrefix(p), true)
 }
 
+// EncompassesWithin is like [PrefixSet.Encompasses], but only considers
+// Prefixes in s whose lengths are between minBits and maxBits (inclusive).
+func (s *PrefixSet) EncompassesWithin(p netip.Prefix, minBits, maxBits int) bool {
+	lo, hi := lenBounds(p, minBits, maxBits)
+	return s.tree.encompassesWithin(keyFromPrefix(p), lo, hi)
+}
+
+// ContainsAddr returns true if a is contained by any Prefix in s. a's zone,
+// if any, is ignored.
+//
+// Since an address is contained by every Prefix that encompasses it,
+// ContainsAddr is equivalent to [PrefixSet.EncompassesAddr]; the name matches
+// that of netipx.IPSet.Contains.
+//
+// ContainsAddr does not allocate.
+func (s *PrefixSet) ContainsAddr(a netip.Addr) bool {
+	return s.EncompassesAddr(a)
+}
+
+// EncompassesAddr returns true if s includes a Prefix which encompasses a.
+// It is equivalent to calling [PrefixSet.Encompasses] with the single-address
+// Prefix of a, but a's zone, if any, is ignored.
+//
+// EncompassesAddr does not allocate.
+func (s *PrefixSet) EncompassesAddr(a netip.Addr) bool {
+	if !a.IsValid() {
+		return false
+	}
+	return s.tree.longestMatch(keyFromAddr(a)) != nil
+}
+
 // OverlapsPrefix returns true if this set includes a Prefix which overlaps p.
 func (s *PrefixSet) OverlapsPrefix(p netip.Prefix) bool {
 	return s.tree.overlapsKey(keyFromPrefix(p))
 }
 
+// OverlappingWith returns the Prefixes in s which overlap p, i.e. p itself
+// and its ancestors and descendants, in order.
+func (s *PrefixSet) OverlappingWith(p netip.Prefix) []netip.Prefix {
+	var res []netip.Prefix
+	s.tree.overlappingKeys(keyFromPrefix(p), func(n *tree[bool]) {
+		res = append(res, n.key.toPrefix())
+	})
+	return res
+}
+
 func (s *PrefixSet) rootOf(
 	p netip.Prefix,
 	strict bool,
@@ -193,33 +436,43 @@ func (s *PrefixSet) ParentOfStrict(p netip.Prefix) (netip.Prefix, bool) {
 // including p itself if it has an entry.
 func (s *PrefixSet) DescendantsOf(p netip.Prefix) *PrefixSet {
 	t := s.tree.descendantsOf(keyFromPrefix(p), false)
-	return &PrefixSet{*t, t.size()}
+	return &PrefixSet{*t, t.stats()}
 }
 
 // DescendantsOfStrict returns a PrefixSet containing all descendants of p in
 // s, excluding p itself.
 func (s *PrefixSet) DescendantsOfStrict(p netip.Prefix) *PrefixSet {
 	t := s.tree.descendantsOf(keyFromPrefix(p), true)
-	return &PrefixSet{*t, t.size()}
+	return &PrefixSet{*t, t.stats()}
+}
+
+// DescendantsOfWithin returns a PrefixSet containing the descendants of p in
+// s, including p itself, whose lengths are between minBits and maxBits
+// (inclusive). For example, DescendantsOfWithin(10.0.0.0/8, 20, 24) returns
+// the Prefixes in s from 10.0.0.0/20 through 10.255.255.0/24.
+func (s *PrefixSet) DescendantsOfWithin(p netip.Prefix, minBits, maxBits int) *PrefixSet {
+	lo, hi := lenBounds(p, minBits, maxBits)
+	t := s.tree.descendantsWithin(keyFromPrefix(p), lo, hi)
+	return &PrefixSet{*t, t.stats()}
 }
 
 // AncestorsOf returns a PrefixSet containing all ancestors of p in s,
 // including p itself if it has an entry.
 func (s *PrefixSet) AncestorsOf(p netip.Prefix) *PrefixSet {
 	t := s.tree.ancestorsOf(keyFromPrefix(p), false)
-	return &PrefixSet{*t, t.size()}
+	return &PrefixSet{*t, t.stats()}
 }
 
 // AncestorsOfStrict returns a PrefixSet containing all ancestors of p in s,
 // excluding p itself.
 func (s *PrefixSet) AncestorsOfStrict(p netip.Prefix) *PrefixSet {
 	t := s.tree.ancestorsOf(keyFromPrefix(p), true)
-	return &PrefixSet{*t, t.size()}
+	return &PrefixSet{*t, t.stats()}
 }
 
 // Prefixes returns a slice of all Prefixes in s.
 func (s *PrefixSet) Prefixes() []netip.Prefix {
-	res := make([]netip.Prefix, s.size)
+	res := make([]netip.Prefix, s.stats.size())
 	i := 0
 	s.tree.walk(key{}, func(n *tree[bool]) bool {
 		if n.hasEntry {
@@ -235,9 +488,10 @@ func (s *PrefixSet) Prefixes() []netip.Prefix {
 // children of other Prefixes in s.
 //
 // Note: PrefixCompact does not merge siblings, so the result may contain
-// complete sets of sibling prefixes, e.g. 1.2.3.0/32 and 1.2.3.1/32.
+// complete sets of sibling prefixes, e.g. 1.2.3.0/32 and 1.2.3.1/32. To merge
+// them, see [PrefixSet.PrefixesAggregated].
 func (s *PrefixSet) PrefixesCompact() []netip.Prefix {
-	res := make([]netip.Prefix, 0, s.size)
+	res := make([]netip.Prefix, 0, s.stats.size())
 	s.tree.walk(key{}, func(n *tree[bool]) bool {
 		if n.hasEntry {
 			res = append(res, n.key.toPrefix())
@@ -248,6 +502,27 @@ func (s *PrefixSet) PrefixesCompact() []netip.Prefix {
 	return res
 }
 
+// Roots returns the Prefixes in s that have no ancestor in s, in order. It is
+// equivalent to [PrefixSet.PrefixesCompact].
+func (s *PrefixSet) Roots() []netip.Prefix {
+	var res []netip.Prefix
+	s.tree.eachRoot(func(n *tree[bool]) bool {
+		res = append(res, n.key.toPrefix())
+		return true
+	})
+	return res
+}
+
+// Leaves returns the Prefixes in s that have no descendants in s, in order.
+func (s *PrefixSet) Leaves() []netip.Prefix {
+	var res []netip.Prefix
+	s.tree.eachLeaf(func(n *tree[bool]) bool {
+		res = append(res, n.key.toPrefix())
+		return true
+	})
+	return res
+}
+
 // String returns a human-readable representation of the s's tree structure.
 func (s *PrefixSet) String() string {
 	return s.tree.stringImpl("", "", true)
@@ -255,5 +530,67 @@ func (s *PrefixSet) String() string {
 
 // Size returns the number of elements in s.
 func (s *PrefixSet) Size() int {
-	return s.size
+	return s.stats.size()
+}
+
+// AddressCount returns the number of addresses covered by s. Addresses
+// covered by more than one Prefix are counted once, so nested Prefixes do
+// not contribute to the count.
+func (s *PrefixSet) AddressCount() *big.Int {
+	n := new(big.Int)
+	for _, r := range s.tree.coverage() {
+		n.Add(n, rangeSize(r))
+	}
+	return n
+}
+
+// Coverage returns the number of addresses in p covered by s, and the
+// fraction of p's addresses that this is. Addresses covered by more than one
+// Prefix are counted once.
+func (s *PrefixSet) Coverage(p netip.Prefix) (covered *big.Int, fraction float64) {
+	covered = new(big.Int)
+	if !p.IsValid() {
+		return covered, 0
+	}
+	k := keyFromPrefix(p)
+	for _, r := range s.tree.coverageOf(k) {
+		covered.Add(covered, rangeSize(r))
+	}
+	f, _ := new(big.Float).SetInt(covered).Float64()
+	return covered, f / keySize(k)
+}
+
+// Uncovered returns the smallest set of Prefixes which covers exactly the
+// addresses in p that s does not. It is empty if s encompasses p.
+func (s *PrefixSet) Uncovered(p netip.Prefix) *PrefixSet {
+	if !p.IsValid() {
+		return &PrefixSet{}
+	}
+	k := keyFromPrefix(p)
+	var gaps []keyRange
+	next, end := k.content, k.content.bitsSetFrom(k.len)
+	done := false
+	for _, r := range s.tree.coverageOf(k) {
+		if next.less(r.lo) {
+			gaps = append(gaps, keyRange{next, r.lo.subOne()})
+		}
+		if r.hi == end {
+			done = true
+			break
+		}
+		next = r.hi.addOne()
+	}
+	if !done {
+		gaps = append(gaps, keyRange{next, end})
+	}
+	t := treeFromRanges(gaps)
+	return &PrefixSet{*t, t.stats()}
+}
+
+// SymmetricDifference returns the smallest set of Prefixes which covers
+// exactly the addresses covered by either s or o, but not both. See
+// [PrefixSetBuilder.SymmetricDifference].
+func (s *PrefixSet) SymmetricDifference(o *PrefixSet) *PrefixSet {
+	t := symmetricDifference(&s.tree, &o.tree)
+	return &PrefixSet{*t, t.stats()}
 }
@@ -0,0 +1,279 @@
+package netipds
+
+import (
+	"container/heap"
+	"math"
+	"math/big"
+	"net/netip"
+)
+
+// Aggregated returns a PrefixSet covering exactly the same addresses as s,
+// using the fewest Prefixes possible: complete sets of siblings are merged
+// into their parent, recursively, and Prefixes nested inside other Prefixes
+// are dropped. For example, {1.2.3.0/25, 1.2.3.128/25, 1.2.3.4/32} becomes
+// {1.2.3.0/24}.
+//
+// IPv4 and IPv6 Prefixes are never merged with each other.
+func (s *PrefixSet) Aggregated() *PrefixSet {
+	t := s.tree.aggregated()
+	return &PrefixSet{*t, t.stats()}
+}
+
+// PrefixesAggregated returns a slice of the Prefixes in [PrefixSet.Aggregated].
+func (s *PrefixSet) PrefixesAggregated() []netip.Prefix {
+	return s.Aggregated().Prefixes()
+}
+
+// aggregated returns a tree whose entries exactly cover the entries of t,
+// with complete sets of siblings merged and nested entries dropped.
+func (t *tree[T]) aggregated() *tree[bool] {
+	ret := &tree[bool]{}
+	// full returns true if the whole of n's key is covered. If not, the
+	// covered parts of n are inserted into ret.
+	var full func(n *tree[T]) bool
+	full = func(n *tree[T]) bool {
+		if n.hasEntry {
+			return true
+		}
+		var childFull [2]bool
+		for _, b := range eachBit {
+			if c := *n.child(b); c != nil {
+				childFull[b] = full(c)
+			}
+		}
+		// ::/0 is not supported as an entry, so the root is never merged
+		if n.key.len > 0 && childFull[bitL] && childFull[bitR] &&
+			n.left.key.len == n.key.len+1 && n.right.key.len == n.key.len+1 &&
+			// Don't mix IPv4 and IPv6
+			n.left.key.is4() == n.key.is4() && n.right.key.is4() == n.key.is4() {
+			return true
+		}
+		for _, b := range eachBit {
+			if childFull[b] {
+				ret = ret.insert((*n.child(b)).key.rooted(), true)
+			}
+		}
+		return false
+	}
+	if full(t) {
+		ret = ret.insert(t.key.rooted(), true)
+	}
+	return ret
+}
+
+// AggregateTo returns a PrefixSet covering every address covered by s, using
+// at most maxEntries Prefixes. To fit the budget, neighboring Prefixes are
+// replaced by their common supernet, which may cover addresses that s does
+// not. AggregateTo also returns the number of such extra addresses.
+//
+// Supernets are chosen greedily, preferring those that introduce the fewest
+// extra addresses per entry saved. Complete sets of siblings (e.g.
+// 1.2.3.0/25 and 1.2.3.128/25) are always merged first, since they introduce
+// no extra addresses. Prefixes nested inside other Prefixes are dropped.
+//
+// IPv4 and IPv6 Prefixes are never merged with each other, so if s contains
+// both, the result has at least two entries regardless of maxEntries.
+func (s *PrefixSet) AggregateTo(maxEntries int) (*PrefixSet, *big.Int) {
+	a := newAggregator(s)
+	for a.count > maxEntries && a.Len() > 0 {
+		a.mergeNext()
+	}
+
+	ret := &tree[bool]{}
+	for i := a.first; i >= 0; i = a.blocks[i].next {
+		ret = ret.insert(a.blocks[i].key, true)
+	}
+
+	extra := new(big.Int)
+	compareCoverage(ret.coverage(), s.tree.coverage(), func(r keyRange, _, inS bool) bool {
+		if !inS {
+			extra.Add(extra, rangeSize(r))
+		}
+		return true
+	})
+	return &PrefixSet{*ret, ret.stats()}, extra
+}
+
+// Summarize returns a PrefixSet of at most maxPrefixes Prefixes which covers
+// every address covered by s, while keeping the number of additional
+// addresses covered small. It is useful for fitting a set of routes within a
+// hardware route limit.
+//
+// Summarize is shorthand for [PrefixSet.AggregateTo] for callers who don't
+// need the count of additional addresses; see it for details.
+func (s *PrefixSet) Summarize(maxPrefixes int) *PrefixSet {
+	ret, _ := s.AggregateTo(maxPrefixes)
+	return ret
+}
+
+// rangeSize returns the number of values in r.
+func rangeSize(r keyRange) *big.Int {
+	lo, hi := new(big.Int), new(big.Int)
+	lo.SetUint64(r.lo.hi).Lsh(lo, 64).Add(lo, new(big.Int).SetUint64(r.lo.lo))
+	hi.SetUint64(r.hi.hi).Lsh(hi, 64).Add(hi, new(big.Int).SetUint64(r.hi.lo))
+	return hi.Sub(hi, lo).Add(hi, big.NewInt(1))
+}
+
+// keySize returns the number of addresses covered by k, as a float64.
+func keySize(k key) float64 {
+	return math.Ldexp(1, 128-int(k.len))
+}
+
+// aggBlock is one of the Prefixes in an aggregator's working set. The blocks
+// form a linked list in address order.
+type aggBlock struct {
+	key key
+	// covered is the number of addresses in the block covered by the
+	// original set.
+	covered    float64
+	prev, next int
+	// gen is incremented whenever the block changes, invalidating candidate
+	// merges involving it.
+	gen     int
+	removed bool
+}
+
+// aggMerge is a candidate merge of the block at left, the block after it, and
+// any other blocks within their common supernet.
+type aggMerge struct {
+	left, right       int
+	leftGen, rightGen int
+	supernet          key
+	// score is the number of extra addresses introduced per entry saved.
+	score float64
+}
+
+// aggregator greedily merges neighboring blocks, using a heap of candidate
+// merges ordered by score. Candidates made stale by earlier merges are
+// discarded when popped.
+type aggregator struct {
+	blocks []aggBlock
+	first  int
+	count  int
+	has4   bool
+	merges []aggMerge
+}
+
+// ipv4Space is the key of ::ffff:0:0/96, which contains all IPv4 keys.
+var ipv4Space = key{uint128{0, 0xffff << 32}, 0, 96}
+
+func newAggregator(s *PrefixSet) *aggregator {
+	a := &aggregator{first: -1}
+	s.tree.walk(key{}, func(n *tree[bool]) bool {
+		if !n.hasEntry {
+			return false
+		}
+		i := len(a.blocks)
+		a.blocks = append(a.blocks, aggBlock{
+			key:     n.key.rooted(),
+			covered: keySize(n.key),
+			prev:    i - 1,
+			next:    -1,
+		})
+		a.has4 = a.has4 || n.key.is4()
+		if i > 0 {
+			a.blocks[i-1].next = i
+		} else {
+			a.first = i
+		}
+		return true
+	})
+	a.count = len(a.blocks)
+	for i := range a.blocks {
+		a.pushMerge(i)
+	}
+	return a
+}
+
+// span returns the first and last of the consecutive blocks around i that
+// are within supernet, along with the number of addresses they cover and
+// the number of blocks.
+func (a *aggregator) span(i int, supernet key) (first, last int, covered float64, n int) {
+	first, last = i, i
+	for j := a.blocks[i].prev; j >= 0 && supernet.isPrefixOf(a.blocks[j].key, false); j = a.blocks[j].prev {
+		first = j
+	}
+	for j := a.blocks[i].next; j >= 0 && supernet.isPrefixOf(a.blocks[j].key, false); j = a.blocks[j].next {
+		last = j
+	}
+	for j := first; ; j = a.blocks[j].next {
+		covered += a.blocks[j].covered
+		n++
+		if j == last {
+			return
+		}
+	}
+}
+
+// pushMerge adds a candidate merge of block i with the block after it.
+func (a *aggregator) pushMerge(i int) {
+	j := a.blocks[i].next
+	if j < 0 {
+		return
+	}
+	l, r := a.blocks[i].key, a.blocks[j].key
+	supernet := l.truncated(l.commonPrefixLen(r)).rooted()
+	// Don't mix IPv4 and IPv6
+	if a.has4 && !supernet.is4() && supernet.isPrefixOf(ipv4Space, false) {
+		return
+	}
+	_, _, covered, n := a.span(i, supernet)
+	heap.Push(a, aggMerge{
+		left:     i,
+		right:    j,
+		leftGen:  a.blocks[i].gen,
+		rightGen: a.blocks[j].gen,
+		supernet: supernet,
+		score:    (keySize(supernet) - covered) / float64(n-1),
+	})
+}
+
+// mergeNext performs the best available merge, unless it has been made stale
+// by an earlier merge.
+func (a *aggregator) mergeNext() {
+	m := heap.Pop(a).(aggMerge)
+	left, right := &a.blocks[m.left], &a.blocks[m.right]
+	if left.removed || right.removed || left.next != m.right ||
+		left.gen != m.leftGen || right.gen != m.rightGen {
+		return
+	}
+
+	// Replace the span with a single block, reusing the first one
+	first, last, covered, n := a.span(m.left, m.supernet)
+	b := &a.blocks[first]
+	for j := b.next; ; j = a.blocks[j].next {
+		a.blocks[j].removed = true
+		if j == last {
+			break
+		}
+	}
+	b.key, b.covered, b.next = m.supernet, covered, a.blocks[last].next
+	b.gen++
+	if b.next >= 0 {
+		a.blocks[b.next].prev = first
+	}
+	a.count -= n - 1
+
+	a.pushMerge(first)
+	if b.prev >= 0 {
+		a.pushMerge(b.prev)
+	}
+}
+
+func (a *aggregator) Len() int { return len(a.merges) }
+
+func (a *aggregator) Less(i, j int) bool {
+	return a.merges[i].score < a.merges[j].score
+}
+
+func (a *aggregator) Swap(i, j int) {
+	a.merges[i], a.merges[j] = a.merges[j], a.merges[i]
+}
+
+func (a *aggregator) Push(x any) { a.merges = append(a.merges, x.(aggMerge)) }
+
+func (a *aggregator) Pop() any {
+	m := a.merges[len(a.merges)-1]
+	a.merges = a.merges[:len(a.merges)-1]
+	return m
+}
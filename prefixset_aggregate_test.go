@@ -0,0 +1,106 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetAggregated(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		want []netip.Prefix
+	}{
+		{pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs("::0/128")},
+		{pfxs("::0/128", "::1/128"), pfxs("::0/127")},
+		{pfxs("::0/128", "::1/128", "::2/127"), pfxs("::0/126")},
+		{pfxs("::0/128", "::2/128"), pfxs("::0/128", "::2/128")},
+		{pfxs("::1/128", "::2/128"), pfxs("::1/128", "::2/128")},
+		// Nested Prefixes are dropped
+		{pfxs("::0/126", "::1/128", "::8/128"), pfxs("::0/126", "::8/128")},
+		// Merging happens recursively, even through nested Prefixes
+		{pfxs("::0/127", "::0/128", "::2/128", "::3/128", "::4/126"), pfxs("::0/125")},
+		{
+			pfxs("1.2.3.0/25", "1.2.3.128/25", "1.2.3.4/32", "1.2.2.0/24"),
+			pfxs("1.2.2.0/23"),
+		},
+		{pfxs("1.2.3.0/25", "1.2.3.128/26"), pfxs("1.2.3.0/25", "1.2.3.128/26")},
+		{pfxs("0.0.0.0/1", "128.0.0.0/1"), pfxs("0.0.0.0/0")},
+		// IPv4 and IPv6 are not merged
+		{pfxs("0.0.0.0/0", "::fffe:0:0/96"), pfxs("::fffe:0:0/96", "0.0.0.0/0")},
+		{pfxs("::/1", "8000::/1"), pfxs("::/1", "8000::/1")},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		ps := psb.PrefixSet()
+		checkPrefixSlice(t, ps.PrefixesAggregated(), tt.want)
+	}
+}
+
+func TestPrefixSetAggregateTo(t *testing.T) {
+	tests := []struct {
+		set       []netip.Prefix
+		max       int
+		want      []netip.Prefix
+		wantExtra int64
+	}{
+		{pfxs(), 0, pfxs(), 0},
+		{pfxs("::0/128"), 0, pfxs("::0/128"), 0},
+		{pfxs("::0/128", "::1/128"), 2, pfxs("::0/128", "::1/128"), 0},
+		// Siblings are merged without introducing extra addresses
+		{pfxs("::0/128", "::1/128"), 1, pfxs("::0/127"), 0},
+		{pfxs("::0/128", "::1/128", "::2/127"), 1, pfxs("::0/126"), 0},
+		// Nested Prefixes are dropped
+		{pfxs("::0/126", "::1/128", "::8/128"), 1, pfxs("::0/124"), 11},
+		// The cheapest merge is chosen first
+		{pfxs("::0/128", "::2/128", "::8/128"), 2, pfxs("::0/126", "::8/128"), 2},
+		{pfxs("::0/128", "::2/128", "::8/128"), 1, pfxs("::0/124"), 13},
+		// A supernet absorbs all Prefixes within it
+		{pfxs("::1/128", "::2/128", "::4/128", "::10/128"), 2, pfxs("::0/125", "::10/128"), 5},
+
+		// IPv4
+		{pfxs("1.2.3.0/25", "1.2.3.128/25"), 1, pfxs("1.2.3.0/24"), 0},
+		{pfxs("1.2.3.0/24", "1.2.5.0/24"), 1, pfxs("1.2.0.0/21"), 6 * 256},
+		// IPv4 and IPv6 are never merged
+		{pfxs("1.2.3.0/24", "::1/128"), 1, pfxs("::1/128", "1.2.3.0/24"), 0},
+		{pfxs("1.2.3.0/24", "1.2.4.0/24", "::1/128", "::2/128"), 2, pfxs("::0/126", "1.2.0.0/21"), 2 + 6*256},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		got, extra := psb.PrefixSet().AggregateTo(tt.max)
+		checkPrefixSlice(t, got.Prefixes(), tt.want)
+		if extra.Int64() != tt.wantExtra {
+			t.Errorf("AggregateTo(%v, %d) extra = %v, want %d", tt.set, tt.max, extra, tt.wantExtra)
+		}
+	}
+}
+
+func TestPrefixSetSummarize(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("10.0.0.0/24", "10.0.1.0/24", "10.0.3.0/24", "10.1.0.0/24") {
+		psb.Add(p)
+	}
+	ps := psb.PrefixSet()
+	tests := []struct {
+		max  int
+		want []netip.Prefix
+	}{
+		{4, pfxs("10.0.0.0/24", "10.0.1.0/24", "10.0.3.0/24", "10.1.0.0/24")},
+		{3, pfxs("10.0.0.0/23", "10.0.3.0/24", "10.1.0.0/24")},
+		{2, pfxs("10.0.0.0/22", "10.1.0.0/24")},
+		{1, pfxs("10.0.0.0/15")},
+	}
+	for _, tt := range tests {
+		got := ps.Summarize(tt.max)
+		checkPrefixSlice(t, got.Prefixes(), tt.want)
+		if !got.Encompasses(pfx("10.0.3.0/24")) {
+			t.Errorf("Summarize(%d) does not cover the original set", tt.max)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package netipds
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// maxBitmapBits is the largest difference between a Bitmap's cell length and
+// the length of the Prefix it covers. A bitmap of this size occupies 512MiB.
+const maxBitmapBits = 32
+
+// Bitmap renders the coverage of p by s as a bitmap of 2^(bits-p.Bits())
+// cells, where each cell is one of the subnets of p having length bits, in
+// address order. For example, a /16 at /32 granularity produces 65536 cells.
+//
+// Cell i is stored in bit i%64 of word i/64 of the result. A cell's bit is set
+// if the cell is encompassed by a Prefix in s. Prefixes in s that are more
+// specific than bits do not set any cells.
+//
+// Bitmap returns an error if bits is shorter than p, longer than p's address,
+// or more than 32 bits longer than p.
+func (s *PrefixSet) Bitmap(p netip.Prefix, bits int) ([]uint64, error) {
+	if !p.IsValid() {
+		return nil, invalidPrefixError(p)
+	}
+	if bits > p.Addr().BitLen() {
+		return nil, fmt.Errorf("bitmap cell length %d for %v: %w", bits, p, ErrMaxLenExceeded)
+	}
+	if bits < p.Bits() {
+		return nil, fmt.Errorf("invalid bitmap cell length %d for %v", bits, p)
+	}
+	width := uint8(bits - p.Bits())
+	if width > maxBitmapBits {
+		return nil, fmt.Errorf(
+			"bitmap of %v at /%d exceeds %d bits", p, bits, maxBitmapBits)
+	}
+
+	k := keyFromPrefix(p)
+	cellLen := k.len + width
+	bitmap := make([]uint64, (uint64(1)<<width+63)/64)
+	s.tree.walk(k, func(n *tree[bool]) bool {
+		switch {
+		// n diverges from k
+		case !n.key.isPrefixOf(k, false) && !k.isPrefixOf(n.key, false):
+			return true
+		// n is too specific to cover any cells
+		case n.key.len > cellLen:
+			return true
+		case !n.hasEntry:
+			return false
+		// n encompasses k
+		case n.key.isPrefixOf(k, false):
+			setBitRange(bitmap, 0, uint64(1)<<width)
+		// n is a descendant of k
+		default:
+			start := n.key.content.shiftLeft(k.len).shiftRight(128 - width).lo
+			count := uint64(1) << (cellLen - n.key.len)
+			setBitRange(bitmap, start, count)
+		}
+		return true
+	})
+	return bitmap, nil
+}
+
+// setBitRange sets count bits in b starting at bit i.
+func setBitRange(b []uint64, i, count uint64) {
+	for ; count > 0 && i%64 != 0; count-- {
+		b[i/64] |= 1 << (i % 64)
+		i++
+	}
+	for ; count >= 64; count -= 64 {
+		b[i/64] = ^uint64(0)
+		i += 64
+	}
+	for ; count > 0; count-- {
+		b[i/64] |= 1 << (i % 64)
+		i++
+	}
+}
@@ -0,0 +1,62 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestPrefixSetBitmap(t *testing.T) {
+	tests := []struct {
+		set     []netip.Prefix
+		p       netip.Prefix
+		bits    int
+		want    []uint64
+		wantErr bool
+	}{
+		{pfxs(), pfx("1.2.3.0/24"), 26, []uint64{0}, false},
+		{pfxs("1.2.3.0/24"), pfx("1.2.3.0/24"), 24, []uint64{0b1}, false},
+		{pfxs("1.2.3.0/24"), pfx("1.2.3.0/24"), 26, []uint64{0b1111}, false},
+		{pfxs("1.2.0.0/16"), pfx("1.2.3.0/24"), 26, []uint64{0b1111}, false},
+		{pfxs("1.2.3.64/26"), pfx("1.2.3.0/24"), 26, []uint64{0b0010}, false},
+		{pfxs("1.2.3.128/25"), pfx("1.2.3.0/24"), 26, []uint64{0b1100}, false},
+		{
+			pfxs("1.2.3.0/26", "1.2.3.192/26"),
+			pfx("1.2.3.0/24"), 26,
+			[]uint64{0b1001},
+			false,
+		},
+		// Entries more specific than the cell length don't set any cells
+		{pfxs("1.2.3.64/27"), pfx("1.2.3.0/24"), 26, []uint64{0}, false},
+		// Entries outside of p are ignored
+		{pfxs("1.2.4.0/24"), pfx("1.2.3.0/24"), 26, []uint64{0}, false},
+		// Multiple words
+		{
+			pfxs("1.2.3.64/26", "1.2.3.255/32"),
+			pfx("1.2.3.0/24"), 32,
+			[]uint64{0, ^uint64(0), 0, 1 << 63},
+			false,
+		},
+		{pfxs("::0/127"), pfx("::0/126"), 128, []uint64{0b0011}, false},
+
+		// Errors
+		{pfxs(), pfx("1.2.3.0/24"), 23, nil, true},
+		{pfxs(), pfx("1.2.3.0/24"), 33, nil, true},
+		{pfxs(), pfx("::0/64"), 128, nil, true},
+		{pfxs(), netip.Prefix{}, 0, nil, true},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		got, err := psb.PrefixSet().Bitmap(tt.p, tt.bits)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ps.Bitmap(%s, %d) error = %v, want error %v", tt.p, tt.bits, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ps.Bitmap(%s, %d) = %b, want %b", tt.p, tt.bits, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package netipds
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec compresses and decompresses the chunks written by
+// [PrefixSet.WriteChunked]. Implementations typically wrap a compression
+// package such as compress/gzip or a zstd library; see [GzipCodec].
+type Codec interface {
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec is a [Codec] which uses compress/gzip at the given Level. A Level
+// of 0 (and so the zero value) means [gzip.DefaultCompression] rather than
+// [gzip.NoCompression], since chunks are meant to be compressed.
+type GzipCodec struct {
+	Level int
+}
+
+// NewWriter returns a gzip.Writer which writes to w.
+func (c GzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewReader returns a gzip.Reader which reads from r.
+func (c GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// WriteChunked writes the Prefixes of s to w in order, as a sequence of
+// independently compressed chunks of up to chunkSize Prefixes each. Because
+// each chunk can be decompressed on its own, a reader can begin using the
+// Prefixes in early chunks before the rest of the stream has been read (see
+// [ReadChunked]).
+//
+// Each chunk is framed as a uvarint byte count followed by that many bytes of
+// compressed data. Decompressed, a chunk is a uvarint Prefix count followed by
+// that many Prefixes, each encoded as its length in bits (IPv4 Prefixes are
+// offset by 96) followed by its significant address bytes.
+func (s *PrefixSet) WriteChunked(w io.Writer, c Codec, chunkSize int) error {
+	if chunkSize < 1 {
+		return fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+	var err error
+	var count int
+	var payload []byte
+	s.tree.walk(key{}, func(n *tree[bool]) bool {
+		if err != nil {
+			return true
+		}
+		if !n.hasEntry {
+			return false
+		}
+		payload = n.key.rooted().appendBinary(payload)
+		if count++; count == chunkSize {
+			err = writeChunk(w, c, count, payload)
+			count, payload = 0, payload[:0]
+		}
+		return false
+	})
+	if err == nil && count > 0 {
+		err = writeChunk(w, c, count, payload)
+	}
+	return err
+}
+
+// writeChunk compresses a chunk of count encoded keys and writes it to w.
+func writeChunk(w io.Writer, c Codec, count int, payload []byte) error {
+	var buf bytes.Buffer
+	cw, err := c.NewWriter(&buf)
+	if err != nil {
+		return err
+	}
+	if _, err = cw.Write(binary.AppendUvarint(nil, uint64(count))); err != nil {
+		return err
+	}
+	if _, err = cw.Write(payload); err != nil {
+		return err
+	}
+	if err = cw.Close(); err != nil {
+		return err
+	}
+	if _, err = w.Write(binary.AppendUvarint(nil, uint64(buf.Len()))); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// ReadChunked reads a stream written by [PrefixSet.WriteChunked] from r,
+// calling fn with a PrefixSet containing each chunk's Prefixes as soon as that
+// chunk has been decompressed. If fn returns false, ReadChunked stops.
+func ReadChunked(r io.Reader, c Codec, fn func(*PrefixSet) bool) error {
+	return readChunks(r, func(chunk io.Reader) (bool, error) {
+		var psb PrefixSetBuilder
+		if err := psb.readChunk(chunk, c); err != nil {
+			return false, err
+		}
+		return fn(psb.PrefixSet()), nil
+	})
+}
+
+// ReadChunked reads a stream written by [PrefixSet.WriteChunked] from r and
+// adds all of its Prefixes to s.
+func (s *PrefixSetBuilder) ReadChunked(r io.Reader, c Codec) error {
+	return readChunks(r, func(chunk io.Reader) (bool, error) {
+		return true, s.readChunk(chunk, c)
+	})
+}
+
+// readChunks calls fn with a reader over the compressed data of each chunk in
+// r, until fn returns false or an error.
+func readChunks(r io.Reader, fn func(chunk io.Reader) (bool, error)) error {
+	br := bufio.NewReader(r)
+	for i := 1; ; i++ {
+		size, err := binary.ReadUvarint(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+		chunk := io.LimitReader(br, int64(size))
+		ok, err := fn(chunk)
+		if err != nil {
+			return fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+		if !ok {
+			return nil
+		}
+		// Skip anything the Codec left unread
+		if _, err = io.Copy(io.Discard, chunk); err != nil {
+			return fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+	}
+}
+
+// readChunk decompresses a single chunk from r and adds its Prefixes to s.
+func (s *PrefixSetBuilder) readChunk(r io.Reader, c Codec) error {
+	cr, err := c.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+	b, err := io.ReadAll(cr)
+	if err != nil {
+		return err
+	}
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return fmt.Errorf("invalid chunk header")
+	}
+	for b = b[n:]; count > 0; count-- {
+		k, n, err := keyFromBinary(b)
+		if err != nil {
+			return err
+		}
+		if err = s.Add(k.toPrefix()); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	if len(b) > 0 {
+		return fmt.Errorf("%d trailing bytes in chunk", len(b))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package netipds
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetWriteChunked(t *testing.T) {
+	tests := []struct {
+		set        []netip.Prefix
+		chunkSize  int
+		wantChunks int
+	}{
+		{pfxs(), 1, 0},
+		{pfxs("::0/128"), 1, 1},
+		{pfxs("::0/128", "::1/128", "::2/127"), 1, 3},
+		{pfxs("::0/128", "::1/128", "::2/127"), 2, 2},
+		{pfxs("::0/128", "::1/128", "::2/127"), 3, 1},
+		{pfxs("1.2.3.0/24", "1.2.3.4/32", "2001:db8::/32", "8000::/1"), 3, 2},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		ps := psb.PrefixSet()
+
+		var buf bytes.Buffer
+		codec := GzipCodec{gzip.DefaultCompression}
+		if err := ps.WriteChunked(&buf, codec, tt.chunkSize); err != nil {
+			t.Fatalf("ps.WriteChunked() error = %v", err)
+		}
+		data := buf.Bytes()
+
+		// Read chunk by chunk
+		var chunks int
+		var got []netip.Prefix
+		err := ReadChunked(bytes.NewReader(data), codec, func(c *PrefixSet) bool {
+			chunks++
+			got = append(got, c.Prefixes()...)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("ReadChunked() error = %v", err)
+		}
+		if chunks != tt.wantChunks {
+			t.Errorf("ReadChunked() read %d chunks, want %d", chunks, tt.wantChunks)
+		}
+		checkPrefixSlice(t, got, ps.Prefixes())
+
+		// Read all at once
+		rt := &PrefixSetBuilder{}
+		if err := rt.ReadChunked(bytes.NewReader(data), codec); err != nil {
+			t.Fatalf("psb.ReadChunked() error = %v", err)
+		}
+		checkPrefixSlice(t, rt.PrefixSet().Prefixes(), ps.Prefixes())
+	}
+}
+
+func TestPrefixSetWriteChunkedErrors(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.3.0/24", "1.2.3.4/32", "10.0.0.0/8", "2001:db8::/32", "8000::/1") {
+		psb.Add(p)
+	}
+	ps := psb.PrefixSet()
+	var buf bytes.Buffer
+	if err := ps.WriteChunked(&buf, GzipCodec{}, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Every write failure is reported, even if later writes would succeed
+	for n := 0; n < 10; n++ {
+		if err := ps.WriteChunked(&nthWriteFails{n: n}, GzipCodec{}, 1); err == nil {
+			t.Errorf("WriteChunked() with write %d failing returned nil error", n)
+		}
+	}
+
+	// The zero value GzipCodec compresses
+	var plain, zero bytes.Buffer
+	repetitive := &PrefixSetBuilder{}
+	for i := 0; i < 256; i++ {
+		repetitive.Add(netip.PrefixFrom(netip.AddrFrom4([4]byte{10, 0, byte(i), 0}), 24))
+	}
+	repetitive.PrefixSet().WriteChunked(&plain, noCodec{}, 256)
+	repetitive.PrefixSet().WriteChunked(&zero, GzipCodec{}, 256)
+	if zero.Len() >= plain.Len() {
+		t.Errorf("GzipCodec{} wrote %d bytes, want fewer than %d", zero.Len(), plain.Len())
+	}
+}
+
+// nthWriteFails is a writer whose nth write fails.
+type nthWriteFails struct{ n, writes int }
+
+func (w *nthWriteFails) Write(b []byte) (int, error) {
+	if w.writes++; w.writes-1 == w.n {
+		return 0, errors.New("write failed")
+	}
+	return len(b), nil
+}
+
+// noCodec is a Codec which doesn't compress.
+type noCodec struct{}
+
+func (noCodec) NewWriter(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+func (noCodec) NewReader(r io.Reader) (io.ReadCloser, error)  { return io.NopCloser(r), nil }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestReadChunkedStop(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("::0/128", "::1/128", "::2/128") {
+		psb.Add(p)
+	}
+	var buf bytes.Buffer
+	psb.PrefixSet().WriteChunked(&buf, GzipCodec{}, 1)
+
+	var chunks int
+	ReadChunked(&buf, GzipCodec{}, func(*PrefixSet) bool {
+		chunks++
+		return false
+	})
+	if chunks != 1 {
+		t.Errorf("ReadChunked() read %d chunks after fn returned false, want 1", chunks)
+	}
+}
+
+func TestReadChunkedErrors(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("::0/128"))
+	var buf bytes.Buffer
+	psb.PrefixSet().WriteChunked(&buf, GzipCodec{}, 1)
+	data := buf.Bytes()
+
+	for _, b := range [][]byte{
+		data[:len(data)-1],
+		{0x05, 1, 2, 3, 4, 5},
+		{0x80},
+	} {
+		rt := &PrefixSetBuilder{}
+		if err := rt.ReadChunked(bytes.NewReader(b), GzipCodec{}); err == nil {
+			t.Errorf("psb.ReadChunked(%v) succeeded, want error", b)
+		}
+	}
+	if err := psb.PrefixSet().WriteChunked(&buf, GzipCodec{}, 0); err == nil {
+		t.Errorf("ps.WriteChunked() with chunk size 0 succeeded, want error")
+	}
+}
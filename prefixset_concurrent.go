@@ -0,0 +1,85 @@
+package netipds
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentPrefixSet is a PrefixSet which can be read and written
+// concurrently. Reads use an immutable PrefixSet which is published
+// atomically after each batch of writes, so they never block and never see a
+// partially applied batch. Writes are serialized.
+//
+// Publishing a batch takes time proportional to the size of the batch, not
+// the size of the set, since the published sets share the nodes that the
+// batch leaves unchanged.
+//
+// The zero value is a valid, empty ConcurrentPrefixSet. A ConcurrentPrefixSet
+// must not be copied after first use.
+type ConcurrentPrefixSet struct {
+	current atomic.Pointer[PrefixSet]
+
+	mu sync.Mutex
+	b  PrefixSetBuilder
+}
+
+// Load returns the most recently published PrefixSet. Load is safe for
+// concurrent use and does not block.
+func (c *ConcurrentPrefixSet) Load() *PrefixSet {
+	if s := c.current.Load(); s != nil {
+		return s
+	}
+	return &PrefixSet{}
+}
+
+// Contains returns true if the most recently published PrefixSet contains
+// the exact Prefix provided.
+func (c *ConcurrentPrefixSet) Contains(p netip.Prefix) bool {
+	return c.Load().Contains(p)
+}
+
+// Encompasses returns true if the most recently published PrefixSet
+// encompasses p.
+func (c *ConcurrentPrefixSet) Encompasses(p netip.Prefix) bool {
+	return c.Load().Encompasses(p)
+}
+
+// Update calls fn with a builder holding the current contents of c. When fn
+// returns, the builder's contents are published as a single change. If fn
+// returns an error, its changes are discarded and the error is returned.
+//
+// fn must not retain the builder or call c's write methods.
+func (c *ConcurrentPrefixSet) Update(fn func(*PrefixSetBuilder) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.b.clone()
+	if err := fn(b); err != nil {
+		return err
+	}
+	c.b = *b
+	c.current.Store(c.b.PrefixSet())
+	return nil
+}
+
+// Store replaces the contents of c with s, and publishes s as is.
+func (c *ConcurrentPrefixSet) Store(s *PrefixSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.b = PrefixSetBuilder{Lazy: c.b.Lazy, tree: *s.tree.copy(), lens: countLens(&s.tree)}
+	c.current.Store(s)
+}
+
+// Add adds p and publishes the change.
+func (c *ConcurrentPrefixSet) Add(p netip.Prefix) error {
+	return c.Update(func(b *PrefixSetBuilder) error {
+		return b.Add(p)
+	})
+}
+
+// Remove removes p and publishes the change.
+func (c *ConcurrentPrefixSet) Remove(p netip.Prefix) error {
+	return c.Update(func(b *PrefixSetBuilder) error {
+		return b.Remove(p)
+	})
+}
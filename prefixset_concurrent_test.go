@@ -0,0 +1,87 @@
+package netipds
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentPrefixSet(t *testing.T) {
+	var c ConcurrentPrefixSet
+	if got := c.Load().Size(); got != 0 {
+		t.Errorf("zero value Size() = %d, want 0", got)
+	}
+
+	c.Add(pfx("1.2.0.0/16"))
+	before := c.Load()
+	err := c.Update(func(b *PrefixSetBuilder) error {
+		b.Add(pfx("1.2.3.0/24"))
+		b.Add(pfx("2001:db8::/32"))
+		return b.Remove(pfx("1.2.0.0/16"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPrefixSlice(t, c.Load().Prefixes(), pfxs("1.2.3.0/24", "2001:db8::/32"))
+	// Previously loaded sets are unaffected
+	checkPrefixSlice(t, before.Prefixes(), pfxs("1.2.0.0/16"))
+
+	// Failed batches are discarded entirely, including changes that don't
+	// go through Add and Remove
+	errFail := errors.New("fail")
+	err = c.Update(func(b *PrefixSetBuilder) error {
+		b.Add(pfx("10.0.0.0/8"))
+		b.Remove(pfx("1.2.3.0/24"))
+		b.SubtractPrefix(pfx("2001:db8::/33"))
+		return errFail
+	})
+	if err != errFail {
+		t.Errorf("Update() = %v, want %v", err, errFail)
+	}
+	checkPrefixSlice(t, c.Load().Prefixes(), pfxs("1.2.3.0/24", "2001:db8::/32"))
+	if err = c.Add(netip.Prefix{}); err == nil {
+		t.Errorf("Add(invalid) returned nil error")
+	}
+	c.Add(pfx("1.2.3.4/32"))
+	checkPrefixSlice(t, c.Load().Prefixes(), pfxs("1.2.3.0/24", "1.2.3.4/32", "2001:db8::/32"))
+	if !c.Contains(pfx("1.2.3.4/32")) || !c.Encompasses(pfx("1.2.3.128/25")) {
+		t.Errorf("Contains/Encompasses missed published entries")
+	}
+
+	// Store publishes the set as is, and later updates build on it
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("192.168.0.0/16"))
+	stored := psb.PrefixSet()
+	c.Store(stored)
+	if c.Load() != stored {
+		t.Errorf("Load() after Store returned a different set")
+	}
+	c.Add(pfx("10.0.0.0/8"))
+	checkPrefixSlice(t, c.Load().Prefixes(), pfxs("10.0.0.0/8", "192.168.0.0/16"))
+	checkPrefixSlice(t, stored.Prefixes(), pfxs("192.168.0.0/16"))
+}
+
+func TestConcurrentPrefixSetRace(t *testing.T) {
+	var c ConcurrentPrefixSet
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Add(netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i), byte(j), 0}), 24))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Encompasses(pfx("10.1.2.3/32"))
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.Load().Size(); got != 200 {
+		t.Errorf("Size() = %d, want 200", got)
+	}
+}
@@ -0,0 +1,51 @@
+package netipds
+
+// MinimalCover selects a minimal subset of the Prefixes in s whose union
+// covers every Prefix in target. For example, it can be used to pick the
+// fewest aggregates (s) to announce that still cover all of a set of routes
+// (target).
+//
+// Each Prefix in target that is not encompassed by another Prefix in target
+// is covered by its shortest ancestor in s if it has one. Otherwise, it is
+// covered by the outermost Prefixes in s beneath it, which are all necessary
+// since they do not overlap one another.
+//
+// If the Prefixes in s cannot cover all of target, then MinimalCover returns
+// the Prefixes which cover as much of it as possible, and false.
+func (s *PrefixSet) MinimalCover(target *PrefixSet) (*PrefixSet, bool) {
+	ret := &tree[bool]{}
+	target.tree.walk(key{}, func(n *tree[bool]) bool {
+		if !n.hasEntry {
+			return false
+		}
+		k := n.key.rooted()
+		if root, _, ok := s.tree.rootOf(k, false); ok {
+			ret = ret.insert(root.rooted(), true)
+			return true
+		}
+		s.tree.walk(k, func(m *tree[bool]) bool {
+			switch {
+			// m is on the path to k
+			case m.key.isPrefixOf(k, true):
+				return false
+			// m diverges from k
+			case !k.isPrefixOf(m.key, false):
+				return true
+			case m.hasEntry:
+				ret = ret.insert(m.key.rooted(), true)
+				return true
+			default:
+				return false
+			}
+		})
+		return true
+	})
+
+	ok := true
+	tCov, cCov := target.tree.coverage(), ret.coverage()
+	compareCoverage(tCov, cCov, func(_ keyRange, inTarget, inCover bool) bool {
+		ok = !inTarget || inCover
+		return ok
+	})
+	return &PrefixSet{*ret, ret.stats()}, ok
+}
@@ -0,0 +1,51 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetMinimalCover(t *testing.T) {
+	tests := []struct {
+		candidates []netip.Prefix
+		target     []netip.Prefix
+		want       []netip.Prefix
+		wantOk     bool
+	}{
+		{pfxs(), pfxs(), pfxs(), true},
+		{pfxs("::0/127"), pfxs(), pfxs(), true},
+		{pfxs(), pfxs("::0/128"), pfxs(), false},
+		{pfxs("::0/127"), pfxs("::0/128"), pfxs("::0/127"), true},
+		// The shortest ancestor is chosen
+		{pfxs("::0/126", "::0/127"), pfxs("::0/128", "::1/128"), pfxs("::0/126"), true},
+		{pfxs("::0/126", "::0/127"), pfxs("::0/128", "::2/128"), pfxs("::0/126"), true},
+		// Unused candidates are left out
+		{pfxs("::0/127", "::2/127"), pfxs("::1/128"), pfxs("::0/127"), true},
+		// Descendants can be combined to cover a target
+		{pfxs("::0/128", "::1/128", "::1/128"), pfxs("::0/127"), pfxs("::0/128", "::1/128"), true},
+		{pfxs("::0/127", "::0/128", "::2/128"), pfxs("::0/126"), pfxs("::0/127", "::2/128"), false},
+		{pfxs("::4/126"), pfxs("::0/126"), pfxs(), false},
+
+		// IPv4
+		{
+			pfxs("10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/16"),
+			pfxs("10.1.2.0/24", "10.2.0.0/16", "192.168.1.0/24"),
+			pfxs("10.0.0.0/8", "192.168.0.0/16"),
+			true,
+		},
+	}
+	build := func(ps []netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	for _, tt := range tests {
+		got, ok := build(tt.candidates).MinimalCover(build(tt.target))
+		checkPrefixSlice(t, got.Prefixes(), tt.want)
+		if ok != tt.wantOk {
+			t.Errorf("MinimalCover(%v, %v) ok = %v, want %v", tt.candidates, tt.target, ok, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package netipds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+)
+
+// The file format written by WritePrefixSetFile is a fixed-size header
+// followed by fixed-size records, so that it can be queried in place:
+//
+//	magic   [4]byte  "NIPF"
+//	version byte     fileVersion
+//	        [3]byte  reserved
+//	lens    [3]uint64 bitmap of the key lengths present; bit l%64 of word l/64
+//	count   uint64   the number of records
+//	records [count]record
+//
+// Each record is a key: 16 bytes of content followed by one byte of length,
+// where IPv4 Prefixes are mapped into ::ffff:0:0/96 as they are in a tree.
+// Records are sorted by key.compare. All integers are big-endian.
+const (
+	fileMagic      = "NIPF"
+	fileVersion    = 1
+	fileHeaderSize = 40
+	fileRecordSize = 17
+)
+
+// WritePrefixSetFile writes s to the file at path in a read-only format which
+// can be opened with [OpenPrefixSetFile] and queried without deserializing it.
+// The file is created if necessary and truncated otherwise.
+func WritePrefixSetFile(path string, s *PrefixSet) error {
+	var lens [3]uint64
+	var records []byte
+	var visit func(*tree[bool])
+	visit = func(n *tree[bool]) {
+		if n.hasEntry {
+			var rec [fileRecordSize]byte
+			binary.BigEndian.PutUint64(rec[:8], n.key.content.hi)
+			binary.BigEndian.PutUint64(rec[8:16], n.key.content.lo)
+			rec[16] = n.key.len
+			records = append(records, rec[:]...)
+			lens[n.key.len/64] |= 1 << (n.key.len % 64)
+		}
+		for _, c := range []*tree[bool]{n.left, n.right} {
+			if c != nil {
+				visit(c)
+			}
+		}
+	}
+	visit(&s.tree)
+
+	b := make([]byte, fileHeaderSize, fileHeaderSize+len(records))
+	copy(b, fileMagic)
+	b[4] = fileVersion
+	for i, w := range lens {
+		binary.BigEndian.PutUint64(b[8+8*i:], w)
+	}
+	binary.BigEndian.PutUint64(b[32:], uint64(len(records)/fileRecordSize))
+	return os.WriteFile(path, append(b, records...), 0o644)
+}
+
+// PrefixSetFile is a read-only PrefixSet stored in a file written by
+// [WritePrefixSetFile]. Where the platform supports it, the file is
+// memory-mapped, so that opening it is cheap and its pages are shared by all
+// processes that open it.
+//
+// Queries take O(log n) time per Prefix length present in the file. Call
+// [PrefixSetFile.Close] to release the file when it is no longer needed.
+type PrefixSetFile struct {
+	data    []byte
+	lens    [3]uint64
+	count   int
+	release func() error
+}
+
+// OpenPrefixSetFile opens a file written by [WritePrefixSetFile]. The file's
+// records are checked, which takes time proportional to its size, and an
+// error is returned if it is corrupt.
+func OpenPrefixSetFile(path string) (*PrefixSetFile, error) {
+	data, release, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newPrefixSetFile(data)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f.release = release
+	return f, nil
+}
+
+func newPrefixSetFile(data []byte) (*PrefixSetFile, error) {
+	if len(data) < fileHeaderSize || string(data[:4]) != fileMagic {
+		return nil, fmt.Errorf("not a PrefixSet file")
+	}
+	if data[4] != fileVersion {
+		return nil, fmt.Errorf("unsupported PrefixSet file version %d", data[4])
+	}
+	f := &PrefixSetFile{data: data}
+	for i := range f.lens {
+		f.lens[i] = binary.BigEndian.Uint64(data[8+8*i:])
+	}
+	count := binary.BigEndian.Uint64(data[32:])
+	if count != uint64(len(data)-fileHeaderSize)/fileRecordSize ||
+		(len(data)-fileHeaderSize)%fileRecordSize != 0 {
+		return nil, fmt.Errorf("PrefixSet file has %d bytes of records, want %d records",
+			len(data)-fileHeaderSize, count)
+	}
+	f.count = int(count)
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// validate returns an error if f's records are not valid keys in strictly
+// increasing order, or if its bitmap of lengths doesn't match them. Lookups
+// rely on both.
+func (f *PrefixSetFile) validate() error {
+	var lens [3]uint64
+	for i := 0; i < f.count; i++ {
+		k := f.record(i)
+		if k.len > 128 {
+			return fmt.Errorf("PrefixSet file record %d has invalid length %d", i, k.len)
+		}
+		if k != newKey(k.content, 0, k.len) {
+			return fmt.Errorf("PrefixSet file record %d has bits set past its length", i)
+		}
+		if i > 0 && f.record(i-1).compare(k) >= 0 {
+			return fmt.Errorf("PrefixSet file record %d is out of order", i)
+		}
+		lens[k.len/64] |= 1 << (k.len % 64)
+	}
+	if lens != f.lens {
+		return fmt.Errorf("PrefixSet file lengths don't match its records")
+	}
+	return nil
+}
+
+// Close releases the file. f must not be used after Close returns.
+func (f *PrefixSetFile) Close() error {
+	f.data = nil
+	if f.release == nil {
+		return nil
+	}
+	release := f.release
+	f.release = nil
+	return release()
+}
+
+// Size returns the number of Prefixes in f.
+func (f *PrefixSetFile) Size() int {
+	return f.count
+}
+
+// Contains returns true if f includes the exact Prefix provided.
+func (f *PrefixSetFile) Contains(p netip.Prefix) bool {
+	return p.IsValid() && f.find(keyFromPrefix(p))
+}
+
+// Encompasses returns true if f includes a Prefix which completely
+// encompasses p. The encompassing Prefix may be p itself.
+func (f *PrefixSetFile) Encompasses(p netip.Prefix) bool {
+	return p.IsValid() && f.encompasses(keyFromPrefix(p))
+}
+
+// ContainsAddr returns true if a is contained by any Prefix in f. a's zone,
+// if any, is ignored.
+func (f *PrefixSetFile) ContainsAddr(a netip.Addr) bool {
+	return a.IsValid() && f.encompasses(keyFromAddr(a))
+}
+
+// PrefixSet reads all of the Prefixes in f into a new PrefixSet.
+func (f *PrefixSetFile) PrefixSet() *PrefixSet {
+	t := &tree[bool]{}
+	for i := 0; i < f.count; i++ {
+		t = t.insert(f.record(i), true)
+	}
+	return &PrefixSet{*t, t.stats()}
+}
+
+// record returns the key stored in the ith record.
+func (f *PrefixSetFile) record(i int) key {
+	rec := f.data[fileHeaderSize+i*fileRecordSize:]
+	return key{
+		content: uint128{
+			binary.BigEndian.Uint64(rec[:8]),
+			binary.BigEndian.Uint64(rec[8:16]),
+		},
+		len: rec[16],
+	}
+}
+
+// find returns true if f contains a record equal to k.
+func (f *PrefixSetFile) find(k key) bool {
+	i := sort.Search(f.count, func(i int) bool {
+		return f.record(i).compare(k) >= 0
+	})
+	return i < f.count && f.record(i).compare(k) == 0
+}
+
+// encompasses returns true if f contains a record which is a prefix of k.
+func (f *PrefixSetFile) encompasses(k key) bool {
+	for l := uint8(0); l <= k.len; l++ {
+		if f.lens[l/64]&(1<<(l%64)) != 0 && f.find(k.truncated(l)) {
+			return true
+		}
+		if l == 128 {
+			break
+		}
+	}
+	return false
+}
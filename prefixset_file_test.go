@@ -0,0 +1,93 @@
+package netipds
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefixSetFile(t *testing.T) {
+	set := pfxs("8000::/1", "1.2.0.0/16", "1.2.3.0/24", "10.0.0.1/32", "::1/128", "2001:db8::/32")
+	psb := &PrefixSetBuilder{}
+	for _, p := range set {
+		psb.Add(p)
+	}
+	ps := psb.PrefixSet()
+	path := filepath.Join(t.TempDir(), "set.nipf")
+	if err := WritePrefixSetFile(path, ps); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenPrefixSetFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if f.Size() != len(set) {
+		t.Errorf("Size() = %d, want %d", f.Size(), len(set))
+	}
+	for _, p := range append(set, pfxs("1.2.3.4/32", "1.3.0.0/16", "2001:db8::/31", "::2/128", "::/0", "8000::1/128")...) {
+		if got, want := f.Contains(p), ps.Contains(p); got != want {
+			t.Errorf("Contains(%v) = %v, want %v", p, got, want)
+		}
+		if got, want := f.Encompasses(p), ps.Encompasses(p); got != want {
+			t.Errorf("Encompasses(%v) = %v, want %v", p, got, want)
+		}
+	}
+	for _, s := range []string{"1.2.3.4", "1.3.0.0", "10.0.0.1", "10.0.0.2", "::1", "2001:db8::1"} {
+		a := netip.MustParseAddr(s)
+		if got, want := f.ContainsAddr(a), ps.ContainsAddr(a); got != want {
+			t.Errorf("ContainsAddr(%v) = %v, want %v", a, got, want)
+		}
+	}
+	checkPrefixSlice(t, f.PrefixSet().Prefixes(), ps.Prefixes())
+	if err := f.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestOpenPrefixSetFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("1.2.3.0/24"))
+	psb.Add(pfx("2001:db8::/32"))
+	good := filepath.Join(dir, "good")
+	if err := WritePrefixSetFile(good, psb.PrefixSet()); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(good)
+
+	// modified returns a copy of b with the byte at i set to v
+	modified := func(i int, v byte) []byte {
+		m := append([]byte(nil), b...)
+		m[i] = v
+		return m
+	}
+	first, second := fileHeaderSize, fileHeaderSize+fileRecordSize
+	swapped := append(append(append([]byte(nil), b[:first]...), b[second:]...), b[first:second]...)
+
+	tests := map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("XXXX"), b[4:]...),
+		"truncated": b[:len(b)-1],
+		"extra":     append(append([]byte(nil), b...), 0),
+		"length":    modified(first+16, 200),
+		"bits":      modified(first+15, 1),
+		"order":     swapped,
+		"lens":      modified(8, 0xff),
+	}
+	for name, data := range tests {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if f, err := OpenPrefixSetFile(path); err == nil {
+			f.Close()
+			t.Errorf("%s: OpenPrefixSetFile succeeded", name)
+		}
+	}
+	if _, err := OpenPrefixSetFile(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("OpenPrefixSetFile of a missing file succeeded")
+	}
+}
@@ -0,0 +1,79 @@
+package netipds
+
+import (
+	"crypto/sha256"
+	"net/netip"
+)
+
+// Fingerprint returns a hash of the Prefixes in s. Two PrefixSets have the
+// same Fingerprint if and only if they contain the same Prefixes (barring
+// SHA-256 collisions), regardless of how they were built.
+//
+// Fingerprints form a Merkle tree over the Prefixes, so processes holding
+// large sets can find where they differ without exchanging the sets: if the
+// Fingerprints differ, compare [PrefixSet.FingerprintOf] for each half of
+// the address space, then for each half of any half that differs, and so on.
+// Only the subtrees containing differences need to be explored, and once
+// they are small enough, their Prefixes can be exchanged directly.
+//
+// Fingerprint takes time proportional to the size of s.
+func (s *PrefixSet) Fingerprint() [32]byte {
+	return s.tree.fingerprint()
+}
+
+// FingerprintOf returns a hash of the Prefixes in s which are encompassed by
+// p, including p itself. As with [PrefixSet.Fingerprint], two PrefixSets have
+// the same FingerprintOf(p) if and only if they contain the same Prefixes
+// encompassed by p.
+//
+// The result is all zeros if s contains no such Prefixes, or if p is invalid.
+func (s *PrefixSet) FingerprintOf(p netip.Prefix) [32]byte {
+	if !p.IsValid() {
+		return [32]byte{}
+	}
+	k := keyFromPrefix(p)
+	for n := &s.tree; n != nil; n = *n.child(k.bit(n.key.len)) {
+		if k.isPrefixOf(n.key, false) {
+			return n.fingerprint()
+		}
+		if !n.key.isPrefixOf(k, true) {
+			break
+		}
+	}
+	return [32]byte{}
+}
+
+// fingerprint returns a hash of the entry keys of t, which depends only on
+// the keys, not on the structure of t.
+//
+// The hash of a subtree is that of the topmost node of the smallest tree
+// holding the same keys, in which every node without an entry has two
+// children. The hash of such a node is the SHA-256 hash of a flags byte, its
+// key in the encoding of key.appendBinary, and the hashes of its left and
+// right subtrees, with all zeros for a missing subtree. So, nodes without
+// entries which have fewer than two non-empty subtrees are passed through.
+func (t *tree[T]) fingerprint() [32]byte {
+	var l, r [32]byte
+	if t.left != nil {
+		l = t.left.fingerprint()
+	}
+	if t.right != nil {
+		r = t.right.fingerprint()
+	}
+	if !t.hasEntry {
+		switch {
+		case l == [32]byte{}:
+			return r
+		case r == [32]byte{}:
+			return l
+		}
+	}
+	var flags byte
+	if t.hasEntry {
+		flags = nodeEntry
+	}
+	b := make([]byte, 0, 1+17+64)
+	b = t.key.rooted().appendBinary(append(b, flags))
+	b = append(append(b, l[:]...), r[:]...)
+	return sha256.Sum256(b)
+}
@@ -0,0 +1,94 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetFingerprint(t *testing.T) {
+	prefixes := pfxs("1.2.0.0/16", "1.2.3.0/24", "10.0.0.0/8", "2001:db8::/32", "2001:db8:1::/48")
+	build := func(lazy bool, ps []netip.Prefix, extra ...netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{Lazy: lazy}
+		for _, p := range extra {
+			psb.Add(p)
+		}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		// Removed Prefixes can leave nodes behind
+		for _, p := range extra {
+			psb.Remove(p)
+		}
+		return psb.PrefixSet()
+	}
+	reversed := make([]netip.Prefix, len(prefixes))
+	for i, p := range prefixes {
+		reversed[len(prefixes)-1-i] = p
+	}
+
+	want := build(false, prefixes).Fingerprint()
+	for _, s := range []*PrefixSet{
+		build(true, prefixes),
+		build(false, reversed),
+		build(false, prefixes, pfxs("1.2.3.4/32", "1.0.0.0/8", "2001:db8:2::/48")...),
+		build(true, prefixes, pfxs("1.2.3.4/32", "1.0.0.0/8", "2001:db8:2::/48")...),
+	} {
+		if got := s.Fingerprint(); got != want {
+			t.Errorf("Fingerprint() of %v = %x, want %x", s, got, want)
+		}
+	}
+	if got := (&PrefixSet{}).Fingerprint(); got != [32]byte{} {
+		t.Errorf("Fingerprint() of empty set = %x, want zero", got)
+	}
+
+	// Any difference changes the Fingerprint
+	for _, s := range []*PrefixSet{
+		build(false, prefixes[1:]),
+		build(false, append(pfxs("1.2.3.4/32"), prefixes...)),
+		build(false, append(pfxs("1.2.0.0/15"), prefixes[1:]...)),
+		build(false, pfxs("1.2.0.0/16")),
+	} {
+		if got := s.Fingerprint(); got == want {
+			t.Errorf("Fingerprint() of %v matches that of %v", s, prefixes)
+		}
+	}
+
+	// FingerprintOf narrows down the location of a difference
+	a := build(false, prefixes)
+	b := build(false, append(pfxs("2001:db8:1:2::/64"), prefixes...))
+	var diverged []netip.Prefix
+	var narrow func(p netip.Prefix)
+	narrow = func(p netip.Prefix) {
+		if a.FingerprintOf(p) == b.FingerprintOf(p) {
+			return
+		}
+		diverged = append(diverged, p)
+		if p.Bits() == p.Addr().BitLen() {
+			return
+		}
+		lo := netip.PrefixFrom(p.Addr(), p.Bits()+1)
+		narrow(lo)
+		hi := p.Addr().AsSlice()
+		hi[p.Bits()/8] |= 0x80 >> (p.Bits() % 8)
+		addr, _ := netip.AddrFromSlice(hi)
+		narrow(netip.PrefixFrom(addr, p.Bits()+1))
+	}
+	narrow(pfx("::/1"))
+	narrow(pfx("0.0.0.0/1"))
+	if last := diverged[len(diverged)-1]; last != pfx("2001:db8:1:2::/64") {
+		t.Errorf("narrowed difference to %v, want 2001:db8:1:2::/64", last)
+	}
+	// Only the path to the difference is explored
+	if len(diverged) != 64 {
+		t.Errorf("explored %d diverging subtrees, want 64", len(diverged))
+	}
+
+	if got := a.FingerprintOf(pfx("1.2.0.0/16")); got != build(false, pfxs("1.2.0.0/16", "1.2.3.0/24")).Fingerprint() {
+		t.Errorf("FingerprintOf(1.2.0.0/16) differs from Fingerprint() of the same Prefixes")
+	}
+	for _, p := range []netip.Prefix{pfx("1.2.3.4/32"), pfx("192.168.0.0/16"), {}} {
+		if got := a.FingerprintOf(p); got != [32]byte{} {
+			t.Errorf("FingerprintOf(%v) = %x, want zero", p, got)
+		}
+	}
+}
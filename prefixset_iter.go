@@ -17,7 +17,7 @@ func (s *PrefixSet) All() iter.Seq[netip.Prefix] {
 				canYield = yield(n.key.toPrefix())
 				i++
 			}
-			return !canYield || i >= s.size
+			return !canYield || i >= s.stats.size()
 		})
 	}
 }
@@ -39,3 +39,43 @@ func (s *PrefixSet) AllCompact() iter.Seq[netip.Prefix] {
 		})
 	}
 }
+
+// DescendantsOfIter returns an iterator over the descendants of p in s,
+// including p itself if it has an entry. Unlike [PrefixSet.DescendantsOf], it
+// visits the nodes of s in place instead of building a new PrefixSet.
+func (s *PrefixSet) DescendantsOfIter(p netip.Prefix) iter.Seq[netip.Prefix] {
+	return func(yield func(netip.Prefix) bool) {
+		s.tree.eachDescendant(keyFromPrefix(p), func(n *tree[bool]) bool {
+			return yield(n.key.toPrefix())
+		})
+	}
+}
+
+// SubnetsOfLength returns an iterator over the subnets of p with prefix length
+// newLen which are completely covered by s, in order. If partial is true,
+// subnets which are only partly covered by s are included too.
+//
+// The iterator yields nothing if p is invalid or if newLen is less than
+// p.Bits() or greater than p.Addr().BitLen().
+func (s *PrefixSet) SubnetsOfLength(p netip.Prefix, newLen int, partial bool) iter.Seq[netip.Prefix] {
+	return func(yield func(netip.Prefix) bool) {
+		if !p.IsValid() || newLen < p.Bits() || newLen > p.Addr().BitLen() {
+			return
+		}
+		k := keyFromPrefix(p)
+		n := uint8(newLen) + k.len - uint8(p.Bits())
+		s.tree.eachSubnet(k, n, partial, func(sub key) bool {
+			return yield(sub.toPrefix())
+		})
+	}
+}
+
+// RootsIter returns an iterator over the prefixes in s that have no ancestor
+// in s. Unlike [PrefixSet.Roots], it does not allocate a slice.
+func (s *PrefixSet) RootsIter() iter.Seq[netip.Prefix] {
+	return func(yield func(netip.Prefix) bool) {
+		s.tree.eachRoot(func(n *tree[bool]) bool {
+			return yield(n.key.toPrefix())
+		})
+	}
+}
@@ -67,6 +67,41 @@ func TestPrefixSetAllCompact(t *testing.T) {
 	}
 }
 
+func TestPrefixSetDescendantsOfIter(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		get  netip.Prefix
+		want []netip.Prefix
+	}{
+		{pfxs(), pfx("::0/128"), pfxs()},
+		{pfxs("::0/128"), pfx("::1/128"), pfxs()},
+		{pfxs("::0/128"), pfx("::0/128"), pfxs("::0/128")},
+		{pfxs("::0/128"), pfx("::1/127"), pfxs("::0/128")},
+		{pfxs("::0/128"), pfx("::/0"), pfxs("::0/128")},
+		{pfxs("::0/128", "::1/128"), pfx("::0/127"), pfxs("::0/128", "::1/128")},
+		{pfxs("::0/128", "::1/128", "::2/128"), pfx("::2/127"), pfxs("::2/128")},
+		// Ancestors are not included
+		{pfxs("::0/126", "::0/128", "::2/127"), pfx("::0/127"), pfxs("::0/128")},
+		// Compressed nodes that diverge from p
+		{pfxs("::4/126", "::8/126"), pfx("::0/127"), pfxs()},
+		{
+			pfxs("1.2.0.0/16", "1.2.3.0/24", "1.2.3.4/32", "1.3.0.0/16"),
+			pfx("1.2.0.0/16"),
+			pfxs("1.2.0.0/16", "1.2.3.0/24", "1.2.3.4/32"),
+		},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		ps := psb.PrefixSet()
+		seq := ps.DescendantsOfIter(tt.get)
+		checkPrefixSeq(t, seq, tt.want)
+		checkYieldFalse(t, seq)
+	}
+}
+
 func checkPrefixSeq(t *testing.T, seq iter.Seq[netip.Prefix], want []netip.Prefix) {
 	t.Helper()
 	got := slices.AppendSeq(make([]netip.Prefix, 0, len(want)), seq)
@@ -86,3 +121,89 @@ func checkYieldFalse[T any](t *testing.T, seq iter.Seq[T]) {
 		t.Fatal("iteration continued after yield returned false")
 	}
 }
+
+func TestPrefixSetSubnetsOfLength(t *testing.T) {
+	tests := []struct {
+		set     []netip.Prefix
+		p       netip.Prefix
+		newLen  int
+		partial bool
+		want    []netip.Prefix
+	}{
+		{pfxs(), pfx("1.2.0.0/16"), 24, true, pfxs()},
+		{
+			pfxs("1.2.3.0/24", "1.2.4.128/25", "1.2.5.0/25", "1.2.5.128/25", "1.3.0.0/24"),
+			pfx("1.2.0.0/16"), 24, false,
+			pfxs("1.2.3.0/24", "1.2.5.0/24"),
+		},
+		{
+			pfxs("1.2.3.0/24", "1.2.4.128/25", "1.2.5.0/25", "1.2.5.128/25", "1.3.0.0/24"),
+			pfx("1.2.0.0/16"), 24, true,
+			pfxs("1.2.3.0/24", "1.2.4.0/24", "1.2.5.0/24"),
+		},
+		// A subnet partly covered by two separate ranges is yielded once
+		{
+			pfxs("1.2.3.0/26", "1.2.3.128/26", "1.2.4.0/24"),
+			pfx("1.2.0.0/16"), 24, true,
+			pfxs("1.2.3.0/24", "1.2.4.0/24"),
+		},
+		{
+			pfxs("1.2.3.0/26", "1.2.3.128/26"),
+			pfx("1.2.0.0/16"), 24, false,
+			pfxs(),
+		},
+		// Ranges spanning several subnets, with unaligned ends
+		{
+			pfxs("1.2.3.192/26", "1.2.4.0/23", "1.2.6.0/25"),
+			pfx("1.2.0.0/16"), 24, false,
+			pfxs("1.2.4.0/24", "1.2.5.0/24"),
+		},
+		// Nested entries
+		{
+			pfxs("1.2.4.0/23", "1.2.4.0/24", "1.2.5.5/32"),
+			pfx("1.2.0.0/16"), 24, false,
+			pfxs("1.2.4.0/24", "1.2.5.0/24"),
+		},
+		// p is covered by an ancestor
+		{
+			pfxs("1.0.0.0/8"),
+			pfx("1.2.3.0/24"), 26, false,
+			pfxs("1.2.3.0/26", "1.2.3.64/26", "1.2.3.128/26", "1.2.3.192/26"),
+		},
+		{pfxs("1.2.3.0/24"), pfx("1.2.3.0/24"), 24, false, pfxs("1.2.3.0/24")},
+		{
+			pfxs("2001:db8::/48", "2001:db8:2::/64"),
+			pfx("2001:db8::/46"), 48, true,
+			pfxs("2001:db8::/48", "2001:db8:2::/48"),
+		},
+		// Subnets at the very end of the address space
+		{
+			pfxs("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127"),
+			pfx("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/126"), 128, false,
+			pfxs("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/128", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff/128"),
+		},
+		// Invalid lengths
+		{pfxs("1.2.3.0/24"), pfx("1.2.3.0/24"), 23, true, pfxs()},
+		{pfxs("1.2.3.0/24"), pfx("1.2.3.0/24"), 33, true, pfxs()},
+		{pfxs("1.2.3.0/24"), netip.Prefix{}, 24, true, pfxs()},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		seq := psb.PrefixSet().SubnetsOfLength(tt.p, tt.newLen, tt.partial)
+		checkPrefixSeq(t, seq, tt.want)
+		checkYieldFalse(t, seq)
+	}
+}
+
+func TestPrefixSetRootsIter(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/16", "2001:db8::/32", "2001:db8::/48") {
+		psb.Add(p)
+	}
+	seq := psb.PrefixSet().RootsIter()
+	checkPrefixSeq(t, seq, pfxs("1.2.0.0/16", "1.3.0.0/16", "2001:db8::/32"))
+	checkYieldFalse(t, seq)
+}
@@ -0,0 +1,191 @@
+package netipds
+
+// Relation describes how the address space covered by one PrefixSet relates
+// to that of another. See [PrefixSet.Relation].
+type Relation int
+
+const (
+	// Equal means both sets cover exactly the same addresses.
+	Equal Relation = iota
+	// Subset means every address covered by the first set is covered by the
+	// second, but not vice versa.
+	Subset
+	// Superset means every address covered by the second set is covered by
+	// the first, but not vice versa.
+	Superset
+	// Overlapping means the sets cover some addresses in common, and each
+	// covers some addresses the other does not.
+	Overlapping
+	// Disjoint means the sets cover no addresses in common.
+	Disjoint
+)
+
+func (r Relation) String() string {
+	switch r {
+	case Equal:
+		return "Equal"
+	case Subset:
+		return "Subset"
+	case Superset:
+		return "Superset"
+	case Overlapping:
+		return "Overlapping"
+	case Disjoint:
+		return "Disjoint"
+	default:
+		return "Relation(?)"
+	}
+}
+
+// Relation reports how the addresses covered by s relate to those covered by
+// o. Relations are determined by coverage, not by how the sets' Prefixes are
+// expressed; for example, {::0/127} is Equal to {::0/128, ::1/128}.
+//
+// An empty set is a Subset of any non-empty set, and Equal to another empty
+// set.
+func (s *PrefixSet) Relation(o *PrefixSet) Relation {
+	var onlyS, onlyO, both bool
+	sCov, oCov := s.tree.coverage(), o.tree.coverage()
+	compareCoverage(sCov, oCov, func(_ keyRange, inS, inO bool) bool {
+		onlyS = onlyS || (inS && !inO)
+		onlyO = onlyO || (inO && !inS)
+		both = both || (inS && inO)
+		// Nothing more can be learned
+		return !(onlyS && onlyO && both)
+	})
+	switch {
+	case !onlyS && !onlyO:
+		return Equal
+	case !onlyS:
+		return Subset
+	case !onlyO:
+		return Superset
+	case !both:
+		return Disjoint
+	default:
+		return Overlapping
+	}
+}
+
+// CoverageDiff compares the addresses covered by s and o, independent of how
+// their Prefixes are expressed. It returns the addresses covered by o but not
+// s (gained) and those covered by s but not o (lost), each as the smallest
+// set of Prefixes covering them.
+//
+// For example, if s is {::0/127} and o is {::0/128, ::2/128}, then gained is
+// {::2/128} and lost is {::1/128}.
+func (s *PrefixSet) CoverageDiff(o *PrefixSet) (gained, lost *PrefixSet) {
+	var gainedRanges, lostRanges []keyRange
+	sCov, oCov := s.tree.coverage(), o.tree.coverage()
+	compareCoverage(sCov, oCov, func(r keyRange, inS, inO bool) bool {
+		switch {
+		case inS && !inO:
+			lostRanges = append(lostRanges, r)
+		case inO && !inS:
+			gainedRanges = append(gainedRanges, r)
+		}
+		return true
+	})
+	g, l := treeFromRanges(gainedRanges), treeFromRanges(lostRanges)
+	return &PrefixSet{*g, g.stats()}, &PrefixSet{*l, l.stats()}
+}
+
+// SubsetOf returns true if every address covered by s is also covered by o.
+// Like [PrefixSet.Relation], it compares coverage rather than Prefixes, so a
+// Prefix in s may be covered by several smaller Prefixes in o. For example,
+// {::0/127} is a subset of {::0/128, ::1/128}.
+//
+// Every set is a subset of itself, and the empty set is a subset of every
+// set.
+func (s *PrefixSet) SubsetOf(o *PrefixSet) bool {
+	subset := true
+	compareCoverage(s.tree.coverage(), o.tree.coverage(), func(_ keyRange, inS, inO bool) bool {
+		subset = !inS || inO
+		return subset
+	})
+	return subset
+}
+
+// SupersetOf returns true if every address covered by o is also covered by s.
+// It is equivalent to o.SubsetOf(s).
+func (s *PrefixSet) SupersetOf(o *PrefixSet) bool {
+	return o.SubsetOf(s)
+}
+
+// Overlaps returns true if any Prefix in s overlaps any Prefix in o, i.e. if
+// they cover any addresses in common.
+func (s *PrefixSet) Overlaps(o *PrefixSet) bool {
+	var found bool
+	overlapping(&s.tree, &o.tree, func(*tree[bool]) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// OverlappingPrefixes returns a PrefixSet containing the Prefixes in s which
+// overlap at least one Prefix in o: those that encompass, or are encompassed
+// by, a Prefix in o.
+func (s *PrefixSet) OverlappingPrefixes(o *PrefixSet) *PrefixSet {
+	ret := &tree[bool]{}
+	overlapping(&s.tree, &o.tree, func(n *tree[bool]) bool {
+		ret = ret.insert(n.key.rooted(), true)
+		return true
+	})
+	return &PrefixSet{*ret, ret.stats()}
+}
+
+// overlapping calls fn with each entry of a which overlaps an entry of b,
+// until fn returns false. The entries are not visited in order.
+//
+// Both trees are walked simultaneously, in key order, keeping track of the
+// entries of each which are ancestors of the current key. An entry of a
+// overlaps b if it has an ancestor in b when it is reached, or if it is still
+// an ancestor of the current key when an entry of b is reached.
+func overlapping[T, U any](a *tree[T], b *tree[U], fn func(*tree[T]) bool) {
+	type open struct {
+		n        *tree[T]
+		overlaps bool
+	}
+	var as []open
+	var bs []key
+	// closeAll closes the open entries which aren't ancestors of k, or all of
+	// them if all is true. It returns false if fn did.
+	closeAll := func(k key, all bool) bool {
+		for len(as) > 0 && (all || !as[len(as)-1].n.key.isPrefixOf(k, false)) {
+			top := as[len(as)-1]
+			as = as[:len(as)-1]
+			if top.overlaps && !fn(top.n) {
+				return false
+			}
+		}
+		for len(bs) > 0 && (all || !bs[len(bs)-1].isPrefixOf(k, false)) {
+			bs = bs[:len(bs)-1]
+		}
+		return true
+	}
+	ai, bi := newEntryIter(a), newEntryIter(b)
+	x, y := ai.next(), bi.next()
+	for x != nil || (y != nil && len(as) > 0) {
+		// On ties, take b's entry first, so that it is open when a's arrives
+		if y != nil && (x == nil || y.key.compare(x.key) <= 0) {
+			if !closeAll(y.key, false) {
+				return
+			}
+			// Every open entry of a encompasses y. Those below the first one
+			// already marked were marked along with it.
+			for i := len(as) - 1; i >= 0 && !as[i].overlaps; i-- {
+				as[i].overlaps = true
+			}
+			bs = append(bs, y.key)
+			y = bi.next()
+		} else {
+			if !closeAll(x.key, false) {
+				return
+			}
+			as = append(as, open{x, len(bs) > 0})
+			x = ai.next()
+		}
+	}
+	closeAll(key{}, true)
+}
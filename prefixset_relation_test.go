@@ -0,0 +1,148 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetRelation(t *testing.T) {
+	tests := []struct {
+		a    []netip.Prefix
+		b    []netip.Prefix
+		want Relation
+	}{
+		{pfxs(), pfxs(), Equal},
+		{pfxs("::0/128"), pfxs("::0/128"), Equal},
+		{pfxs("::0/127"), pfxs("::0/128", "::1/128"), Equal},
+		{pfxs("::0/126", "::0/128"), pfxs("::0/127", "::2/127"), Equal},
+		{pfxs(), pfxs("::0/128"), Subset},
+		{pfxs("::0/128"), pfxs("::0/127"), Subset},
+		{pfxs("::1/128", "::2/128"), pfxs("::0/126"), Subset},
+		{pfxs("::0/127"), pfxs("::0/128"), Superset},
+		{pfxs("::0/128"), pfxs("::1/128"), Disjoint},
+		{pfxs("::0/127"), pfxs("::2/127"), Disjoint},
+		{pfxs("::0/127"), pfxs("::1/128", "::2/128"), Overlapping},
+		{pfxs("::0/128", "::2/128"), pfxs("::2/127"), Overlapping},
+
+		// IPv4
+		{pfxs("1.2.3.0/24"), pfxs("1.2.0.0/16"), Subset},
+		{pfxs("1.2.3.0/24"), pfxs("1.2.3.128/25", "1.2.3.0/25"), Equal},
+		{pfxs("1.2.3.0/24"), pfxs("1.2.4.0/24"), Disjoint},
+		{pfxs("1.2.3.0/24"), pfxs("::0/128"), Disjoint},
+	}
+	build := func(ps []netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	converse := map[Relation]Relation{
+		Equal:       Equal,
+		Subset:      Superset,
+		Superset:    Subset,
+		Overlapping: Overlapping,
+		Disjoint:    Disjoint,
+	}
+	for _, tt := range tests {
+		a, b := build(tt.a), build(tt.b)
+		if got := a.Relation(b); got != tt.want {
+			t.Errorf("%v.Relation(%v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Relation(a); got != converse[tt.want] {
+			t.Errorf("%v.Relation(%v) = %v, want %v", tt.b, tt.a, got, converse[tt.want])
+		}
+		wantSubset := tt.want == Equal || tt.want == Subset
+		if got := a.SubsetOf(b); got != wantSubset {
+			t.Errorf("%v.SubsetOf(%v) = %v, want %v", tt.a, tt.b, got, wantSubset)
+		}
+		if got := b.SupersetOf(a); got != wantSubset {
+			t.Errorf("%v.SupersetOf(%v) = %v, want %v", tt.b, tt.a, got, wantSubset)
+		}
+	}
+}
+
+func TestPrefixSetCoverageDiff(t *testing.T) {
+	tests := []struct {
+		a      []netip.Prefix
+		b      []netip.Prefix
+		gained []netip.Prefix
+		lost   []netip.Prefix
+	}{
+		{pfxs(), pfxs(), pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs(), pfxs(), pfxs("::0/128")},
+		{pfxs(), pfxs("::0/128"), pfxs("::0/128"), pfxs()},
+		// Re-expressed Prefixes are not changes
+		{pfxs("::0/127"), pfxs("::0/128", "::1/128"), pfxs(), pfxs()},
+		{pfxs("::0/126", "::0/128"), pfxs("::0/127", "::2/127"), pfxs(), pfxs()},
+		{pfxs("::0/127"), pfxs("::0/128", "::2/128"), pfxs("::2/128"), pfxs("::1/128")},
+		{pfxs("::0/124"), pfxs("::0/128"), pfxs(), pfxs("::1/128", "::2/127", "::4/126", "::8/125")},
+		{pfxs("::1/128", "::2/128"), pfxs("::0/126"), pfxs("::0/128", "::3/128"), pfxs()},
+
+		// IPv4
+		{
+			pfxs("1.2.3.0/24"),
+			pfxs("1.2.3.0/25", "1.2.4.0/24"),
+			pfxs("1.2.4.0/24"),
+			pfxs("1.2.3.128/25"),
+		},
+	}
+	build := func(ps []netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	for _, tt := range tests {
+		gained, lost := build(tt.a).CoverageDiff(build(tt.b))
+		checkPrefixSlice(t, gained.Prefixes(), tt.gained)
+		checkPrefixSlice(t, lost.Prefixes(), tt.lost)
+	}
+}
+
+func TestPrefixSetOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b []netip.Prefix
+		want []netip.Prefix
+	}{
+		{pfxs(), pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs(), pfxs()},
+		{pfxs(), pfxs("::0/128"), pfxs()},
+		{pfxs("::0/128"), pfxs("::0/128"), pfxs("::0/128")},
+		{pfxs("::0/128"), pfxs("::1/128"), pfxs()},
+		// Ancestors and descendants in either set
+		{pfxs("::0/128", "::2/128"), pfxs("::0/127"), pfxs("::0/128")},
+		{pfxs("::0/126", "::4/126"), pfxs("::2/128"), pfxs("::0/126")},
+		{pfxs("::0/126", "::0/127", "::2/127", "::3/128"), pfxs("::2/128"), pfxs("::0/126", "::2/127")},
+		{
+			pfxs("1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/16", "2.0.0.0/8", "2001:db8::/32"),
+			pfxs("1.2.3.4/32", "2.0.0.0/7", "2001:db8:1::/48"),
+			pfxs("1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "2.0.0.0/8", "2001:db8::/32"),
+		},
+		{pfxs("1.2.3.0/24"), pfxs("::0/128", "2001:db8::/32"), pfxs()},
+	}
+	build := func(ps []netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	for _, tt := range tests {
+		a, b := build(tt.a), build(tt.b)
+		checkPrefixSlice(t, a.OverlappingPrefixes(b).Prefixes(), tt.want)
+		if got, want := a.Overlaps(b), len(tt.want) > 0; got != want {
+			t.Errorf("%v.Overlaps(%v) = %v, want %v", tt.a, tt.b, got, want)
+		}
+		if got, want := b.Overlaps(a), len(tt.want) > 0; got != want {
+			t.Errorf("%v.Overlaps(%v) = %v, want %v", tt.b, tt.a, got, want)
+		}
+		// Each result must match OverlapsPrefix
+		for _, p := range tt.a {
+			if got, want := a.OverlappingPrefixes(b).Contains(p), b.OverlapsPrefix(p); got != want {
+				t.Errorf("%v.OverlappingPrefixes(%v) contains %v = %v, want %v", tt.a, tt.b, p, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package netipds
+
+import (
+	"encoding/binary"
+	"math"
+	"net/netip"
+)
+
+// IPv4Bitmap is the subset of a 32-bit bitmap's methods needed to convert
+// between bitmaps and the IPv4 portion of a PrefixSet. It is satisfied by
+// *roaring.Bitmap from github.com/RoaringBitmap/roaring, among others.
+//
+// Each value in the bitmap is an IPv4 address in big-endian integer form.
+type IPv4Bitmap interface {
+	// AddRange adds all values in [start, end).
+	AddRange(start, end uint64)
+	// Iterate calls fn with each value in ascending order until fn returns
+	// false.
+	Iterate(fn func(x uint32) bool)
+}
+
+// FillIPv4Bitmap adds every IPv4 address covered by s to b. IPv6 Prefixes in s
+// are ignored.
+func (s *PrefixSet) FillIPv4Bitmap(b IPv4Bitmap) {
+	s.tree.walk(key{}, func(n *tree[bool]) bool {
+		if !n.hasEntry {
+			return false
+		}
+		p := n.key.toPrefix()
+		if !p.Addr().Is4() {
+			return false
+		}
+		start := uint64(ipv4ToUint32(p.Addr()))
+		b.AddRange(start, start+uint64(1)<<(32-p.Bits()))
+		return true
+	})
+}
+
+// AddIPv4Bitmap adds the addresses in b to s, as the smallest set of
+// Prefixes which exactly covers them.
+func (s *PrefixSetBuilder) AddIPv4Bitmap(b IPv4Bitmap) {
+	var from, to uint32
+	var inRun bool
+	b.Iterate(func(x uint32) bool {
+		if inRun && to < math.MaxUint32 && x == to+1 {
+			to = x
+			return true
+		}
+		if inRun {
+			s.addIPv4Range(from, to)
+		}
+		from, to, inRun = x, x, true
+		return true
+	})
+	if inRun {
+		s.addIPv4Range(from, to)
+	}
+}
+
+// addIPv4Range adds the smallest set of Prefixes which exactly covers the
+// IPv4 addresses [from, to] to s.
+func (s *PrefixSetBuilder) addIPv4Range(from, to uint32) {
+	rangeKeys(
+		u128From16(uint32ToIPv4(from).As16()),
+		u128From16(uint32ToIPv4(to).As16()),
+		func(k key) bool {
+			s.Add(k.toPrefix())
+			return true
+		},
+	)
+}
+
+func ipv4ToUint32(a netip.Addr) uint32 {
+	a4 := a.As4()
+	return binary.BigEndian.Uint32(a4[:])
+}
+
+func uint32ToIPv4(x uint32) netip.Addr {
+	var a4 [4]byte
+	binary.BigEndian.PutUint32(a4[:], x)
+	return netip.AddrFrom4(a4)
+}
@@ -0,0 +1,95 @@
+package netipds
+
+import (
+	"net/netip"
+	"sort"
+	"testing"
+)
+
+// sliceBitmap is a naive IPv4Bitmap used for testing.
+type sliceBitmap []uint32
+
+func (b *sliceBitmap) AddRange(start, end uint64) {
+	for x := start; x < end; x++ {
+		*b = append(*b, uint32(x))
+	}
+	sort.Slice(*b, func(i, j int) bool { return (*b)[i] < (*b)[j] })
+}
+
+func (b *sliceBitmap) Iterate(fn func(x uint32) bool) {
+	for _, x := range *b {
+		if !fn(x) {
+			return
+		}
+	}
+}
+
+func TestPrefixSetFillIPv4Bitmap(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		want []string
+	}{
+		{pfxs(), nil},
+		{pfxs("1.2.3.4/32"), []string{"1.2.3.4"}},
+		{pfxs("1.2.3.4/31"), []string{"1.2.3.4", "1.2.3.5"}},
+		{pfxs("1.2.3.4/31", "1.2.3.5/32"), []string{"1.2.3.4", "1.2.3.5"}},
+		{pfxs("255.255.255.255/32"), []string{"255.255.255.255"}},
+		{pfxs("1.2.3.4/32", "::1/128"), []string{"1.2.3.4"}},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		var b sliceBitmap
+		psb.PrefixSet().FillIPv4Bitmap(&b)
+		if len(b) != len(tt.want) {
+			t.Errorf("got %v, want %v", b, tt.want)
+			continue
+		}
+		for i, x := range b {
+			if got := uint32ToIPv4(x).String(); got != tt.want[i] {
+				t.Errorf("got %v, want %v", got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestPrefixSetBuilderAddIPv4Bitmap(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		want  []netip.Prefix
+	}{
+		{nil, pfxs()},
+		{[]string{"1.2.3.4"}, pfxs("1.2.3.4/32")},
+		{[]string{"1.2.3.4", "1.2.3.5"}, pfxs("1.2.3.4/31")},
+		{[]string{"1.2.3.3", "1.2.3.4"}, pfxs("1.2.3.3/32", "1.2.3.4/32")},
+		{
+			[]string{"1.2.3.4", "1.2.3.5", "1.2.3.6", "1.2.3.7", "1.2.3.9"},
+			pfxs("1.2.3.4/30", "1.2.3.9/32"),
+		},
+		{[]string{"255.255.255.254", "255.255.255.255"}, pfxs("255.255.255.254/31")},
+	}
+	for _, tt := range tests {
+		var b sliceBitmap
+		for _, s := range tt.addrs {
+			b = append(b, ipv4ToUint32(netip.MustParseAddr(s)))
+		}
+		psb := &PrefixSetBuilder{}
+		psb.AddIPv4Bitmap(&b)
+		checkPrefixSlice(t, psb.PrefixSet().Prefixes(), tt.want)
+	}
+}
+
+func TestPrefixSetIPv4BitmapRoundTrip(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("10.0.0.0/30", "10.0.0.8/29", "192.168.0.0/28") {
+		psb.Add(p)
+	}
+	var b sliceBitmap
+	psb.PrefixSet().FillIPv4Bitmap(&b)
+
+	got := &PrefixSetBuilder{}
+	got.AddIPv4Bitmap(&b)
+	checkPrefixSlice(t, got.PrefixSet().Prefixes(), psb.PrefixSet().Prefixes())
+}
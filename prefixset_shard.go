@@ -0,0 +1,44 @@
+package netipds
+
+// Shard partitions the Prefixes in s into n disjoint PrefixSets of roughly
+// equal size. Each shard holds a contiguous range of s in sorted order, and
+// a Prefix is always placed in the same shard as its ancestors, so the shards
+// cover disjoint ranges of addresses. As a result, shards may be unbalanced
+// when s contains Prefixes with many descendants.
+//
+// Shard always returns n PrefixSets, some of which may be empty. It returns
+// nil if n < 1.
+func (s *PrefixSet) Shard(n int) []*PrefixSet {
+	if n < 1 {
+		return nil
+	}
+	shards := make([]*tree[bool], n)
+	for i := range shards {
+		shards[i] = &tree[bool]{}
+	}
+	// i is the current shard; count is the number of Prefixes placed so far
+	i, count, shardStart := 0, 0, 0
+	s.tree.walk(key{}, func(r *tree[bool]) bool {
+		if !r.hasEntry {
+			return false
+		}
+		// Move on to the next shard once this one has its share
+		if i < n-1 && count > shardStart && count >= ((i+1)*s.stats.size()+n-1)/n {
+			i++
+			shardStart = count
+		}
+		r.walk(key{}, func(d *tree[bool]) bool {
+			if d.hasEntry {
+				shards[i] = shards[i].insert(d.key.rooted(), true)
+				count++
+			}
+			return false
+		})
+		return true
+	})
+	ret := make([]*PrefixSet, n)
+	for i, t := range shards {
+		ret[i] = &PrefixSet{*t, t.stats()}
+	}
+	return ret
+}
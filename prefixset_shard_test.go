@@ -0,0 +1,65 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetShard(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		n    int
+		want [][]netip.Prefix
+	}{
+		{pfxs(), 0, nil},
+		{pfxs("::0/128"), -1, nil},
+		{pfxs(), 2, [][]netip.Prefix{pfxs(), pfxs()}},
+		{pfxs("::0/128", "::1/128"), 1, [][]netip.Prefix{pfxs("::0/128", "::1/128")}},
+		{
+			pfxs("::0/128", "::1/128", "::2/128", "::3/128"),
+			2,
+			[][]netip.Prefix{pfxs("::0/128", "::1/128"), pfxs("::2/128", "::3/128")},
+		},
+		{
+			pfxs("::0/128", "::1/128"),
+			4,
+			[][]netip.Prefix{pfxs("::0/128"), pfxs("::1/128"), pfxs(), pfxs()},
+		},
+		{
+			pfxs("::0/128", "::1/128", "::2/128"),
+			2,
+			[][]netip.Prefix{pfxs("::0/128", "::1/128"), pfxs("::2/128")},
+		},
+		// Descendants stay with their ancestors
+		{
+			pfxs("::0/126", "::0/128", "::1/128", "::4/128"),
+			2,
+			[][]netip.Prefix{pfxs("::0/126", "::0/128", "::1/128"), pfxs("::4/128")},
+		},
+
+		// IPv4
+		{
+			pfxs("10.0.0.0/8", "10.1.0.0/16", "172.16.0.0/12", "192.168.0.0/16"),
+			3,
+			[][]netip.Prefix{
+				pfxs("10.0.0.0/8", "10.1.0.0/16"),
+				pfxs("172.16.0.0/12"),
+				pfxs("192.168.0.0/16"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		got := psb.PrefixSet().Shard(tt.n)
+		if len(got) != len(tt.want) {
+			t.Errorf("Shard(%d) returned %d shards, want %d", tt.n, len(got), len(tt.want))
+			continue
+		}
+		for i, s := range got {
+			checkPrefixSlice(t, s.Prefixes(), tt.want[i])
+		}
+	}
+}
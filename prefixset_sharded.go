@@ -0,0 +1,100 @@
+package netipds
+
+import (
+	"math/bits"
+	"net/netip"
+	"runtime"
+	"sync"
+)
+
+// ShardedPrefixSetBuilder builds an immutable [PrefixSet] from many goroutines
+// at once. The key space is partitioned into ranges, each with its own
+// builder and lock, so goroutines adding Prefixes in different ranges don't
+// contend with each other.
+//
+// Its methods are safe for concurrent use. Use
+// [NewShardedPrefixSetBuilder] to create one.
+type ShardedPrefixSetBuilder struct {
+	bits   uint
+	shards []builderShard
+}
+
+type builderShard struct {
+	mu sync.Mutex
+	b  PrefixSetBuilder
+}
+
+// NewShardedPrefixSetBuilder returns an empty ShardedPrefixSetBuilder suited
+// to concurrent use by up to workers goroutines. If workers <= 0, GOMAXPROCS
+// is used.
+//
+// If lazy, then the builder for each range is lazy (see
+// [PrefixSetBuilder]).
+func NewShardedPrefixSetBuilder(workers int, lazy bool) *ShardedPrefixSetBuilder {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	// Use several shards per worker, so that workers rarely collide
+	b := uint(min(bits.Len(uint(workers))+2, 16))
+	s := &ShardedPrefixSetBuilder{
+		bits:   b,
+		shards: make([]builderShard, numPartitions(b)),
+	}
+	for i := range s.shards {
+		s.shards[i].b.Lazy = lazy
+	}
+	return s
+}
+
+// shard returns the shard responsible for k.
+func (s *ShardedPrefixSetBuilder) shard(k key) *builderShard {
+	return &s.shards[partitionOf(k, s.bits)]
+}
+
+// Add adds p to s.
+func (s *ShardedPrefixSetBuilder) Add(p netip.Prefix) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	sh := s.shard(keyFromPrefix(p))
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.b.Add(p)
+}
+
+// Remove removes p from s. Only the exact Prefix provided is removed;
+// descendants are not.
+func (s *ShardedPrefixSetBuilder) Remove(p netip.Prefix) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	sh := s.shard(keyFromPrefix(p))
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.b.Remove(p)
+}
+
+// PrefixSet returns an immutable PrefixSet representing the current state of
+// s. Adds and Removes that happen concurrently with PrefixSet may or may not
+// be included.
+//
+// Every key in a shard sorts before every key in the following shards, so
+// the shards' contents are joined into a single tree in one linear pass.
+//
+// The builder remains usable after calling PrefixSet.
+func (s *ShardedPrefixSetBuilder) PrefixSet() *PrefixSet {
+	var keys []key
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		sh.b.tree.walk(key{}, func(n *tree[bool]) bool {
+			if n.hasEntry {
+				keys = append(keys, n.key.rooted())
+			}
+			return false
+		})
+		sh.mu.Unlock()
+	}
+	t := treeFromSorted(keys, func(int) bool { return true })
+	return &PrefixSet{*t, t.stats()}
+}
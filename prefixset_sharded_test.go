@@ -0,0 +1,68 @@
+package netipds
+
+import (
+	"math/rand"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+func TestShardedPrefixSetBuilder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var ps []netip.Prefix
+	for i := 0; i < 2000; i++ {
+		if i%4 == 0 {
+			var a [16]byte
+			r.Read(a[:])
+			ps = append(ps, netip.PrefixFrom(netip.AddrFrom16(a), 16+r.Intn(113)).Masked())
+		} else {
+			a := netip.AddrFrom4([4]byte{byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), 0})
+			ps = append(ps, netip.PrefixFrom(a, 1+r.Intn(32)).Masked())
+		}
+	}
+	// Prefixes spanning several shards, and low IPv6 addresses
+	ps = append(ps, pfxs("0.0.0.0/1", "128.0.0.0/2", "::1/128", "8000::/1")...)
+
+	for _, lazy := range []bool{false, true} {
+		want := &PrefixSetBuilder{}
+		for _, p := range ps {
+			want.Add(p)
+		}
+		for _, p := range ps[:100] {
+			want.Remove(p)
+		}
+
+		sb := NewShardedPrefixSetBuilder(4, lazy)
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := w; i < len(ps); i += 4 {
+					sb.Add(ps[i])
+				}
+			}(w)
+		}
+		wg.Wait()
+		for _, p := range ps[:100] {
+			sb.Remove(p)
+		}
+
+		got := sb.PrefixSet()
+		checkPrefixSlice(t, got.Prefixes(), want.PrefixSet().Prefixes())
+		if got.Size() != want.PrefixSet().Size() {
+			t.Errorf("lazy=%v: Size() = %d, want %d", lazy, got.Size(), want.PrefixSet().Size())
+		}
+		if got, want := countNodes(&got.tree), countNodes(&want.PrefixSet().tree); got > want {
+			t.Errorf("lazy=%v: %d nodes, want at most %d", lazy, got, want)
+		}
+	}
+
+	sb := NewShardedPrefixSetBuilder(0, false)
+	if err := sb.Add(netip.Prefix{}); err == nil {
+		t.Errorf("Add(invalid) returned nil error")
+	}
+	if got := sb.PrefixSet().Size(); got != 0 {
+		t.Errorf("empty Size() = %d, want 0", got)
+	}
+}
@@ -90,6 +90,53 @@ func TestPrefixSetEncompassesStrict(t *testing.T) {
 	}
 }
 
+func TestPrefixSetContainsAddr(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		get  netip.Addr
+		want bool
+	}{
+		{pfxs(), netip.MustParseAddr("::0"), false},
+		{pfxs("::0/128"), netip.MustParseAddr("::0"), true},
+		{pfxs("::0/128"), netip.MustParseAddr("::1"), false},
+		{pfxs("::0/127"), netip.MustParseAddr("::1"), true},
+		{pfxs("::0/128", "::2/128"), netip.MustParseAddr("::1"), false},
+		{pfxs("1.2.3.0/24"), netip.MustParseAddr("1.2.3.4"), true},
+		{pfxs("1.2.3.0/24"), netip.MustParseAddr("1.2.4.4"), false},
+		{pfxs("1.2.3.0/24"), netip.MustParseAddr("::ffff:1.2.3.4"), true},
+		{pfxs("fe80::/64"), netip.MustParseAddr("fe80::1%eth0"), true},
+		{pfxs("::0/128"), netip.Addr{}, false},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		ps := psb.PrefixSet()
+		if got := ps.ContainsAddr(tt.get); got != tt.want {
+			t.Errorf("ps.ContainsAddr(%s) = %v, want %v", tt.get, got, tt.want)
+		}
+		if got := ps.EncompassesAddr(tt.get); got != tt.want {
+			t.Errorf("ps.EncompassesAddr(%s) = %v, want %v", tt.get, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixSetContainsAddrAllocs(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	for _, p := range pfxs("1.2.0.0/16", "1.2.3.0/24", "2001:db8::/32") {
+		psb.Add(p)
+	}
+	ps := psb.PrefixSet()
+	a := netip.MustParseAddr("1.2.3.4")
+	if n := testing.AllocsPerRun(100, func() { ps.ContainsAddr(a) }); n != 0 {
+		t.Errorf("ps.ContainsAddr allocated %v times, want 0", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { ps.EncompassesAddr(a) }); n != 0 {
+		t.Errorf("ps.EncompassesAddr allocated %v times, want 0", n)
+	}
+}
+
 func TestPrefixSetRootOf(t *testing.T) {
 	tests := []struct {
 		set        []netip.Prefix
@@ -423,6 +470,72 @@ func TestPrefixSetDescendantsOfStrict(t *testing.T) {
 	}
 }
 
+func TestPrefixSetEncompassesWithin(t *testing.T) {
+	tests := []struct {
+		set      []netip.Prefix
+		get      netip.Prefix
+		min, max int
+		want     bool
+	}{
+		{pfxs(), pfx("::0/128"), 0, 128, false},
+		{pfxs("::0/128"), pfx("::0/128"), 0, 128, true},
+		{pfxs("::0/128"), pfx("::0/128"), 0, 127, false},
+		{pfxs("::0/127"), pfx("::0/128"), 0, 127, true},
+		{pfxs("::0/127"), pfx("::0/128"), 128, 128, false},
+		{pfxs("::0/126", "::0/128"), pfx("::0/128"), 127, 128, true},
+		{pfxs("::0/126", "::0/128"), pfx("::0/128"), 127, 127, false},
+		{pfxs("::0/128"), pfx("::0/128"), 128, 0, false},
+
+		// IPv4 lengths are relative to IPv4
+		{pfxs("10.0.0.0/8"), pfx("10.1.2.0/24"), 0, 24, true},
+		{pfxs("10.0.0.0/8"), pfx("10.1.2.0/24"), 16, 24, false},
+		{pfxs("10.0.0.0/8", "10.1.0.0/16"), pfx("10.1.2.0/24"), 16, 200, true},
+		{pfxs("10.0.0.0/8", "10.1.0.0/16"), pfx("10.1.2.0/24"), -5, 8, true},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		ps := psb.PrefixSet()
+		if got := ps.EncompassesWithin(tt.get, tt.min, tt.max); got != tt.want {
+			t.Errorf("ps.EncompassesWithin(%s, %d, %d) = %v, want %v",
+				tt.get, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixSetDescendantsOfWithin(t *testing.T) {
+	tests := []struct {
+		set      []netip.Prefix
+		get      netip.Prefix
+		min, max int
+		want     []netip.Prefix
+	}{
+		{pfxs(), pfx("::0/126"), 0, 128, pfxs()},
+		{pfxs("::0/126", "::0/127", "::0/128"), pfx("::0/126"), 0, 128, pfxs("::0/126", "::0/127", "::0/128")},
+		{pfxs("::0/126", "::0/127", "::0/128"), pfx("::0/126"), 127, 127, pfxs("::0/127")},
+		{pfxs("::0/126", "::0/127", "::0/128"), pfx("::0/127"), 0, 127, pfxs("::0/127")},
+		{pfxs("::0/125", "::0/127", "::2/128"), pfx("::0/126"), 0, 128, pfxs("::0/127", "::2/128")},
+
+		// IPv4
+		{
+			pfxs("10.0.0.0/8", "10.1.0.0/20", "10.1.2.0/24", "10.1.2.3/32", "11.0.0.0/24"),
+			pfx("10.0.0.0/8"),
+			20, 24,
+			pfxs("10.1.0.0/20", "10.1.2.0/24"),
+		},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		got := psb.PrefixSet().DescendantsOfWithin(tt.get, tt.min, tt.max)
+		checkPrefixSlice(t, got.Prefixes(), tt.want)
+	}
+}
+
 func TestPrefixSetAncestorsOf(t *testing.T) {
 	tests := []struct {
 		set  []netip.Prefix
@@ -621,6 +734,34 @@ func TestPrefixSetOverlapsPrefix(t *testing.T) {
 	}
 }
 
+func TestPrefixSetOverlappingWith(t *testing.T) {
+	tests := []struct {
+		set  []netip.Prefix
+		get  netip.Prefix
+		want []netip.Prefix
+	}{
+		{pfxs(), pfx("::0/128"), pfxs()},
+		{pfxs("::0/128"), pfx("::0/128"), pfxs("::0/128")},
+		{pfxs("::0/128"), pfx("::1/128"), pfxs()},
+		{pfxs("::0/128", "::1/128", "::2/128"), pfx("::0/127"), pfxs("::0/128", "::1/128")},
+		{pfxs("::0/126", "::0/127", "::0/128", "::2/127"), pfx("::0/127"), pfxs("::0/126", "::0/127", "::0/128")},
+		{
+			pfxs("1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.2.3.4/32", "1.3.0.0/16"),
+			pfx("1.2.3.0/24"),
+			pfxs("1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.2.3.4/32"),
+		},
+		// Make sure entry-less nodes don't count
+		{pfxs("::0/128", "::2/128"), pfx("::3/128"), pfxs()},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		checkPrefixSlice(t, psb.PrefixSet().OverlappingWith(tt.get), tt.want)
+	}
+}
+
 func checkPrefixSlice(t *testing.T, got, want []netip.Prefix) {
 	if len(got) != len(want) {
 		t.Errorf("got %v (len %d), want %v (len %d)", got, len(got), want, len(want))
@@ -689,6 +830,9 @@ func TestPrefixSetSubtract(t *testing.T) {
 		{pfxs("::0/128", "::1/128"), pfxs("::0/128", "::1/128"), pfxs()},
 		{pfxs("::0/127", "::1/128"), pfxs("::0/127"), pfxs()},
 		{pfxs("::3/128"), pfxs("::2/127", "::1/128"), pfxs()},
+		{pfxs(), pfxs("::0/128"), pfxs()},
+		{pfxs("::0/126", "::0/127"), pfxs("::0/128"), pfxs("::1/128", "::2/127")},
+		{pfxs("::0/126", "::0/128"), pfxs("::1/128", "::3/128"), pfxs("::0/128", "::2/128")},
 	}
 	for _, tt := range tests {
 		psb := &PrefixSetBuilder{}
@@ -807,6 +951,123 @@ func TestPrefixSetMerge(t *testing.T) {
 	}
 }
 
+func TestPrefixSetSymmetricDifference(t *testing.T) {
+	tests := []struct {
+		a    []netip.Prefix
+		b    []netip.Prefix
+		want []netip.Prefix
+	}{
+		// Note: since symmetric difference is commutative, all test cases are
+		// performed twice (a ^ b) and (b ^ a)
+		{pfxs(), pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs(), pfxs("::0/128")},
+		{pfxs("::0/128"), pfxs("::0/128"), pfxs()},
+		{pfxs("::0/128"), pfxs("::1/128"), pfxs("::0/127")},
+		{pfxs("::0/126"), pfxs("::1/128", "::4/127"), pfxs("::0/128", "::2/127", "::4/127")},
+		// Nested Prefixes only count once
+		{pfxs("::0/126", "::0/127"), pfxs("::0/127", "::0/128"), pfxs("::2/127")},
+		{pfxs("::0/127", "::2/127"), pfxs("::0/126"), pfxs()},
+		{
+			pfxs("10.0.0.0/8", "192.168.0.0/24"),
+			pfxs("10.128.0.0/9", "192.168.1.0/24", "2001:db8::/32"),
+			pfxs("10.0.0.0/9", "192.168.0.0/23", "2001:db8::/32"),
+		},
+	}
+	build := func(ps []netip.Prefix) *PrefixSet {
+		psb := &PrefixSetBuilder{}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb.PrefixSet()
+	}
+	performTest := func(x, y []netip.Prefix, want []netip.Prefix) {
+		xs, ys := build(x), build(y)
+		checkPrefixSlice(t, xs.SymmetricDifference(ys).Prefixes(), want)
+
+		psb := &PrefixSetBuilder{}
+		for _, p := range x {
+			psb.Add(p)
+		}
+		psb.SymmetricDifference(ys)
+		checkPrefixSlice(t, psb.PrefixSet().Prefixes(), want)
+
+		// The operands must be left unchanged
+		checkPrefixSlice(t, xs.Prefixes(), build(x).Prefixes())
+		checkPrefixSlice(t, ys.Prefixes(), build(y).Prefixes())
+	}
+	for _, tt := range tests {
+		performTest(tt.a, tt.b, tt.want)
+		performTest(tt.b, tt.a, tt.want)
+	}
+}
+
+func TestPrefixSetBuilderOps(t *testing.T) {
+	ops := []struct {
+		name     string
+		withSet  func(*PrefixSetBuilder, *PrefixSet)
+		withBldr func(*PrefixSetBuilder, *PrefixSetBuilder)
+	}{
+		{"Merge", (*PrefixSetBuilder).Merge, (*PrefixSetBuilder).MergeBuilder},
+		{"Intersect", (*PrefixSetBuilder).Intersect, (*PrefixSetBuilder).IntersectBuilder},
+		{"Subtract", (*PrefixSetBuilder).Subtract, (*PrefixSetBuilder).SubtractBuilder},
+		{"Filter", (*PrefixSetBuilder).Filter, (*PrefixSetBuilder).FilterBuilder},
+		{
+			"SymmetricDifference",
+			(*PrefixSetBuilder).SymmetricDifference,
+			(*PrefixSetBuilder).SymmetricDifferenceBuilder,
+		},
+	}
+	tests := []struct {
+		a []netip.Prefix
+		b []netip.Prefix
+	}{
+		{pfxs(), pfxs()},
+		{pfxs("::0/128"), pfxs()},
+		{pfxs(), pfxs("::0/128")},
+		{pfxs("::0/128"), pfxs("::0/128")},
+		{pfxs("::0/126"), pfxs("::0/128")},
+		{pfxs("::0/128", "::1/128"), pfxs("::0/127")},
+		{pfxs("::0/128", "::1/128"), pfxs("::0/126", "::0/127", "::2/127")},
+		{pfxs("1.2.0.0/16", "1.2.3.0/24"), pfxs("1.2.3.0/24", "1.3.0.0/16")},
+		{pfxs("10.0.0.128/27"), pfxs("10.0.0.160/29", "10.0.0.168/29")},
+		{pfxs("10.0.0.160/29", "10.0.0.168/29"), pfxs("10.0.0.128/27")},
+	}
+	build := func(lazy bool, ps []netip.Prefix) *PrefixSetBuilder {
+		psb := &PrefixSetBuilder{Lazy: lazy}
+		for _, p := range ps {
+			psb.Add(p)
+		}
+		return psb
+	}
+	for _, op := range ops {
+		for _, tt := range tests {
+			for _, lazy := range []bool{false, true} {
+				want := build(false, tt.a)
+				op.withSet(want, build(false, tt.b).PrefixSet())
+
+				b := build(lazy, tt.b)
+				bBefore := b.PrefixSet().Prefixes()
+				got := build(false, tt.a)
+				op.withBldr(got, b)
+				checkPrefixSlice(t, got.PrefixSet().Prefixes(), want.PrefixSet().Prefixes())
+
+				// The argument must be left unchanged
+				checkPrefixSlice(t, b.PrefixSet().Prefixes(), bBefore)
+			}
+		}
+	}
+
+	// Merging is equivalent to adding each Prefix
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			want := build(false, append(append([]netip.Prefix{}, tt.a...), tt.b...))
+			got := build(false, tt.a)
+			got.MergeBuilder(build(lazy, tt.b))
+			checkPrefixSlice(t, got.PrefixSet().Prefixes(), want.PrefixSet().Prefixes())
+		}
+	}
+}
+
 func TestPrefixSetRemove(t *testing.T) {
 	tests := []struct {
 		add    []netip.Prefix
@@ -861,6 +1122,32 @@ func TestPrefixSetFilter(t *testing.T) {
 	}
 }
 
+func TestPrefixSetFilterFunc(t *testing.T) {
+	is4 := func(p netip.Prefix) bool { return p.Addr().Is4() }
+	short := func(p netip.Prefix) bool { return p.Bits() <= 16 }
+	tests := []struct {
+		set  []netip.Prefix
+		fn   func(netip.Prefix) bool
+		want []netip.Prefix
+	}{
+		{pfxs(), is4, pfxs()},
+		{pfxs("::0/128"), is4, pfxs()},
+		{pfxs("::0/128", "1.2.3.0/24"), is4, pfxs("1.2.3.0/24")},
+		{pfxs("1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/16"), short, pfxs("1.2.0.0/16", "1.3.0.0/16")},
+		{pfxs("::0/127", "::0/128", "::1/128"), short, pfxs()},
+	}
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			psb := &PrefixSetBuilder{Lazy: lazy}
+			for _, p := range tt.set {
+				psb.Add(p)
+			}
+			psb.FilterFunc(tt.fn)
+			checkPrefixSlice(t, psb.PrefixSet().Prefixes(), tt.want)
+		}
+	}
+}
+
 func TestPrefixSetPrefixesCompact(t *testing.T) {
 	tests := []struct {
 		add  []netip.Prefix
@@ -885,6 +1172,38 @@ func TestPrefixSetPrefixesCompact(t *testing.T) {
 		}
 		ps := psb.PrefixSet()
 		checkPrefixSlice(t, ps.PrefixesCompact(), tt.want)
+		checkPrefixSlice(t, ps.Roots(), tt.want)
+	}
+}
+
+func TestPrefixSetLeaves(t *testing.T) {
+	tests := []struct {
+		add    []netip.Prefix
+		remove []netip.Prefix
+		want   []netip.Prefix
+	}{
+		{pfxs(), nil, pfxs()},
+		{pfxs("::0/128"), nil, pfxs("::0/128")},
+		{pfxs("::0/127", "::0/128"), nil, pfxs("::0/128")},
+		{pfxs("::0/126", "::0/128", "::2/127"), nil, pfxs("::0/128", "::2/127")},
+		{pfxs("::0/126", "::0/128", "::4/126"), nil, pfxs("::0/128", "::4/126")},
+		{
+			pfxs("1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/16", "2000::/3"),
+			nil,
+			pfxs("1.2.3.0/24", "1.3.0.0/16", "2000::/3"),
+		},
+		// Removed descendants leave their ancestors as leaves
+		{pfxs("1.2.0.0/16", "1.2.3.0/24"), pfxs("1.2.3.0/24"), pfxs("1.2.0.0/16")},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.add {
+			psb.Add(p)
+		}
+		for _, p := range tt.remove {
+			psb.Remove(p)
+		}
+		checkPrefixSlice(t, psb.PrefixSet().Leaves(), tt.want)
 	}
 }
 
@@ -914,3 +1233,226 @@ func TestPrefixSetSize(t *testing.T) {
 		}
 	}
 }
+
+func TestPrefixSetAddressCount(t *testing.T) {
+	tests := []struct {
+		add  []netip.Prefix
+		want string
+	}{
+		{pfxs(), "0"},
+		{pfxs("::0/128"), "1"},
+		{pfxs("::0/128", "::1/128"), "2"},
+		// Nested Prefixes are counted once
+		{pfxs("::0/127", "::0/128"), "2"},
+		{pfxs("1.2.3.0/24", "1.2.3.4/32", "1.2.4.0/24"), "512"},
+		{pfxs("0.0.0.0/0"), "4294967296"},
+		{pfxs("8000::/1"), "170141183460469231731687303715884105728"},
+		{pfxs("8000::/1", "::/1"), "340282366920938463463374607431768211456"},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.add {
+			psb.Add(p)
+		}
+		if got := psb.PrefixSet().AddressCount().String(); got != tt.want {
+			t.Errorf("AddressCount(%v) = %s, want %s", tt.add, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixSetCoverage(t *testing.T) {
+	tests := []struct {
+		set           []netip.Prefix
+		p             netip.Prefix
+		wantCovered   string
+		wantFraction  float64
+		wantUncovered []netip.Prefix
+	}{
+		{pfxs(), pfx("10.0.0.0/24"), "0", 0, pfxs("10.0.0.0/24")},
+		{pfxs("10.0.0.0/8"), pfx("10.0.0.0/24"), "256", 1, pfxs()},
+		{pfxs("10.0.0.0/24"), pfx("10.0.0.0/24"), "256", 1, pfxs()},
+		{pfxs("10.0.0.0/25"), pfx("10.0.0.0/24"), "128", 0.5, pfxs("10.0.0.128/25")},
+		{pfxs("10.0.0.128/25"), pfx("10.0.0.0/24"), "128", 0.5, pfxs("10.0.0.0/25")},
+		{
+			pfxs("10.0.0.0/26", "10.0.0.0/27", "10.0.0.192/27", "10.1.0.0/16"),
+			pfx("10.0.0.0/24"),
+			"96", 0.375,
+			pfxs("10.0.0.64/26", "10.0.0.128/26", "10.0.0.224/27"),
+		},
+		{pfxs("10.0.0.0/26", "10.0.0.64/26"), pfx("10.0.0.0/24"), "128", 0.5, pfxs("10.0.0.128/25")},
+		// Only the part within p is counted
+		{pfxs("10.0.1.0/24"), pfx("10.0.0.0/24"), "0", 0, pfxs("10.0.0.0/24")},
+		{pfxs("::/128", "::2/128"), pfx("::/126"), "2", 0.5, pfxs("::1/128", "::3/128")},
+		{pfxs("8000::/1"), pfx("8000::/1"), "170141183460469231731687303715884105728", 1, pfxs()},
+		{pfxs("ffff::/16"), pfx("ff00::/8"), "5192296858534827628530496329220096", 1.0 / 256, pfxs(
+			"ff00::/9", "ff80::/10", "ffc0::/11", "ffe0::/12", "fff0::/13", "fff8::/14", "fffc::/15", "fffe::/16",
+		)},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		s := psb.PrefixSet()
+		covered, fraction := s.Coverage(tt.p)
+		if covered.String() != tt.wantCovered || fraction != tt.wantFraction {
+			t.Errorf("Coverage(%v) of %v = %s, %v, want %s, %v",
+				tt.p, tt.set, covered, fraction, tt.wantCovered, tt.wantFraction)
+		}
+		checkPrefixSlice(t, s.Uncovered(tt.p).Prefixes(), tt.wantUncovered)
+	}
+}
+
+func TestPrefixSetBuilderSnapshots(t *testing.T) {
+	// Each step modifies the builder, then takes a snapshot. Snapshots share
+	// nodes with the builder, so every snapshot is checked again at the end.
+	steps := []struct {
+		add    []netip.Prefix
+		remove []netip.Prefix
+		op     func(*PrefixSetBuilder)
+		want   []netip.Prefix
+	}{
+		{add: pfxs("1.2.0.0/16", "1.2.3.0/24"), want: pfxs("1.2.0.0/16", "1.2.3.0/24")},
+		{add: pfxs("1.2.3.4/32"), want: pfxs("1.2.0.0/16", "1.2.3.0/24", "1.2.3.4/32")},
+		{
+			add:    pfxs("1.2.3.5/32", "2001:db8::/32"),
+			remove: pfxs("1.2.3.0/24"),
+			want:   pfxs("1.2.0.0/16", "1.2.3.4/32", "1.2.3.5/32", "2001:db8::/32"),
+		},
+		{
+			remove: pfxs("1.2.0.0/16", "1.2.3.4/32"),
+			want:   pfxs("1.2.3.5/32", "2001:db8::/32"),
+		},
+		{
+			op: func(b *PrefixSetBuilder) {
+				b.SubtractPrefix(pfx("2001:db8::/33"))
+			},
+			want: pfxs("1.2.3.5/32", "2001:db8:8000::/33"),
+		},
+		{
+			add:  pfxs("1.2.3.4/31", "1.2.3.6/32"),
+			want: pfxs("1.2.3.4/31", "1.2.3.5/32", "1.2.3.6/32", "2001:db8:8000::/33"),
+		},
+		{},
+		{
+			op: func(b *PrefixSetBuilder) {
+				// Merging an earlier snapshot of the builder into itself
+				b.Merge(b.PrefixSet())
+			},
+			remove: pfxs("1.2.3.6/32"),
+			want:   pfxs("1.2.3.4/31", "1.2.3.5/32", "2001:db8:8000::/33"),
+		},
+	}
+	psb := &PrefixSetBuilder{}
+	var sets []*PrefixSet
+	var wants [][]netip.Prefix
+	for _, s := range steps {
+		if s.op != nil {
+			s.op(psb)
+		}
+		for _, p := range s.add {
+			psb.Add(p)
+		}
+		for _, p := range s.remove {
+			psb.Remove(p)
+		}
+		if s.want == nil && len(wants) > 0 {
+			s.want = wants[len(wants)-1]
+		}
+		sets = append(sets, psb.PrefixSet())
+		wants = append(wants, s.want)
+		checkPrefixSlice(t, sets[len(sets)-1].Prefixes(), s.want)
+	}
+	for i, s := range sets {
+		checkPrefixSlice(t, s.Prefixes(), wants[i])
+		if s.Size() != len(wants[i]) {
+			t.Errorf("snapshot %d: Size() = %d, want %d", i, s.Size(), len(wants[i]))
+		}
+	}
+}
+
+// countNodes returns the number of nodes in t, including t itself.
+func countNodes[T any](t *tree[T]) int {
+	if t == nil {
+		return 0
+	}
+	return 1 + countNodes(t.left) + countNodes(t.right)
+}
+
+func TestPrefixSetBuilderCompact(t *testing.T) {
+	for _, tt := range []struct {
+		lazy      bool
+		wantNodes int
+	}{
+		// The root, ::/128, 1.2.3.0/24, and their common ancestor
+		{false, 4},
+		// The root and the full path to each remaining Prefix; the paths
+		// diverge after 80 bits
+		{true, 1 + 128 + (120 - 80)},
+	} {
+		psb := &PrefixSetBuilder{Lazy: tt.lazy}
+		for _, p := range pfxs("::0/128", "::1/128", "::2/128", "1.2.3.0/24", "1.2.3.4/32", "2001:db8::/32") {
+			psb.Add(p)
+		}
+		before := psb.PrefixSet()
+		for _, p := range pfxs("::1/128", "::2/128", "1.2.3.4/32", "2001:db8::/32") {
+			psb.Remove(p)
+		}
+		psb.Compact()
+		if got := countNodes(&psb.tree); got != tt.wantNodes {
+			t.Errorf("lazy=%v: %d nodes after Compact, want %d", tt.lazy, got, tt.wantNodes)
+		}
+		checkPrefixSlice(t, psb.PrefixSet().Prefixes(), pfxs("::0/128", "1.2.3.0/24"))
+		checkPrefixSlice(t, before.Prefixes(), pfxs("::0/128", "::1/128", "::2/128", "1.2.3.0/24", "1.2.3.4/32", "2001:db8::/32"))
+
+		// The builder remains usable
+		psb.Add(pfx("::3/128"))
+		checkPrefixSlice(t, psb.PrefixSet().Prefixes(), pfxs("::0/128", "::3/128", "1.2.3.0/24"))
+	}
+}
+
+func TestPrefixSetBuilderCompress(t *testing.T) {
+	eager := &PrefixSetBuilder{}
+	psb := &PrefixSetBuilder{Lazy: true}
+	add := func(p netip.Prefix) {
+		eager.Add(p)
+		psb.Add(p)
+	}
+	// Load 10.0.0.0/8 and compress it before moving on
+	for i := 0; i < 16; i++ {
+		add(netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i), 0, 0}), 24))
+	}
+	before := countNodes(&psb.tree)
+	if err := psb.CompressPrefix(pfx("10.0.0.0/8")); err != nil {
+		t.Fatal(err)
+	}
+	// The path above 10.0.0.0/8 stays uncompressed: the root, the 103 nodes
+	// leading to it, and then a compressed subtree of 16 entries and 15
+	// branches
+	if got, want := countNodes(&psb.tree), 1+103+31; got != want {
+		t.Errorf("%d nodes after CompressPrefix (%d before), want %d", got, before, want)
+	}
+	// Compressing elsewhere leaves the tree as is
+	psb.CompressPrefix(pfx("11.0.0.0/8"))
+	psb.CompressPrefix(pfx("10.0.0.0/32"))
+	if got, want := countNodes(&psb.tree), 1+103+31; got != want {
+		t.Errorf("%d nodes after no-op CompressPrefix, want %d", got, want)
+	}
+
+	// Later insertions, including into the compressed part, are not lost
+	add(pfx("10.0.0.128/25"))
+	add(pfx("10.0.0.0/12"))
+	add(pfx("11.0.0.0/8"))
+	add(pfx("2001:db8::/32"))
+	checkPrefixSlice(t, psb.PrefixSet().Prefixes(), eager.PrefixSet().Prefixes())
+
+	psb.Compress()
+	if got, want := countNodes(&psb.tree), countNodes(&eager.tree); got != want {
+		t.Errorf("%d nodes after Compress, want %d", got, want)
+	}
+	checkPrefixSlice(t, psb.PrefixSet().Prefixes(), eager.PrefixSet().Prefixes())
+
+	if err := psb.CompressPrefix(netip.Prefix{}); err == nil {
+		t.Errorf("CompressPrefix(invalid) returned nil error")
+	}
+}
@@ -0,0 +1,140 @@
+package netipds
+
+import (
+	"net/netip"
+	"sync"
+)
+
+// VersionedPrefixSet is a PrefixSet which records each version of its
+// contents, so that consumers holding an older version can be sent only the
+// changes made since. Each call to Update, Add or Remove publishes a new
+// version, numbered one more than the last. Version 0 is the empty set.
+//
+// Successive versions share the nodes of their trees that were not changed
+// between them, so each version costs memory in proportion to the size of
+// its changes, not the size of the set.
+//
+// The zero value is a valid VersionedPrefixSet which retains every version.
+// A VersionedPrefixSet is safe for concurrent use, and must not be copied
+// after first use.
+type VersionedPrefixSet struct {
+	// Retain is the number of versions retained, including the latest. Older
+	// versions are discarded as new ones are published. If Retain <= 0, all
+	// versions are retained.
+	Retain int
+
+	mu sync.RWMutex
+	b  PrefixSetBuilder
+	// versions holds the retained versions, oldest first. The latest version
+	// is first+len(versions)-1.
+	versions []*PrefixSet
+	first    uint64
+}
+
+// Version returns the number of the latest version.
+func (v *VersionedPrefixSet) Version() uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.latest()
+}
+
+// latest returns the number of the latest version. v.mu must be held.
+func (v *VersionedPrefixSet) latest() uint64 {
+	if len(v.versions) == 0 {
+		return 0
+	}
+	return v.first + uint64(len(v.versions)) - 1
+}
+
+// Load returns the latest version, along with its number.
+func (v *VersionedPrefixSet) Load() (uint64, *PrefixSet) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.latest(), v.at(v.latest())
+}
+
+// At returns the given version of the set. It returns false if the version
+// has not been published yet or is no longer retained.
+func (v *VersionedPrefixSet) At(version uint64) (*PrefixSet, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	s := v.at(version)
+	return s, s != nil
+}
+
+// at returns the given version of the set, or nil if it is not available.
+// v.mu must be held.
+func (v *VersionedPrefixSet) at(version uint64) *PrefixSet {
+	switch {
+	// Nothing has been published yet, so only the empty version 0 exists
+	case len(v.versions) == 0 && version == 0:
+		return &PrefixSet{}
+	case version < v.first || version > v.latest():
+		return nil
+	}
+	return v.versions[version-v.first]
+}
+
+// ChangesSince returns the Prefixes added to and removed from the set
+// between the given version and the latest one, along with the number of the
+// latest version. Applying the changes to the given version yields the latest
+// version. It returns false if the given version has not been published yet
+// or is no longer retained, in which case consumers must start over from
+// [VersionedPrefixSet.Load].
+//
+// As with [PrefixSet.Diff], Prefixes are compared exactly.
+func (v *VersionedPrefixSet) ChangesSince(
+	version uint64,
+) (latest uint64, added, removed *PrefixSet, ok bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	old := v.at(version)
+	if old == nil {
+		return v.latest(), nil, nil, false
+	}
+	added, removed = old.Diff(v.at(v.latest()))
+	return v.latest(), added, removed, true
+}
+
+// Update calls fn with a builder holding the latest version of the set. When
+// fn returns, the builder's contents are published as a new version, whose
+// number is returned. If fn returns an error, its changes are discarded, no
+// version is published, and the error is returned.
+//
+// fn must not retain the builder or call v's methods.
+func (v *VersionedPrefixSet) Update(fn func(*PrefixSetBuilder) error) (uint64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.b.Begin()
+	if err := fn(&v.b); err != nil {
+		v.b.Rollback()
+		return v.latest(), err
+	}
+	v.b.Commit()
+	if len(v.versions) == 0 {
+		// Version 0 is the empty set; the first published version is 1
+		v.versions = []*PrefixSet{{}}
+	}
+	v.versions = append(v.versions, v.b.PrefixSet())
+	if v.Retain > 0 && len(v.versions) > v.Retain {
+		drop := len(v.versions) - v.Retain
+		clear(v.versions[:drop])
+		v.versions = v.versions[drop:]
+		v.first += uint64(drop)
+	}
+	return v.latest(), nil
+}
+
+// Add adds p and publishes a new version, whose number is returned.
+func (v *VersionedPrefixSet) Add(p netip.Prefix) (uint64, error) {
+	return v.Update(func(b *PrefixSetBuilder) error {
+		return b.Add(p)
+	})
+}
+
+// Remove removes p and publishes a new version, whose number is returned.
+func (v *VersionedPrefixSet) Remove(p netip.Prefix) (uint64, error) {
+	return v.Update(func(b *PrefixSetBuilder) error {
+		return b.Remove(p)
+	})
+}
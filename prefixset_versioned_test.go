@@ -0,0 +1,102 @@
+package netipds
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestVersionedPrefixSet(t *testing.T) {
+	var v VersionedPrefixSet
+	if n, s := v.Load(); n != 0 || s.Size() != 0 {
+		t.Errorf("zero value Load() = (%d, %v), want (0, [])", n, s)
+	}
+
+	v.Add(pfx("1.2.0.0/16"))
+	v.Add(pfx("10.0.0.0/8"))
+	n, err := v.Update(func(b *PrefixSetBuilder) error {
+		b.Add(pfx("1.2.3.0/24"))
+		b.Add(pfx("2001:db8::/32"))
+		return b.Remove(pfx("1.2.0.0/16"))
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("Update() = (%d, %v), want (3, nil)", n, err)
+	}
+
+	// Failed updates publish nothing
+	errFail := errors.New("fail")
+	n, err = v.Update(func(b *PrefixSetBuilder) error {
+		b.Remove(pfx("10.0.0.0/8"))
+		return errFail
+	})
+	if err != errFail || n != 3 {
+		t.Errorf("Update() = (%d, %v), want (3, %v)", n, err, errFail)
+	}
+	if n, err = v.Add(netip.Prefix{}); err == nil || n != 3 {
+		t.Errorf("Add(invalid) = (%d, %v), want (3, error)", n, err)
+	}
+	v.Remove(pfx("10.0.0.0/8"))
+	if got := v.Version(); got != 4 {
+		t.Errorf("Version() = %d, want 4", got)
+	}
+
+	history := [][]netip.Prefix{
+		pfxs(),
+		pfxs("1.2.0.0/16"),
+		pfxs("1.2.0.0/16", "10.0.0.0/8"),
+		pfxs("1.2.3.0/24", "10.0.0.0/8", "2001:db8::/32"),
+		pfxs("1.2.3.0/24", "2001:db8::/32"),
+	}
+	for i, want := range history {
+		s, ok := v.At(uint64(i))
+		if !ok {
+			t.Errorf("At(%d) not found", i)
+			continue
+		}
+		checkPrefixSlice(t, s.Prefixes(), want)
+	}
+	if _, ok := v.At(5); ok {
+		t.Errorf("At(5) found an unpublished version")
+	}
+
+	changeTests := []struct {
+		since   uint64
+		added   []netip.Prefix
+		removed []netip.Prefix
+	}{
+		{0, pfxs("1.2.3.0/24", "2001:db8::/32"), pfxs()},
+		{1, pfxs("1.2.3.0/24", "2001:db8::/32"), pfxs("1.2.0.0/16")},
+		{2, pfxs("1.2.3.0/24", "2001:db8::/32"), pfxs("1.2.0.0/16", "10.0.0.0/8")},
+		{3, pfxs(), pfxs("10.0.0.0/8")},
+		{4, pfxs(), pfxs()},
+	}
+	for _, tt := range changeTests {
+		latest, added, removed, ok := v.ChangesSince(tt.since)
+		if !ok || latest != 4 {
+			t.Errorf("ChangesSince(%d) = (%d, %v), want (4, true)", tt.since, latest, ok)
+			continue
+		}
+		checkPrefixSlice(t, added.Prefixes(), tt.added)
+		checkPrefixSlice(t, removed.Prefixes(), tt.removed)
+	}
+	if _, _, _, ok := v.ChangesSince(5); ok {
+		t.Errorf("ChangesSince(5) succeeded for an unpublished version")
+	}
+
+	// Older versions are discarded once more than Retain are published
+	v.Retain = 2
+	v.Add(pfx("192.168.0.0/16"))
+	for i := uint64(0); i <= 5; i++ {
+		if _, ok := v.At(i); ok != (i >= 4) {
+			t.Errorf("At(%d) with Retain = 2: got found = %v", i, ok)
+		}
+	}
+	if _, _, _, ok := v.ChangesSince(3); ok {
+		t.Errorf("ChangesSince(3) succeeded for a discarded version")
+	}
+	if _, added, _, ok := v.ChangesSince(4); !ok {
+		t.Errorf("ChangesSince(4) failed")
+	} else {
+		checkPrefixSlice(t, added.Prefixes(), pfxs("192.168.0.0/16"))
+	}
+}
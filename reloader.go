@@ -0,0 +1,154 @@
+package netipds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultReloadInterval is the default interval at which a Reloader checks
+// its file for changes.
+const defaultReloadInterval = 10 * time.Second
+
+// Reloader keeps a PrefixSet loaded from a file up to date. Each time the file
+// changes, Reloader rebuilds the set in the background, validates it, and
+// atomically publishes it, so readers calling Load never block and never see
+// a partially built set.
+//
+// The zero value is not usable; Path must be set. Reloader must not be copied
+// after first use.
+type Reloader struct {
+	// Path is the file to load.
+	Path string
+
+	// Parse builds a PrefixSet from the contents of the file. If nil,
+	// [ReadPrefixSet] is used.
+	Parse func(io.Reader) (*PrefixSet, error)
+
+	// Validate, if non-nil, is called with each newly built set before it is
+	// published. If it returns an error, the set is discarded and the
+	// previously published set remains in place.
+	Validate func(*PrefixSet) error
+
+	// Interval is how often Run checks the file for changes. If zero or
+	// negative, the file is checked every 10 seconds.
+	Interval time.Duration
+
+	// OnReload, if non-nil, is called with each newly published set.
+	OnReload func(*PrefixSet)
+
+	// OnError, if non-nil, is called with each error encountered while
+	// reloading.
+	OnError func(error)
+
+	current atomic.Pointer[PrefixSet]
+
+	mu sync.Mutex
+	// modTime and size identify the version of the file last loaded, whether
+	// or not it was published
+	modTime time.Time
+	size    int64
+}
+
+// Load returns the most recently published PrefixSet, or nil if no set has
+// been published yet. Load is safe for concurrent use and does not block.
+func (r *Reloader) Load() *PrefixSet {
+	return r.current.Load()
+}
+
+// Reload loads, validates, and publishes the file immediately, regardless of
+// whether it has changed. It is useful for reloading in response to a signal.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		return r.fail(err)
+	}
+	return r.reload(info)
+}
+
+// Run loads the file, then checks it for changes at each Interval, reloading
+// it whenever its size or modification time changes, until ctx is done.
+//
+// If the initial load fails, Run returns its error. Errors from subsequent
+// reloads are reported to OnError, and the previous set remains published.
+func (r *Reloader) Run(ctx context.Context) error {
+	if err := r.Reload(); err != nil {
+		return err
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged reloads the file if its size or modification time has
+// changed since the last reload. A version of the file which was rejected is
+// not loaded again.
+func (r *Reloader) reloadIfChanged() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return
+	}
+	r.reload(info)
+}
+
+// reload loads, validates, and publishes the file. r.mu must be held.
+func (r *Reloader) reload(info os.FileInfo) error {
+	f, err := os.Open(r.Path)
+	if err != nil {
+		return r.fail(err)
+	}
+	defer f.Close()
+	// If this version is rejected, don't retry it until the file changes
+	r.modTime, r.size = info.ModTime(), info.Size()
+
+	parse := r.Parse
+	if parse == nil {
+		parse = ReadPrefixSet
+	}
+	s, err := parse(f)
+	if err != nil {
+		return r.fail(fmt.Errorf("failed to parse %s: %w", r.Path, err))
+	}
+	if r.Validate != nil {
+		if err = r.Validate(s); err != nil {
+			return r.fail(fmt.Errorf("failed to validate %s: %w", r.Path, err))
+		}
+	}
+
+	r.current.Store(s)
+	if r.OnReload != nil {
+		r.OnReload(s)
+	}
+	return nil
+}
+
+// fail reports err to OnError and returns it.
+func (r *Reloader) fail(err error) error {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+	return err
+}
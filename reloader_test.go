@@ -0,0 +1,153 @@
+package netipds
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloaderReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeFile(t, path, "1.2.3.0/24\n")
+
+	var reloads, errs int
+	r := &Reloader{
+		Path: path,
+		Validate: func(s *PrefixSet) error {
+			if s.Size() == 0 {
+				return errors.New("empty")
+			}
+			return nil
+		},
+		OnReload: func(*PrefixSet) { reloads++ },
+		OnError:  func(error) { errs++ },
+	}
+	if r.Load() != nil {
+		t.Errorf("r.Load() before first reload = %v, want nil", r.Load())
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatalf("r.Reload() error = %v", err)
+	}
+	checkPrefixSlice(t, r.Load().Prefixes(), pfxs("1.2.3.0/24"))
+
+	// Invalid contents are rejected and the previous set is kept
+	writeFile(t, path, "bogus\n")
+	if err := r.Reload(); err == nil {
+		t.Errorf("r.Reload() with invalid contents succeeded, want error")
+	}
+	writeFile(t, path, "# nothing\n")
+	if err := r.Reload(); err == nil {
+		t.Errorf("r.Reload() with failed validation succeeded, want error")
+	}
+	os.Remove(path)
+	if err := r.Reload(); err == nil {
+		t.Errorf("r.Reload() with missing file succeeded, want error")
+	}
+	checkPrefixSlice(t, r.Load().Prefixes(), pfxs("1.2.3.0/24"))
+
+	if reloads != 1 || errs != 3 {
+		t.Errorf("got %d reloads and %d errors, want 1 and 3", reloads, errs)
+	}
+}
+
+func TestReloaderRejectedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeFile(t, path, "1.2.3.0/24\n")
+
+	var reloads, errs int
+	r := &Reloader{
+		Path:     path,
+		OnReload: func(*PrefixSet) { reloads++ },
+		OnError:  func(error) { errs++ },
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatalf("r.Reload() error = %v", err)
+	}
+
+	// A rejected version is reported once, not on every check
+	writeFile(t, path, "bogus\n")
+	for i := 0; i < 3; i++ {
+		r.reloadIfChanged()
+	}
+	if errs != 1 {
+		t.Errorf("got %d errors for one rejected version, want 1", errs)
+	}
+
+	writeFile(t, path, "1.2.3.0/24\n5.6.7.0/24\n")
+	r.reloadIfChanged()
+	checkPrefixSlice(t, r.Load().Prefixes(), pfxs("1.2.3.0/24", "5.6.7.0/24"))
+	if reloads != 2 || errs != 1 {
+		t.Errorf("got %d reloads and %d errors, want 2 and 1", reloads, errs)
+	}
+}
+
+func TestReloaderCustomParse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeFile(t, path, "ignored")
+	r := &Reloader{
+		Path: path,
+		Parse: func(io.Reader) (*PrefixSet, error) {
+			psb := &PrefixSetBuilder{}
+			psb.Add(pfx("::1/128"))
+			return psb.PrefixSet(), nil
+		},
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatalf("r.Reload() error = %v", err)
+	}
+	checkPrefixSlice(t, r.Load().Prefixes(), pfxs("::1/128"))
+}
+
+func TestReloaderRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeFile(t, path, "1.2.3.0/24\n")
+
+	reloaded := make(chan *PrefixSet, 2)
+	r := &Reloader{
+		Path:     path,
+		Interval: time.Millisecond,
+		OnReload: func(s *PrefixSet) { reloaded <- s },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+	<-reloaded
+
+	writeFile(t, path, "1.2.3.0/24\n5.6.7.0/24\n")
+	select {
+	case s := <-reloaded:
+		checkPrefixSlice(t, s.Prefixes(), pfxs("1.2.3.0/24", "5.6.7.0/24"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("r.Run() error = %v", err)
+	}
+
+	// A negative Interval means the default
+	r = &Reloader{Path: path, Interval: -time.Second}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- r.Run(ctx) }()
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("r.Run() with negative Interval error = %v", err)
+	}
+
+	// Run returns the initial load error
+	r = &Reloader{Path: filepath.Join(t.TempDir(), "missing.txt")}
+	if err := r.Run(context.Background()); err == nil {
+		t.Errorf("r.Run() with missing file succeeded, want error")
+	}
+}
@@ -0,0 +1,232 @@
+package netipds
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sync"
+)
+
+// UpdateOp is the kind of change described by an [Update].
+type UpdateOp uint8
+
+const (
+	// OpAdd adds a Prefix to a set.
+	OpAdd UpdateOp = iota + 1
+	// OpRemove removes a Prefix from a set.
+	OpRemove
+)
+
+func (op UpdateOp) String() string {
+	switch op {
+	case OpAdd:
+		return "add"
+	case OpRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("UpdateOp(%d)", uint8(op))
+	}
+}
+
+// Update is a single change to a replicated set. Seq numbers start at 1 and
+// increase by one with each Update, so a [Replica] can detect lost or
+// reordered Updates.
+type Update struct {
+	Seq    uint64
+	Op     UpdateOp
+	Prefix netip.Prefix
+}
+
+// Publisher is a PrefixSetBuilder whose changes are published as an ordered
+// stream of Updates, so that Replicas in other goroutines or processes can
+// stay synchronized without receiving full snapshots.
+//
+// Send is called with each Update, in order, while the Publisher is locked.
+// It may, for example, write the Update to an [UpdateWriter] or send it on a
+// channel. If Send returns an error, the change has still been made to the
+// Publisher's set, so Replicas should be reinitialized from a Snapshot.
+//
+// A Publisher is safe for concurrent use.
+type Publisher struct {
+	Send func(Update) error
+
+	mu  sync.Mutex
+	b   PrefixSetBuilder
+	seq uint64
+}
+
+// Add adds p to the set and publishes the change.
+func (p *Publisher) Add(pfx netip.Prefix) error {
+	return p.apply(OpAdd, pfx)
+}
+
+// Remove removes p from the set and publishes the change.
+func (p *Publisher) Remove(pfx netip.Prefix) error {
+	return p.apply(OpRemove, pfx)
+}
+
+func (p *Publisher) apply(op UpdateOp, pfx netip.Prefix) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := applyUpdate(&p.b, op, pfx); err != nil {
+		return err
+	}
+	p.seq++
+	return p.Send(Update{p.seq, op, pfx})
+}
+
+// Snapshot returns the current state of the set, along with the Seq of the
+// last Update published. A new Replica can be initialized from a Snapshot
+// using [Replica.Reset], then kept up to date with subsequent Updates.
+func (p *Publisher) Snapshot() (uint64, *PrefixSet) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seq, p.b.PrefixSet()
+}
+
+// Replica maintains a copy of a [Publisher]'s set by applying its Updates.
+//
+// The zero value is a valid Replica of an empty set with no Updates applied.
+// A Replica is safe for concurrent use.
+type Replica struct {
+	mu  sync.Mutex
+	b   PrefixSetBuilder
+	seq uint64
+}
+
+// Apply applies u to r. It returns an error if u is not the next Update in
+// sequence, in which case r should be reinitialized with [Replica.Reset].
+func (r *Replica) Apply(u Update) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u.Seq != r.seq+1 {
+		return fmt.Errorf("out of order update: got seq %d, want %d", u.Seq, r.seq+1)
+	}
+	if err := applyUpdate(&r.b, u.Op, u.Prefix); err != nil {
+		return err
+	}
+	r.seq = u.Seq
+	return nil
+}
+
+// Reset replaces the contents of r with s, as of the Update with sequence
+// number seq.
+func (r *Replica) Reset(seq uint64, s *PrefixSet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.b = PrefixSetBuilder{}
+	r.b.Merge(s)
+	r.seq = seq
+}
+
+// Seq returns the sequence number of the last Update applied to r.
+func (r *Replica) Seq() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seq
+}
+
+// PrefixSet returns an immutable PrefixSet representing the current state of
+// r.
+func (r *Replica) PrefixSet() *PrefixSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.b.PrefixSet()
+}
+
+// Consume reads Updates from ur and applies them to r until ur is exhausted.
+func (r *Replica) Consume(ur *UpdateReader) error {
+	for {
+		u, err := ur.ReadUpdate()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = r.Apply(u); err != nil {
+			return err
+		}
+	}
+}
+
+func applyUpdate(b *PrefixSetBuilder, op UpdateOp, p netip.Prefix) error {
+	switch op {
+	case OpAdd:
+		return b.Add(p)
+	case OpRemove:
+		return b.Remove(p)
+	default:
+		return fmt.Errorf("invalid update op: %v", op)
+	}
+}
+
+// UpdateWriter encodes Updates to an io.Writer.
+//
+// Each Update is encoded as a uvarint Seq, one byte for the Op, and the
+// Prefix's length in bits (IPv4 Prefixes are offset by 96) followed by its
+// significant address bytes.
+type UpdateWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewUpdateWriter returns an UpdateWriter which writes to w.
+func NewUpdateWriter(w io.Writer) *UpdateWriter {
+	return &UpdateWriter{w: w}
+}
+
+// WriteUpdate writes u to the underlying io.Writer.
+func (uw *UpdateWriter) WriteUpdate(u Update) error {
+	if !u.Prefix.IsValid() {
+		return invalidPrefixError(u.Prefix)
+	}
+	uw.buf = binary.AppendUvarint(uw.buf[:0], u.Seq)
+	uw.buf = append(uw.buf, byte(u.Op))
+	uw.buf = keyFromPrefix(u.Prefix).appendBinary(uw.buf)
+	_, err := uw.w.Write(uw.buf)
+	return err
+}
+
+// UpdateReader decodes Updates written by an [UpdateWriter].
+type UpdateReader struct {
+	r *bufio.Reader
+}
+
+// NewUpdateReader returns an UpdateReader which reads from r.
+func NewUpdateReader(r io.Reader) *UpdateReader {
+	return &UpdateReader{bufio.NewReader(r)}
+}
+
+// ReadUpdate reads the next Update. It returns io.EOF if there are no more
+// Updates.
+func (ur *UpdateReader) ReadUpdate() (Update, error) {
+	seq, err := binary.ReadUvarint(ur.r)
+	if err != nil {
+		return Update{}, err
+	}
+	var hdr [2]byte
+	if _, err = io.ReadFull(ur.r, hdr[:]); err != nil {
+		return Update{}, fmt.Errorf("failed to read update %d: %w", seq, noEOF(err))
+	}
+	b := make([]byte, 1+(int(hdr[1])+7)/8)
+	b[0] = hdr[1]
+	if _, err = io.ReadFull(ur.r, b[1:]); err != nil {
+		return Update{}, fmt.Errorf("failed to read update %d: %w", seq, noEOF(err))
+	}
+	k, _, err := keyFromBinary(b)
+	if err != nil {
+		return Update{}, fmt.Errorf("failed to read update %d: %w", seq, err)
+	}
+	return Update{seq, UpdateOp(hdr[0]), k.toPrefix()}, nil
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
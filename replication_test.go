@@ -0,0 +1,80 @@
+package netipds
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReplicationOverWriter(t *testing.T) {
+	var buf bytes.Buffer
+	uw := NewUpdateWriter(&buf)
+	pub := &Publisher{Send: uw.WriteUpdate}
+	pub.Add(pfx("10.0.0.0/8"))
+	pub.Add(pfx("2001:db8::/32"))
+	pub.Add(pfx("::1/128"))
+	pub.Remove(pfx("10.0.0.0/8"))
+	pub.Add(pfx("192.168.0.0/16"))
+
+	var r Replica
+	if err := r.Consume(NewUpdateReader(&buf)); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	checkPrefixSlice(t, r.PrefixSet().Prefixes(), pfxs("::1/128", "192.168.0.0/16", "2001:db8::/32"))
+	if r.Seq() != 5 {
+		t.Errorf("r.Seq() = %d, want 5", r.Seq())
+	}
+
+	// Truncated streams are reported
+	buf.Reset()
+	uw.WriteUpdate(Update{6, OpAdd, pfx("1.2.3.0/24")})
+	buf.Truncate(buf.Len() - 1)
+	if err := r.Consume(NewUpdateReader(&buf)); err == nil {
+		t.Errorf("Consume of truncated update succeeded")
+	}
+}
+
+func TestReplicationOverChannel(t *testing.T) {
+	ch := make(chan Update, 10)
+	pub := &Publisher{Send: func(u Update) error {
+		ch <- u
+		return nil
+	}}
+	pub.Add(pfx("::0/127"))
+	pub.Add(pfx("::2/128"))
+
+	// A new Replica starts from a Snapshot
+	var r Replica
+	seq, s := pub.Snapshot()
+	r.Reset(seq, s)
+	pub.Remove(pfx("::0/127"))
+	pub.Add(pfx("::3/128"))
+	close(ch)
+	for u := range ch {
+		if u.Seq <= r.Seq() {
+			continue
+		}
+		if err := r.Apply(u); err != nil {
+			t.Fatalf("Apply(%v): %v", u, err)
+		}
+	}
+	checkPrefixSlice(t, r.PrefixSet().Prefixes(), pfxs("::2/128", "::3/128"))
+	_, want := pub.Snapshot()
+	checkPrefixSlice(t, r.PrefixSet().Prefixes(), want.Prefixes())
+}
+
+func TestReplicaApplyOutOfOrder(t *testing.T) {
+	var r Replica
+	if err := r.Apply(Update{2, OpAdd, pfx("::0/128")}); err == nil {
+		t.Errorf("Apply of seq 2 to new Replica succeeded")
+	}
+	if err := r.Apply(Update{1, OpAdd, pfx("::0/128")}); err != nil {
+		t.Errorf("Apply of seq 1 failed: %v", err)
+	}
+	if err := r.Apply(Update{1, OpAdd, pfx("::1/128")}); err == nil {
+		t.Errorf("Apply of repeated seq 1 succeeded")
+	}
+	if err := r.Apply(Update{2, UpdateOp(9), pfx("::1/128")}); err == nil {
+		t.Errorf("Apply of invalid op succeeded")
+	}
+	checkPrefixSlice(t, r.PrefixSet().Prefixes(), pfxs("::0/128"))
+}
@@ -0,0 +1,151 @@
+// Package rir loads the "delegated-extended" statistics files published by
+// the Regional Internet Registries (AFRINIC, APNIC, ARIN, LACNIC and RIPE
+// NCC), which record the registry, country and status of every IP address
+// block, into [netipds.PrefixMap]s.
+package rir
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aromatt/netipds"
+)
+
+// Delegation describes the registration of an address block.
+type Delegation struct {
+	// Registry is the registry responsible for the block, such as "arin" or
+	// "ripencc".
+	Registry string
+	// Country is the ISO 3166 alpha-2 code of the country of the holder, or
+	// empty if none is recorded (e.g. for available blocks).
+	Country string
+	// Status is one of "allocated", "assigned", "available" or "reserved".
+	Status string
+	// Date is the date of the allocation or assignment, or the zero Time if
+	// none is recorded.
+	Date time.Time
+	// OpaqueID identifies the holder of the block, consistently across all
+	// of its resources within one registry's file. It is empty in files
+	// without the extended fields.
+	OpaqueID string
+}
+
+// ReadDelegated reads a delegated or delegated-extended file from r, and
+// returns a map of the Prefixes of each IPv4 and IPv6 record to its
+// Delegation. IPv4 records whose address counts aren't powers of two, or
+// whose start addresses aren't aligned to their counts, are split into the
+// fewest Prefixes covering exactly the same addresses.
+//
+// The version line, summary lines, comments and ASN records are skipped.
+func ReadDelegated(r io.Reader) (*netipds.PrefixMap[Delegation], error) {
+	pmb := &netipds.PrefixMapBuilder[Delegation]{Lazy: true}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Split(text, "|")
+		// The version line starts with the format version, and records
+		// with the registry
+		if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			continue
+		}
+		if len(fields) < 7 {
+			if len(fields) == 6 && fields[5] == "summary" {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: expected at least 7 fields, got %d", line, len(fields))
+		}
+		typ := fields[2]
+		if typ != "ipv4" && typ != "ipv6" {
+			continue
+		}
+		prefixes, err := recordPrefixes(typ, fields[3], fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		d := Delegation{
+			Registry: fields[0],
+			Country:  fields[1],
+			Status:   fields[6],
+		}
+		if d.Date, err = parseDate(fields[5]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(fields) > 7 {
+			d.OpaqueID = fields[7]
+		}
+		// Some registries use "ZZ" for blocks with no country
+		if d.Country == "ZZ" {
+			d.Country = ""
+		}
+		for _, p := range prefixes {
+			if err := pmb.Set(p, d); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return pmb.PrefixMap(), nil
+}
+
+// recordPrefixes returns the Prefixes of the record of type typ ("ipv4" or
+// "ipv6") with the provided start and value fields. The value of an IPv4
+// record is a count of addresses; that of an IPv6 record is a Prefix length.
+func recordPrefixes(typ, start, value string) ([]netip.Prefix, error) {
+	addr, err := netip.ParseAddr(start)
+	if err != nil {
+		return nil, err
+	}
+	if typ == "ipv6" {
+		if !addr.Is6() {
+			return nil, fmt.Errorf("ipv6 record has start %v", addr)
+		}
+		bits, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix length %q", value)
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil || p.Addr() != addr {
+			return nil, fmt.Errorf("invalid ipv6 block %v/%s", addr, value)
+		}
+		return []netip.Prefix{p}, nil
+	}
+	if !addr.Is4() {
+		return nil, fmt.Errorf("ipv4 record has start %v", addr)
+	}
+	count, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || count == 0 {
+		return nil, fmt.Errorf("invalid address count %q", value)
+	}
+	a4 := addr.As4()
+	first := uint64(a4[0])<<24 | uint64(a4[1])<<16 | uint64(a4[2])<<8 | uint64(a4[3])
+	last := first + count - 1
+	if last > math.MaxUint32 {
+		return nil, fmt.Errorf("block of %d addresses at %v overflows the IPv4 address space", count, addr)
+	}
+	end := netip.AddrFrom4([4]byte{byte(last >> 24), byte(last >> 16), byte(last >> 8), byte(last)})
+	return netipds.IPRangeFrom(addr, end).Prefixes(), nil
+}
+
+// parseDate parses the date field of a record, which is empty or all zeros if
+// no date is recorded.
+func parseDate(s string) (time.Time, error) {
+	if strings.Trim(s, "0") == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("20060102", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
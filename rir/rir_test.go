@@ -0,0 +1,72 @@
+package rir
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+const delegated = `# A comment
+2.3|arin|1700000000000|3|19700101|20231114|-0500
+arin|*|asn|*|1|summary
+arin|*|ipv4|*|4|summary
+arin|*|ipv6|*|1|summary
+arin|US|asn|1|1|20010920|assigned|e5e3b9c13678dfc483fb1f819d70883c
+arin|US|ipv4|3.0.0.0|16777216|19880223|allocated|6a27cda1fd5ae2fd1b4ec1b1bd9b9c1e
+arin|CA|ipv4|23.128.0.0|1536|20150304|assigned|0ce0d7a0bbbf8d6a3ef5d6b1c5c0b845
+arin||ipv4|23.130.0.0|256||available
+arin|ZZ|ipv4|192.0.0.0|768|00000000|reserved
+arin|US|ipv6|2001:400::|32|19990803|allocated|6a27cda1fd5ae2fd1b4ec1b1bd9b9c1e
+`
+
+func TestReadDelegated(t *testing.T) {
+	m, err := ReadDelegated(strings.NewReader(delegated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(y int, mo time.Month, d int) time.Time {
+		return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+	}
+	us3 := Delegation{"arin", "US", "allocated", date(1988, 2, 23), "6a27cda1fd5ae2fd1b4ec1b1bd9b9c1e"}
+	ca := Delegation{"arin", "CA", "assigned", date(2015, 3, 4), "0ce0d7a0bbbf8d6a3ef5d6b1c5c0b845"}
+	avail := Delegation{"arin", "", "available", time.Time{}, ""}
+	reserved := Delegation{"arin", "", "reserved", time.Time{}, ""}
+	v6 := Delegation{"arin", "US", "allocated", date(1999, 8, 3), "6a27cda1fd5ae2fd1b4ec1b1bd9b9c1e"}
+	want := map[string]Delegation{
+		"3.0.0.0/8": us3,
+		// 1536 addresses: a /22 and a /23
+		"23.128.0.0/22": ca,
+		"23.128.4.0/23": ca,
+		"23.130.0.0/24": avail,
+		// 768 addresses from an unaligned start
+		"192.0.0.0/23":  reserved,
+		"192.0.2.0/24":  reserved,
+		"2001:400::/32": v6,
+	}
+	got := m.ToMap()
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for s, d := range want {
+		if g, ok := got[netip.MustParsePrefix(s)]; !ok || g != d {
+			t.Errorf("%s: got %+v, want %+v", s, g, d)
+		}
+	}
+}
+
+func TestReadDelegatedInvalid(t *testing.T) {
+	for _, line := range []string{
+		"arin|US|ipv4|3.0.0.0",
+		"arin|US|ipv4|3.0.0.0|0|19880223|allocated",
+		"arin|US|ipv4|255.255.255.0|512|19880223|allocated",
+		"arin|US|ipv4|2001:400::|256|19880223|allocated",
+		"arin|US|ipv6|2001:400::|129|19990803|allocated",
+		"arin|US|ipv6|2001:400::1|32|19990803|allocated",
+		"arin|US|ipv4|3.0.0.0|256|1988-02-23|allocated",
+	} {
+		if _, err := ReadDelegated(strings.NewReader(line)); err == nil {
+			t.Errorf("ReadDelegated(%q) succeeded", line)
+		}
+	}
+}
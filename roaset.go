@@ -0,0 +1,125 @@
+package netipds
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// ROA is a Validated ROA Payload: an authorization for the AS numbered ASN to
+// originate routes to Prefix and to its descendants up to MaxLength bits long.
+type ROA struct {
+	Prefix    netip.Prefix
+	MaxLength int
+	ASN       uint32
+}
+
+// Validity is the result of route origin validation (RFC 6811).
+type Validity int
+
+const (
+	// NotFound means that no ROA covers the route's Prefix.
+	NotFound Validity = iota
+	// Valid means that at least one ROA covering the route's Prefix matches
+	// it.
+	Valid
+	// Invalid means that ROAs cover the route's Prefix, but none match it.
+	Invalid
+)
+
+// String returns "NotFound", "Valid" or "Invalid".
+func (v Validity) String() string {
+	switch v {
+	case NotFound:
+		return "NotFound"
+	case Valid:
+		return "Valid"
+	case Invalid:
+		return "Invalid"
+	default:
+		return fmt.Sprintf("Validity(%d)", int(v))
+	}
+}
+
+// ROASetBuilder builds an immutable [ROASet].
+//
+// The zero value is a valid ROASetBuilder representing a builder with zero
+// ROAs.
+type ROASetBuilder struct {
+	b PrefixMultiMapBuilder[ROA]
+}
+
+// Add adds r to s. If r.MaxLength is zero, it is taken to be the length of
+// r.Prefix. Add returns an error if r.Prefix is invalid, or if r.MaxLength is
+// shorter than r.Prefix or longer than its address.
+func (s *ROASetBuilder) Add(r ROA) error {
+	if !r.Prefix.IsValid() {
+		return invalidPrefixError(r.Prefix)
+	}
+	r.Prefix = r.Prefix.Masked()
+	if r.MaxLength == 0 {
+		r.MaxLength = r.Prefix.Bits()
+	}
+	if r.MaxLength > r.Prefix.Addr().BitLen() {
+		return fmt.Errorf("max length %d for %v: %w", r.MaxLength, r.Prefix, ErrMaxLenExceeded)
+	}
+	if r.MaxLength < r.Prefix.Bits() {
+		return fmt.Errorf("max length %d is not valid for %v", r.MaxLength, r.Prefix)
+	}
+	return s.b.Add(r.Prefix, r)
+}
+
+// ROASet returns an immutable ROASet representing the current state of s.
+//
+// The builder remains usable after calling ROASet.
+func (s *ROASetBuilder) ROASet() *ROASet {
+	return &ROASet{s.b.PrefixMultiMap()}
+}
+
+// ROASet is a set of ROAs which can validate the origins of routes.
+//
+// Use [ROASetBuilder] to construct ROASets.
+type ROASet struct {
+	m *PrefixMultiMap[ROA]
+}
+
+// Covering returns the ROAs in s whose Prefixes encompass p, ordered from the
+// shortest Prefix to the longest.
+func (s *ROASet) Covering(p netip.Prefix) []ROA {
+	if s.m == nil {
+		return nil
+	}
+	entries := s.m.LookupAll(p)
+	if len(entries) == 0 {
+		return nil
+	}
+	ret := make([]ROA, len(entries))
+	for i, e := range entries {
+		ret[i] = e.Value
+	}
+	return ret
+}
+
+// Validate returns the validity of a route to p originated by the AS
+// numbered origin, as defined by RFC 6811: the route is Valid if a ROA
+// covering p authorizes origin and allows p's length, Invalid if ROAs cover p
+// but none match, and NotFound if no ROA covers p. ROAs for AS 0 never match.
+func (s *ROASet) Validate(p netip.Prefix, origin uint32) Validity {
+	covering := s.Covering(p)
+	if len(covering) == 0 {
+		return NotFound
+	}
+	for _, r := range covering {
+		if r.ASN != 0 && r.ASN == origin && p.Bits() <= r.MaxLength {
+			return Valid
+		}
+	}
+	return Invalid
+}
+
+// Size returns the number of Prefixes in s which have ROAs.
+func (s *ROASet) Size() int {
+	if s.m == nil {
+		return 0
+	}
+	return s.m.Size()
+}
@@ -0,0 +1,83 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestROASetValidate(t *testing.T) {
+	b := &ROASetBuilder{}
+	for _, r := range []ROA{
+		{pfx("1.2.0.0/16"), 24, 64500},
+		{pfx("1.2.0.0/16"), 0, 64501},
+		{pfx("1.2.3.0/24"), 24, 64502},
+		{pfx("10.0.0.0/8"), 8, 0},
+		{pfx("2001:db8::/32"), 48, 64496},
+	} {
+		if err := b.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := b.ROASet()
+
+	tests := []struct {
+		p      netip.Prefix
+		origin uint32
+		want   Validity
+	}{
+		{pfx("1.2.0.0/16"), 64500, Valid},
+		{pfx("1.2.4.0/24"), 64500, Valid},
+		// Longer than the max length
+		{pfx("1.2.4.0/25"), 64500, Invalid},
+		// MaxLength defaults to the Prefix length
+		{pfx("1.2.0.0/16"), 64501, Valid},
+		{pfx("1.2.4.0/24"), 64501, Invalid},
+		// Any covering ROA may match
+		{pfx("1.2.3.0/24"), 64500, Valid},
+		{pfx("1.2.3.0/24"), 64502, Valid},
+		{pfx("1.2.3.0/24"), 64503, Invalid},
+		// ROAs only cover their descendants
+		{pfx("1.0.0.0/8"), 64500, NotFound},
+		{pfx("1.3.0.0/16"), 64500, NotFound},
+		// AS 0 never matches
+		{pfx("10.0.0.0/8"), 0, Invalid},
+		{pfx("2001:db8:1::/48"), 64496, Valid},
+		{pfx("2001:db8:1::/49"), 64496, Invalid},
+		{pfx("2001:db9::/32"), 64496, NotFound},
+	}
+	for _, tt := range tests {
+		if got := s.Validate(tt.p, tt.origin); got != tt.want {
+			t.Errorf("Validate(%v, %d) = %v, want %v", tt.p, tt.origin, got, tt.want)
+		}
+	}
+	if got := len(s.Covering(pfx("1.2.3.0/24"))); got != 3 {
+		t.Errorf("len(Covering(1.2.3.0/24)) = %d, want 3", got)
+	}
+	if got := s.Size(); got != 4 {
+		t.Errorf("Size() = %d, want 4", got)
+	}
+	if got := (&ROASet{}).Validate(pfx("1.2.3.0/24"), 1); got != NotFound {
+		t.Errorf("empty Validate() = %v, want NotFound", got)
+	}
+}
+
+func TestROASetBuilderAddErrors(t *testing.T) {
+	for _, r := range []ROA{
+		{netip.Prefix{}, 24, 1},
+		{pfx("1.2.0.0/16"), 15, 1},
+		{pfx("1.2.0.0/16"), 33, 1},
+		{pfx("2001:db8::/32"), 129, 1},
+	} {
+		if err := (&ROASetBuilder{}).Add(r); err == nil {
+			t.Errorf("Add(%v) returned nil error", r)
+		}
+	}
+}
+
+func TestValidityString(t *testing.T) {
+	for v, want := range map[Validity]string{NotFound: "NotFound", Valid: "Valid", Invalid: "Invalid", 7: "Validity(7)"} {
+		if got := v.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
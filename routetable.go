@@ -0,0 +1,136 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// Route is a candidate route to a Prefix, as stored in a [RouteTable].
+//
+// Among the candidates for a Prefix, the route with the lowest Distance is
+// preferred, and ties are broken by the lowest Metric. Routes which are still
+// tied are preferred in the order they were added.
+type Route[T comparable] struct {
+	Prefix   netip.Prefix
+	Distance uint32
+	Metric   uint32
+	Value    T
+}
+
+// less reports whether r is preferred over o.
+func (r Route[T]) less(o Route[T]) bool {
+	if r.Distance != o.Distance {
+		return r.Distance < o.Distance
+	}
+	return r.Metric < o.Metric
+}
+
+// RouteTable maps Prefixes to one or more candidate routes, and selects the
+// best route to an address by longest-prefix match, and then by the
+// preference order of the candidates for that Prefix (see [Route]).
+//
+// Candidates for the same Prefix are identified by their Value, such as a
+// next hop: adding a route with a Value that the Prefix already has replaces
+// that candidate.
+//
+// The zero value is a valid, empty RouteTable. A RouteTable is not safe for
+// concurrent use; see [PrefixMap] for an immutable alternative.
+type RouteTable[T comparable] struct {
+	// tree holds the candidates for each Prefix, most preferred first. Every
+	// entry has at least one candidate.
+	tree tree[[]Route[T]]
+	size int
+}
+
+// Add adds a route to p with the provided distance, metric and value. If p
+// already has a route with value v, that route is replaced.
+func (t *RouteTable[T]) Add(p netip.Prefix, distance, metric uint32, v T) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	k := keyFromPrefix(p)
+	r := Route[T]{k.toPrefix(), distance, metric, v}
+	old, ok := t.tree.get(k)
+	if !ok {
+		t.size++
+	}
+	// Candidate slices are never modified in place, so slices returned by
+	// Routes remain valid.
+	routes := make([]Route[T], 0, len(old)+1)
+	for _, o := range old {
+		if o.Value != v {
+			routes = append(routes, o)
+		}
+	}
+	i := len(routes)
+	for i > 0 && r.less(routes[i-1]) {
+		i--
+	}
+	t.tree = *t.tree.insert(k, slices.Insert(routes, i, r))
+	return nil
+}
+
+// Withdraw removes the route to p with value v, if any. If it was the last
+// route to p, p is removed from t.
+func (t *RouteTable[T]) Withdraw(p netip.Prefix, v T) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	k := keyFromPrefix(p)
+	old, ok := t.tree.get(k)
+	if !ok {
+		return nil
+	}
+	routes := make([]Route[T], 0, len(old))
+	for _, o := range old {
+		if o.Value != v {
+			routes = append(routes, o)
+		}
+	}
+	switch {
+	case len(routes) == len(old):
+	case len(routes) == 0:
+		t.tree.remove(k)
+		t.size--
+	default:
+		t.tree = *t.tree.insert(k, routes)
+	}
+	return nil
+}
+
+// WithdrawPrefix removes all routes to p.
+func (t *RouteTable[T]) WithdrawPrefix(p netip.Prefix) error {
+	if !p.IsValid() {
+		return invalidPrefixError(p)
+	}
+	k := keyFromPrefix(p)
+	if t.tree.contains(k) {
+		t.tree.remove(k)
+		t.size--
+	}
+	return nil
+}
+
+// Routes returns the candidate routes to the exact Prefix provided, most
+// preferred first. The returned slice must not be modified.
+func (t *RouteTable[T]) Routes(p netip.Prefix) []Route[T] {
+	routes, _ := t.tree.get(keyFromPrefix(p))
+	return routes
+}
+
+// BestRoute returns the most preferred route to a among those of the longest
+// Prefix in t which contains a. a's zone, if any, is ignored.
+func (t *RouteTable[T]) BestRoute(a netip.Addr) (Route[T], bool) {
+	if !a.IsValid() {
+		return Route[T]{}, false
+	}
+	if n := t.tree.longestMatch(keyFromAddr(a)); n != nil {
+		return n.value[0], true
+	}
+	return Route[T]{}, false
+}
+
+// Size returns the number of Prefixes in t.
+func (t *RouteTable[T]) Size() int {
+	return t.size
+}
@@ -0,0 +1,92 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestRouteTable(t *testing.T) {
+	var rt RouteTable[string]
+	rt.Add(pfx("0.0.0.0/1"), 20, 0, "default-a")
+	rt.Add(pfx("10.0.0.0/8"), 110, 20, "ospf")
+	rt.Add(pfx("10.0.0.0/8"), 20, 0, "bgp")
+	rt.Add(pfx("10.1.0.0/16"), 110, 30, "ospf-a")
+	rt.Add(pfx("10.1.0.0/16"), 110, 10, "ospf-b")
+	rt.Add(pfx("10.1.0.0/16"), 110, 10, "ospf-c")
+	rt.Add(pfx("2001:db8::/32"), 1, 0, "static")
+
+	tests := []struct {
+		addr string
+		want string
+		ok   bool
+	}{
+		// Longest prefix wins regardless of distance
+		{"10.1.2.3", "ospf-b", true},
+		// Lowest distance wins within a prefix
+		{"10.2.3.4", "bgp", true},
+		{"1.2.3.4", "default-a", true},
+		{"2001:db8::1", "static", true},
+		{"200.1.2.3", "", false},
+		{"2001:db9::1", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := rt.BestRoute(netip.MustParseAddr(tt.addr))
+		if got.Value != tt.want || ok != tt.ok {
+			t.Errorf("BestRoute(%s) = (%v, %v), want (%s, %v)", tt.addr, got, ok, tt.want, tt.ok)
+		}
+	}
+	if r, _ := rt.BestRoute(netip.MustParseAddr("10.1.2.3")); r.Prefix != pfx("10.1.0.0/16") {
+		t.Errorf("BestRoute(10.1.2.3).Prefix = %v, want 10.1.0.0/16", r.Prefix)
+	}
+	if got := rt.Size(); got != 4 {
+		t.Errorf("Size() = %d, want 4", got)
+	}
+
+	// Re-adding a value replaces its route
+	rt.Add(pfx("10.1.0.0/16"), 110, 5, "ospf-a")
+	routes := rt.Routes(pfx("10.1.0.0/16"))
+	var vals []string
+	for _, r := range routes {
+		vals = append(vals, r.Value)
+	}
+	if want := []string{"ospf-a", "ospf-b", "ospf-c"}; len(vals) != len(want) ||
+		vals[0] != want[0] || vals[1] != want[1] || vals[2] != want[2] {
+		t.Errorf("Routes(10.1.0.0/16) = %v, want %v", vals, want)
+	}
+
+	// Withdrawing the best route falls back to the next candidate, then to
+	// shorter prefixes
+	rt.Withdraw(pfx("10.0.0.0/8"), "bgp")
+	if r, _ := rt.BestRoute(netip.MustParseAddr("10.2.3.4")); r.Value != "ospf" {
+		t.Errorf("BestRoute(10.2.3.4) = %v, want ospf", r)
+	}
+	rt.Withdraw(pfx("10.0.0.0/8"), "ospf")
+	if r, _ := rt.BestRoute(netip.MustParseAddr("10.2.3.4")); r.Value != "default-a" {
+		t.Errorf("BestRoute(10.2.3.4) = %v, want default-a", r)
+	}
+	// Withdrawing unknown routes is a no-op
+	rt.Withdraw(pfx("10.0.0.0/8"), "ospf")
+	rt.Withdraw(pfx("10.1.0.0/16"), "rip")
+	if got := rt.Size(); got != 3 {
+		t.Errorf("Size() = %d, want 3", got)
+	}
+
+	// Slices returned earlier are unaffected by later changes
+	rt.WithdrawPrefix(pfx("10.1.0.0/16"))
+	if routes[0].Value != "ospf-a" || len(routes) != 3 {
+		t.Errorf("earlier Routes result changed: %v", routes)
+	}
+	if got := rt.Routes(pfx("10.1.0.0/16")); got != nil {
+		t.Errorf("Routes(10.1.0.0/16) = %v, want none", got)
+	}
+	if got := rt.Size(); got != 2 {
+		t.Errorf("Size() = %d, want 2", got)
+	}
+
+	if err := rt.Add(netip.Prefix{}, 0, 0, "x"); err == nil {
+		t.Errorf("Add(invalid) returned nil error")
+	}
+	if err := rt.Withdraw(netip.Prefix{}, "x"); err == nil {
+		t.Errorf("Withdraw(invalid) returned nil error")
+	}
+}
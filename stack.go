@@ -2,8 +2,11 @@ package netipds
 
 // stack is used for depth-first traversals without recursion or heap
 // allocation.
+//
+// A depth-first traversal of an uncompressed tree holds at most one element
+// per key length (0 through 128) plus one, hence the capacity of 129.
 type stack[T any] struct {
-	data [128]T
+	data [129]T
 	// top starts at 0, so it is the index of the next available slot.
 	top int
 }
@@ -26,3 +29,9 @@ func (s *stack[T]) Pop() T {
 	value := s.data[s.top]
 	return value
 }
+
+// Peek returns the element at the top of the stack without removing it.
+// Panics if stack is empty (use IsEmpty()).
+func (s *stack[T]) Peek() T {
+	return s.data[s.top-1]
+}
@@ -0,0 +1,101 @@
+package netipds
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// Tables is a set of independent routing tables, such as VRFs, each holding a
+// [PrefixMapBuilder] and identified by name.
+//
+// The zero value is a valid Tables containing no tables. Tables is not safe
+// for concurrent use.
+type Tables[T any] struct {
+	tables map[string]*PrefixMapBuilder[T]
+}
+
+// Table returns the table named id, creating an empty one if it does not
+// exist. Changes made through the returned builder are reflected in t.
+func (t *Tables[T]) Table(id string) *PrefixMapBuilder[T] {
+	if b, ok := t.tables[id]; ok {
+		return b
+	}
+	if t.tables == nil {
+		t.tables = make(map[string]*PrefixMapBuilder[T])
+	}
+	b := &PrefixMapBuilder[T]{}
+	t.tables[id] = b
+	return b
+}
+
+// Has returns true if t has a table named id.
+func (t *Tables[T]) Has(id string) bool {
+	_, ok := t.tables[id]
+	return ok
+}
+
+// Remove removes the table named id, if any.
+func (t *Tables[T]) Remove(id string) {
+	delete(t.tables, id)
+}
+
+// IDs returns the names of the tables in t, in ascending order.
+func (t *Tables[T]) IDs() []string {
+	ids := make([]string, 0, len(t.tables))
+	for id := range t.tables {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Leak copies the entries of table from which are encompassed by s into table
+// to, replacing any entries that to already has for the same Prefixes. If s
+// is nil, all entries are copied. Table to is created if it does not exist.
+func (t *Tables[T]) Leak(from, to string, s *PrefixSet) error {
+	src, ok := t.tables[from]
+	if !ok {
+		return fmt.Errorf("table does not exist: %s", from)
+	}
+	if from == to {
+		return nil
+	}
+	dst := t.Table(to)
+	src.tree.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry && (s == nil || s.tree.encompasses(n.key, false)) {
+			dst.Set(n.key.toPrefix(), n.value)
+		}
+		return false
+	})
+	return nil
+}
+
+// Lookup returns the longest Prefix containing a, and its value, from the
+// first table in ids that has one. The name of that table is returned too.
+// Tables in ids which do not exist are skipped. a's zone, if any, is ignored.
+func (t *Tables[T]) Lookup(a netip.Addr, ids ...string) (id string, p netip.Prefix, val T, ok bool) {
+	if !a.IsValid() {
+		return
+	}
+	k := keyFromAddr(a)
+	for _, id := range ids {
+		b, exists := t.tables[id]
+		if !exists {
+			continue
+		}
+		if n := b.tree.longestMatch(k); n != nil {
+			return id, n.key.toPrefix(), n.value, true
+		}
+	}
+	return
+}
+
+// PrefixMap returns an immutable PrefixMap representing the current state of
+// the table named id, which is empty if the table does not exist.
+func (t *Tables[T]) PrefixMap(id string) *PrefixMap[T] {
+	if b, ok := t.tables[id]; ok {
+		return b.PrefixMap()
+	}
+	return &PrefixMap[T]{}
+}
@@ -0,0 +1,78 @@
+package netipds
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestTables(t *testing.T) {
+	var ts Tables[string]
+	if ts.Has("red") {
+		t.Errorf("zero value Has(red) = true")
+	}
+	red, blue := ts.Table("red"), ts.Table("blue")
+	red.Set(pfx("10.0.0.0/8"), "red-corp")
+	red.Set(pfx("10.1.0.0/16"), "red-lab")
+	red.Set(pfx("2001:db8::/32"), "red-v6")
+	blue.Set(pfx("10.0.0.0/8"), "blue-corp")
+	ts.Table("global").Set(pfx("0.0.0.0/1"), "internet")
+
+	if ts.Table("red") != red {
+		t.Errorf("Table(red) returned a different builder")
+	}
+	if got, want := ts.IDs(), []string{"blue", "global", "red"}; !slices.Equal(got, want) {
+		t.Errorf("IDs() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		addr   string
+		ids    []string
+		wantID string
+		wantP  netip.Prefix
+		wantV  string
+		ok     bool
+	}{
+		{"10.1.2.3", []string{"red", "global"}, "red", pfx("10.1.0.0/16"), "red-lab", true},
+		{"10.1.2.3", []string{"blue", "red"}, "blue", pfx("10.0.0.0/8"), "blue-corp", true},
+		{"1.2.3.4", []string{"blue", "global"}, "global", pfx("0.0.0.0/1"), "internet", true},
+		// Missing tables are skipped
+		{"1.2.3.4", []string{"green", "global"}, "global", pfx("0.0.0.0/1"), "internet", true},
+		{"200.0.0.1", []string{"red", "blue", "global"}, "", netip.Prefix{}, "", false},
+		{"10.1.2.3", nil, "", netip.Prefix{}, "", false},
+	}
+	for _, tt := range tests {
+		id, p, v, ok := ts.Lookup(netip.MustParseAddr(tt.addr), tt.ids...)
+		if id != tt.wantID || p != tt.wantP || v != tt.wantV || ok != tt.ok {
+			t.Errorf("Lookup(%s, %v) = (%q, %v, %q, %v), want (%q, %v, %q, %v)",
+				tt.addr, tt.ids, id, p, v, ok, tt.wantID, tt.wantP, tt.wantV, tt.ok)
+		}
+	}
+
+	// Leak a filtered set of routes from red into blue
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("10.1.0.0/16"))
+	psb.Add(pfx("2000::/3"))
+	if err := ts.Leak("red", "blue", psb.PrefixSet()); err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, map[netip.Prefix]string{
+		pfx("10.0.0.0/8"):    "blue-corp",
+		pfx("10.1.0.0/16"):   "red-lab",
+		pfx("2001:db8::/32"): "red-v6",
+	}, ts.PrefixMap("blue").ToMap())
+
+	// Leak everything into a new table
+	if err := ts.Leak("global", "green", nil); err != nil {
+		t.Fatal(err)
+	}
+	checkMap(t, map[netip.Prefix]string{pfx("0.0.0.0/1"): "internet"}, ts.PrefixMap("green").ToMap())
+	if err := ts.Leak("missing", "green", nil); err == nil {
+		t.Errorf("Leak from missing table returned nil error")
+	}
+
+	ts.Remove("red")
+	if ts.Has("red") || ts.PrefixMap("red").Size() != 0 {
+		t.Errorf("table red still present after Remove")
+	}
+}
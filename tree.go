@@ -2,6 +2,7 @@ package netipds
 
 import (
 	"fmt"
+	"slices"
 )
 
 // tree is a binary radix tree supporting 128-bit keys (see key.go).
@@ -10,13 +11,19 @@ import (
 // insertion via insertLazy(). This can be much faster than insert() and works
 // well with netipds's intended usage pattern (build a collection with a
 // builder type, then generate an immutable version). After lazy insertions,
-// the tree can be compressed using the compress() method.
+// a compressed copy of the tree can be made using the compressedCopy() method.
+//
+// Each node stores its own value inline, with hasEntry indicating whether the
+// value is present, so reading a value never requires a separate lookup.
 type tree[T any] struct {
 	key      key
 	hasEntry bool
-	value    T
-	left     *tree[T]
-	right    *tree[T]
+	// gen is the generation of a builder that owns this node exclusively
+	// (see ownPath). It fits in padding, so it doesn't enlarge the node.
+	gen   uint16
+	value T
+	left  *tree[T]
+	right *tree[T]
 }
 
 // newTree returns a new tree with the provided key.
@@ -86,6 +93,70 @@ func (t *tree[T]) copy() *tree[T] {
 	return ret
 }
 
+// owned returns t if it belongs to generation gen, or a shallow copy of t
+// belonging to gen otherwise.
+func (t *tree[T]) owned(gen uint16) *tree[T] {
+	if t.gen == gen {
+		return t
+	}
+	c := *t
+	c.gen = gen
+	return &c
+}
+
+// ownPath prepares t for an in-place insert or remove of k when t's nodes may
+// be shared with other trees. Every node that such an operation could modify
+// (the descendants of t on the path to k, and the children of the last of
+// them) is replaced by a copy belonging to generation gen, unless it already
+// belongs to gen. t itself must already be owned by the caller.
+//
+// Nodes that belong to gen are never shared, so they are modified in place.
+// Untouched subtrees remain shared.
+func (t *tree[T]) ownPath(k key, gen uint16) {
+	n := t
+	for n.key.isPrefixOf(k, true) {
+		c := n.child(k.bit(n.key.len))
+		if *c == nil {
+			return
+		}
+		*c = (*c).owned(gen)
+		n = *c
+	}
+	for _, c := range []**tree[T]{&n.left, &n.right} {
+		if *c != nil {
+			*c = (*c).owned(gen)
+		}
+	}
+}
+
+// stampPath marks the nodes on the path from t to k as belonging to
+// generation gen. It is used after an insert following ownPath, when the only
+// nodes on the path that don't already belong to gen are newly created.
+func (t *tree[T]) stampPath(k key, gen uint16) {
+	for n := t; n != nil && n.key.isPrefixOf(k, false); n = *n.child(k.bit(n.key.len)) {
+		n.gen = gen
+		if n.key.len == k.len {
+			return
+		}
+	}
+}
+
+// mapTree returns a copy of t with the same structure, in which the value of
+// each entry has been replaced by the result of fn.
+func mapTree[T, U any](t *tree[T], fn func(key, T) U) *tree[U] {
+	ret := newTree[U](t.key)
+	if t.left != nil {
+		ret.left = mapTree(t.left, fn)
+	}
+	if t.right != nil {
+		ret.right = mapTree(t.right, fn)
+	}
+	if t.hasEntry {
+		ret.setValue(fn(t.key, t.value))
+	}
+	return ret
+}
+
 func (t *tree[T]) stringImpl(indent string, pre string, hideVal bool) string {
 	var ret string
 	if hideVal {
@@ -153,6 +224,10 @@ func (t *tree[T]) insert(k key, v T) *tree[T] {
 }
 
 // insertLazy inserts value v at key k without path compression.
+//
+// Parts of t may have been compressed by operations other than insertLazy,
+// such as subtraction. If k diverges from a compressed node, insertLazy falls
+// back to insert at that node.
 func (t *tree[T]) insertLazy(k key, v T) *tree[T] {
 	switch {
 	// Inserting at t itself
@@ -165,27 +240,89 @@ func (t *tree[T]) insertLazy(k key, v T) *tree[T] {
 		if *child == nil {
 			*child = newTree[T](t.key.next(bit))
 		}
-		(*child).insertLazy(k, v)
+		*child = (*child).insertLazy(k, v)
 		return t
-	// Nothing to do
+	// k is a prefix of t's compressed key, or diverges from it
 	default:
-		return t
+		return t.insert(k, v)
+	}
+}
+
+// compressedCopy returns a copy of t with path compression applied to all of
+// its descendants. t itself is kept as the root of the copy.
+func (t *tree[T]) compressedCopy() *tree[T] {
+	ret := newTree[T](t.key)
+	ret.setValueFrom(t)
+	for _, bit := range eachBit {
+		n := *t.child(bit)
+		// Skip over entry-less nodes with a single child
+		for n != nil && !n.hasEntry && (n.left == nil) != (n.right == nil) {
+			if n.left != nil {
+				n = n.left
+			} else {
+				n = n.right
+			}
+		}
+		if n == nil || (!n.hasEntry && n.left == nil && n.right == nil) {
+			continue
+		}
+		c := n.compressedCopy()
+		c.key.offset = t.key.len
+		*ret.child(bit) = c
 	}
+	return ret
 }
 
-// compress performs path compression on tree t.
-func (t *tree[T]) compress() *tree[T] {
+// prune removes the nodes below t which hold no entries and lead to none, such
+// as those left behind by removals. If compress, entry-less nodes with a
+// single child are also removed, with the child taking their place. t itself
+// is kept.
+func (t *tree[T]) prune(compress bool) {
+	pruneAt(&t.left, compress)
+	pruneAt(&t.right, compress)
+}
+
+// pruneAt prunes the subtree at *c as described in prune, including the node
+// at *c itself, which may be replaced by its child or removed.
+func pruneAt[T any](c **tree[T], compress bool) {
+	n := *c
+	if n == nil {
+		return
+	}
+	n.prune(compress)
 	switch {
-	case t.left == nil && t.right == nil:
-		return t
-	case t.left == nil:
-		t.right.key.offset = t.key.offset
-		return t.right
-	case t.right == nil:
-		t.left.key.offset = t.key.offset
-		return t.left
-	default:
-		return t
+	case n.hasEntry:
+	case n.left == nil && n.right == nil:
+		*c = nil
+	case compress && n.left == nil:
+		n.right.key.offset = n.key.offset
+		*c = n.right
+	case compress && n.right == nil:
+		n.left.key.offset = n.key.offset
+		*c = n.left
+	}
+}
+
+// compressBelow prunes and compresses the nodes of t whose keys are
+// encompassed by k, as in prune(true). The rest of t is left as is.
+func (t *tree[T]) compressBelow(k key) {
+	if k.len == 0 {
+		t.prune(true)
+		return
+	}
+	for n := t; ; {
+		c := n.child(k.bit(n.key.len))
+		switch {
+		case *c == nil:
+			return
+		case k.isPrefixOf((*c).key, false):
+			pruneAt(c, true)
+			return
+		// *c diverges from k
+		case !(*c).key.isPrefixOf(k, true):
+			return
+		}
+		n = *c
 	}
 }
 
@@ -249,35 +386,23 @@ func (t *tree[T]) subtractKey(k key) *tree[T] {
 	return t
 }
 
-// subtractTree removes all entries from t that have counterparts in o. If a
-// child of t is removed, then new nodes may be created to fill in the gaps
-// around the removed node.
+// subtractTree removes all entries from t that have counterparts in o, along
+// with their descendants. Ancestor entries are split as in subtractKeyFunc,
+// keeping their values.
 //
 // TODO: this method only makes sense in the context of a PrefixSet.
 // "subtracting" a whole key-value entry from another isn't meaningful. So
 // maybe we need two types of trees: value-bearing ones, and others that just
 // have value-less entries.
 func (t *tree[T]) subtractTree(o *tree[T]) *tree[T] {
-	if o.hasEntry {
-		// This whole branch is being subtracted; no need to traverse further
-		if o.key.isPrefixOf(t.key, false) {
-			return nil
-		}
-		// A descendant of t is being subtracted
-		if t.key.isPrefixOf(o.key, false) {
-			t.insertHole(o.key, t.value)
-		}
-	}
-	// Consider the children of both t and o
-	for _, bit := range eachBit {
-		tChild, oChild := t.child(bit), o.child(bit)
-		if *oChild != nil {
-			if *tChild == nil {
-				tChild = &t
-			}
-			*tChild = (*tChild).subtractTree(*oChild)
+	keep := func(_ key, v T) (T, bool) { return v, true }
+	o.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry {
+			t = t.subtractKeyFunc(n.key.rooted(), keep)
+			return true
 		}
-	}
+		return false
+	})
 	return t
 }
 
@@ -341,11 +466,11 @@ func (t *tree[T]) mergeTree(o *tree[T]) *tree[T] {
 		return t.newParent(o.key).setValueFrom(o).mergeTree(o)
 	// Neither is a prefix of the other
 	default:
-		// Insert a new parent above t, and create a new sibling for t having
-		// o's key and value.
-		return t.newParent(t.key.truncated(common)).setChild(
-			newTree[T](o.key.rest(common)).setValueFrom(o),
-		)
+		// Insert a new parent above t, and give it a copy of o, including its
+		// descendants, as t's sibling.
+		sibling := o.copy()
+		sibling.key.offset = common
+		return t.newParent(t.key.truncated(common)).setChild(sibling)
 	}
 }
 
@@ -483,6 +608,125 @@ func (t *tree[T]) insertHole(k key, v T) *tree[T] {
 	}
 }
 
+// pruneKey removes k and all of its descendants from the tree, without
+// filling in any gaps. Entry-less nodes left with a single child are merged
+// with that child. The root of the tree is never removed.
+func (t *tree[T]) pruneKey(k key) *tree[T] {
+	switch {
+	// This whole branch is being removed
+	case k.isPrefixOf(t.key, false):
+		return nil
+	// A descendant of t is being removed; recurse into the appropriate child
+	case t.key.isPrefixOf(k, false):
+		child := t.child(k.bit(t.key.len))
+		if *child != nil {
+			*child = (*child).pruneKey(k)
+		}
+		if t.hasEntry || t.key.isZero() {
+			return t
+		}
+		switch {
+		case t.left == nil && t.right == nil:
+			return nil
+		case t.left == nil:
+			t.right.key.offset = t.key.offset
+			return t.right
+		case t.right == nil:
+			t.left.key.offset = t.key.offset
+			return t.left
+		}
+		return t
+	// Nothing to do
+	default:
+		return t
+	}
+}
+
+// fillPath creates an entry at each key which branches off of the path to k
+// at depths [from, to). Keys which already have entries are left alone.
+//
+// The value of each new entry is determined by calling fn with the new key and
+// v. If fn returns false, no entry is created for that key.
+func (t *tree[T]) fillPath(
+	k key,
+	from, to uint8,
+	v T,
+	fn func(key, T) (T, bool),
+) *tree[T] {
+	for i := from; i < to; i++ {
+		sibling := k.truncated(i).next((^k.bit(i)) & 1).rooted()
+		if _, ok := t.get(sibling); ok {
+			continue
+		}
+		if val, ok := fn(sibling, v); ok {
+			t = t.insert(sibling, val)
+		}
+	}
+	return t
+}
+
+// carve replaces the entry at e with entries covering the remainder of e's key
+// space after k is removed from it, with values determined by fn (see
+// fillPath), which is called with e's value. e must have an entry and be a
+// prefix of k.
+//
+// As in subtractKeyFunc, e's key space is only split as far as the next entry
+// on the path to k (or k itself). Other entries are left unchanged.
+func (t *tree[T]) carve(e, k key, fn func(key, T) (T, bool)) *tree[T] {
+	v, _ := t.get(e)
+	to := k.len
+	t.walk(k, func(n *tree[T]) bool {
+		if !n.key.isPrefixOf(k, false) {
+			return true
+		}
+		if n.hasEntry && e.isPrefixOf(n.key, true) {
+			to = n.key.len
+			return true
+		}
+		return false
+	})
+	t = t.remove(e)
+	return t.fillPath(k, e.len, to, v, fn)
+}
+
+// subtractKeyFunc removes k and all of its descendants from the tree. Each
+// ancestor entry of k is replaced by new entries covering the remainder of its
+// key space, with values determined by fn (see fillPath).
+//
+// An ancestor's key space is only split as far as the next ancestor entry
+// below it, since the remainder is already covered by that entry. As a result,
+// the longest-prefix match of every key outside of k is unchanged, except
+// where fn declines to create an entry.
+func (t *tree[T]) subtractKeyFunc(k key, fn func(key, T) (T, bool)) *tree[T] {
+	type entry struct {
+		key   key
+		value T
+	}
+	var ancestors []entry
+	t.walk(k, func(n *tree[T]) bool {
+		if !n.key.isPrefixOf(k, false) {
+			return true
+		}
+		if n.hasEntry {
+			ancestors = append(ancestors, entry{n.key.rooted(), n.value})
+		}
+		return false
+	})
+
+	if t = t.pruneKey(k); t == nil {
+		return &tree[T]{}
+	}
+	for i, a := range ancestors {
+		to := k.len
+		if i+1 < len(ancestors) {
+			to = ancestors[i+1].key.len
+		}
+		t = t.remove(a.key)
+		t = t.fillPath(k, a.key.len, to, a.value, fn)
+	}
+	return t
+}
+
 // walk traverses the tree starting at this tree's root, following the
 // provided path and calling fn(node) at each visited node.
 //
@@ -568,6 +812,18 @@ func (t *tree[T]) encompasses(k key, strict bool) (ret bool) {
 	return
 }
 
+// longestMatch returns the node with the longest key that has an entry and is
+// a prefix of k, or nil if there is none. Unlike parentOf, longestMatch does
+// not allocate.
+func (t *tree[T]) longestMatch(k key) (match *tree[T]) {
+	for n := t.pathNext(k); n != nil && n.key.isPrefixOf(k, false); n = n.pathNext(k) {
+		if n.hasEntry {
+			match = n
+		}
+	}
+	return
+}
+
 // rootOf returns the shortest-prefix ancestor of the key provided, if any.
 // If strict == true, the key itself is not considered.
 func (t *tree[T]) rootOf(k key, strict bool) (outKey key, val T, ok bool) {
@@ -614,6 +870,146 @@ func (t *tree[T]) descendantsOf(k key, strict bool) (ret *tree[T]) {
 	return
 }
 
+// eachDescendant calls fn for each entry in t that is a descendant of k,
+// including k itself, in place and in walk order, until fn returns false.
+func (t *tree[T]) eachDescendant(k key, fn func(*tree[T]) bool) {
+	ok := true
+	t.walk(k, func(n *tree[T]) bool {
+		switch {
+		case !ok:
+			return true
+		// n is on the path to k
+		case n.key.isPrefixOf(k, true):
+			return false
+		// n diverges from k
+		case !k.isPrefixOf(n.key, false):
+			return true
+		case n.hasEntry:
+			ok = fn(n)
+		}
+		return !ok
+	})
+}
+
+// eachRoot calls fn for each entry in t that has no ancestor entry in t, in
+// place and in walk order, until fn returns false.
+func (t *tree[T]) eachRoot(fn func(*tree[T]) bool) {
+	ok := true
+	t.walk(key{}, func(n *tree[T]) bool {
+		if ok && n.hasEntry {
+			ok = fn(n)
+			return true
+		}
+		return !ok
+	})
+}
+
+// eachLeaf calls fn for each entry in t that has no descendant entries in t,
+// in place and in walk order, until fn returns false.
+func (t *tree[T]) eachLeaf(fn func(*tree[T]) bool) {
+	// Entries are visited before their descendants, so the previous entry is a
+	// leaf unless the current one descends from it.
+	var prev *tree[T]
+	ok := true
+	t.walk(key{}, func(n *tree[T]) bool {
+		if ok && n.hasEntry {
+			if prev != nil && !prev.key.isPrefixOf(n.key, true) {
+				ok = fn(prev)
+			}
+			prev = n
+		}
+		return !ok
+	})
+	if ok && prev != nil {
+		fn(prev)
+	}
+}
+
+// covered reports whether the key space of t is completely covered by the
+// entries in t.
+func (t *tree[T]) covered() bool {
+	if t.hasEntry {
+		return true
+	}
+	for _, bit := range eachBit {
+		c := *t.child(bit)
+		if c == nil || c.key.len != t.key.len+1 || !c.covered() {
+			return false
+		}
+	}
+	return true
+}
+
+// covers reports whether the entries in t together cover all of k.
+func (t *tree[T]) covers(k key) bool {
+	for n := t.pathNext(k); n != nil; n = n.pathNext(k) {
+		if n.key.len >= k.len {
+			return n.key.equalFromRoot(k) && n.covered()
+		}
+		if !n.key.isPrefixOf(k, false) {
+			return false
+		}
+		if n.hasEntry {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowedKeys returns the keys of the entries in t whose key spaces are
+// completely covered by their descendant entries, in walk order.
+func (t *tree[T]) shadowedKeys() []key {
+	var res []key
+	// check returns whether n's key space is completely covered by entries
+	var check func(n *tree[T]) bool
+	check = func(n *tree[T]) bool {
+		i := len(res)
+		full := true
+		for _, bit := range eachBit {
+			c := *n.child(bit)
+			full = c != nil && check(c) && c.key.len == n.key.len+1 && full
+		}
+		if n.hasEntry && full {
+			// Ancestors precede the descendants already added
+			res = slices.Insert(res, i, n.key)
+		}
+		return n.hasEntry || full
+	}
+	check(t)
+	return res
+}
+
+// encompassesWithin returns true if t contains an entry which encompasses k
+// and whose key length is within [lo, hi].
+func (t *tree[T]) encompassesWithin(k key, lo, hi uint8) bool {
+	for n := t.pathNext(k); n != nil && n.key.len <= hi; n = n.pathNext(k) {
+		if n.hasEntry && n.key.len >= lo && n.key.isPrefixOf(k, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// descendantsWithin returns a new tree containing the descendants of k in t,
+// including k itself, whose key lengths are within [lo, hi].
+func (t *tree[T]) descendantsWithin(k key, lo, hi uint8) *tree[T] {
+	ret := &tree[T]{}
+	t.walk(k, func(n *tree[T]) bool {
+		switch {
+		// n is on the path to k
+		case n.key.isPrefixOf(k, true):
+			return false
+		// n diverges from k, or everything beneath n is too long
+		case !k.isPrefixOf(n.key, false) || n.key.len > hi:
+			return true
+		case n.hasEntry && n.key.len >= lo:
+			ret = ret.insert(n.key.rooted(), n.value)
+		}
+		return false
+	})
+	return ret
+}
+
 // ancestorsOf returns the sub-tree containing all ancestors of the provided
 // key. The key itself will be included if it has an entry in the tree, unless
 // strict == true. ancestorsOf returns an empty tree if key has no ancestors in
@@ -649,22 +1045,288 @@ func (t *tree[T]) filter(o *tree[bool]) {
 	}
 }
 
-// filterCopy returns a recursive copy of t that includes only keys that are
+// filterFunc removes all entries from t for which fn returns false.
+func (t *tree[T]) filterFunc(fn func(key, T) bool) {
+	remove := make([]key, 0)
+	t.walk(key{}, func(n *tree[T]) bool {
+		if n.hasEntry && !fn(n.key, n.value) {
+			remove = append(remove, n.key)
+		}
+		return false
+	})
+	for _, k := range remove {
+		t.remove(k)
+	}
+}
+
+// filterCopy returns a copy of t that includes only keys that are
 // encompassed by o.
-// TODO: I think this can be done more efficiently by walking t and o
-// at the same time.
-// TODO: does it make sense to have both this method and filter()?
+//
+// t and o are walked at the same time. Both visit keys in ascending order, so
+// the entry of o which might encompass the current key of t only ever moves
+// forward.
 func (t *tree[T]) filterCopy(o *tree[bool]) *tree[T] {
-	ret := &tree[T]{}
+	var keys []key
+	var vals []T
+	it, tit := newEntryIter(o), newEntryIter(t)
+	r := it.next()
+	for n := tit.next(); n != nil && r != nil; n = tit.next() {
+		for r != nil && r.key.content.bitsSetFrom(r.key.len).less(n.key.content) {
+			r = it.next()
+		}
+		if r != nil && r.key.isPrefixOf(n.key, false) {
+			keys = append(keys, n.key)
+			vals = append(vals, n.value)
+		}
+	}
+	return treeFromSorted(keys, func(i int) T { return vals[i] })
+}
+
+// keyRange is an inclusive range [lo, hi] of 128-bit key values.
+type keyRange struct {
+	lo, hi uint128
+}
+
+// coverage returns the ranges of key values covered by the entries of t, in
+// ascending order. Adjacent ranges are joined.
+func (t *tree[T]) coverage() []keyRange {
+	var ret []keyRange
 	t.walk(key{}, func(n *tree[T]) bool {
-		if n.hasEntry && o.encompasses(n.key, false) {
-			ret = ret.insert(n.key, n.value)
+		if !n.hasEntry {
+			return false
 		}
-		return false
+		r := keyRange{n.key.content, n.key.content.bitsSetFrom(n.key.len)}
+		if last := len(ret) - 1; last >= 0 && ret[last].hi.addOne() == r.lo {
+			ret[last].hi = r.hi
+		} else {
+			ret = append(ret, r)
+		}
+		return true
 	})
 	return ret
 }
 
+// coverageOf returns the ranges of key values beneath k covered by the entries
+// of t, in ascending order. Adjacent ranges are joined.
+func (t *tree[T]) coverageOf(k key) []keyRange {
+	if t.encompasses(k, false) {
+		return []keyRange{{k.content, k.content.bitsSetFrom(k.len)}}
+	}
+	var ranges []keyRange
+	t.eachDescendant(k, func(d *tree[T]) bool {
+		r := keyRange{d.key.content, d.key.content.bitsSetFrom(d.key.len)}
+		last := len(ranges) - 1
+		switch {
+		// Nested within the previous range
+		case last >= 0 && !ranges[last].hi.less(r.hi):
+		case last >= 0 && ranges[last].hi.addOne() == r.lo:
+			ranges[last].hi = r.hi
+		default:
+			ranges = append(ranges, r)
+		}
+		return true
+	})
+	return ranges
+}
+
+// eachSubnet calls fn with each key of length n (n >= k.len) beneath k that
+// is completely covered by the entries of t, in order, until fn returns
+// false. If partial, keys that are only partly covered are included too.
+func (t *tree[T]) eachSubnet(k key, n uint8, partial bool, fn func(key) bool) {
+	ranges := t.coverageOf(k)
+
+	// prev is the last subnet visited, if any
+	var prev uint128
+	var started bool
+	for _, r := range ranges {
+		// [first, last] are the subnets to visit from r
+		first, last := r.lo.bitsClearedFrom(n), r.hi.bitsClearedFrom(n)
+		if partial {
+			// A subnet may overlap the previous range too
+			if started && first == prev {
+				if first == last {
+					continue
+				}
+				first = first.bitsSetFrom(n).addOne()
+			}
+		} else {
+			// Skip the subnets at either end unless r covers them completely
+			if first != r.lo {
+				if !r.lo.bitsSetFrom(n).less(r.hi) {
+					continue
+				}
+				first = r.lo.bitsSetFrom(n).addOne()
+			}
+			if r.hi.bitsSetFrom(n) != r.hi {
+				if !first.less(last) {
+					continue
+				}
+				last = last.subOne().bitsClearedFrom(n)
+			}
+		}
+		for sub := first; ; sub = sub.bitsSetFrom(n).addOne() {
+			if !fn(key{sub, 0, n}) {
+				return
+			}
+			prev, started = sub, true
+			if sub == last {
+				break
+			}
+		}
+	}
+}
+
+// firstFree returns the first key of length n (n >= k.len) beneath k which
+// does not overlap any entry of t, if there is one.
+func (t *tree[T]) firstFree(k key, n uint8) (key, bool) {
+	if t.encompasses(k, false) {
+		return key{}, false
+	}
+	if !t.overlapsKey(k) {
+		return key{k.content, 0, n}, true
+	}
+	if k.len < n {
+		for _, b := range eachBit {
+			if f, ok := t.firstFree(k.next(b), n); ok {
+				return f, true
+			}
+		}
+	}
+	return key{}, false
+}
+
+// treeFromRanges returns a tree containing the smallest set of keys which
+// exactly covers rs. rs must be ascending and non-overlapping.
+func treeFromRanges(rs []keyRange) *tree[bool] {
+	ret := &tree[bool]{}
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		// Join adjacent ranges
+		for i+1 < len(rs) && r.hi.addOne() == rs[i+1].lo {
+			i++
+			r.hi = rs[i].hi
+		}
+		rangeKeys(r.lo, r.hi, func(k key) bool {
+			ret = ret.insert(k, true)
+			return true
+		})
+	}
+	return ret
+}
+
+// compareCoverage sweeps across two sets of ascending, non-overlapping ranges,
+// calling fn with each range of values covered by either a or b, along with
+// which of them covers it. The ranges passed to fn are ascending and do not
+// overlap. If fn returns false, compareCoverage stops.
+//
+// a and b are modified.
+func compareCoverage(a, b []keyRange, fn func(r keyRange, inA, inB bool) bool) {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i].hi.less(b[j].lo)):
+			if !fn(a[i], true, false) {
+				return
+			}
+			i++
+		case i == len(a) || b[j].hi.less(a[i].lo):
+			if !fn(b[j], false, true) {
+				return
+			}
+			j++
+		case a[i].lo.less(b[j].lo):
+			if !fn(keyRange{a[i].lo, b[j].lo.subOne()}, true, false) {
+				return
+			}
+			a[i].lo = b[j].lo
+		case b[j].lo.less(a[i].lo):
+			if !fn(keyRange{b[j].lo, a[i].lo.subOne()}, false, true) {
+				return
+			}
+			b[j].lo = a[i].lo
+		// a[i] and b[j] start at the same value
+		default:
+			end := a[i].hi
+			if b[j].hi.less(end) {
+				end = b[j].hi
+			}
+			if !fn(keyRange{a[i].lo, end}, true, true) {
+				return
+			}
+			if a[i].hi == end {
+				i++
+			} else {
+				a[i].lo = end.addOne()
+			}
+			if b[j].hi == end {
+				j++
+			} else {
+				b[j].lo = end.addOne()
+			}
+		}
+	}
+}
+
+// symmetricDifference returns a tree containing the smallest set of keys which
+// covers exactly the key values covered by the entries of one of a and b, but
+// not both.
+func symmetricDifference[T, U any](a *tree[T], b *tree[U]) *tree[bool] {
+	var ranges []keyRange
+	compareCoverage(a.coverage(), b.coverage(), func(r keyRange, inA, inB bool) bool {
+		if inA != inB {
+			ranges = append(ranges, r)
+		}
+		return true
+	})
+	return treeFromRanges(ranges)
+}
+
+// lpmEquivalent reports whether every key has the same longest-prefix match
+// in a and b, where values are compared using eq.
+//
+// The entry keys of a and b partition the key space into regions, each
+// consisting of the part of an entry key not covered by longer entry keys.
+// All keys in a region have the same longest-prefix match in each tree as the
+// region's entry key does, so only nonempty regions need to be compared.
+func lpmEquivalent[T any](a, b *tree[T], eq func(a, b T) bool) bool {
+	isEntry := func(key, T) bool { return true }
+	u := mapTree(a, isEntry).mergeTree(mapTree(b, isEntry))
+
+	ok := true
+	// check compares the regions beneath n, and returns whether n's key
+	// space is completely covered by entries.
+	var check func(n *tree[bool]) bool
+	check = func(n *tree[bool]) bool {
+		full := true
+		for _, bit := range eachBit {
+			c := *n.child(bit)
+			full = c != nil && check(c) && c.key.len == n.key.len+1 && full
+		}
+		if n.hasEntry && !full && ok {
+			_, aVal, aOk := a.parentOf(n.key, false)
+			_, bVal, bOk := b.parentOf(n.key, false)
+			ok = aOk == bOk && (!aOk || eq(aVal, bVal))
+		}
+		return n.hasEntry || full
+	}
+	check(u)
+	return ok
+}
+
+// overlappingKeys calls fn with each key in t that has an entry and overlaps
+// k, in order.
+func (t *tree[T]) overlappingKeys(k key, fn func(*tree[T])) {
+	t.walk(k, func(n *tree[T]) bool {
+		if !n.key.isPrefixOf(k, false) && !k.isPrefixOf(n.key, false) {
+			return true
+		}
+		if n.hasEntry {
+			fn(n)
+		}
+		return false
+	})
+}
+
 // overlapsKey reports whether any key in t overlaps k.
 func (t *tree[T]) overlapsKey(k key) bool {
 	var ret bool
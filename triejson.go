@@ -0,0 +1,147 @@
+package netipds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// trieJSONNode is a node in the JSON encoding of a tree. See
+// [PrefixMap.WriteTrieJSON].
+type trieJSONNode[T any] struct {
+	Prefix  netip.Prefix     `json:"prefix"`
+	Segment string           `json:"segment"`
+	Entry   bool             `json:"entry"`
+	Value   *T               `json:"value,omitempty"`
+	Left    *trieJSONNode[T] `json:"left,omitempty"`
+	Right   *trieJSONNode[T] `json:"right,omitempty"`
+}
+
+// trieJSON returns the JSON encoding of the subtree rooted at t, whose parent
+// has key length parentLen. Values are omitted if hideVal is true.
+func trieJSON[T any](t *tree[T], parentLen uint8, hideVal bool) *trieJSONNode[T] {
+	var seg strings.Builder
+	for i := parentLen; i < t.key.len; i++ {
+		seg.WriteByte('0' + byte(t.key.bit(i)))
+	}
+	n := &trieJSONNode[T]{
+		Prefix:  t.key.toPrefix(),
+		Segment: seg.String(),
+		Entry:   t.hasEntry,
+	}
+	if t.hasEntry && !hideVal {
+		v := t.value
+		n.Value = &v
+	}
+	if t.left != nil {
+		n.Left = trieJSON(t.left, t.key.len, hideVal)
+	}
+	if t.right != nil {
+		n.Right = trieJSON(t.right, t.key.len, hideVal)
+	}
+	return n
+}
+
+// walkTrieJSON validates the structure of the JSON trie rooted at n, whose
+// parent has key parent, and calls fn with each entry.
+func walkTrieJSON[T any](n *trieJSONNode[T], parent key, b bit, fn func(*trieJSONNode[T]) error) error {
+	if !n.Prefix.IsValid() {
+		return fmt.Errorf("invalid trie: %w", invalidPrefixError(n.Prefix))
+	}
+	k := keyFromPrefix(n.Prefix)
+	if parent.len > 0 || k.len > 0 {
+		if !parent.isPrefixOf(k, true) || k.bit(parent.len) != b {
+			return fmt.Errorf("invalid trie: %v is not a valid child of %v",
+				n.Prefix, parent.toPrefix())
+		}
+	}
+	if n.Entry {
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+	if n.Left != nil {
+		if err := walkTrieJSON(n.Left, k, bitL, fn); err != nil {
+			return err
+		}
+	}
+	if n.Right != nil {
+		return walkTrieJSON(n.Right, k, bitR, fn)
+	}
+	return nil
+}
+
+// WriteTrieJSON writes the structure of m's underlying radix tree to w as a
+// single JSON object, so that it can be traversed by non-Go consumers. For
+// example, the map {1.2.0.0/16: "hello", 1.2.3.0/24: "world"} is encoded as
+// follows (with the root's long segment elided):
+//
+//	{
+//	  "prefix": "::/0",
+//	  "segment": "",
+//	  "entry": false,
+//	  "left": {
+//	    "prefix": "1.2.0.0/16",
+//	    "segment": "00...0011111111111111110000000100000010",
+//	    "entry": true,
+//	    "value": "hello",
+//	    "left": {
+//	      "prefix": "1.2.3.0/24",
+//	      "segment": "00000011",
+//	      "entry": true,
+//	      "value": "world"
+//	    }
+//	  }
+//	}
+//
+// Each node has the following fields:
+//   - prefix: the full Prefix represented by the node.
+//   - segment: the bits of the node's key that follow its parent's key, as a
+//     string of '0' and '1' characters. Keys are 128 bits long; IPv4
+//     Prefixes are represented within the IPv4-mapped range ::ffff:0:0/96.
+//   - entry: whether the node holds an entry. Nodes without entries exist
+//     where two branches of the tree meet.
+//   - value: the entry's value, encoded with [encoding/json]. Omitted if the
+//     node has no entry.
+//   - left, right: the node's children, whose next bit is 0 or 1
+//     respectively. Omitted if absent.
+func (m *PrefixMap[T]) WriteTrieJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(trieJSON(&m.tree, 0, false))
+}
+
+// ReadTrieJSON reads a tree in the format written by
+// [PrefixMap.WriteTrieJSON] from r, and sets each of its entries in m. The
+// structure of the tree is validated, but nodes without entries are otherwise
+// ignored, since m determines its own structure.
+func (m *PrefixMapBuilder[T]) ReadTrieJSON(r io.Reader) error {
+	var root trieJSONNode[T]
+	if err := json.NewDecoder(r).Decode(&root); err != nil {
+		return fmt.Errorf("failed to decode trie: %w", err)
+	}
+	return walkTrieJSON(&root, key{}, bitL, func(n *trieJSONNode[T]) error {
+		if n.Value == nil {
+			return fmt.Errorf("invalid trie: entry %v has no value", n.Prefix)
+		}
+		return m.Set(n.Prefix, *n.Value)
+	})
+}
+
+// WriteTrieJSON writes the structure of s's underlying radix tree to w in the
+// format described by [PrefixMap.WriteTrieJSON]. Nodes have no values.
+func (s *PrefixSet) WriteTrieJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(trieJSON(&s.tree, 0, true))
+}
+
+// ReadTrieJSON reads a tree in the format written by
+// [PrefixSet.WriteTrieJSON] from r, and adds each of its entries to s.
+func (s *PrefixSetBuilder) ReadTrieJSON(r io.Reader) error {
+	var root trieJSONNode[bool]
+	if err := json.NewDecoder(r).Decode(&root); err != nil {
+		return fmt.Errorf("failed to decode trie: %w", err)
+	}
+	return walkTrieJSON(&root, key{}, bitL, func(n *trieJSONNode[bool]) error {
+		return s.Add(n.Prefix)
+	})
+}
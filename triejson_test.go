@@ -0,0 +1,79 @@
+package netipds
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrefixMapTrieJSON(t *testing.T) {
+	tests := []map[string]int{
+		{},
+		{"::0/128": 1},
+		{"::0/128": 1, "::1/128": 2},
+		{"::0/126": 1, "::2/128": 0},
+		{"1.2.0.0/16": 1, "1.2.3.0/24": 2, "2001:db8::/32": 3},
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[int]{}
+		for p, v := range tt {
+			pmb.Set(pfx(p), v)
+		}
+		pm := pmb.PrefixMap()
+
+		var buf bytes.Buffer
+		if err := pm.WriteTrieJSON(&buf); err != nil {
+			t.Fatalf("WriteTrieJSON: %v", err)
+		}
+		got := &PrefixMapBuilder[int]{}
+		if err := got.ReadTrieJSON(&buf); err != nil {
+			t.Fatalf("ReadTrieJSON: %v", err)
+		}
+		checkMap(t, pm.ToMap(), got.PrefixMap().ToMap())
+		// The structure is preserved. Compare the encodings rather than
+		// String(), which depends on insertion order through node offsets.
+		var want, gotBuf bytes.Buffer
+		pm.WriteTrieJSON(&want)
+		got.PrefixMap().WriteTrieJSON(&gotBuf)
+		if gotBuf.String() != want.String() {
+			t.Errorf("got tree\n%v\nwant\n%v", gotBuf.String(), want.String())
+		}
+	}
+}
+
+func TestPrefixSetTrieJSON(t *testing.T) {
+	psb := &PrefixSetBuilder{}
+	psb.Add(pfx("::0/128"))
+	psb.Add(pfx("::2/128"))
+	var buf bytes.Buffer
+	if err := psb.PrefixSet().WriteTrieJSON(&buf); err != nil {
+		t.Fatalf("WriteTrieJSON: %v", err)
+	}
+	want := `{"prefix":"::/0","segment":"","entry":false,"left":` +
+		`{"prefix":"::/126","segment":"` + strings.Repeat("0", 126) + `","entry":false,` +
+		`"left":{"prefix":"::/128","segment":"00","entry":true},` +
+		`"right":{"prefix":"::2/128","segment":"10","entry":true}}}` + "\n"
+	if buf.String() != want {
+		t.Errorf("WriteTrieJSON() = %s, want %s", buf.String(), want)
+	}
+	got := &PrefixSetBuilder{}
+	if err := got.ReadTrieJSON(&buf); err != nil {
+		t.Fatalf("ReadTrieJSON: %v", err)
+	}
+	checkPrefixSlice(t, got.PrefixSet().Prefixes(), pfxs("::0/128", "::2/128"))
+}
+
+func TestReadTrieJSONInvalid(t *testing.T) {
+	tests := []string{
+		`not json`,
+		`{"prefix":"::/0","left":{"prefix":"8000::/1","entry":true,"value":1}}`,
+		`{"prefix":"::/0","left":{"prefix":"::/1","entry":true}}`,
+		`{"prefix":"::/0","right":{"prefix":"8000::/1","left":{"prefix":"::/2","entry":true,"value":1}}}`,
+	}
+	for _, tt := range tests {
+		pmb := &PrefixMapBuilder[int]{}
+		if err := pmb.ReadTrieJSON(strings.NewReader(tt)); err == nil {
+			t.Errorf("ReadTrieJSON(%s) succeeded", tt)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package netipds
+
+// truncateKeys returns a copy of t in which every key longer than its address
+// family's limit is truncated to that limit. When several entries end up with
+// the same key, their values are combined with merge, in the order they
+// appear in t. The limits are key lengths, so v4 is at least 96.
+func (t *tree[T]) truncateKeys(v4, v6 uint8, merge func(a, b T) T) *tree[T] {
+	ret := &tree[T]{}
+	t.walk(key{}, func(n *tree[T]) bool {
+		if !n.hasEntry {
+			return false
+		}
+		k, v := n.key.rooted(), n.value
+		limit := v6
+		if k.is4() {
+			limit = v4
+		}
+		if k.len > limit {
+			k = k.truncated(limit)
+		}
+		if prev, ok := ret.get(k); ok {
+			v = merge(prev, v)
+		}
+		ret = ret.insert(k, v)
+		return false
+	})
+	return ret
+}
+
+// truncateLimits converts Prefix length limits to key length limits, clamping
+// them to the valid lengths for each address family.
+func truncateLimits(v4Bits, v6Bits int) (v4, v6 uint8) {
+	return uint8(min(max(v4Bits, 0), 32) + 96), uint8(min(max(v6Bits, 0), 128))
+}
+
+// TruncateTo returns a PrefixSet in which every IPv4 Prefix in s longer than
+// v4Bits is replaced by its ancestor of length v4Bits, and likewise for IPv6
+// Prefixes and v6Bits. Prefixes that become identical are coalesced into one,
+// and Prefixes that are already short enough are left unchanged.
+//
+// For example, truncating to /24 and /48 anonymizes host-level data:
+// {1.2.3.4/32, 1.2.3.5/32, 2001:db8::1/128} becomes
+// {1.2.3.0/24, 2001:db8::/48}.
+func (s *PrefixSet) TruncateTo(v4Bits, v6Bits int) *PrefixSet {
+	v4, v6 := truncateLimits(v4Bits, v6Bits)
+	t := s.tree.truncateKeys(v4, v6, func(a, _ bool) bool { return a })
+	return &PrefixSet{*t, t.stats()}
+}
+
+// TruncateTo is like [PrefixSet.TruncateTo]. When several entries are
+// truncated to the same Prefix, their values are combined with merge, which is
+// called with the value accumulated so far and the next value, in Prefix
+// order. An entry already at the truncated Prefix comes first.
+func (m *PrefixMap[T]) TruncateTo(v4Bits, v6Bits int, merge func(a, b T) T) *PrefixMap[T] {
+	v4, v6 := truncateLimits(v4Bits, v6Bits)
+	t := m.tree.truncateKeys(v4, v6, merge)
+	return &PrefixMap[T]{*t, t.stats(), nil}
+}
@@ -0,0 +1,76 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSetTruncateTo(t *testing.T) {
+	tests := []struct {
+		set    []netip.Prefix
+		v4, v6 int
+		want   []netip.Prefix
+	}{
+		{pfxs(), 24, 48, pfxs()},
+		{
+			pfxs("1.2.3.4/32", "1.2.3.5/32", "2001:db8::1/128"), 24, 48,
+			pfxs("1.2.3.0/24", "2001:db8::/48"),
+		},
+		// Short Prefixes are left alone, and are coalesced with truncated
+		// descendants
+		{
+			pfxs("1.0.0.0/8", "1.2.3.0/24", "1.2.3.4/32", "2.3.0.0/16"), 24, 48,
+			pfxs("1.0.0.0/8", "1.2.3.0/24", "2.3.0.0/16"),
+		},
+		{pfxs("1.2.3.4/32", "1.2.4.4/32"), 16, 128, pfxs("1.2.0.0/16")},
+		{pfxs("1.2.3.4/32", "::1/128"), 32, 8, pfxs("::/8", "1.2.3.4/32")},
+		// Limits are clamped
+		{pfxs("1.2.3.4/32", "::1/128"), 40, 200, pfxs("::1/128", "1.2.3.4/32")},
+		{pfxs("1.2.3.4/32"), -1, 0, pfxs("0.0.0.0/0")},
+	}
+	for _, tt := range tests {
+		psb := &PrefixSetBuilder{}
+		for _, p := range tt.set {
+			psb.Add(p)
+		}
+		got := psb.PrefixSet().TruncateTo(tt.v4, tt.v6)
+		checkPrefixSlice(t, got.Prefixes(), tt.want)
+		if got.Size() != len(tt.want) {
+			t.Errorf("TruncateTo(%v, %d, %d).Size() = %d, want %d",
+				tt.set, tt.v4, tt.v6, got.Size(), len(tt.want))
+		}
+	}
+}
+
+func TestPrefixMapTruncateTo(t *testing.T) {
+	pmb := &PrefixMapBuilder[int]{}
+	pmb.Set(pfx("1.2.3.0/24"), 1)
+	pmb.Set(pfx("1.2.3.4/32"), 2)
+	pmb.Set(pfx("1.2.3.5/32"), 3)
+	pmb.Set(pfx("1.2.4.4/32"), 4)
+	pmb.Set(pfx("2001:db8::1/128"), 5)
+	pmb.Set(pfx("2001:db8:0:1::1/128"), 6)
+	sum := func(a, b int) int { return a + b }
+
+	checkMap(t, map[netip.Prefix]int{
+		pfx("1.2.3.0/24"):    6,
+		pfx("1.2.4.0/24"):    4,
+		pfx("2001:db8::/48"): 11,
+	}, pmb.PrefixMap().TruncateTo(24, 48, sum).ToMap())
+
+	// merge is called in Prefix order, starting with any existing entry
+	var order []int
+	pmb.PrefixMap().TruncateTo(16, 48, func(a, b int) int {
+		order = append(order, a, b)
+		return b
+	})
+	want := []int{1, 2, 2, 3, 3, 4, 5, 6}
+	if len(order) != len(want) {
+		t.Fatalf("merge calls: got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("merge calls: got %v, want %v", order, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package netipds
+
+import "time"
+
+// txn records how to undo the changes made to a builder of type B since the
+// start of a transaction.
+//
+// Changes to individual Prefixes are undone by restoring each Prefix's
+// previous state, in reverse order. Operations which may change many Prefixes
+// at once instead take a snapshot of the whole builder, the first time one is
+// performed; after that, nothing more needs to be recorded.
+type txn[B any, T any] struct {
+	log      []txnEntry[T]
+	snapshot *B
+}
+
+// txnEntry records the state of the Prefix with key k before it was changed.
+type txnEntry[T any] struct {
+	k       key
+	v       T
+	ok      bool
+	t       time.Time
+	hasTime bool
+}
+
+// logging reports whether changes to individual Prefixes must be recorded in
+// x.
+func (x *txn[B, T]) logging() bool {
+	return x != nil && x.snapshot == nil
+}
+
+// Begin starts a transaction. Changes made to s until the next call to Commit
+// or Rollback can be undone all at once by calling Rollback. This is useful
+// when loading a batch of Prefixes which must be applied completely or not at
+// all, for example if a later input line fails validation.
+//
+// Transactions do not nest: calling Begin during a transaction has no
+// effect.
+//
+// Add and Remove record only the Prefixes they change. The first call within a
+// transaction to any other method which modifies s, such as Merge or
+// Subtract, copies s's tree.
+func (s *PrefixSetBuilder) Begin() {
+	if s.txn == nil {
+		s.txn = &txn[PrefixSetBuilder, bool]{}
+	}
+}
+
+// Commit ends the transaction in progress, keeping its changes. It has no
+// effect if there is no transaction in progress.
+func (s *PrefixSetBuilder) Commit() {
+	s.txn = nil
+}
+
+// Rollback ends the transaction in progress, undoing all of the changes made
+// to s since Begin was called. PrefixSets created from s during the
+// transaction are unaffected. Rollback has no effect if there is no
+// transaction in progress.
+func (s *PrefixSetBuilder) Rollback() {
+	x := s.txn
+	if x == nil {
+		return
+	}
+	s.txn = nil
+	if x.snapshot != nil {
+		s.tree, s.lens = x.snapshot.tree, x.snapshot.lens
+		s.gen, s.shared = x.snapshot.gen, x.snapshot.shared
+	}
+	for i := len(x.log) - 1; i >= 0; i-- {
+		if e := x.log[i]; e.ok {
+			s.add(e.k)
+		} else {
+			s.remove(e.k)
+		}
+	}
+}
+
+// logKey records the state of k, if required by the transaction in progress.
+func (s *PrefixSetBuilder) logKey(k key) {
+	if s.txn.logging() {
+		s.txn.log = append(s.txn.log, txnEntry[bool]{k: k, ok: s.tree.contains(k)})
+	}
+}
+
+// snapshot takes a snapshot of s, if required by the transaction in progress.
+// It must be called before s.tree is modified other than by add or remove.
+func (s *PrefixSetBuilder) snapshot() {
+	if s.txn.logging() {
+		s.txn.snapshot = s.clone()
+	}
+}
+
+// Begin starts a transaction. Changes made to m until the next call to Commit
+// or Rollback can be undone all at once by calling Rollback. This is useful
+// when loading a batch of entries which must be applied completely or not at
+// all, for example if a later input line fails validation. Rollback restores
+// entry times as well as values.
+//
+// Transactions do not nest: calling Begin during a transaction has no
+// effect.
+//
+// Methods which set or remove one Prefix at a time, such as Set, Remove and
+// MergeWith, record only the Prefixes they change. The first call within a
+// transaction to any other method which modifies m, such as Filter or
+// Subtract, copies m's tree.
+func (m *PrefixMapBuilder[T]) Begin() {
+	if m.txn == nil {
+		m.txn = &txn[PrefixMapBuilder[T], T]{}
+	}
+}
+
+// Commit ends the transaction in progress, keeping its changes. It has no
+// effect if there is no transaction in progress.
+func (m *PrefixMapBuilder[T]) Commit() {
+	m.txn = nil
+}
+
+// Rollback ends the transaction in progress, undoing all of the changes made
+// to m since Begin was called. Rollback has no effect if there is no
+// transaction in progress.
+func (m *PrefixMapBuilder[T]) Rollback() {
+	x := m.txn
+	if x == nil {
+		return
+	}
+	m.txn = nil
+	if x.snapshot != nil {
+		m.tree, m.lens, m.times = x.snapshot.tree, x.snapshot.lens, x.snapshot.times
+	}
+	for i := len(x.log) - 1; i >= 0; i-- {
+		e := x.log[i]
+		if !e.ok {
+			m.remove(e.k)
+			continue
+		}
+		m.set(e.k, e.v)
+		if e.hasTime {
+			m.setTime(e.k, e.t)
+		} else {
+			m.times.remove(e.k)
+		}
+	}
+}
+
+// logKey records the state of k, if required by the transaction in progress.
+func (m *PrefixMapBuilder[T]) logKey(k key) {
+	if m.txn.logging() {
+		e := txnEntry[T]{k: k}
+		e.v, e.ok = m.tree.get(k)
+		e.t, e.hasTime = m.times.get(k)
+		m.txn.log = append(m.txn.log, e)
+	}
+}
+
+// snapshot takes a snapshot of m, if required by the transaction in progress.
+// It must be called before m.tree is modified other than by set or remove.
+func (m *PrefixMapBuilder[T]) snapshot() {
+	if m.txn.logging() {
+		m.txn.snapshot = m.clone()
+	}
+}
@@ -0,0 +1,137 @@
+package netipds
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPrefixSetBuilderTxn(t *testing.T) {
+	base := pfxs("1.0.0.0/8", "1.2.0.0/16", "2001:db8::/32")
+	tests := []struct {
+		name  string
+		batch func(*PrefixSetBuilder)
+		want  []netip.Prefix
+	}{
+		{
+			"add and remove",
+			func(s *PrefixSetBuilder) {
+				s.Add(pfx("3.0.0.0/8"))
+				s.Remove(pfx("1.2.0.0/16"))
+				s.Add(pfx("1.2.0.0/16"))
+				s.Remove(pfx("1.0.0.0/8"))
+				s.Add(pfx("1.0.0.0/8"))
+			},
+			pfxs("1.0.0.0/8", "1.2.0.0/16", "3.0.0.0/8", "2001:db8::/32"),
+		},
+		{
+			"bulk operation between adds",
+			func(s *PrefixSetBuilder) {
+				s.Add(pfx("3.0.0.0/8"))
+				s.Remove(pfx("1.2.0.0/16"))
+				o := &PrefixSetBuilder{}
+				o.Add(pfx("1.128.0.0/9"))
+				s.Subtract(o.PrefixSet())
+				s.Add(pfx("4.0.0.0/8"))
+			},
+			pfxs("1.0.0.0/9", "3.0.0.0/8", "4.0.0.0/8", "2001:db8::/32"),
+		},
+		{
+			"bulk operation only",
+			func(s *PrefixSetBuilder) {
+				o := &PrefixSetBuilder{}
+				o.Add(pfx("2001:db8::/33"))
+				s.SymmetricDifference(o.PrefixSet())
+			},
+			pfxs("1.0.0.0/8", "2001:db8:8000::/33"),
+		},
+	}
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			s := &PrefixSetBuilder{Lazy: lazy}
+			for _, p := range base {
+				s.Add(p)
+			}
+			before := s.PrefixSet()
+
+			s.Begin()
+			tt.batch(s)
+			during := s.PrefixSet()
+			s.Rollback()
+			checkPrefixSlice(t, s.PrefixSet().Prefixes(), base)
+			if got := s.PrefixSet().Size(); got != len(base) {
+				t.Errorf("%s: Size() after Rollback = %d, want %d", tt.name, got, len(base))
+			}
+			// Sets created before and during the transaction are unaffected
+			checkPrefixSlice(t, before.Prefixes(), base)
+			checkPrefixSlice(t, during.Prefixes(), tt.want)
+
+			// The builder can still be modified after Rollback
+			s.Add(pfx("5.0.0.0/8"))
+			s.Remove(pfx("5.0.0.0/8"))
+			checkPrefixSlice(t, before.Prefixes(), base)
+
+			s.Begin()
+			tt.batch(s)
+			s.Commit()
+			// Without a transaction in progress, Rollback does nothing
+			s.Rollback()
+			checkPrefixSlice(t, s.PrefixSet().Prefixes(), tt.want)
+		}
+	}
+}
+
+func TestPrefixMapBuilderTxn(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := map[netip.Prefix]int{
+		pfx("1.0.0.0/8"):     1,
+		pfx("1.2.0.0/16"):    2,
+		pfx("2001:db8::/32"): 3,
+	}
+	for _, lazy := range []bool{false, true} {
+		for _, bulk := range []bool{false, true} {
+			m := &PrefixMapBuilder[int]{Lazy: lazy}
+			m.SetWithTime(pfx("1.0.0.0/8"), 1, t1)
+			m.Set(pfx("1.2.0.0/16"), 2)
+			m.SetWithTime(pfx("2001:db8::/32"), 3, t1)
+
+			m.Begin()
+			// Begin during a transaction has no effect
+			m.Begin()
+			m.SetWithTime(pfx("1.0.0.0/8"), 10, t2)
+			m.SetWithTime(pfx("1.2.0.0/16"), 20, t2)
+			m.Remove(pfx("2001:db8::/32"))
+			if bulk {
+				m.SubtractPrefix(pfx("1.0.0.0/9"), func(_ netip.Prefix, v int) (int, bool) {
+					return v, true
+				})
+			}
+			m.Set(pfx("3.0.0.0/8"), 4)
+			m.Rollback()
+
+			pm := m.PrefixMap()
+			checkMap(t, want, pm.ToMap())
+			if got := pm.Size(); got != len(want) {
+				t.Errorf("Size() after Rollback = %d, want %d", got, len(want))
+			}
+			for p, wantTime := range map[string]time.Time{
+				"1.0.0.0/8":     t1,
+				"1.2.0.0/16":    {},
+				"2001:db8::/32": t1,
+			} {
+				if _, tm, _ := pm.GetWithTime(pfx(p)); !tm.Equal(wantTime) {
+					t.Errorf("GetWithTime(%s) after Rollback: got time %v, want %v", p, tm, wantTime)
+				}
+			}
+
+			m.Begin()
+			m.Set(pfx("3.0.0.0/8"), 4)
+			m.Commit()
+			m.Rollback()
+			if v, ok := m.Get(pfx("3.0.0.0/8")); !ok || v != 4 {
+				t.Errorf("Get(3.0.0.0/8) after Commit = (%v, %v), want (4, true)", v, ok)
+			}
+		}
+	}
+}
@@ -162,3 +162,22 @@ func TestIsBitSet(t *testing.T) {
 		}
 	}
 }
+
+func TestUint128Less(t *testing.T) {
+	tests := []struct {
+		u, v uint128
+		want bool
+	}{
+		{uint128{0, 0}, uint128{0, 0}, false},
+		{uint128{0, 0}, uint128{0, 1}, true},
+		{uint128{0, 1}, uint128{0, 0}, false},
+		{uint128{0, ^uint64(0)}, uint128{1, 0}, true},
+		{uint128{1, 0}, uint128{0, ^uint64(0)}, false},
+		{uint128{1, 1}, uint128{1, 2}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.u.less(tt.v); got != tt.want {
+			t.Errorf("%v.less(%v) = %v; want %v", tt.u, tt.v, got, tt.want)
+		}
+	}
+}